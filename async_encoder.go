@@ -0,0 +1,114 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by AsyncEncoder.Submit when the queue has no room
+// for another frame because encoding, or the reader of Results, is falling
+// behind.
+var ErrQueueFull = errors.New("opus: AsyncEncoder queue is full")
+
+// AsyncResult is the outcome of encoding one frame submitted to an
+// AsyncEncoder. Frame.PTS counts the audio submitted before the frame,
+// including frames that failed to encode.
+type AsyncResult struct {
+	Frame Frame
+	Err   error
+}
+
+// AsyncEncoder encodes frames on a dedicated goroutine, so that a real-time
+// producer, such as an audio callback, never waits for libopus. Frames wait
+// in a bounded queue; when it is full Submit fails with ErrQueueFull instead
+// of blocking, leaving it to the caller to drop audio or slow down.
+//
+// The results are delivered in submission order on Results. A reader that
+// stops receiving from Results stalls the encoder, which fills the queue.
+type AsyncEncoder struct {
+	enc     *Encoder
+	queue   chan []int16
+	results chan AsyncResult
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewAsyncEncoder starts encoding the frames submitted to the returned
+// AsyncEncoder with enc, queueing up to queueSize frames (at least 1). enc
+// now belongs to the AsyncEncoder's goroutine: it may still be configured,
+// but must not be used to encode.
+func NewAsyncEncoder(enc *Encoder, queueSize int) *AsyncEncoder {
+	queueSize = max(queueSize, 1)
+	a := &AsyncEncoder{
+		enc:     enc,
+		queue:   make(chan []int16, queueSize),
+		results: make(chan AsyncResult, queueSize),
+	}
+	go a.run()
+	return a
+}
+
+// Submit queues a copy of pcm, a frame of interleaved samples, for encoding
+// and returns without waiting. It returns ErrQueueFull if the queue is full,
+// and an error after Close.
+func (a *AsyncEncoder) Submit(pcm []int16) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return errors.New("opus: submit to closed AsyncEncoder")
+	}
+	select {
+	case a.queue <- append([]int16(nil), pcm...):
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Queued returns the number of frames waiting to be encoded.
+func (a *AsyncEncoder) Queued() int {
+	return len(a.queue)
+}
+
+// Results returns the channel the encoded frames are sent to. It is closed
+// after Close once every queued frame has been encoded.
+func (a *AsyncEncoder) Results() <-chan AsyncResult {
+	return a.results
+}
+
+// Close stops accepting frames. The frames already queued are still
+// encoded; Close doesn't wait for them.
+func (a *AsyncEncoder) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	return nil
+}
+
+// run encodes the queued frames until the queue is closed.
+func (a *AsyncEncoder) run() {
+	defer close(a.results)
+	data := make([]byte, DefaultMaxPacketSize)
+	var pts time.Duration
+	for pcm := range a.queue {
+		duration := time.Duration(len(pcm)/a.enc.channels) * time.Second / time.Duration(a.enc.sampleRate)
+		r := AsyncResult{Frame: Frame{PTS: pts, Duration: duration}}
+		n, err := a.enc.Encode(pcm, data)
+		if err != nil {
+			r.Err = err
+		} else {
+			r.Frame.Data = append([]byte(nil), data[:n]...)
+		}
+		pts += duration
+		a.results <- r
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAsyncEncoder(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const frames = 10
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	ref, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	a := NewAsyncEncoder(enc, frames)
+	pcm := make([]int16, FRAME_SIZE)
+	var want [][]byte
+	for i := 0; i < frames; i++ {
+		addSine(pcm, SAMPLE_RATE, float64(300+50*i))
+		data := make([]byte, 1000)
+		n, err := ref.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		want = append(want, data[:n])
+		// Submit copies pcm, so reusing it doesn't change queued frames.
+		if err := a.Submit(pcm); err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := a.Submit(pcm); err == nil {
+		t.Errorf("Expected error submitting after Close")
+	}
+	i := 0
+	for r := range a.Results() {
+		if r.Err != nil {
+			t.Fatalf("Frame %d: %v", i, r.Err)
+		}
+		if !bytes.Equal(r.Frame.Data, want[i]) {
+			t.Errorf("Frame %d differs from synchronous encoding", i)
+		}
+		if r.Frame.PTS != time.Duration(i)*20*time.Millisecond || r.Frame.Duration != 20*time.Millisecond {
+			t.Errorf("Frame %d at %v for %v", i, r.Frame.PTS, r.Frame.Duration)
+		}
+		i++
+	}
+	if i != frames {
+		t.Errorf("Got %d results, want %d", i, frames)
+	}
+}
+
+func TestAsyncEncoderBackpressure(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	a := NewAsyncEncoder(enc, 2)
+	defer a.Close()
+	pcm := make([]int16, FRAME_SIZE)
+	// Nobody reads the results, so at most 2 results, 1 frame being encoded
+	// and 2 queued frames fit before Submit reports a full queue.
+	var submitErr error
+	submitted := 0
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if submitErr = a.Submit(pcm); submitErr != nil {
+			break
+		}
+		submitted++
+	}
+	if !errors.Is(submitErr, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", submitErr)
+	}
+	if submitted > 5 {
+		t.Errorf("Submitted %d frames before the queue was full, want at most 5", submitted)
+	}
+	<-a.Results()
+	// Receiving a result frees room again.
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Submit(pcm) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Queue still full after a result was received")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
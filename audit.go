@@ -0,0 +1,97 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"crypto/sha256"
+	"time"
+)
+
+// AuditDirection tells whether an audited packet was produced or consumed.
+type AuditDirection int
+
+const (
+	// AuditEncode marks a packet produced by an Encoder.
+	AuditEncode AuditDirection = iota
+	// AuditDecode marks a packet consumed by a Decoder.
+	AuditDecode
+)
+
+func (d AuditDirection) String() string {
+	switch d {
+	case AuditEncode:
+		return "encode"
+	case AuditDecode:
+		return "decode"
+	}
+	return "unknown"
+}
+
+// AuditRecord describes a single packet processed by an Encoder or Decoder.
+type AuditRecord struct {
+	Direction AuditDirection
+	// Time is the wall-clock time at which the packet was processed.
+	Time time.Time
+	// Hash is the SHA-256 digest of the encoded packet.
+	Hash [sha256.Size]byte
+	// Size is the length of the encoded packet in bytes.
+	Size int
+	// Duration is the amount of audio carried by the packet.
+	Duration time.Duration
+}
+
+// AuditSink receives an AuditRecord for every packet encoded or decoded by
+// the instance it is attached to. Record is called synchronously with the
+// instance lock held, so it must not call back into the instance and should
+// hand slow work (disk, network) off to another goroutine.
+type AuditSink interface {
+	Record(AuditRecord)
+}
+
+// AuditSinkFunc adapts an ordinary function to the AuditSink interface.
+type AuditSinkFunc func(AuditRecord)
+
+// Record calls f(r).
+func (f AuditSinkFunc) Record(r AuditRecord) {
+	f(r)
+}
+
+// auditor forwards packets to an optional AuditSink.
+type auditor struct {
+	sink AuditSink
+}
+
+func (a *auditor) record(dir AuditDirection, packet []byte, samples int, sampleRate int) {
+	if a.sink == nil {
+		return
+	}
+	r := AuditRecord{
+		Direction: dir,
+		Time:      time.Now(),
+		Hash:      sha256.Sum256(packet),
+		Size:      len(packet),
+	}
+	if sampleRate > 0 {
+		r.Duration = time.Duration(samples) * time.Second / time.Duration(sampleRate)
+	}
+	a.sink.Record(r)
+}
+
+// SetAuditSink attaches an audit sink to the encoder. A nil sink disables
+// auditing.
+func (enc *Encoder) SetAuditSink(sink AuditSink) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	enc.audit.sink = sink
+}
+
+// SetAuditSink attaches an audit sink to the decoder. A nil sink disables
+// auditing. Concealed frames (PLC) and FEC recoveries are not recorded, since
+// they don't consume a packet of their own.
+func (dec *Decoder) SetAuditSink(sink AuditSink) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+	dec.audit.sink = sink
+}
@@ -0,0 +1,64 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+func TestAuditSink(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	var records []AuditRecord
+	sink := AuditSinkFunc(func(r AuditRecord) { records = append(records, r) })
+
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	enc.SetAuditSink(sink)
+	dec.SetAuditSink(sink)
+
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	data = data[:n]
+	if _, err := dec.Decode(data, pcm); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	if _, err := dec.DecodePLC(pcm); err != nil {
+		t.Fatalf("Couldn't conceal frame: %v", err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 audit records, got %d", len(records))
+	}
+	want := sha256.Sum256(data)
+	for i, dir := range []AuditDirection{AuditEncode, AuditDecode} {
+		r := records[i]
+		if r.Direction != dir {
+			t.Errorf("Record %d: direction %v, expected %v", i, r.Direction, dir)
+		}
+		if r.Hash != want || r.Size != n {
+			t.Errorf("Record %d: hash/size mismatch", i)
+		}
+		if r.Duration != 20*time.Millisecond {
+			t.Errorf("Record %d: duration %v, expected 20ms", i, r.Duration)
+		}
+		if r.Time.IsZero() {
+			t.Errorf("Record %d: missing timestamp", i)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// EncodeBatch encodes each of frames, which must all have the same length,
+// into the matching buffer of out and reslices that buffer to the packet. It
+// returns the number of frames encoded, which is less than len(frames) only
+// with an error.
+//
+// When the module exports bridge_encode_batch, all frames are written to wasm
+// memory at once and encoded in a single call, which saves the per-call
+// overhead of offline transcoding. Otherwise, and for multistream encoders or
+// encoders with a preprocessor or a transport slot size, the frames are
+// encoded one by one with Encode.
+func (enc *Encoder) EncodeBatch(frames [][]int16, out [][]byte) (int, error) {
+	if len(out) < len(frames) {
+		return 0, fmt.Errorf("opus: %d output buffers for %d frames", len(out), len(frames))
+	}
+	enc.mu.Lock()
+	batched := enc.wctx != nil && enc.wctx.functions.BridgeEncodeBatch != nil &&
+		enc.layout == nil && enc.preprocessor == nil && enc.transport.SlotSize == 0
+	if batched {
+		defer enc.unlockAndNotify()
+		return enc.encodeBatchLocked(context.Background(), frames, out)
+	}
+	enc.mu.Unlock()
+
+	for i, pcm := range frames {
+		n, err := enc.Encode(pcm, out[i])
+		if err != nil {
+			return i, err
+		}
+		out[i] = out[i][:n]
+	}
+	return len(frames), nil
+}
+
+func (enc *Encoder) encodeBatchLocked(ctx context.Context, frames [][]int16, out [][]byte) (int, error) {
+	if enc.encoderPtr == 0 {
+		return 0, errEncUninitialized
+	}
+	if len(frames) == 0 {
+		return 0, nil
+	}
+	frameLen := len(frames[0])
+	if frameLen == 0 {
+		return 0, fmt.Errorf("opus: no PCM data supplied")
+	}
+	if frameLen%enc.channels != 0 {
+		return 0, fmt.Errorf("opus: input buffer length must be multiple of channels")
+	}
+	if err := checkFrameSize(enc.sampleRate, frameLen/enc.channels); err != nil {
+		return 0, err
+	}
+	bufLen := len(out[0])
+	pcm := make([]int16, 0, frameLen*len(frames))
+	for i, frame := range frames {
+		if len(frame) != frameLen {
+			return 0, fmt.Errorf("opus: frame %d has %d samples, frame 0 has %d", i, len(frame), frameLen)
+		}
+		if len(out[i]) == 0 {
+			return 0, fmt.Errorf("opus: no target buffer for encoded data")
+		}
+		bufLen = min(bufLen, len(out[i]))
+		pcm = append(pcm, frame...)
+	}
+	maxDataBytes := enc.payloadLimit(bufLen)
+
+	// The packets are followed by the aligned opus_int32 lengths.
+	dataBytes := uint32(maxDataBytes * len(frames))
+	pcmPtr, dataPtr, err := enc.writePCMLocked(ctx, int16SliceToByteSlice(pcm), int(dataBytes)+3+4*len(frames))
+	if err != nil {
+		return 0, err
+	}
+	lensPtr := (dataPtr + dataBytes + 3) &^ 3
+
+	samplesPerChannel := frameLen / enc.channels
+	start := time.Now()
+	results, err := enc.wctx.functions.BridgeEncodeBatch.Call(ctx,
+		uint64(enc.encoderPtr),
+		uint64(pcmPtr),
+		uint64(int32(samplesPerChannel)),
+		uint64(int32(frameLen)), // Stride between frames
+		uint64(int32(len(frames))),
+		uint64(dataPtr),
+		uint64(int32(maxDataBytes)),
+		uint64(lensPtr),
+	)
+	if err != nil {
+		return 0, callError(ctx, "bridge_encode_batch", err)
+	}
+	encoded := int(int32(results[0]))
+	if encoded < 0 || encoded > len(frames) {
+		return 0, fmt.Errorf("bridge_encode_batch reported %d frames for %d", encoded, len(frames))
+	}
+	elapsed := time.Since(start) / time.Duration(len(frames))
+
+	mem := enc.wctx.module.Memory()
+	for i := 0; i < encoded; i++ {
+		length, ok := mem.ReadUint32Le(lensPtr + uint32(4*i))
+		if !ok || int(length) > maxDataBytes {
+			return i, fmt.Errorf("bridge_encode_batch reported %d bytes for frame %d, but buffer has %d", int32(length), i, maxDataBytes)
+		}
+		packet, ok := mem.Read(dataPtr+uint32(i*maxDataBytes), length)
+		if !ok {
+			return i, fmt.Errorf("failed to read encoded data from Wasm memory: %d, %d", dataPtr, length)
+		}
+		out[i] = out[i][:copy(out[i], packet)]
+		enc.audit.record(AuditEncode, out[i], samplesPerChannel, enc.sampleRate)
+		frameEncoded(out[i], samplesPerChannel, enc.sampleRate, enc.channels, elapsed)
+	}
+	if encoded < len(frames) {
+		code, _ := mem.ReadUint32Le(lensPtr + uint32(4*encoded))
+		return encoded, enc.watchdog.observe(Error(int(int32(code))), enc.reinitLocked)
+	}
+	enc.watchdog.succeeded()
+	return encoded, nil
+}
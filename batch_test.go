@@ -0,0 +1,61 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncoder_EncodeBatch(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	frames := make([][]int16, 10)
+	for i := range frames {
+		frames[i] = make([]int16, FRAME_SIZE)
+		addSine(frames[i], SAMPLE_RATE, 220*float64(i+1))
+	}
+
+	batch, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if batch.wctx != nil && batch.wctx.functions.BridgeEncodeBatch == nil {
+		t.Fatalf("wasm module does not export bridge_encode_batch")
+	}
+	single, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	out := make([][]byte, len(frames))
+	for i := range out {
+		out[i] = make([]byte, 1000)
+	}
+	n, err := batch.EncodeBatch(frames, out)
+	if err != nil || n != len(frames) {
+		t.Fatalf("Encoded %d frames (err=%v), want %d", n, err, len(frames))
+	}
+	data := make([]byte, 1000)
+	for i, pcm := range frames {
+		n, err := single.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		if !bytes.Equal(out[i], data[:n]) {
+			t.Errorf("Frame %d: EncodeBatch and Encode produced different packets", i)
+		}
+	}
+
+	if _, err := batch.EncodeBatch(frames, out[:1]); err == nil {
+		t.Errorf("Expected an error for missing output buffers")
+	}
+
+	// Only the batched path needs frames of one length; Encode would take
+	// a 10 ms frame among 20 ms ones.
+	mixed := [][]int16{frames[0], frames[1][:FRAME_SIZE/2]}
+	if n, err := batch.EncodeBatch(mixed, out); batch.wctx != nil && (err == nil || n != 0) {
+		t.Errorf("Encoded %d frames of different lengths (err=%v), want an error from the batched path", n, err)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PacketReader is a source of Opus packets, one packet per call. ReadPacket
+// returns io.EOF once the source is exhausted.
+type PacketReader interface {
+	ReadPacket() ([]byte, error)
+}
+
+// PacketReaderFunc adapts an ordinary function to the PacketReader interface.
+type PacketReaderFunc func() ([]byte, error)
+
+// ReadPacket calls f().
+func (f PacketReaderFunc) ReadPacket() ([]byte, error) {
+	return f()
+}
+
+// PacketWriter is a sink of Opus packets, one packet per call.
+type PacketWriter interface {
+	WritePacket(packet []byte) error
+}
+
+// PacketWriterFunc adapts an ordinary function to the PacketWriter interface.
+type PacketWriterFunc func(packet []byte) error
+
+// WritePacket calls f(packet).
+func (f PacketWriterFunc) WritePacket(packet []byte) error {
+	return f(packet)
+}
+
+// Streamer is the audio stream interface of gopxl/beep (and faiface/beep).
+// Any beep.Streamer satisfies it, and BeepStreamer implements it, without
+// this package depending on beep.
+type Streamer interface {
+	Stream(samples [][2]float64) (n int, ok bool)
+	Err() error
+}
+
+// BeepStreamer exposes decoded Opus packets as a beep Streamer, so they can
+// be played with beep's speaker package or mixed with other beep streams.
+// Mono streams are played on both channels.
+type BeepStreamer struct {
+	dec     *Decoder
+	src     PacketReader
+	pcm     []float32
+	pending []float32 // decoded samples not yet streamed
+	err     error
+	done    bool
+}
+
+var _ Streamer = (*BeepStreamer)(nil)
+
+// NewBeepStreamer returns a streamer that decodes packets from src with dec.
+func NewBeepStreamer(dec *Decoder, src PacketReader) *BeepStreamer {
+	return &BeepStreamer{
+		dec: dec,
+		src: src,
+		pcm: make([]float32, maxFrameSize48k*dec.channels),
+	}
+}
+
+// Stream fills samples with decoded audio. It returns false once the packet
+// source is exhausted or failed and all decoded audio has been streamed.
+func (s *BeepStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+	channels := s.dec.channels
+	for n < len(samples) {
+		if len(s.pending) == 0 {
+			if s.done || !s.fill() {
+				break
+			}
+			continue
+		}
+		left := float64(s.pending[0])
+		right := left
+		if channels == 2 {
+			right = float64(s.pending[1])
+		}
+		samples[n] = [2]float64{left, right}
+		s.pending = s.pending[channels:]
+		n++
+	}
+	return n, n > 0 || !s.done
+}
+
+// fill decodes the next packet into pending. It returns false at the end of
+// the stream.
+func (s *BeepStreamer) fill() bool {
+	packet, err := s.src.ReadPacket()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.err = err
+		}
+		s.done = true
+		return false
+	}
+	samples, err := s.dec.DecodeFloat32(packet, s.pcm)
+	if err != nil {
+		s.err = err
+		s.done = true
+		return false
+	}
+	s.pending = s.pcm[:samples*s.dec.channels]
+	return true
+}
+
+// Err returns the error that stopped the stream, if any.
+func (s *BeepStreamer) Err() error {
+	return s.err
+}
+
+// EncodeStreamer encodes the audio of a beep Streamer in frames of frameSize
+// samples per channel and writes the packets to dst until src is drained.
+// Stereo input is mixed down for mono encoders. The last frame is padded
+// with silence.
+func EncodeStreamer(enc *Encoder, src Streamer, frameSize int, dst PacketWriter) error {
+	if frameSize <= 0 {
+		return fmt.Errorf("opus: invalid frame size: %d", frameSize)
+	}
+	channels := enc.channels
+	samples := make([][2]float64, frameSize)
+	pcm := make([]float32, frameSize*channels)
+	data := make([]byte, 4000)
+	for {
+		filled := 0
+		ok := true
+		for filled < frameSize && ok {
+			var n int
+			n, ok = src.Stream(samples[filled:])
+			filled += n
+		}
+		if filled == 0 {
+			break
+		}
+		for i := range samples[filled:] {
+			samples[filled+i] = [2]float64{}
+		}
+		for i, s := range samples {
+			if channels == 2 {
+				pcm[2*i] = float32(s[0])
+				pcm[2*i+1] = float32(s[1])
+			} else {
+				pcm[i] = float32((s[0] + s[1]) / 2)
+			}
+		}
+		n, err := enc.EncodeFloat32(pcm, data)
+		if err != nil {
+			return err
+		}
+		if err := dst.WritePacket(data[:n]); err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+	return src.Err()
+}
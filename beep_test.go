@@ -0,0 +1,80 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"io"
+	"math"
+	"testing"
+)
+
+// sineStreamer is a finite beep-style Streamer producing a stereo sine.
+type sineStreamer struct {
+	pos, length int
+}
+
+func (s *sineStreamer) Stream(samples [][2]float64) (int, bool) {
+	if s.pos >= s.length {
+		return 0, false
+	}
+	n := 0
+	for n < len(samples) && s.pos < s.length {
+		v := 0.5 * math.Sin(2*math.Pi*440*float64(s.pos)/48000)
+		samples[n] = [2]float64{v, v}
+		n++
+		s.pos++
+	}
+	return n, true
+}
+
+func (s *sineStreamer) Err() error { return nil }
+
+func TestBeepRoundTrip(t *testing.T) {
+	const FRAME_SIZE = 960
+	enc, err := NewEncoder(48000, 2, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	var packets [][]byte
+	src := &sineStreamer{length: 10*FRAME_SIZE + 100}
+	err = EncodeStreamer(enc, src, FRAME_SIZE, PacketWriterFunc(func(p []byte) error {
+		packets = append(packets, append([]byte(nil), p...))
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Couldn't encode streamer: %v", err)
+	}
+	if len(packets) != 11 {
+		t.Fatalf("Expected 11 packets, got %d", len(packets))
+	}
+
+	dec, err := NewDecoder(48000, 2)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	next := 0
+	streamer := NewBeepStreamer(dec, PacketReaderFunc(func() ([]byte, error) {
+		if next == len(packets) {
+			return nil, io.EOF
+		}
+		next++
+		return packets[next-1], nil
+	}))
+	buf := make([][2]float64, 512)
+	total := 0
+	for {
+		n, ok := streamer.Stream(buf)
+		total += n
+		if !ok {
+			break
+		}
+	}
+	if streamer.Err() != nil {
+		t.Errorf("Unexpected stream error: %v", streamer.Err())
+	}
+	if total != 11*FRAME_SIZE {
+		t.Errorf("Expected %d samples, streamed %d", 11*FRAME_SIZE, total)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"slices"
+	"time"
+)
+
+// BenchConfig describes the audio and encoder measured by Benchmark.
+type BenchConfig struct {
+	// SampleRate and Channels default to 48000 and 2.
+	SampleRate, Channels int
+	// Application defaults to AppAudio.
+	Application Application
+	// Config is applied to the encoder, e.g. to measure a complexity.
+	Config EncoderConfigDelta
+	// FrameDuration is the length of each frame; 0 means 20 ms.
+	FrameDuration time.Duration
+	// Duration is the amount of audio coded; 0 means 10 s.
+	Duration time.Duration
+}
+
+// LatencyStats are percentiles of the time taken per frame.
+type LatencyStats struct {
+	P50, P90, P99, Max time.Duration
+}
+
+// BenchStats measure encoding or decoding in a Benchmark.
+type BenchStats struct {
+	// Total is the time spent coding all frames.
+	Total time.Duration
+	// Realtime is the realtime factor: the duration of the audio divided
+	// by Total. Below 1 the codec can't keep up with live audio.
+	Realtime float64
+	Latency  LatencyStats
+	// AllocsPerFrame and BytesPerFrame are the Go heap allocations per
+	// frame, counted for the whole process.
+	AllocsPerFrame, BytesPerFrame float64
+}
+
+// BenchResult is the outcome of a Benchmark.
+type BenchResult struct {
+	Frames int
+	// Audio is the duration of the audio coded.
+	Audio          time.Duration
+	Encode, Decode BenchStats
+}
+
+// Benchmark encodes cfg.Duration of synthetic audio, a chord with some
+// noise, frame by frame, then decodes the packets, and reports how fast
+// both went on this machine. It tells whether the wasm codec meets a
+// realtime budget on the target hardware; run it on an otherwise idle
+// process, as other goroutines skew the latencies and allocation counts.
+func Benchmark(cfg BenchConfig) (BenchResult, error) {
+	if cfg.SampleRate == 0 {
+		cfg.SampleRate = 48000
+	}
+	if cfg.Channels == 0 {
+		cfg.Channels = 2
+	}
+	if cfg.Application == 0 {
+		cfg.Application = AppAudio
+	}
+	if cfg.FrameDuration == 0 {
+		cfg.FrameDuration = 20 * time.Millisecond
+	}
+	if cfg.Duration == 0 {
+		cfg.Duration = 10 * time.Second
+	}
+	if err := validFrameDuration(cfg.FrameDuration); err != nil {
+		return BenchResult{}, err
+	}
+	frameSize := int(cfg.FrameDuration * time.Duration(cfg.SampleRate) / time.Second)
+	frames := max(int(cfg.Duration/cfg.FrameDuration), 1)
+
+	enc, err := NewEncoder(cfg.SampleRate, cfg.Channels, cfg.Application)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	if err := enc.Apply(cfg.Config); err != nil {
+		return BenchResult{}, err
+	}
+	dec, err := NewDecoder(cfg.SampleRate, cfg.Channels)
+	if err != nil {
+		return BenchResult{}, err
+	}
+
+	pcm := benchAudio(frames*frameSize, cfg.SampleRate, cfg.Channels)
+	// The packets are encoded back to back into one buffer, so only the
+	// codec's allocations are counted.
+	packets := make([][]byte, frames)
+	data := make([]byte, frames*DefaultMaxPacketSize)
+	encode, err := benchMeasure(frames, func(i int) error {
+		n, err := enc.Encode(pcm[i*frameSize*cfg.Channels:(i+1)*frameSize*cfg.Channels], data[:DefaultMaxPacketSize])
+		if err != nil {
+			return err
+		}
+		packets[i], data = data[:n], data[n:]
+		return nil
+	})
+	if err != nil {
+		return BenchResult{}, err
+	}
+	out := make([]int16, frameSize*cfg.Channels)
+	decode, err := benchMeasure(frames, func(i int) error {
+		_, err := dec.Decode(packets[i], out)
+		return err
+	})
+	if err != nil {
+		return BenchResult{}, err
+	}
+
+	audio := time.Duration(frames) * cfg.FrameDuration
+	encode.Realtime = float64(audio) / float64(max(encode.Total, 1))
+	decode.Realtime = float64(audio) / float64(max(decode.Total, 1))
+	return BenchResult{Frames: frames, Audio: audio, Encode: encode, Decode: decode}, nil
+}
+
+// benchMeasure times f for each of the frames.
+func benchMeasure(frames int, f func(i int) error) (BenchStats, error) {
+	latencies := make([]time.Duration, frames)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := range latencies {
+		start := time.Now()
+		if err := f(i); err != nil {
+			return BenchStats{}, err
+		}
+		latencies[i] = time.Since(start)
+	}
+	runtime.ReadMemStats(&after)
+
+	var s BenchStats
+	for _, l := range latencies {
+		s.Total += l
+	}
+	slices.Sort(latencies)
+	percentile := func(p float64) time.Duration {
+		return latencies[min(int(p*float64(frames)), frames-1)]
+	}
+	s.Latency = LatencyStats{
+		P50: percentile(0.5),
+		P90: percentile(0.9),
+		P99: percentile(0.99),
+		Max: latencies[frames-1],
+	}
+	s.AllocsPerFrame = float64(after.Mallocs-before.Mallocs) / float64(frames)
+	s.BytesPerFrame = float64(after.TotalAlloc-before.TotalAlloc) / float64(frames)
+	return s, nil
+}
+
+// benchAudio returns samples per channel of interleaved test audio: an A
+// major chord over quiet noise, so the encoder has tonal and noisy content
+// to work on.
+func benchAudio(samples, sampleRate, channels int) []int16 {
+	rng := rand.New(rand.NewSource(1))
+	pcm := make([]int16, samples*channels)
+	for i := 0; i < samples; i++ {
+		t := float64(i) / float64(sampleRate)
+		v := 0.0
+		for _, freq := range []float64{440, 554.37, 659.25} {
+			v += math.Sin(2*math.Pi*freq*t) / 4
+		}
+		for c := 0; c < channels; c++ {
+			pcm[i*channels+c] = int16((v + rng.Float64()*0.02 - 0.01) * math.MaxInt16)
+		}
+	}
+	return pcm
+}
@@ -0,0 +1,32 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBenchmark(t *testing.T) {
+	res, err := Benchmark(BenchConfig{Channels: 1, Duration: time.Second})
+	if err != nil {
+		t.Fatalf("Benchmark: %v", err)
+	}
+	if res.Frames != 50 || res.Audio != time.Second {
+		t.Errorf("Got %d frames of %v, want 50 of 1s", res.Frames, res.Audio)
+	}
+	for name, s := range map[string]BenchStats{"encode": res.Encode, "decode": res.Decode} {
+		if s.Total <= 0 || s.Realtime <= 0 {
+			t.Errorf("%s: got total %v and realtime factor %g", name, s.Total, s.Realtime)
+		}
+		l := s.Latency
+		if l.P50 > l.P90 || l.P90 > l.P99 || l.P99 > l.Max {
+			t.Errorf("%s: percentiles out of order: %+v", name, l)
+		}
+	}
+	if _, err := Benchmark(BenchConfig{FrameDuration: 30 * time.Millisecond}); err == nil {
+		t.Errorf("Expected an error for a 30 ms frame")
+	}
+}
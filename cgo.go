@@ -0,0 +1,496 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+//go:build opus_cgo
+
+package opus
+
+/*
+#cgo pkg-config: opus
+#include <stdlib.h>
+#include <opus.h>
+#include <opus_projection.h>
+
+// The CTL functions are variadic, which cgo can't call. The codecs pass at
+// most one opus_int32 or one pointer, except for the demixing matrix of a
+// projection encoder, which takes a buffer and its size.
+
+static int go_opus_encoder_ctl(OpusEncoder *st, int request, int nargs, opus_int32 arg, void *out) {
+	if (nargs == 0) return opus_encoder_ctl(st, request);
+	if (nargs == 1 && out) return opus_encoder_ctl(st, request, (opus_int32 *)out);
+	if (nargs == 1) return opus_encoder_ctl(st, request, arg);
+	return OPUS_UNIMPLEMENTED;
+}
+
+static int go_opus_decoder_ctl(OpusDecoder *st, int request, int nargs, opus_int32 arg, void *out) {
+	if (nargs == 0) return opus_decoder_ctl(st, request);
+	if (nargs == 1 && out) return opus_decoder_ctl(st, request, (opus_int32 *)out);
+	if (nargs == 1) return opus_decoder_ctl(st, request, arg);
+	return OPUS_UNIMPLEMENTED;
+}
+
+static int go_opus_projection_encoder_ctl(OpusProjectionEncoder *st, int request, int nargs, opus_int32 arg, void *out) {
+	if (nargs == 0) return opus_projection_encoder_ctl(st, request);
+	if (nargs == 1 && out) return opus_projection_encoder_ctl(st, request, (opus_int32 *)out);
+	if (nargs == 1) return opus_projection_encoder_ctl(st, request, arg);
+	if (nargs == 2 && out) return opus_projection_encoder_ctl(st, request, (unsigned char *)out, arg);
+	return OPUS_UNIMPLEMENTED;
+}
+
+// go_bridge_encode_batch mirrors bridge_encode_batch of the wasm bridge.
+static int go_bridge_encode_batch(OpusEncoder *st, const opus_int16 *pcm, int frame_size, int stride, int frames,
+		unsigned char *data, opus_int32 max_data_bytes, opus_int32 *lens) {
+	for (int i = 0; i < frames; i++) {
+		lens[i] = opus_encode(st, pcm + i * stride, frame_size, data + i * max_data_bytes, max_data_bytes);
+		if (lens[i] < 0) return i;
+	}
+	return frames;
+}
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"unsafe"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// opusWasmBinary is not embedded in cgo builds, which link libopus instead.
+var opusWasmBinary []byte
+
+// cgoMemoryPages is the size of the memory of an instance, unless
+// WithInitialMemoryPages asks for more. Unlike wasm memory it can't grow,
+// but calloc only commits the pages libopus touches.
+const cgoMemoryPages = 1024
+
+// The cgo backend stands in for the libopus wasm module: every instance has
+// a block of C memory addressed with 32-bit offsets like wasm memory, and
+// exports Go functions with the names and signatures of wasm-bridge's, which
+// call libopus with pointers into the block. The codecs run unchanged on
+// top of it.
+
+// start checks that the host can run the cgo backend. Options selecting a
+// wasm runtime or module don't apply.
+func (m *wasmManager) start(ctx context.Context, opts runtimeOptions) error {
+	if !hostLittleEndian {
+		// The codecs write little-endian samples, as wasm expects.
+		return errors.New("opus: the cgo backend needs a little-endian host")
+	}
+	return nil
+}
+
+// instantiate creates an instance with its own memory.
+func (m *wasmManager) instantiate(ctx context.Context, name string) (api.Module, error) {
+	pages := max(m.memoryPages, cgoMemoryPages)
+	if pages >= 1<<16 {
+		return nil, fmt.Errorf("opus: memory of %d pages exceeds the 4 GiB the cgo backend can address", pages)
+	}
+	return newCgoModule(name, pages*wasmPageSize)
+}
+
+// cgoModule implements the parts of api.Module the codecs use.
+type cgoModule struct {
+	api.Module // not implemented; only the methods below are called
+	name       string
+	mem        *cgoMemory
+	funcs      map[string]api.Function
+}
+
+func (m *cgoModule) Name() string { return m.name }
+
+func (m *cgoModule) Memory() api.Memory { return m.mem }
+
+func (m *cgoModule) ExportedFunction(name string) api.Function { return m.funcs[name] }
+
+func (m *cgoModule) IsClosed() bool { return m.mem.base == nil }
+
+func (m *cgoModule) Close(context.Context) error {
+	if m.mem.base != nil {
+		C.free(m.mem.base)
+		m.mem.base, m.mem.buf = nil, nil
+	}
+	return nil
+}
+
+// cgoMemory implements the parts of api.Memory the codecs use over a block
+// of C memory. Offset 0 stands for NULL and is never allocated.
+type cgoMemory struct {
+	api.Memory // not implemented; only the methods below are called
+	base       unsafe.Pointer
+	buf        []byte
+	heap       cgoHeap
+}
+
+func (m *cgoMemory) Size() uint32 { return uint32(len(m.buf)) }
+
+func (m *cgoMemory) Grow(deltaPages uint32) (uint32, bool) {
+	return m.Size() / wasmPageSize, deltaPages == 0
+}
+
+func (m *cgoMemory) Read(offset, byteCount uint32) ([]byte, bool) {
+	if uint64(offset)+uint64(byteCount) > uint64(len(m.buf)) {
+		return nil, false
+	}
+	return m.buf[offset : offset+byteCount : offset+byteCount], true
+}
+
+func (m *cgoMemory) Write(offset uint32, v []byte) bool {
+	if uint64(offset)+uint64(len(v)) > uint64(len(m.buf)) {
+		return false
+	}
+	copy(m.buf[offset:], v)
+	return true
+}
+
+func (m *cgoMemory) ReadUint32Le(offset uint32) (uint32, bool) {
+	b, ok := m.Read(offset, 4)
+	if !ok {
+		return 0, false
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24, true
+}
+
+// ptr returns the C address of offset, nil for 0.
+func (m *cgoMemory) ptr(offset uint64) unsafe.Pointer {
+	if offset == 0 {
+		return nil
+	}
+	return unsafe.Add(m.base, uint32(offset))
+}
+
+// writeCString copies s, NUL-terminated, into a new block.
+func (m *cgoMemory) writeCString(s string) uint32 {
+	off := m.heap.alloc(uint32(len(s) + 1))
+	if off != 0 {
+		copy(m.buf[off:], s)
+		m.buf[off+uint32(len(s))] = 0
+	}
+	return off
+}
+
+// cgoFunction implements the parts of api.Function the codecs use.
+type cgoFunction struct {
+	api.Function // not implemented; only the methods below are called
+	params       int
+	results      int
+	fn           func(params []uint64) uint64
+}
+
+func (f *cgoFunction) Call(_ context.Context, params ...uint64) ([]uint64, error) {
+	if len(params) != f.params {
+		return nil, fmt.Errorf("expected %d params, but passed %d", f.params, len(params))
+	}
+	v := f.fn(params)
+	if f.results == 0 {
+		return nil, nil
+	}
+	return []uint64{v}, nil
+}
+
+func (f *cgoFunction) Definition() api.FunctionDefinition {
+	return cgoFunctionDefinition{params: f.params, results: f.results}
+}
+
+// cgoFunctionDefinition describes a function taking and returning i32s, as
+// the wasm32 exports do.
+type cgoFunctionDefinition struct {
+	api.FunctionDefinition // not implemented; only the methods below are called
+	params, results        int
+}
+
+func (d cgoFunctionDefinition) ParamTypes() []api.ValueType {
+	return i32Types(d.params)
+}
+
+func (d cgoFunctionDefinition) ResultTypes() []api.ValueType {
+	return i32Types(d.results)
+}
+
+// i32 returns a C result as a wasm i32 result.
+func i32[T ~int32](v T) uint64 {
+	return uint64(uint32(v))
+}
+
+func newCgoModule(name string, size uint32) (*cgoModule, error) {
+	base := C.calloc(C.size_t(size), 1)
+	if base == nil {
+		return nil, fmt.Errorf("opus: failed to allocate %d bytes of instance memory", size)
+	}
+	mem := &cgoMemory{
+		base: base,
+		buf:  unsafe.Slice((*byte)(base), size),
+		heap: newCgoHeap(cgoHeapAlign, size),
+	}
+	m := &cgoModule{name: name, mem: mem, funcs: make(map[string]api.Function)}
+	export := func(name string, params, results int, fn func(p []uint64) uint64) {
+		m.funcs[name] = &cgoFunction{params: params, results: results, fn: fn}
+	}
+	enc := func(p uint64) *C.OpusEncoder { return (*C.OpusEncoder)(mem.ptr(p)) }
+	dec := func(p uint64) *C.OpusDecoder { return (*C.OpusDecoder)(mem.ptr(p)) }
+	penc := func(p uint64) *C.OpusProjectionEncoder { return (*C.OpusProjectionEncoder)(mem.ptr(p)) }
+	pdec := func(p uint64) *C.OpusProjectionDecoder { return (*C.OpusProjectionDecoder)(mem.ptr(p)) }
+	i16 := func(p uint64) *C.opus_int16 { return (*C.opus_int16)(mem.ptr(p)) }
+	f32 := func(p uint64) *C.float { return (*C.float)(mem.ptr(p)) }
+	u8 := func(p uint64) *C.uchar { return (*C.uchar)(mem.ptr(p)) }
+	ci := func(p uint64) C.int { return C.int(int32(p)) }
+	ci32 := func(p uint64) C.opus_int32 { return C.opus_int32(int32(p)) }
+	// ctlArgs returns the number of CTL arguments spilled at p by callCtl,
+	// known from the size of their block, the first one, and the address
+	// it holds if it is a getter's pointer.
+	ctlArgs := func(request int32, p uint64) (C.int, C.opus_int32, unsafe.Pointer) {
+		n := mem.heap.requested(uint32(p)) / 4
+		if n == 0 {
+			return 0, 0, nil
+		}
+		first, _ := mem.ReadUint32Le(uint32(p))
+		if n == 2 {
+			// A buffer and its size.
+			second, _ := mem.ReadUint32Le(uint32(p) + 4)
+			return 2, C.opus_int32(int32(second)), mem.ptr(uint64(first))
+		}
+		if request%2 == 1 {
+			return C.int(n), 0, mem.ptr(uint64(first))
+		}
+		return C.int(n), C.opus_int32(int32(first)), nil
+	}
+
+	export("malloc", 1, 1, func(p []uint64) uint64 { return uint64(mem.heap.alloc(uint32(p[0]))) })
+	export("free", 1, 0, func(p []uint64) uint64 { mem.heap.release(uint32(p[0])); return 0 })
+
+	version := mem.writeCString(C.GoString(C.opus_get_version_string()))
+	export("opus_get_version_string", 0, 1, func([]uint64) uint64 { return uint64(version) })
+	errorStrings := make(map[int32]uint32)
+	for code := int32(ErrAllocFail); code <= 0; code++ {
+		errorStrings[code] = mem.writeCString(C.GoString(C.opus_strerror(C.int(code))))
+	}
+	unknownError := mem.writeCString(C.GoString(C.opus_strerror(1)))
+	export("opus_strerror", 1, 1, func(p []uint64) uint64 {
+		if s, ok := errorStrings[int32(p[0])]; ok {
+			return uint64(s)
+		}
+		return uint64(unknownError)
+	})
+
+	// Encoder
+	export("opus_encoder_get_size", 1, 1, func(p []uint64) uint64 { return i32(C.opus_encoder_get_size(ci(p[0]))) })
+	export("opus_encoder_init", 4, 1, func(p []uint64) uint64 {
+		return i32(C.opus_encoder_init(enc(p[0]), ci32(p[1]), ci(p[2]), ci(p[3])))
+	})
+	export("opus_encode", 5, 1, func(p []uint64) uint64 {
+		return i32(C.opus_encode(enc(p[0]), i16(p[1]), ci(p[2]), u8(p[3]), ci32(p[4])))
+	})
+	export("opus_encode_float", 5, 1, func(p []uint64) uint64 {
+		return i32(C.opus_encode_float(enc(p[0]), f32(p[1]), ci(p[2]), u8(p[3]), ci32(p[4])))
+	})
+	export("opus_encoder_ctl", 3, 1, func(p []uint64) uint64 {
+		n, arg, out := ctlArgs(int32(p[1]), p[2])
+		return i32(C.go_opus_encoder_ctl(enc(p[0]), ci(p[1]), n, arg, out))
+	})
+	encoderCtls := []struct {
+		name    string
+		request C.int
+	}{
+		{"dtx", C.OPUS_SET_DTX_REQUEST},
+		{"bitrate", C.OPUS_SET_BITRATE_REQUEST},
+		{"complexity", C.OPUS_SET_COMPLEXITY_REQUEST},
+		{"max_bandwidth", C.OPUS_SET_MAX_BANDWIDTH_REQUEST},
+		{"inband_fec", C.OPUS_SET_INBAND_FEC_REQUEST},
+		{"packet_loss_perc", C.OPUS_SET_PACKET_LOSS_PERC_REQUEST},
+		{"vbr", C.OPUS_SET_VBR_REQUEST},
+		{"vbr_constraint", C.OPUS_SET_VBR_CONSTRAINT_REQUEST},
+	}
+	for _, ctl := range encoderCtls {
+		// The getter of a setting is the request after its setter.
+		set, get := ctl.request, ctl.request+1
+		export("bridge_encoder_set_"+ctl.name, 2, 1, func(p []uint64) uint64 {
+			return i32(C.go_opus_encoder_ctl(enc(p[0]), set, 1, ci32(p[1]), nil))
+		})
+		export("bridge_encoder_get_"+ctl.name, 2, 1, func(p []uint64) uint64 {
+			return i32(C.go_opus_encoder_ctl(enc(p[0]), get, 1, 0, mem.ptr(p[1])))
+		})
+	}
+	for name, request := range map[string]C.int{
+		"bridge_encoder_get_in_dtx":      C.OPUS_GET_IN_DTX_REQUEST,
+		"bridge_encoder_get_sample_rate": C.OPUS_GET_SAMPLE_RATE_REQUEST,
+	} {
+		export(name, 2, 1, func(p []uint64) uint64 {
+			return i32(C.go_opus_encoder_ctl(enc(p[0]), request, 1, 0, mem.ptr(p[1])))
+		})
+	}
+	export("bridge_encoder_reset_state", 1, 1, func(p []uint64) uint64 {
+		return i32(C.go_opus_encoder_ctl(enc(p[0]), C.OPUS_RESET_STATE, 0, 0, nil))
+	})
+	export("bridge_encode_batch", 8, 1, func(p []uint64) uint64 {
+		return i32(C.go_bridge_encode_batch(enc(p[0]), i16(p[1]), ci(p[2]), ci(p[3]), ci(p[4]), u8(p[5]), ci32(p[6]),
+			(*C.opus_int32)(mem.ptr(p[7]))))
+	})
+
+	// Decoder
+	export("opus_decoder_get_size", 1, 1, func(p []uint64) uint64 { return i32(C.opus_decoder_get_size(ci(p[0]))) })
+	export("opus_decoder_init", 3, 1, func(p []uint64) uint64 {
+		return i32(C.opus_decoder_init(dec(p[0]), ci32(p[1]), ci(p[2])))
+	})
+	export("opus_decode", 6, 1, func(p []uint64) uint64 {
+		return i32(C.opus_decode(dec(p[0]), u8(p[1]), ci32(p[2]), i16(p[3]), ci(p[4]), ci(p[5])))
+	})
+	export("opus_decode_float", 6, 1, func(p []uint64) uint64 {
+		return i32(C.opus_decode_float(dec(p[0]), u8(p[1]), ci32(p[2]), f32(p[3]), ci(p[4]), ci(p[5])))
+	})
+	export("opus_decoder_ctl", 3, 1, func(p []uint64) uint64 {
+		n, arg, out := ctlArgs(int32(p[1]), p[2])
+		return i32(C.go_opus_decoder_ctl(dec(p[0]), ci(p[1]), n, arg, out))
+	})
+	export("bridge_decoder_get_last_packet_duration", 2, 1, func(p []uint64) uint64 {
+		return i32(C.go_opus_decoder_ctl(dec(p[0]), C.OPUS_GET_LAST_PACKET_DURATION_REQUEST, 1, 0, mem.ptr(p[1])))
+	})
+	export("bridge_decoder_reset_state", 1, 1, func(p []uint64) uint64 {
+		return i32(C.go_opus_decoder_ctl(dec(p[0]), C.OPUS_RESET_STATE, 0, 0, nil))
+	})
+
+	// Projection
+	export("opus_projection_ambisonics_encoder_get_size", 2, 1, func(p []uint64) uint64 {
+		return i32(C.opus_projection_ambisonics_encoder_get_size(ci(p[0]), ci(p[1])))
+	})
+	export("opus_projection_ambisonics_encoder_init", 7, 1, func(p []uint64) uint64 {
+		return i32(C.opus_projection_ambisonics_encoder_init(penc(p[0]), ci32(p[1]), ci(p[2]), ci(p[3]),
+			(*C.int)(mem.ptr(p[4])), (*C.int)(mem.ptr(p[5])), ci(p[6])))
+	})
+	export("opus_projection_encode", 5, 1, func(p []uint64) uint64 {
+		return i32(C.opus_projection_encode(penc(p[0]), i16(p[1]), ci(p[2]), u8(p[3]), ci32(p[4])))
+	})
+	export("opus_projection_encode_float", 5, 1, func(p []uint64) uint64 {
+		return i32(C.opus_projection_encode_float(penc(p[0]), f32(p[1]), ci(p[2]), u8(p[3]), ci32(p[4])))
+	})
+	export("opus_projection_encoder_ctl", 3, 1, func(p []uint64) uint64 {
+		n, arg, out := ctlArgs(int32(p[1]), p[2])
+		return i32(C.go_opus_projection_encoder_ctl(penc(p[0]), ci(p[1]), n, arg, out))
+	})
+	export("opus_projection_decoder_get_size", 3, 1, func(p []uint64) uint64 {
+		return i32(C.opus_projection_decoder_get_size(ci(p[0]), ci(p[1]), ci(p[2])))
+	})
+	export("opus_projection_decoder_init", 7, 1, func(p []uint64) uint64 {
+		return i32(C.opus_projection_decoder_init(pdec(p[0]), ci32(p[1]), ci(p[2]), ci(p[3]), ci(p[4]), u8(p[5]), ci32(p[6])))
+	})
+	export("opus_projection_decode", 6, 1, func(p []uint64) uint64 {
+		return i32(C.opus_projection_decode(pdec(p[0]), u8(p[1]), ci32(p[2]), i16(p[3]), ci(p[4]), ci(p[5])))
+	})
+	export("opus_projection_decode_float", 6, 1, func(p []uint64) uint64 {
+		return i32(C.opus_projection_decode_float(pdec(p[0]), u8(p[1]), ci32(p[2]), f32(p[3]), ci(p[4]), ci(p[5])))
+	})
+
+	// Constants, stored in memory like the globals of wasm-bridge's
+	// constants.c.
+	for name, value := range map[string]C.int{
+		"ok":                              C.OPUS_OK,
+		"bad_arg":                         C.OPUS_BAD_ARG,
+		"buffer_too_small":                C.OPUS_BUFFER_TOO_SMALL,
+		"internal_error":                  C.OPUS_INTERNAL_ERROR,
+		"invalid_packet":                  C.OPUS_INVALID_PACKET,
+		"unimplemented":                   C.OPUS_UNIMPLEMENTED,
+		"invalid_state":                   C.OPUS_INVALID_STATE,
+		"alloc_fail":                      C.OPUS_ALLOC_FAIL,
+		"bandwidth_narrowband":            C.OPUS_BANDWIDTH_NARROWBAND,
+		"bandwidth_mediumband":            C.OPUS_BANDWIDTH_MEDIUMBAND,
+		"bandwidth_wideband":              C.OPUS_BANDWIDTH_WIDEBAND,
+		"bandwidth_superwideband":         C.OPUS_BANDWIDTH_SUPERWIDEBAND,
+		"bandwidth_fullband":              C.OPUS_BANDWIDTH_FULLBAND,
+		"auto":                            C.OPUS_AUTO,
+		"bitrate_max":                     C.OPUS_BITRATE_MAX,
+		"application_voip":                C.OPUS_APPLICATION_VOIP,
+		"application_audio":               C.OPUS_APPLICATION_AUDIO,
+		"application_restricted_lowdelay": C.OPUS_APPLICATION_RESTRICTED_LOWDELAY,
+	} {
+		addr := mem.heap.alloc(4)
+		if addr == 0 {
+			m.Close(context.Background())
+			return nil, errors.New("opus: instance memory too small for the constants")
+		}
+		v := uint32(int32(value))
+		mem.Write(addr, []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+		export("get_opus_"+name+"_address", 0, 1, func([]uint64) uint64 { return uint64(addr) })
+	}
+	return m, nil
+}
+
+// cgoHeapAlign is the alignment of the blocks of a cgoHeap, enough for any
+// libopus state.
+const cgoHeapAlign = 16
+
+// cgoSpan is a range of free memory of a cgoHeap.
+type cgoSpan struct {
+	off, size uint32
+}
+
+// cgoBlock is an allocated block of a cgoHeap.
+type cgoBlock struct {
+	size      uint32 // rounded up to cgoHeapAlign
+	requested uint32
+}
+
+// cgoHeap is a first-fit allocator for the memory of a cgo instance. The
+// codecs only hold a few long-lived blocks, their states and buffers, so
+// a sorted free list is plenty.
+type cgoHeap struct {
+	free []cgoSpan // sorted by offset, never adjacent
+	used map[uint32]cgoBlock
+}
+
+// newCgoHeap returns a heap allocating from [start, end).
+func newCgoHeap(start, end uint32) cgoHeap {
+	return cgoHeap{
+		free: []cgoSpan{{start, end - start}},
+		used: make(map[uint32]cgoBlock),
+	}
+}
+
+// alloc returns the offset of a block of n bytes, or 0 if none is free. Like
+// malloc(0), a request for 0 bytes gets a block of its own.
+func (h *cgoHeap) alloc(n uint32) uint32 {
+	size := max((uint64(n)+cgoHeapAlign-1)&^(cgoHeapAlign-1), cgoHeapAlign)
+	for i, s := range h.free {
+		if uint64(s.size) < size {
+			continue
+		}
+		if uint64(s.size) == size {
+			h.free = slices.Delete(h.free, i, i+1)
+		} else {
+			h.free[i] = cgoSpan{s.off + uint32(size), s.size - uint32(size)}
+		}
+		h.used[s.off] = cgoBlock{size: uint32(size), requested: n}
+		return s.off
+	}
+	return 0
+}
+
+// release frees the block at off; other offsets are ignored, like free
+// ignores NULL.
+func (h *cgoHeap) release(off uint32) {
+	b, ok := h.used[off]
+	if !ok {
+		return
+	}
+	delete(h.used, off)
+	i, _ := slices.BinarySearchFunc(h.free, off, func(s cgoSpan, off uint32) int {
+		return int(int64(s.off) - int64(off))
+	})
+	h.free = slices.Insert(h.free, i, cgoSpan{off, b.size})
+	// Merge with the following and the preceding span.
+	if i+1 < len(h.free) && h.free[i].off+h.free[i].size == h.free[i+1].off {
+		h.free[i].size += h.free[i+1].size
+		h.free = slices.Delete(h.free, i+1, i+2)
+	}
+	if i > 0 && h.free[i-1].off+h.free[i-1].size == h.free[i].off {
+		h.free[i-1].size += h.free[i].size
+		h.free = slices.Delete(h.free, i, i+1)
+	}
+}
+
+// requested returns the size asked for the block at off.
+func (h *cgoHeap) requested(off uint32) uint32 {
+	return h.used[off].requested
+}
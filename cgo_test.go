@@ -0,0 +1,88 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+//go:build opus_cgo
+
+package opus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCgoHeap(t *testing.T) {
+	h := newCgoHeap(cgoHeapAlign, 4*cgoHeapAlign)
+	a := h.alloc(1)
+	b := h.alloc(cgoHeapAlign + 1)
+	if a != cgoHeapAlign || b != 2*cgoHeapAlign {
+		t.Fatalf("Got blocks at %d and %d, want %d and %d", a, b, cgoHeapAlign, 2*cgoHeapAlign)
+	}
+	if got := h.requested(b); got != cgoHeapAlign+1 {
+		t.Errorf("Requested size %d, want %d", got, cgoHeapAlign+1)
+	}
+	if p := h.alloc(1); p != 0 {
+		t.Errorf("Allocated %d from a full heap", p)
+	}
+
+	// Freed neighbours coalesce into one span.
+	h.release(a)
+	h.release(b)
+	h.release(b)
+	if len(h.free) != 1 || h.free[0] != (cgoSpan{cgoHeapAlign, 3 * cgoHeapAlign}) {
+		t.Errorf("Free list %v, want a single span", h.free)
+	}
+	if p := h.alloc(3 * cgoHeapAlign); p != cgoHeapAlign {
+		t.Errorf("Got block at %d, want %d", p, cgoHeapAlign)
+	}
+}
+
+func TestCgoRoundtrip(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	if err := enc.SetBitrate(32000); err != nil {
+		t.Fatalf("SetBitrate: %v", err)
+	}
+	if _, err := enc.Bitrate(); err != nil {
+		t.Errorf("Bitrate: %v", err)
+	}
+
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	out := make([]int16, FRAME_SIZE)
+	if n, err := dec.Decode(data[:n], out); err != nil || n != FRAME_SIZE {
+		t.Errorf("Decoded %d samples (err=%v), want %d", n, err, FRAME_SIZE)
+	}
+	if v, err := VersionErr(); err != nil || v == "" {
+		t.Errorf("VersionErr() = %q, %v", v, err)
+	}
+	if _, err := NewRNNoise(context.Background(), nil); err == nil {
+		t.Errorf("Expected an error creating RNNoise with the cgo backend")
+	}
+}
+
+func TestCgoDecoderState(t *testing.T) {
+	dec, err := NewDecoder(48000, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	if _, err := dec.SaveState(); err != ErrDecoderStateUnsupported {
+		t.Errorf("SaveState: %v, want ErrDecoderStateUnsupported", err)
+	}
+	if err := dec.RestoreState(make([]byte, 100)); err != ErrDecoderStateUnsupported {
+		t.Errorf("RestoreState: %v, want ErrDecoderStateUnsupported", err)
+	}
+}
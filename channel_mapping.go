@@ -0,0 +1,206 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"fmt"
+)
+
+// MappingFamily identifies how the channels of an Opus stream are laid out
+// (RFC 7845, section 5.1.1 and RFC 8486).
+type MappingFamily int
+
+const (
+	// MappingFamilyRTP is mono or stereo in a single Opus stream, with no
+	// mapping table. It is the only family RTP supports.
+	MappingFamilyRTP MappingFamily = 0
+	// MappingFamilyVorbis is 1 to 8 channels in Vorbis channel order.
+	MappingFamilyVorbis MappingFamily = 1
+	// MappingFamilyAmbisonics is ambisonics with one coded channel per
+	// ambisonic channel, plus an optional non-diegetic stereo pair.
+	MappingFamilyAmbisonics MappingFamily = 2
+	// MappingFamilyProjection is ambisonics coded through a mixing matrix,
+	// see ProjectionEncoder.
+	MappingFamilyProjection MappingFamily = 3
+	// MappingFamilyDiscrete is any number of channels with no defined
+	// meaning.
+	MappingFamilyDiscrete MappingFamily = 255
+)
+
+// ChannelMapping describes how channels are spread over the Opus streams of
+// a packet, as in the channel mapping fields of an Ogg Opus header.
+type ChannelMapping struct {
+	Family   MappingFamily
+	Channels int
+	// Streams is the number of Opus streams in each packet, and
+	// CoupledStreams how many of them are stereo. Coupled streams come
+	// first.
+	Streams        int
+	CoupledStreams int
+	// Table holds one entry per channel: the coded channel carrying it,
+	// where coupled streams contribute two coded channels each and 255
+	// marks a silent channel. It is nil for MappingFamilyRTP. For
+	// MappingFamilyProjection it holds the demixing matrix instead (see
+	// ProjectionEncoder.DemixingMatrix).
+	Table []byte
+}
+
+// vorbisMappings are the MappingFamilyVorbis layouts for 1 to 8 channels,
+// in Vorbis channel order (RFC 7845, section 5.1.1.2).
+var vorbisMappings = [maxChannels]struct {
+	streams, coupledStreams int
+	table                   []byte
+}{
+	{1, 0, []byte{0}},                      // mono
+	{1, 1, []byte{0, 1}},                   // stereo
+	{2, 1, []byte{0, 2, 1}},                // linear surround
+	{2, 2, []byte{0, 1, 2, 3}},             // quadraphonic
+	{3, 2, []byte{0, 4, 1, 2, 3}},          // 5.0
+	{4, 2, []byte{0, 4, 1, 2, 3, 5}},       // 5.1
+	{4, 3, []byte{0, 4, 1, 2, 3, 5, 6}},    // 6.1
+	{5, 3, []byte{0, 6, 1, 2, 3, 4, 5, 7}}, // 7.1
+}
+
+// MonoMapping returns the mapping of a single mono stream.
+func MonoMapping() ChannelMapping {
+	return ChannelMapping{Family: MappingFamilyRTP, Channels: 1, Streams: 1}
+}
+
+// StereoMapping returns the mapping of a single stereo stream.
+func StereoMapping() ChannelMapping {
+	return ChannelMapping{Family: MappingFamilyRTP, Channels: 2, Streams: 1, CoupledStreams: 1}
+}
+
+// Surround51Mapping returns the Vorbis order 5.1 mapping: front left,
+// center, front right, rear left, rear right, LFE.
+func Surround51Mapping() ChannelMapping {
+	m, _ := VorbisMapping(6)
+	return m
+}
+
+// Surround71Mapping returns the Vorbis order 7.1 mapping: front left,
+// center, front right, side left, side right, rear left, rear right, LFE.
+func Surround71Mapping() ChannelMapping {
+	m, _ := VorbisMapping(8)
+	return m
+}
+
+// VorbisMapping returns the standard MappingFamilyVorbis layout for 1 to 8
+// channels, as used by opusenc and NewEncoder.
+func VorbisMapping(channels int) (ChannelMapping, error) {
+	if channels < 1 || channels > maxChannels {
+		return ChannelMapping{}, fmt.Errorf("opus: Vorbis channel mapping needs 1 to %d channels, got %d", maxChannels, channels)
+	}
+	v := vorbisMappings[channels-1]
+	return ChannelMapping{
+		Family:         MappingFamilyVorbis,
+		Channels:       channels,
+		Streams:        v.streams,
+		CoupledStreams: v.coupledStreams,
+		Table:          append([]byte(nil), v.table...),
+	}, nil
+}
+
+// defaultMapping returns the mapping NewEncoder and NewDecoder use for
+// channels.
+func defaultMapping(channels int) (ChannelMapping, error) {
+	switch channels {
+	case 1:
+		return MonoMapping(), nil
+	case 2:
+		return StereoMapping(), nil
+	}
+	return VorbisMapping(channels)
+}
+
+// Validate checks that m is a well-formed mapping for its family.
+func (m ChannelMapping) Validate() error {
+	if m.Channels < 1 || m.Channels > 255 {
+		return fmt.Errorf("opus: invalid channel count %d in channel mapping", m.Channels)
+	}
+	if m.Streams < 1 || m.CoupledStreams < 0 || m.CoupledStreams > m.Streams || m.Streams+m.CoupledStreams > 255 {
+		return fmt.Errorf("opus: invalid stream counts in channel mapping: %d streams, %d coupled", m.Streams, m.CoupledStreams)
+	}
+	switch m.Family {
+	case MappingFamilyRTP:
+		if m.Channels > 2 || m.Streams != 1 || m.CoupledStreams != m.Channels-1 {
+			return fmt.Errorf("opus: mapping family 0 must be a single mono or stereo stream")
+		}
+		if m.Table != nil {
+			return fmt.Errorf("opus: mapping family 0 has no mapping table")
+		}
+		return nil
+	case MappingFamilyVorbis:
+		if m.Channels > maxChannels {
+			return fmt.Errorf("opus: mapping family 1 supports at most %d channels, got %d", maxChannels, m.Channels)
+		}
+	case MappingFamilyAmbisonics, MappingFamilyProjection:
+		if !isAmbisonicsChannels(m.Channels) {
+			return fmt.Errorf("opus: %d channels is not a valid ambisonics layout", m.Channels)
+		}
+		if m.Family == MappingFamilyProjection {
+			if want := 2 * m.Channels * (m.Streams + m.CoupledStreams); len(m.Table) != want {
+				return fmt.Errorf("opus: demixing matrix has %d bytes, want %d", len(m.Table), want)
+			}
+			return nil
+		}
+	case MappingFamilyDiscrete:
+	default:
+		return fmt.Errorf("opus: unsupported channel mapping family %d", m.Family)
+	}
+	if len(m.Table) != m.Channels {
+		return fmt.Errorf("opus: mapping table has %d entries for %d channels", len(m.Table), m.Channels)
+	}
+	for i, c := range m.Table {
+		if c != 255 && int(c) >= m.Streams+m.CoupledStreams {
+			return fmt.Errorf("opus: mapping table entry %d refers to coded channel %d of %d", i, c, m.Streams+m.CoupledStreams)
+		}
+	}
+	return nil
+}
+
+// isAmbisonicsChannels reports whether channels is a full ambisonics order,
+// (order+1)^2 for order 0 to 14, optionally plus 2 non-diegetic channels.
+func isAmbisonicsChannels(channels int) bool {
+	for order := 0; order <= 14; order++ {
+		n := (order + 1) * (order + 1)
+		if channels == n || channels == n+2 {
+			return true
+		}
+	}
+	return false
+}
+
+// multistream reports whether m needs the multistream code path rather than
+// a single Opus stream.
+func (m *ChannelMapping) multistream() bool {
+	return m.Family != MappingFamilyRTP
+}
+
+// streamChannels returns the number of channels coded in stream s.
+func (m *ChannelMapping) streamChannels(s int) int {
+	if s < m.CoupledStreams {
+		return 2
+	}
+	return 1
+}
+
+// codedChannel returns the stream and the channel within it that coded
+// channel c belongs to.
+func (m *ChannelMapping) codedChannel(c int) (stream, channel int) {
+	if c < 2*m.CoupledStreams {
+		return c / 2, c % 2
+	}
+	return c - m.CoupledStreams, 0
+}
+
+// lfeStream returns the stream carrying the LFE channel, or -1. As in
+// libopus, that is the last stream of the 5.1 and larger Vorbis layouts.
+func (m *ChannelMapping) lfeStream() int {
+	if m.Family == MappingFamilyVorbis && m.Channels >= 6 {
+		return m.Streams - 1
+	}
+	return -1
+}
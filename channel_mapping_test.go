@@ -0,0 +1,133 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVorbisMapping(t *testing.T) {
+	for channels := 1; channels <= maxChannels; channels++ {
+		m, err := VorbisMapping(channels)
+		if err != nil {
+			t.Fatalf("%d channels: %v", channels, err)
+		}
+		if err := m.Validate(); err != nil {
+			t.Errorf("%d channels: %v", channels, err)
+		}
+		if got := m.Streams + m.CoupledStreams; got != channels {
+			t.Errorf("%d channels: layout codes %d channels", channels, got)
+		}
+		seen := make(map[byte]bool)
+		for _, c := range m.Table {
+			if seen[c] {
+				t.Errorf("%d channels: coded channel %d used twice in %v", channels, c, m.Table)
+			}
+			seen[c] = true
+		}
+	}
+	if _, err := VorbisMapping(maxChannels + 1); err == nil {
+		t.Errorf("Expected error for %d channels", maxChannels+1)
+	}
+	m := Surround51Mapping()
+	if m.Streams != 4 || m.CoupledStreams != 2 || !bytes.Equal(m.Table, []byte{0, 4, 1, 2, 3, 5}) {
+		t.Errorf("Unexpected 5.1 mapping %+v", m)
+	}
+	if m.lfeStream() != 3 {
+		t.Errorf("5.1 LFE stream is %d, want 3", m.lfeStream())
+	}
+	// Presets must not share their tables.
+	m.Table[0] = 9
+	if Surround51Mapping().Table[0] != 0 {
+		t.Errorf("Surround51Mapping table was modified through a copy")
+	}
+}
+
+func TestChannelMappingValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		m    ChannelMapping
+		ok   bool
+	}{
+		{"mono", MonoMapping(), true},
+		{"stereo", StereoMapping(), true},
+		{"7.1", Surround71Mapping(), true},
+		{"family 0 surround", ChannelMapping{Family: MappingFamilyRTP, Channels: 3, Streams: 2, CoupledStreams: 1}, false},
+		{"family 0 with table", ChannelMapping{Family: MappingFamilyRTP, Channels: 1, Streams: 1, Table: []byte{0}}, false},
+		{"family 1 too many channels", ChannelMapping{Family: MappingFamilyVorbis, Channels: 9, Streams: 9, Table: make([]byte, 9)}, false},
+		{"short table", ChannelMapping{Family: MappingFamilyDiscrete, Channels: 3, Streams: 3, Table: []byte{0, 1}}, false},
+		{"coded channel out of range", ChannelMapping{Family: MappingFamilyDiscrete, Channels: 2, Streams: 2, Table: []byte{0, 2}}, false},
+		{"silent channel", ChannelMapping{Family: MappingFamilyDiscrete, Channels: 3, Streams: 2, Table: []byte{0, 255, 1}}, true},
+		{"coupled above streams", ChannelMapping{Family: MappingFamilyDiscrete, Channels: 2, Streams: 1, CoupledStreams: 2, Table: []byte{0, 1}}, false},
+		{"first order ambisonics", ChannelMapping{Family: MappingFamilyAmbisonics, Channels: 4, Streams: 4, Table: []byte{0, 1, 2, 3}}, true},
+		{"bad ambisonics order", ChannelMapping{Family: MappingFamilyAmbisonics, Channels: 5, Streams: 5, Table: []byte{0, 1, 2, 3, 4}}, false},
+		{"projection", ChannelMapping{Family: MappingFamilyProjection, Channels: 4, Streams: 2, CoupledStreams: 2, Table: make([]byte, 32)}, true},
+		{"projection short matrix", ChannelMapping{Family: MappingFamilyProjection, Channels: 4, Streams: 2, CoupledStreams: 2, Table: make([]byte, 16)}, false},
+		{"unknown family", ChannelMapping{Family: 7, Channels: 1, Streams: 1, Table: []byte{0}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.m.Validate()
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestMultistreamEncoderDecoder(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	// Three discrete channels in mono streams, the middle one silent.
+	mapping := ChannelMapping{Family: MappingFamilyDiscrete, Channels: 3, Streams: 2, Table: []byte{0, 255, 1}}
+	enc, err := NewMultistreamEncoder(SAMPLE_RATE, mapping, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if got := enc.ChannelMapping(); !bytes.Equal(got.Table, mapping.Table) || got.Streams != 2 {
+		t.Errorf("Encoder mapping is %+v, want %+v", got, mapping)
+	}
+	dec, err := NewMultistreamDecoder(SAMPLE_RATE, enc.ChannelMapping())
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	mono := make([]int16, FRAME_SIZE)
+	addSine(mono, SAMPLE_RATE, 440)
+	pcm := make([]int16, FRAME_SIZE*3)
+	for i, v := range mono {
+		pcm[i*3] = v / 2
+		pcm[i*3+1] = v / 2
+		pcm[i*3+2] = v / 2
+	}
+	data := make([]byte, 4000)
+	out := make([]int16, FRAME_SIZE*3)
+	for i := 0; i < 3; i++ {
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		if n, err = dec.Decode(data[:n], out); err != nil || n != FRAME_SIZE {
+			t.Fatalf("Decode = %d, %v; want %d", n, err, FRAME_SIZE)
+		}
+	}
+	for i := 0; i < FRAME_SIZE; i++ {
+		if out[i*3+1] != 0 {
+			t.Fatalf("Silent channel has sample %d at %d", out[i*3+1], i)
+		}
+	}
+
+	stereo, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if got := stereo.ChannelMapping(); got.Family != MappingFamilyRTP || got.Channels != 2 {
+		t.Errorf("Stereo encoder has mapping %+v", got)
+	}
+	if _, err := NewMultistreamDecoder(SAMPLE_RATE, ChannelMapping{Family: MappingFamilyProjection, Channels: 4, Streams: 2, CoupledStreams: 2, Table: make([]byte, 32)}); err == nil {
+		t.Errorf("Expected error for mapping family 3")
+	}
+}
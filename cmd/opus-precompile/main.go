@@ -0,0 +1,57 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+// Command opus-precompile compiles the libopus wasm bridge ahead of time with
+// wazero's compiler and stores the result in a compilation cache directory.
+//
+// The directory is embedded into the opus package when it is built with the
+// opus_precompiled tag, so the runtime loads native code from the cache
+// instead of compiling the module at startup:
+//
+//	go generate -tags opus_precompiled github.com/godeps/opus
+//	go build -tags opus_precompiled ./...
+//
+// Compiled code is specific to the wazero version, GOOS and GOARCH of the
+// machine running this tool. Artifacts that don't match the target are
+// ignored at runtime and the module is compiled as usual.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/tetratelabs/wazero"
+)
+
+func main() {
+	wasmPath := flag.String("wasm", "wasm-bridge/build/wasm_bridge", "path to the wasm bridge binary")
+	outDir := flag.String("o", "precompiled", "output compilation cache directory")
+	flag.Parse()
+
+	wasmBinary, err := os.ReadFile(*wasmPath)
+	if err != nil {
+		log.Fatalf("opus-precompile: %v", err)
+	}
+	if err := os.RemoveAll(*outDir); err != nil {
+		log.Fatalf("opus-precompile: %v", err)
+	}
+
+	ctx := context.Background()
+	cache, err := wazero.NewCompilationCacheWithDir(*outDir)
+	if err != nil {
+		log.Fatalf("opus-precompile: %v", err)
+	}
+	defer cache.Close(ctx)
+
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigCompiler().WithCompilationCache(cache))
+	defer rt.Close(ctx)
+
+	compiled, err := rt.CompileModule(ctx, wasmBinary)
+	if err != nil {
+		log.Fatalf("opus-precompile: failed to compile wasm module: %v", err)
+	}
+	compiled.Close(ctx)
+}
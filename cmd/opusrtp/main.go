@@ -0,0 +1,50 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+// Command opusrtp streams audio over RTP (RFC 7587) for interop testing
+// against softphones and other Opus endpoints.
+//
+// Send a WAV file (16-bit PCM at 8, 12, 16, 24 or 48 kHz) to a peer:
+//
+//	opusrtp send -dst 127.0.0.1:5004 input.wav
+//
+// Receive a stream and record it to a WAV file, concealing losses with
+// in-band FEC when available and PLC otherwise:
+//
+//	opusrtp recv -listen :5004 -rate 48000 -channels 2 output.wav
+//
+// Both modes can run at once against the same peer to exercise both
+// directions of a call.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	fmt.Fprintf(os.Stderr, "  opusrtp send [flags] input.wav\n")
+	fmt.Fprintf(os.Stderr, "  opusrtp recv [flags] output.wav\n")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "send":
+		err = runSend(os.Args[2:])
+	case "recv":
+		err = runRecv(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "opusrtp: %v\n", err)
+		os.Exit(1)
+	}
+}
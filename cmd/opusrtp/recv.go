@@ -0,0 +1,122 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/godeps/opus"
+	"github.com/godeps/opus/rtp"
+	"github.com/godeps/opus/wav"
+)
+
+func runRecv(args []string) error {
+	fs := flag.NewFlagSet("recv", flag.ExitOnError)
+	listen := fs.String("listen", ":5004", "local `address` to receive RTP on")
+	sampleRate := fs.Int("rate", 48000, "output sample rate")
+	channels := fs.Int("channels", 1, "output channels")
+	frameMs := fs.Int("frame", 20, "expected frame duration in ms")
+	delay := fs.Duration("jitter", 60*time.Millisecond, "jitter buffer delay")
+	idle := fs.Duration("idle", 5*time.Second, "stop after this long without packets")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("recv: expected exactly one output file")
+	}
+
+	dec, err := opus.NewDecoder(*sampleRate, *channels)
+	if err != nil {
+		return fmt.Errorf("creating decoder: %w", err)
+	}
+	out, err := wav.Create(fs.Arg(0), wav.Format{SampleRate: *sampleRate, Channels: *channels})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	conn, err := net.ListenPacket("udp", *listen)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	jb := opus.NewJitterBuffer(dec, max(int(*delay/(time.Duration(*frameMs)*time.Millisecond)), 1))
+	var (
+		mu       sync.Mutex
+		started  bool
+		last     = time.Now()
+		received int
+	)
+	go func() {
+		buf := make([]byte, 1500)
+		var d rtp.Depayloader
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var pkt rtp.Packet
+			if err := pkt.UnmarshalBinary(buf[:n]); err != nil {
+				log.Printf("dropping packet: %v", err)
+				continue
+			}
+			frames, err := d.Depayload(pkt)
+			if err != nil {
+				log.Printf("dropping packet %d: %v", pkt.SequenceNumber, err)
+				continue
+			}
+			for _, f := range frames {
+				jb.Push(f.Frame)
+			}
+			mu.Lock()
+			started = true
+			last = time.Now()
+			received++
+			mu.Unlock()
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	// Up to 120 ms, the longest Opus packet.
+	pcm := make([]int16, *sampleRate*120/1000**channels)
+	ticker := time.NewTicker(time.Duration(*frameMs) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-interrupt:
+			mu.Lock()
+			log.Printf("received %d packets; %+v", received, jb.Stats())
+			mu.Unlock()
+			return nil
+		case now := <-ticker.C:
+			mu.Lock()
+			idleFor, ok, count := now.Sub(last), started, received
+			mu.Unlock()
+			if !ok {
+				continue
+			}
+			if idleFor > *idle {
+				log.Printf("idle timeout; received %d packets; %+v", count, jb.Stats())
+				return nil
+			}
+			n, err := jb.Pop(pcm)
+			if err != nil {
+				log.Printf("decoding: %v", err)
+				continue
+			}
+			if err := out.WriteInt16(pcm[:n**channels]); err != nil {
+				return err
+			}
+		}
+	}
+}
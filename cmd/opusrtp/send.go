@@ -0,0 +1,103 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/godeps/opus"
+	"github.com/godeps/opus/rtp"
+	"github.com/godeps/opus/wav"
+)
+
+func runSend(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	dst := fs.String("dst", "127.0.0.1:5004", "destination `address`")
+	payloadType := fs.Int("pt", 111, "RTP payload type")
+	frameMs := fs.Int("frame", 20, "frame duration in ms (10, 20, 40 or 60)")
+	bitrate := fs.Int("bitrate", 32000, "encoder bitrate in bit/s")
+	fec := fs.Bool("fec", true, "enable in-band FEC")
+	loss := fs.Int("loss", 10, "expected packet loss percentage (tunes FEC)")
+	dtx := fs.Bool("dtx", false, "enable DTX")
+	loop := fs.Bool("loop", false, "loop the input until interrupted")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("send: expected exactly one input file")
+	}
+
+	pcm, format, err := wav.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	sampleRate, channels := format.SampleRate, format.Channels
+	enc, err := opus.NewEncoder(sampleRate, channels, opus.AppVoIP)
+	if err != nil {
+		return fmt.Errorf("creating encoder: %w", err)
+	}
+	fecOn := *fec
+	lossPerc := *loss
+	dtxOn := *dtx
+	err = enc.Apply(opus.EncoderConfigDelta{
+		Bitrate:        bitrate,
+		InBandFEC:      &fecOn,
+		PacketLossPerc: &lossPerc,
+		DTX:            &dtxOn,
+	})
+	if err != nil {
+		return fmt.Errorf("configuring encoder: %w", err)
+	}
+
+	conn, err := net.Dial("udp", *dst)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	frameSize := sampleRate * *frameMs / 1000 // samples per channel
+	if len(pcm) < frameSize*channels {
+		return fmt.Errorf("send: input shorter than one frame")
+	}
+	payloader := rtp.NewPayloader(uint8(*payloadType), rand.Uint32(), uint16(rand.Uint32()), rand.Uint32())
+	data := make([]byte, opus.DefaultMaxPacketSize)
+	frame := make([]int16, frameSize*channels)
+	var buf []byte
+	sent := 0
+
+	ticker := time.NewTicker(time.Duration(*frameMs) * time.Millisecond)
+	defer ticker.Stop()
+	for offset := 0; ; offset += frameSize * channels {
+		if offset+len(frame) > len(pcm) {
+			if !*loop {
+				break
+			}
+			offset = 0
+		}
+		copy(frame, pcm[offset:offset+len(frame)])
+		n, err := enc.Encode(frame, data)
+		if err != nil {
+			return fmt.Errorf("encoding: %w", err)
+		}
+		// DTX updates aren't sent, so the receiver sees a timestamp gap.
+		pkt, ok, err := payloader.Payload(data[:n])
+		if err != nil {
+			return fmt.Errorf("payloading: %w", err)
+		}
+		if ok {
+			buf = pkt.Append(buf[:0])
+			if _, err := conn.Write(buf); err != nil {
+				return err
+			}
+			sent++
+		}
+		<-ticker.C
+	}
+	log.Printf("sent %d packets to %s", sent, *dst)
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ConcealmentStats counts the frames a ConcealmentManager produced.
+type ConcealmentStats struct {
+	// Decoded, FEC, PLC and Silence count the frames decoded from their
+	// packet, recovered from in-band FEC, concealed with PLC and replaced
+	// by silence.
+	Decoded, FEC, PLC, Silence int
+	// Consecutive is the number of frames lost in a row so far, and
+	// LongestRun the highest it has been.
+	Consecutive, LongestRun int
+}
+
+// ConcealmentManager decodes a stream with losses like
+// Decoder.DecodeWithLoss, and keeps long losses from turning into minutes
+// of synthetic audio. PLC extrapolates the last audio it heard, which sounds
+// natural for a few frames but turns into a drone over a long outage, so
+// after maxPLC lost frames in a row the concealment fades out and the
+// following frames are silence. The first frame decoded after silence
+// fades back in to avoid a click.
+type ConcealmentManager struct {
+	dec    *Decoder
+	maxPLC int
+
+	mu     sync.Mutex
+	silent bool
+	stats  ConcealmentStats
+}
+
+// NewConcealmentManager returns a ConcealmentManager decoding with dec that
+// conceals up to maxPLC consecutive lost frames with PLC before fading to
+// silence; 0 means 5, or 100 ms of 20 ms frames.
+func NewConcealmentManager(dec *Decoder, maxPLC int) *ConcealmentManager {
+	if maxPLC <= 0 {
+		maxPLC = 5
+	}
+	return &ConcealmentManager{dec: dec, maxPLC: maxPLC}
+}
+
+// Decode decodes packet into pcm, or recovers it if it is nil, see
+// Decoder.DecodeWithLoss. It returns the number of samples per channel
+// written.
+func (m *ConcealmentManager) Decode(packet, next []byte, pcm []int16) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(packet) == 0 && m.stats.Consecutive >= m.maxPLC {
+		if fec, _ := PacketHasFEC(next); !fec {
+			return m.silence(pcm)
+		}
+	}
+
+	n, method, err := m.dec.DecodeWithLoss(packet, next, pcm)
+	if err != nil {
+		return 0, err
+	}
+	frame := pcm[:n*m.dec.channels]
+	switch method {
+	case DecodedPacket, DecodedFEC:
+		if method == DecodedPacket {
+			m.stats.Decoded++
+		} else {
+			m.stats.FEC++
+		}
+		m.stats.Consecutive = 0
+		if m.silent {
+			fade(frame, m.dec.channels, false)
+			m.silent = false
+		}
+	case DecodedPLC:
+		m.stats.PLC++
+		m.lost()
+		if m.stats.Consecutive == m.maxPLC {
+			fade(frame, m.dec.channels, true)
+			m.silent = true
+		}
+	}
+	return n, nil
+}
+
+// silence writes a lost frame of silence to pcm.
+func (m *ConcealmentManager) silence(pcm []int16) (int, error) {
+	frameSize, err := m.dec.lostFrameSize(nil)
+	if err != nil {
+		return 0, err
+	}
+	size := frameSize * m.dec.channels
+	if size > len(pcm) {
+		return 0, fmt.Errorf("opus: PCM buffer of %d samples too short for a %d sample frame", len(pcm), size)
+	}
+	clear(pcm[:size])
+	m.stats.Silence++
+	m.lost()
+	m.silent = true
+	return frameSize, nil
+}
+
+// lost counts a lost frame.
+func (m *ConcealmentManager) lost() {
+	m.stats.Consecutive++
+	m.stats.LongestRun = max(m.stats.LongestRun, m.stats.Consecutive)
+}
+
+// Stats returns the counts of frames produced so far.
+func (m *ConcealmentManager) Stats() ConcealmentStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// fade applies a linear fade across the interleaved frame pcm, out to
+// silence or in from it.
+func fade(pcm []int16, channels int, out bool) {
+	n := len(pcm) / channels
+	for i := 0; i < n; i++ {
+		gain := float32(i) / float32(n)
+		if out {
+			gain = 1 - gain
+		}
+		for c := 0; c < channels; c++ {
+			pcm[i*channels+c] = int16(float32(pcm[i*channels+c]) * gain)
+		}
+	}
+}
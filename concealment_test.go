@@ -0,0 +1,76 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+)
+
+func TestConcealmentManager(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	var packets [][]byte
+	for i := 0; i < 3; i++ {
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		packets = append(packets, data[:n])
+	}
+
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	m := NewConcealmentManager(dec, 3)
+	out := make([]int16, maxFrameSize48k)
+	decode := func(packet []byte) []int16 {
+		t.Helper()
+		n, err := m.Decode(packet, nil, out)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if n != FRAME_SIZE {
+			t.Fatalf("Decoded %d samples, want %d", n, FRAME_SIZE)
+		}
+		return out[:n]
+	}
+	decode(packets[0])
+	decode(packets[1])
+	for i := 0; i < 6; i++ {
+		frame := decode(nil)
+		switch {
+		case i == 2:
+			// The last PLC frame fades out.
+			if v := frame[FRAME_SIZE-1]; v > 100 || v < -100 {
+				t.Errorf("Faded PLC frame ends with %d", v)
+			}
+		case i > 2:
+			for j, v := range frame {
+				if v != 0 {
+					t.Fatalf("Lost frame %d: sample %d is %d, want silence", i, j, v)
+				}
+			}
+		}
+	}
+	if got := m.Stats(); got.Consecutive != 6 {
+		t.Errorf("Got %d consecutive losses, want 6", got.Consecutive)
+	}
+	// Audio after silence fades in.
+	if frame := decode(packets[2]); frame[0] != 0 {
+		t.Errorf("First sample after silence is %d, want 0", frame[0])
+	}
+	want := ConcealmentStats{Decoded: 3, PLC: 3, Silence: 3, LongestRun: 6}
+	if got := m.Stats(); got != want {
+		t.Errorf("Got stats %+v, want %+v", got, want)
+	}
+}
@@ -8,35 +8,83 @@ package opus
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/tetratelabs/wazero/api" // Added for api.Function type
 	// "unsafe" // Only needed if byte slice helpers using unsafe are copied here directly
-	// "log" // Only if new logging specific to decoder is added
 )
 
 var errDecUninitialized = fmt.Errorf("opus decoder uninitialized")
 
-// Decoder contains the state of an Opus decoder using WebAssembly.
+// ErrDecoderCtlUnavailable is returned by decoder CTLs that need the
+// opus_decoder_ctl export when the loaded wasm module doesn't provide it.
+var ErrDecoderCtlUnavailable = errors.New("opus: wasm module does not export opus_decoder_ctl")
+
+// Decoder contains the state of an Opus decoder using WebAssembly. Like an
+// Encoder, it runs in a wasm instance of its own and serializes its calls.
 type Decoder struct {
 	wctx        *wasmContext // Shared Wasm context
 	decoderPtr  uint32       // Pointer to the OpusDecoder struct in Wasm memory
 	sample_rate int
 	channels    int
-	mu          sync.Mutex
+	// layout is set for more than two channels. Each stream then has its
+	// own OpusDecoder in streamPtrs, and streamPtrs[0] == decoderPtr.
+	layout     *ChannelMapping
+	streamPtrs []uint32
+	watchdog   watchdogState
+	audit      auditor
+	lastPacket []byte // copy of the last packet passed to a decode call
+	// gain and complexity are the values last set by CTL request, which
+	// reinitLocked restores after opus_decoder_init.
+	gain       int32
+	complexity int32
+	// buf holds the output PCM followed by the input packet of every
+	// decode call, so decoding doesn't allocate wasm memory per frame.
+	buf wasmBuffer
+	mu  sync.Mutex
 	// module, malloc, free are now accessed via wctx
 }
 
 // NewDecoder allocates a new Opus decoder and initializes it.
 // wasmBinary is the []byte content of the opus.wasm file.
+// sampleRate must be 8000, 12000, 16000, 24000 or 48000.
 func NewDecoder(sampleRate int, channels int) (*Decoder, error) {
+	return newDecoder(sampleRate, channels, nil)
+}
+
+// NewMultistreamDecoder allocates a decoder for multistream packets laid out
+// by mapping, e.g. one read from an Ogg Opus header. Mapping family 3 needs
+// NewProjectionDecoder instead.
+func NewMultistreamDecoder(sampleRate int, mapping ChannelMapping) (*Decoder, error) {
+	if err := mapping.Validate(); err != nil {
+		return nil, err
+	}
+	if mapping.Family == MappingFamilyProjection {
+		return nil, fmt.Errorf("opus: mapping family 3 needs a ProjectionDecoder")
+	}
+	mapping.Table = append([]byte(nil), mapping.Table...)
+	return newDecoder(sampleRate, mapping.Channels, &mapping)
+}
+
+// newDecoder implements NewDecoder and NewMultistreamDecoder. A nil mapping
+// selects the default one for channels.
+func newDecoder(sampleRate int, channels int, mapping *ChannelMapping) (*Decoder, error) {
+	if err := checkSampleRate(sampleRate); err != nil {
+		return nil, err
+	}
 	ctx := context.Background() // Context for initialization
 	wctx, err := GetWasmContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get wasm context for decoder: %w", err)
 	}
+	if wctx.functions.OpusDecoderInit == nil {
+		releaseWasmContext(wctx)
+		return nil, ErrDecoderUnavailable
+	}
 
 	// malloc and free are now part of wctx
 	// if wctx.module == nil || wctx.malloc == nil || wctx.free == nil {
@@ -49,7 +97,9 @@ func NewDecoder(sampleRate int, channels int) (*Decoder, error) {
 		channels:    channels,
 	}
 
-	err = dec.Init(sampleRate, channels)
+	dec.mu.Lock()
+	err = dec.initLocked(sampleRate, channels, mapping)
+	dec.mu.Unlock()
 	if err != nil {
 		releaseWasmContext(dec.wctx)
 		return nil, err
@@ -61,12 +111,19 @@ func NewDecoder(sampleRate int, channels int) (*Decoder, error) {
 		defer d.mu.Unlock()
 		if d.decoderPtr != 0 && d.wctx != nil && d.wctx.functions.Free != nil {
 			// Similar to Encoder, use context.Background() cautiously.
-			// Directly call Free here as freeMemory helper returns an error we can't easily handle in a finalizer.
-			_, finErr := d.wctx.functions.Free.Call(context.Background(), uint64(d.decoderPtr))
-			if finErr != nil {
-				fmt.Printf("opus: error freeing Wasm decoder memory in finalizer: %v\n", finErr)
+			// Directly call free here as freeMemory helper returns an error we can't easily handle in a finalizer.
+			for _, ptr := range d.states() {
+				if finErr := d.wctx.free(context.Background(), ptr); finErr != nil {
+					logger().Warn("opus: error freeing Wasm decoder memory in finalizer", "err", finErr)
+				}
 			}
 			d.decoderPtr = 0 // Mark as freed
+			d.streamPtrs = nil
+		}
+		if d.wctx != nil {
+			if finErr := d.buf.free(context.Background(), d.wctx); finErr != nil {
+				logger().Warn("opus: error freeing Wasm decoder buffer in finalizer", "err", finErr)
+			}
 		}
 		if d.wctx != nil {
 			releaseWasmContext(d.wctx)
@@ -78,87 +135,172 @@ func NewDecoder(sampleRate int, channels int) (*Decoder, error) {
 
 // Init initializes a pre-allocated opus decoder.
 func (dec *Decoder) Init(sampleRate int, channels int) error {
+	if err := checkSampleRate(sampleRate); err != nil {
+		return err
+	}
 	dec.mu.Lock()
 	defer dec.mu.Unlock()
+	return dec.initLocked(sampleRate, channels, nil)
+}
 
+// initLocked implements Init. A nil mapping selects the default one for
+// channels. Callers must hold dec.mu.
+func (dec *Decoder) initLocked(sampleRate int, channels int, mapping *ChannelMapping) error {
 	if dec.decoderPtr != 0 {
 		return fmt.Errorf("opus decoder already initialized")
 	}
-	if channels != 1 && channels != 2 {
-		return fmt.Errorf("number of channels must be 1 or 2: %d", channels)
+	if mapping == nil && (channels < 1 || channels > maxChannels) {
+		return fmt.Errorf("number of channels must be between 1 and %d: %d", maxChannels, channels)
 	}
-
 	if dec.wctx == nil || dec.wctx.module == nil {
 		return fmt.Errorf("wasm context or module not initialized in decoder")
 	}
 	ctx := context.Background()
 
+	if mapping == nil {
+		m, err := defaultMapping(channels)
+		if err != nil {
+			return err
+		}
+		mapping = &m
+	}
+	if mapping.multistream() {
+		dec.layout = mapping
+	}
+	if dec.layout == nil {
+		ptr, err := dec.newState(ctx, sampleRate, channels)
+		if err != nil {
+			return err
+		}
+		dec.decoderPtr = ptr
+	} else {
+		for s := 0; s < dec.layout.Streams; s++ {
+			ptr, err := dec.newState(ctx, sampleRate, dec.layout.streamChannels(s))
+			if err != nil {
+				for _, p := range dec.streamPtrs {
+					dec.wctx.freeMemory(ctx, p)
+				}
+				dec.streamPtrs = nil
+				return err
+			}
+			dec.streamPtrs = append(dec.streamPtrs, ptr)
+		}
+		dec.decoderPtr = dec.streamPtrs[0]
+	}
+
+	dec.sample_rate = sampleRate
+	dec.channels = channels
+	return nil
+}
+
+// newState allocates and initializes a single-stream OpusDecoder.
+func (dec *Decoder) newState(ctx context.Context, sampleRate int, channels int) (uint32, error) {
 	opusDecoderGetSize := dec.wctx.functions.OpusDecoderGetSize
 	if opusDecoderGetSize == nil {
-		return fmt.Errorf("opus_decoder_get_size not found in Wasm functions cache")
+		return 0, fmt.Errorf("opus_decoder_get_size not found in Wasm functions cache")
 	}
 
 	results, err := opusDecoderGetSize.Call(ctx, uint64(channels))
 	if err != nil {
-		return fmt.Errorf("opus_decoder_get_size call failed: %w", err)
+		return 0, fmt.Errorf("opus_decoder_get_size call failed: %w", err)
 	}
 	size := uint32(results[0])
 
 	if dec.wctx.functions.Malloc == nil {
-		return fmt.Errorf("wasm malloc function not initialized in decoder")
+		return 0, fmt.Errorf("wasm malloc function not initialized in decoder")
 	}
-	results, err = dec.wctx.functions.Malloc.Call(ctx, uint64(size))
+	ptr, err := dec.wctx.malloc(ctx, size)
 	if err != nil {
-		return fmt.Errorf("wasm malloc for decoder failed: %w", err)
+		return 0, fmt.Errorf("wasm malloc for decoder failed: %w", err)
 	}
-	dec.decoderPtr = uint32(results[0])
-	if dec.decoderPtr == 0 {
-		return fmt.Errorf("wasm malloc returned NULL for decoder")
+	if ptr == 0 {
+		return 0, fmt.Errorf("wasm malloc returned NULL for decoder")
 	}
 
 	opusDecoderInit := dec.wctx.functions.OpusDecoderInit
 	if opusDecoderInit == nil {
-		dec.wctx.freeMemory(ctx, dec.decoderPtr) // Clean up
-		dec.decoderPtr = 0
-		return fmt.Errorf("opus_decoder_init not found in Wasm functions cache")
+		dec.wctx.freeMemory(ctx, ptr) // Clean up
+		return 0, fmt.Errorf("opus_decoder_init not found in Wasm functions cache")
 	}
 
-	results, err = opusDecoderInit.Call(ctx, uint64(dec.decoderPtr), uint64(int32(sampleRate)), uint64(int32(channels)))
+	results, err = opusDecoderInit.Call(ctx, uint64(ptr), uint64(int32(sampleRate)), uint64(int32(channels)))
 	if err != nil {
-		dec.wctx.freeMemory(ctx, dec.decoderPtr) // Clean up
-		dec.decoderPtr = 0
-		return fmt.Errorf("opus_decoder_init call failed: %w", err)
+		dec.wctx.freeMemory(ctx, ptr) // Clean up
+		return 0, fmt.Errorf("opus_decoder_init call failed: %w", err)
 	}
 	errno := int32(results[0])
 	if errno != opusOk { // opusOk is a global constant
-		dec.wctx.freeMemory(ctx, dec.decoderPtr) // Clean up
-		dec.decoderPtr = 0
-		return Error(int(errno))
+		dec.wctx.freeMemory(ctx, ptr) // Clean up
+		return 0, Error(int(errno))
 	}
+	return ptr, nil
+}
 
-	dec.sample_rate = sampleRate
-	dec.channels = channels
-	return nil
+// states returns the OpusDecoder of every stream.
+func (dec *Decoder) states() []uint32 {
+	if dec.layout == nil {
+		return []uint32{dec.decoderPtr}
+	}
+	return dec.streamPtrs
 }
 
-func (dec *Decoder) decodeInternal(data []byte, pcmPtr uint32, frameSize int, decodeFEC int, isFloat bool) (int, error) {
+// SampleRate returns the output sample rate the decoder was initialized with.
+// The value is the one passed to opus_decoder_init, so it is answered in Go
+// without a CTL call.
+func (dec *Decoder) SampleRate() (int, error) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+
 	if dec.decoderPtr == 0 || dec.wctx == nil {
 		return 0, errDecUninitialized
 	}
+	return dec.sample_rate, nil
+}
+
+// ChannelMapping returns the channel mapping of the packets the decoder
+// expects.
+func (dec *Decoder) ChannelMapping() ChannelMapping {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+
+	if dec.layout == nil {
+		m, _ := defaultMapping(dec.channels)
+		return m
+	}
+	m := *dec.layout
+	m.Table = append([]byte(nil), m.Table...)
+	return m
+}
 
-	ctx := context.Background()
-	var dataPtr uint32
-	var err error
+// Channels returns the number of interleaved output channels.
+func (dec *Decoder) Channels() int {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+	return dec.channels
+}
+
+// decodeInternal decodes data, or conceals a lost packet if it is empty,
+// into the first pcmBytes of dec.buf. It returns the number of samples per
+// channel and the address of the PCM. Callers must hold dec.mu.
+func (dec *Decoder) decodeInternal(ctx context.Context, data []byte, pcmBytes int, frameSize int, decodeFEC int, isFloat bool) (int, uint32, error) {
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return 0, 0, errDecUninitialized
+	}
+	if dec.layout != nil {
+		return dec.decodeMultistreamLocked(ctx, data, pcmBytes, frameSize, decodeFEC, isFloat)
+	}
 
+	// For PLC, data is NULL (represented by 0 pointer) and length is 0
+	pcmPtr, dataPtr, err := dec.reserveLocked(ctx, pcmBytes, len(data))
+	if err != nil {
+		return 0, 0, err
+	}
 	if len(data) > 0 {
-		dataPtr, err = dec.wctx.writeToMemory(ctx, data) // Use method from wasmContext
-		if err != nil {
-			return 0, fmt.Errorf("failed to write input data to Wasm memory: %w", err)
+		if !dec.wctx.module.Memory().Write(dataPtr, data) {
+			return 0, 0, fmt.Errorf("failed to write input data to Wasm memory")
 		}
-		defer dec.wctx.freeMemory(ctx, dataPtr) // Use free from wasmContext
 	} else {
-		// For PLC, data is NULL (represented by 0 pointer) and length is 0
-		dataPtr = 0 // Remains 0 if data is nil or empty, writeToMemory handles malloc(0) if needed
+		dataPtr = 0
 	}
 
 	dataLen := len(data)
@@ -178,9 +320,10 @@ func (dec *Decoder) decodeInternal(data []byte, pcmPtr uint32, frameSize int, de
 	}
 
 	if decodeFunc == nil {
-		return 0, fmt.Errorf("%s not found in Wasm functions cache", funcNameForLog)
+		return 0, 0, fmt.Errorf("%s not found in Wasm functions cache", funcNameForLog)
 	}
 
+	start := time.Now()
 	results, err := decodeFunc.Call(ctx,
 		uint64(dec.decoderPtr),
 		uint64(dataPtr),          // pointer to encoded data, or 0 for PLC
@@ -190,21 +333,49 @@ func (dec *Decoder) decodeInternal(data []byte, pcmPtr uint32, frameSize int, de
 		uint64(int32(decodeFEC)), // 0 for no FEC, 1 for FEC
 	)
 	if err != nil {
-		return 0, fmt.Errorf("%s call failed: %w", funcNameForLog, err)
+		return 0, 0, callError(ctx, funcNameForLog, err)
 	}
 
 	samplesDecoded := int32(results[0])
 	if samplesDecoded < 0 {
-		return 0, Error(int(samplesDecoded))
+		return 0, 0, dec.watchdog.observe(Error(int(samplesDecoded)), dec.reinitLocked)
+	}
+	dec.watchdog.succeeded()
+	if decodeFEC == 0 && len(data) > 0 {
+		dec.lastPacket = append(dec.lastPacket[:0], data...)
+		dec.audit.record(AuditDecode, data, int(samplesDecoded), dec.sample_rate)
+	}
+	frameDecoded(data, decodeFEC, int(samplesDecoded), dec.sample_rate, dec.channels, time.Since(start))
+	return int(samplesDecoded), pcmPtr, nil
+}
+
+// reserveLocked grows dec.buf to pcmBytes for the decoded PCM followed by
+// extra bytes, and returns the addresses of both. Callers must hold dec.mu.
+func (dec *Decoder) reserveLocked(ctx context.Context, pcmBytes, extra int) (pcmPtr, extraPtr uint32, err error) {
+	pcmPtr, err = dec.buf.reserve(ctx, dec.wctx, uint32(pcmBytes+extra))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to allocate Wasm memory for decoding: %w", err)
 	}
-	return int(samplesDecoded), nil
+	return pcmPtr, pcmPtr + uint32(pcmBytes), nil
 }
 
 // Decode encoded Opus data into the supplied int16 PCM buffer.
 // Returns the number of decoded samples per channel.
 func (dec *Decoder) Decode(data []byte, pcm []int16) (int, error) {
+	return dec.DecodeContext(context.Background(), data, pcm)
+}
+
+// DecodeContext is Decode with a context. If ctx is cancelled while libopus
+// runs, the call is interrupted and returns ctx's error; the wasm instance
+// is then closed, so the Decoder can't be used any more.
+func (dec *Decoder) DecodeContext(ctx context.Context, data []byte, pcm []int16) (int, error) {
 	dec.mu.Lock()
-	defer dec.mu.Unlock()
+	defer dec.unlockAndNotify()
+
+	// Don't start a call that would only close the wasm instance.
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 
 	if dec.wctx == nil {
 		return 0, errDecUninitialized
@@ -216,24 +387,11 @@ func (dec *Decoder) Decode(data []byte, pcm []int16) (int, error) {
 		return 0, fmt.Errorf("opus: target PCM buffer capacity must be multiple of channels")
 	}
 
-	ctx := context.Background()
-	// pcmLenBytes := len(pcm) * 2 // 2 bytes per int16. This is for current length, cap is for max.
-	// Max possible output size based on capacity
 	pcmAllocSizeBytes := cap(pcm) * 2
 
-	// We need to allocate memory for PCM output.
-	// The current content of pcmDataForWasm (zeros) doesn't matter as Opus will overwrite it.
-	// The size must be based on the capacity of the Go pcm slice to hold the decoded data.
-	pcmDataForWasm := make([]byte, pcmAllocSizeBytes)          // Allocate based on capacity
-	pcmPtr, err := dec.wctx.writeToMemory(ctx, pcmDataForWasm) // Effectively allocates
-	if err != nil {
-		return 0, fmt.Errorf("failed to allocate Wasm memory for PCM output: %w", err)
-	}
-	defer dec.wctx.freeMemory(ctx, pcmPtr)
-
 	// frameSize is samples per channel, pcmLenBytes is total bytes for allocation
 	frameSize := cap(pcm) / dec.channels
-	samplesDecoded, err := dec.decodeInternal(data, pcmPtr, frameSize, 0, false)
+	samplesDecoded, pcmPtr, err := dec.decodeInternal(ctx, data, pcmAllocSizeBytes, frameSize, 0, false)
 	if err != nil {
 		return 0, err
 	}
@@ -259,8 +417,18 @@ func (dec *Decoder) Decode(data []byte, pcm []int16) (int, error) {
 // DecodeFloat32 encoded Opus data into the supplied float32 PCM buffer.
 // Returns the number of decoded samples per channel.
 func (dec *Decoder) DecodeFloat32(data []byte, pcm []float32) (int, error) {
+	return dec.DecodeFloat32Context(context.Background(), data, pcm)
+}
+
+// DecodeFloat32Context is DecodeFloat32 with a context, see DecodeContext.
+func (dec *Decoder) DecodeFloat32Context(ctx context.Context, data []byte, pcm []float32) (int, error) {
 	dec.mu.Lock()
-	defer dec.mu.Unlock()
+	defer dec.unlockAndNotify()
+
+	// Don't start a call that would only close the wasm instance.
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 
 	if dec.wctx == nil {
 		return 0, errDecUninitialized
@@ -272,19 +440,10 @@ func (dec *Decoder) DecodeFloat32(data []byte, pcm []float32) (int, error) {
 		return 0, fmt.Errorf("opus: target PCM buffer capacity must be multiple of channels")
 	}
 
-	ctx := context.Background()
-	// pcmLenBytes := len(pcm) * 4 // 4 bytes per float32. For current length.
-	pcmAllocSizeBytes := cap(pcm) * 4 // For capacity
-
-	pcmDataForWasm := make([]byte, pcmAllocSizeBytes)          // Allocate based on capacity
-	pcmPtr, err := dec.wctx.writeToMemory(ctx, pcmDataForWasm) // Effectively allocates
-	if err != nil {
-		return 0, fmt.Errorf("failed to allocate Wasm memory for PCM output: %w", err)
-	}
-	defer dec.wctx.freeMemory(ctx, pcmPtr)
+	pcmAllocSizeBytes := cap(pcm) * 4
 
 	frameSize := cap(pcm) / dec.channels
-	samplesDecoded, err := dec.decodeInternal(data, pcmPtr, frameSize, 0, true)
+	samplesDecoded, pcmPtr, err := dec.decodeInternal(ctx, data, pcmAllocSizeBytes, frameSize, 0, true)
 	if err != nil {
 		return 0, err
 	}
@@ -305,11 +464,42 @@ func (dec *Decoder) DecodeFloat32(data []byte, pcm []float32) (int, error) {
 	return samplesDecoded, nil
 }
 
+// DecodeAll is Decode into a buffer it allocates, sized with NbSamples, and
+// returns the interleaved samples decoded. It trades an allocation per
+// packet for not having to size the buffer. data must not be empty; lost
+// packets are concealed with DecodePLC.
+func (dec *Decoder) DecodeAll(data []byte) ([]int16, error) {
+	samples, err := dec.NbSamples(data)
+	if err != nil {
+		return nil, err
+	}
+	pcm := make([]int16, samples*dec.Channels())
+	n, err := dec.Decode(data, pcm)
+	if err != nil {
+		return nil, err
+	}
+	return pcm[:n*dec.Channels()], nil
+}
+
+// DecodeAllFloat32 is DecodeAll for float32 PCM.
+func (dec *Decoder) DecodeAllFloat32(data []byte) ([]float32, error) {
+	samples, err := dec.NbSamples(data)
+	if err != nil {
+		return nil, err
+	}
+	pcm := make([]float32, samples*dec.Channels())
+	n, err := dec.DecodeFloat32(data, pcm)
+	if err != nil {
+		return nil, err
+	}
+	return pcm[:n*dec.Channels()], nil
+}
+
 // DecodeFEC decodes a packet with FEC. pcm must be the size of the lost packet.
 // Returns samples decoded per channel.
 func (dec *Decoder) DecodeFEC(data []byte, pcm []int16) (int, error) {
 	dec.mu.Lock()
-	defer dec.mu.Unlock()
+	defer dec.unlockAndNotify()
 
 	if dec.wctx == nil {
 		return 0, errDecUninitialized
@@ -323,15 +513,9 @@ func (dec *Decoder) DecodeFEC(data []byte, pcm []int16) (int, error) {
 
 	ctx := context.Background()
 	pcmAllocSizeBytes := cap(pcm) * 2
-	pcmDataForWasm := make([]byte, pcmAllocSizeBytes)
-	pcmPtr, err := dec.wctx.writeToMemory(ctx, pcmDataForWasm)
-	if err != nil {
-		return 0, fmt.Errorf("failed to allocate Wasm memory for FEC PCM output: %w", err)
-	}
-	defer dec.wctx.freeMemory(ctx, pcmPtr)
 
 	frameSize := cap(pcm) / dec.channels
-	samplesDecoded, err := dec.decodeInternal(data, pcmPtr, frameSize, 1, false) // decode_fec = 1
+	samplesDecoded, pcmPtr, err := dec.decodeInternal(ctx, data, pcmAllocSizeBytes, frameSize, 1, false) // decode_fec = 1
 	if err != nil {
 		return 0, err
 	}
@@ -354,7 +538,7 @@ func (dec *Decoder) DecodeFEC(data []byte, pcm []int16) (int, error) {
 // Returns samples decoded per channel.
 func (dec *Decoder) DecodeFECFloat32(data []byte, pcm []float32) (int, error) {
 	dec.mu.Lock()
-	defer dec.mu.Unlock()
+	defer dec.unlockAndNotify()
 
 	if dec.wctx == nil {
 		return 0, errDecUninitialized
@@ -368,15 +552,9 @@ func (dec *Decoder) DecodeFECFloat32(data []byte, pcm []float32) (int, error) {
 
 	ctx := context.Background()
 	pcmAllocSizeBytes := cap(pcm) * 4
-	pcmDataForWasm := make([]byte, pcmAllocSizeBytes)
-	pcmPtr, err := dec.wctx.writeToMemory(ctx, pcmDataForWasm)
-	if err != nil {
-		return 0, fmt.Errorf("failed to allocate Wasm memory for FEC PCM output: %w", err)
-	}
-	defer dec.wctx.freeMemory(ctx, pcmPtr)
 
 	frameSize := cap(pcm) / dec.channels
-	samplesDecoded, err := dec.decodeInternal(data, pcmPtr, frameSize, 1, true) // decode_fec = 1
+	samplesDecoded, pcmPtr, err := dec.decodeInternal(ctx, data, pcmAllocSizeBytes, frameSize, 1, true) // decode_fec = 1
 	if err != nil {
 		return 0, err
 	}
@@ -399,7 +577,7 @@ func (dec *Decoder) DecodeFECFloat32(data []byte, pcm []float32) (int, error) {
 // Returns samples decoded per channel.
 func (dec *Decoder) DecodePLC(pcm []int16) (int, error) {
 	dec.mu.Lock()
-	defer dec.mu.Unlock()
+	defer dec.unlockAndNotify()
 
 	if dec.wctx == nil {
 		return 0, errDecUninitialized
@@ -413,16 +591,9 @@ func (dec *Decoder) DecodePLC(pcm []int16) (int, error) {
 
 	ctx := context.Background()
 	pcmAllocSizeBytes := cap(pcm) * 2
-	pcmDataForWasm := make([]byte, pcmAllocSizeBytes)
-	pcmPtr, err := dec.wctx.writeToMemory(ctx, pcmDataForWasm)
-	if err != nil {
-		return 0, fmt.Errorf("failed to allocate Wasm memory for PLC PCM output: %w", err)
-	}
-	defer dec.wctx.freeMemory(ctx, pcmPtr)
 
 	frameSize := cap(pcm) / dec.channels
-	// For PLC, data is NULL (dataPtr=0) and dataLen is 0. decodeInternal handles data=nil.
-	samplesDecoded, err := dec.decodeInternal(nil, pcmPtr, frameSize, 0, false)
+	samplesDecoded, pcmPtr, err := dec.decodeInternal(ctx, nil, pcmAllocSizeBytes, frameSize, 0, false)
 	if err != nil {
 		return 0, err
 	}
@@ -445,7 +616,7 @@ func (dec *Decoder) DecodePLC(pcm []int16) (int, error) {
 // Returns samples decoded per channel.
 func (dec *Decoder) DecodePLCFloat32(pcm []float32) (int, error) {
 	dec.mu.Lock()
-	defer dec.mu.Unlock()
+	defer dec.unlockAndNotify()
 
 	if dec.wctx == nil {
 		return 0, errDecUninitialized
@@ -459,15 +630,9 @@ func (dec *Decoder) DecodePLCFloat32(pcm []float32) (int, error) {
 
 	ctx := context.Background()
 	pcmAllocSizeBytes := cap(pcm) * 4
-	pcmDataForWasm := make([]byte, pcmAllocSizeBytes)
-	pcmPtr, err := dec.wctx.writeToMemory(ctx, pcmDataForWasm)
-	if err != nil {
-		return 0, fmt.Errorf("failed to allocate Wasm memory for PLC PCM output: %w", err)
-	}
-	defer dec.wctx.freeMemory(ctx, pcmPtr)
 
 	frameSize := cap(pcm) / dec.channels
-	samplesDecoded, err := dec.decodeInternal(nil, pcmPtr, frameSize, 0, true)
+	samplesDecoded, pcmPtr, err := dec.decodeInternal(ctx, nil, pcmAllocSizeBytes, frameSize, 0, true)
 	if err != nil {
 		return 0, err
 	}
@@ -520,3 +685,269 @@ func (dec *Decoder) LastPacketDuration() (int, error) {
 	}
 	return int(samplesValue), nil
 }
+
+// NbSamples returns the number of samples per channel that decoding packet
+// will produce, so PCM buffers can be sized exactly. It is a Go port of
+// opus_decoder_get_nb_samples and doesn't touch the decoder state.
+func (dec *Decoder) NbSamples(packet []byte) (int, error) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return 0, errDecUninitialized
+	}
+	if dec.layout != nil {
+		// All streams of a multistream packet have the same duration.
+		packets, err := dec.layout.splitMultistream(packet)
+		if err != nil {
+			return 0, err
+		}
+		packet = packets[0]
+	}
+	return packetSampleCount(packet, dec.sample_rate)
+}
+
+// Bandwidth returns the audio bandwidth of the last packet passed to Decode
+// or DecodeFloat32. Like LastPacketInfo it reads the packet's TOC byte in Go
+// rather than querying the wasm decoder.
+func (dec *Decoder) Bandwidth() (Bandwidth, error) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return 0, errDecUninitialized
+	}
+	if len(dec.lastPacket) == 0 {
+		return 0, fmt.Errorf("opus: no packet decoded yet")
+	}
+	return tocBandwidth(dec.lastPacket[0]), nil
+}
+
+// CTL request codes from opus_defines.h for decoder settings without a
+// dedicated bridge function.
+const (
+	opusSetComplexityRequest = 4010
+	opusGetComplexityRequest = 4011
+	opusGetPitchRequest      = 4033
+	opusSetGainRequest       = 4034
+	opusGetGainRequest       = 4045
+)
+
+// decoderCtlLocked calls opus_decoder_ctl(st, request, args...). Setters
+// (even requests) go to every stream of a surround decoder, getters to the
+// first one. The caller must hold dec.mu.
+func (dec *Decoder) decoderCtlLocked(ctx context.Context, request int32, args ...uint32) error {
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return errDecUninitialized
+	}
+	ctlFunc := dec.wctx.functions.OpusDecoderCtl
+	if ctlFunc == nil {
+		return ErrDecoderCtlUnavailable
+	}
+	if request%2 != 0 {
+		return dec.wctx.callCtl(ctx, ctlFunc, "opus_decoder_ctl", dec.decoderPtr, request, args...)
+	}
+	for _, ptr := range dec.states() {
+		if err := dec.wctx.callCtl(ctx, ctlFunc, "opus_decoder_ctl", ptr, request, args...); err != nil {
+			return err
+		}
+	}
+	if len(args) == 1 {
+		switch request {
+		case opusSetGainRequest:
+			dec.gain = int32(args[0])
+		case opusSetComplexityRequest:
+			dec.complexity = int32(args[0])
+		}
+	}
+	return nil
+}
+
+// setRequest issues a setter CTL taking a single opus_int32 value.
+func (dec *Decoder) setRequest(request int32, value int32) error {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+	return dec.decoderCtlLocked(context.Background(), request, uint32(value))
+}
+
+// getRequest issues a getter CTL writing a single opus_int32 value.
+func (dec *Decoder) getRequest(request int32) (int32, error) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return 0, errDecUninitialized
+	}
+	ctx := context.Background()
+	valPtr, err := dec.wctx.allocateInt32Ptr(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer dec.wctx.freeMemory(ctx, valPtr)
+	if err := dec.decoderCtlLocked(ctx, request, valPtr); err != nil {
+		return 0, err
+	}
+	value, ok := dec.wctx.module.Memory().ReadUint32Le(valPtr)
+	if !ok {
+		return 0, fmt.Errorf("failed to read value from Wasm memory for ctl request %d", request)
+	}
+	return int32(value), nil
+}
+
+// Ctl issues an arbitrary decoder CTL request (see opus_defines.h) for
+// settings this package does not wrap yet, with the same argument and
+// return conventions as Encoder.Ctl. It returns ErrDecoderCtlUnavailable if
+// the wasm module doesn't export opus_decoder_ctl.
+func (dec *Decoder) Ctl(request int32, args ...int32) (int32, error) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return 0, errDecUninitialized
+	}
+	ctx := context.Background()
+	wasmArgs := make([]uint32, len(args), len(args)+1)
+	for i, arg := range args {
+		wasmArgs[i] = uint32(arg)
+	}
+	if request%2 == 0 {
+		return 0, dec.decoderCtlLocked(ctx, request, wasmArgs...)
+	}
+	valPtr, err := dec.wctx.allocateInt32Ptr(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer dec.wctx.freeMemory(ctx, valPtr)
+	if err := dec.decoderCtlLocked(ctx, request, append(wasmArgs, valPtr)...); err != nil {
+		return 0, err
+	}
+	value, ok := dec.wctx.module.Memory().ReadUint32Le(valPtr)
+	if !ok {
+		return 0, fmt.Errorf("failed to read value from Wasm memory for ctl request %d", request)
+	}
+	return int32(value), nil
+}
+
+// Pitch returns the pitch period, in samples at 48 kHz, that the decoder
+// estimated for the last decoded frame, or 0 for unvoiced frames. It needs a
+// wasm module exporting opus_decoder_ctl and returns ErrDecoderCtlUnavailable
+// otherwise.
+func (dec *Decoder) Pitch() (int, error) {
+	val, err := dec.getRequest(opusGetPitchRequest)
+	return int(val), err
+}
+
+// SetComplexity sets the decoder's computational complexity (0-10). Since
+// libopus 1.5 higher values enable the neural deep PLC and speech
+// enhancement models, when those are compiled into the wasm module. It returns ErrDecoderCtlUnavailable if the module doesn't export
+// opus_decoder_ctl.
+func (dec *Decoder) SetComplexity(complexity int) error {
+	return dec.setRequest(opusSetComplexityRequest, int32(complexity))
+}
+
+// Complexity gets the decoder's computational complexity.
+func (dec *Decoder) Complexity() (int, error) {
+	val, err := dec.getRequest(opusGetComplexityRequest)
+	return int(val), err
+}
+
+// SetGain sets the gain applied to the decoded output, in Q7.8 dB
+// (1/256 dB steps) from -32768 to 32767, such as the OpusHead output gain. It
+// returns ErrDecoderCtlUnavailable if the module doesn't export
+// opus_decoder_ctl.
+func (dec *Decoder) SetGain(gainQ8 int) error {
+	return dec.setRequest(opusSetGainRequest, int32(gainQ8))
+}
+
+// Gain gets the decoder's output gain in Q7.8 dB.
+func (dec *Decoder) Gain() (int, error) {
+	val, err := dec.getRequest(opusGetGainRequest)
+	return int(val), err
+}
+
+// Reset resets the decoder state to be equivalent to a freshly initialized
+// state, e.g. to re-sync a stream after a long gap. The wasm memory of the
+// decoder is reused, and settings such as the gain are kept. Modules without
+// bridge_decoder_reset_state fall back to re-running opus_decoder_init in
+// place and setting the gain and complexity again.
+func (dec *Decoder) Reset() error {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return errDecUninitialized
+	}
+	dec.lastPacket = dec.lastPacket[:0]
+	resetFunc := dec.wctx.functions.BridgeDecoderResetState
+	if resetFunc == nil {
+		return dec.reinitLocked()
+	}
+	for _, ptr := range dec.states() {
+		results, err := resetFunc.Call(context.Background(), uint64(ptr))
+		if err != nil {
+			return fmt.Errorf("bridge_decoder_reset_state call failed: %w", err)
+		}
+		res := int32(results[0])
+		if res != opusOk {
+			return Error(int(res))
+		}
+	}
+	return nil
+}
+
+// PacketInfo describes an Opus packet.
+type PacketInfo struct {
+	// Duration is the number of samples per channel in the packet, at the
+	// decoder's sample rate.
+	Duration int
+	// Bandwidth is the audio bandwidth the packet was coded with.
+	Bandwidth Bandwidth
+	// Channels is the number of coded channels (1 or 2).
+	Channels int
+	// Frames is the number of Opus frames in the packet.
+	Frames int
+	// HasFEC reports whether the packet carries in-band FEC (LBRR) data that
+	// DecodeFEC can use to recover the packet before it.
+	HasFEC bool
+}
+
+// LastPacketInfo returns the metrics of the last packet passed to Decode or
+// DecodeFloat32 (packets used for FEC recovery don't count). The metrics are
+// parsed from the packet framing in Go, so unlike a series of CTL calls this
+// doesn't cost any wasm round trips.
+func (dec *Decoder) LastPacketInfo() (PacketInfo, error) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return PacketInfo{}, errDecUninitialized
+	}
+	if len(dec.lastPacket) == 0 {
+		return PacketInfo{}, fmt.Errorf("opus: no packet decoded yet")
+	}
+	return packetInfo(dec.lastPacket, dec.sample_rate)
+}
+
+// packetInfo gathers the PacketInfo of data for a decoder running at
+// sampleRate.
+func packetInfo(data []byte, sampleRate int) (PacketInfo, error) {
+	p, err := parsePacket(data)
+	if err != nil {
+		return PacketInfo{}, err
+	}
+	duration, err := packetSampleCount(data, sampleRate)
+	if err != nil {
+		return PacketInfo{}, err
+	}
+	fec, err := packetHasLBRR(data)
+	if err != nil {
+		return PacketInfo{}, err
+	}
+	return PacketInfo{
+		Duration:  duration,
+		Bandwidth: tocBandwidth(p.toc),
+		Channels:  tocChannels(p.toc),
+		Frames:    len(p.frames),
+		HasFEC:    fec,
+	}, nil
+}
@@ -0,0 +1,188 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrDecoderStateUnsupported is returned by SaveState and RestoreState with
+// the cgo backend, whose decoder state holds native pointers.
+var ErrDecoderStateUnsupported = errors.New("opus: decoder state can't be saved with the cgo backend")
+
+// decoderStateMagic starts every state returned by Decoder.SaveState,
+// followed by decoderStateVersion, the version of the layout.
+var decoderStateMagic = []byte("OPDS")
+
+const decoderStateVersion = 2
+
+// moduleID identifies a libopus module binary in saved decoder states.
+func moduleID(wasmBinary []byte) []byte {
+	sum := sha256.Sum256(wasmBinary)
+	return sum[:]
+}
+
+// SaveState returns a snapshot of the decoder: the libopus state of each
+// stream and the last packet decoded. RestoreState on a decoder with the same
+// sample rate and channel mapping continues decoding from there, so a long
+// voice session can be checkpointed or handed to another process without an
+// audible reset.
+//
+// The libopus state refers to static data of the module, so it can only be
+// restored with the same WASM module; the state records a hash of it, and a
+// checksum of its contents. The cgo backend returns
+// ErrDecoderStateUnsupported.
+func (dec *Decoder) SaveState() ([]byte, error) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return nil, errDecUninitialized
+	}
+	id := dec.wctx.manager.moduleID
+	if id == nil {
+		return nil, ErrDecoderStateUnsupported
+	}
+	ctx := context.Background()
+	states := dec.states()
+	out := append([]byte(nil), decoderStateMagic...)
+	out = append(out, decoderStateVersion)
+	out = append(out, id...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(dec.sample_rate))
+	out = binary.LittleEndian.AppendUint32(out, uint32(dec.channels))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(states)))
+	for i, ptr := range states {
+		size, err := dec.stateSize(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+		state, ok := dec.wctx.module.Memory().Read(ptr, size)
+		if !ok {
+			return nil, fmt.Errorf("failed to read decoder state from Wasm memory: %d, %d", ptr, size)
+		}
+		out = binary.LittleEndian.AppendUint32(out, size)
+		out = append(out, state...)
+	}
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(dec.lastPacket)))
+	out = append(out, dec.lastPacket...)
+	sum := sha256.Sum256(out)
+	return append(out, sum[:]...), nil
+}
+
+// RestoreState replaces the decoder's state with one returned by SaveState.
+// The decoder must run the same WASM module and have the sample rate and
+// channel mapping of the one that saved it. States that don't match, or
+// whose checksum doesn't, are rejected.
+func (dec *Decoder) RestoreState(state []byte) error {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return errDecUninitialized
+	}
+	id := dec.wctx.manager.moduleID
+	if id == nil {
+		return ErrDecoderStateUnsupported
+	}
+	header := len(decoderStateMagic) + 1 + len(id)
+	if len(state) < header+sha256.Size || !bytes.HasPrefix(state, decoderStateMagic) {
+		return fmt.Errorf("opus: not a decoder state")
+	}
+	if v := state[len(decoderStateMagic)]; v != decoderStateVersion {
+		return fmt.Errorf("opus: decoder state version %d, want %d", v, decoderStateVersion)
+	}
+	body := state[:len(state)-sha256.Size]
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], state[len(body):]) {
+		return fmt.Errorf("opus: decoder state checksum mismatch")
+	}
+	if !bytes.Equal(state[len(decoderStateMagic)+1:header], id) {
+		return fmt.Errorf("opus: decoder state was saved by another libopus module")
+	}
+	r := decoderStateReader{buf: body[header:]}
+	sampleRate, channels, streams := r.uint32(), r.uint32(), r.uint32()
+	states := dec.states()
+	if r.err == nil && (int(sampleRate) != dec.sample_rate || int(channels) != dec.channels || int(streams) != len(states)) {
+		return fmt.Errorf("opus: decoder state is for %d Hz, %d channels in %d streams, decoder has %d Hz, %d channels in %d streams",
+			sampleRate, channels, streams, dec.sample_rate, dec.channels, len(states))
+	}
+
+	ctx := context.Background()
+	saved := make([][]byte, len(states))
+	for i := range states {
+		size, err := dec.stateSize(ctx, i)
+		if err != nil {
+			return err
+		}
+		saved[i] = r.bytes()
+		if r.err == nil && len(saved[i]) != int(size) {
+			return fmt.Errorf("opus: decoder state of stream %d has %d bytes, want %d", i, len(saved[i]), size)
+		}
+	}
+	lastPacket := r.bytes()
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.buf) > 0 {
+		return fmt.Errorf("opus: %d bytes after the decoder state", len(r.buf))
+	}
+
+	for i, ptr := range states {
+		if !dec.wctx.module.Memory().Write(ptr, saved[i]) {
+			return fmt.Errorf("wasm memory write failed")
+		}
+	}
+	dec.lastPacket = append(dec.lastPacket[:0], lastPacket...)
+	return nil
+}
+
+// stateSize returns the size of the OpusDecoder of stream s.
+func (dec *Decoder) stateSize(ctx context.Context, s int) (uint32, error) {
+	channels := dec.channels
+	if dec.layout != nil {
+		channels = dec.layout.streamChannels(s)
+	}
+	results, err := dec.wctx.functions.OpusDecoderGetSize.Call(ctx, uint64(channels))
+	if err != nil {
+		return 0, fmt.Errorf("opus_decoder_get_size call failed: %w", err)
+	}
+	return uint32(results[0]), nil
+}
+
+// decoderStateReader reads the fields of a saved decoder state, recording
+// the first error.
+type decoderStateReader struct {
+	buf []byte
+	err error
+}
+
+func (r *decoderStateReader) uint32() uint32 {
+	if r.err == nil && len(r.buf) < 4 {
+		r.err = fmt.Errorf("opus: truncated decoder state")
+	}
+	if r.err != nil {
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *decoderStateReader) bytes() []byte {
+	n := r.uint32()
+	if r.err == nil && uint32(len(r.buf)) < n {
+		r.err = fmt.Errorf("opus: truncated decoder state")
+	}
+	if r.err != nil {
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
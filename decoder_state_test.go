@@ -0,0 +1,133 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+//go:build !opus_cgo
+
+package opus
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"slices"
+	"testing"
+)
+
+func TestDecoder_SaveState(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	var packets [][]byte
+	for i := 0; i < 10; i++ {
+		pcm := make([]int16, FRAME_SIZE*2)
+		addSine(pcm, SAMPLE_RATE, 220*float64(i+1))
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		packets = append(packets, data[:n])
+	}
+
+	dec, err := NewDecoder(SAMPLE_RATE, 2)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE*2)
+	for _, p := range packets[:5] {
+		if _, err := dec.Decode(p, pcm); err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+	}
+	state, err := dec.SaveState()
+	if err != nil {
+		t.Fatalf("Couldn't save decoder state: %v", err)
+	}
+	restored, err := NewDecoder(SAMPLE_RATE, 2)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	if err := restored.RestoreState(state); err != nil {
+		t.Fatalf("Couldn't restore decoder state: %v", err)
+	}
+
+	// Both continue with the same samples, including concealment.
+	want, got := make([]int16, FRAME_SIZE*2), make([]int16, FRAME_SIZE*2)
+	for i, p := range packets[5:] {
+		if _, err := dec.Decode(p, want); err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+		if _, err := restored.Decode(p, got); err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("Packet %d: restored decoder produced different samples", i+5)
+		}
+	}
+	if _, err := dec.DecodePLC(want); err != nil {
+		t.Fatalf("Couldn't conceal a packet: %v", err)
+	}
+	if _, err := restored.DecodePLC(got); err != nil {
+		t.Fatalf("Couldn't conceal a packet: %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Restored decoder concealed different samples")
+	}
+
+	mono, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	if err := mono.RestoreState(state); err == nil {
+		t.Errorf("Expected an error restoring a stereo state into a mono decoder")
+	}
+	if err := restored.RestoreState(state[:len(state)-10]); err == nil {
+		t.Errorf("Expected an error for a truncated state")
+	}
+	if err := restored.RestoreState([]byte("not a state")); err == nil {
+		t.Errorf("Expected an error for garbage")
+	}
+}
+
+func TestDecoder_RestoreStateRejectsTampering(t *testing.T) {
+	dec, err := NewDecoder(48000, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	state, err := dec.SaveState()
+	if err != nil {
+		t.Fatalf("Couldn't save decoder state: %v", err)
+	}
+	// resign fixes up the checksum of a modified state.
+	resign := func(s []byte) []byte {
+		body := s[:len(s)-sha256.Size]
+		sum := sha256.Sum256(body)
+		return append(append([]byte(nil), body...), sum[:]...)
+	}
+	header := len(decoderStateMagic) + 1
+
+	tampered := bytes.Clone(state)
+	tampered[len(tampered)/2] ^= 0xff
+	otherModule := bytes.Clone(state)
+	otherModule[header] ^= 0xff
+	otherVersion := bytes.Clone(state)
+	otherVersion[len(decoderStateMagic)]++
+	for _, tt := range []struct {
+		name  string
+		state []byte
+	}{
+		{"tampered", tampered},
+		{"other module", resign(otherModule)},
+		{"other version", resign(otherVersion)},
+	} {
+		if err := dec.RestoreState(tt.state); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+	if err := dec.RestoreState(state); err != nil {
+		t.Errorf("Couldn't restore the untouched state: %v", err)
+	}
+}
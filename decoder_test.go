@@ -5,6 +5,7 @@
 package opus
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -17,6 +18,9 @@ func TestDecoderNew(t *testing.T) {
 	if err == nil || dec != nil {
 		t.Errorf("Expected error for illegal samplerate 12345")
 	}
+	if err != nil && !strings.Contains(err.Error(), "8000, 12000, 16000, 24000 or 48000") {
+		t.Errorf("Error for illegal samplerate doesn't list the legal ones: %v", err)
+	}
 }
 
 func TestDecoderUnitialized(t *testing.T) {
@@ -66,3 +70,404 @@ func TestDecoder_GetLastPacketDuration(t *testing.T) {
 		t.Fatalf("Wrong duration length. Expected %d. Got %d", n, samples)
 	}
 }
+
+func TestDecoder_LastPacketInfo(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetInBandFEC(true); err != nil {
+		t.Fatalf("Error enabling FEC: %v", err)
+	}
+	if err := enc.SetPacketLossPerc(30); err != nil {
+		t.Fatalf("Error setting packet loss: %v", err)
+	}
+	if err := enc.SetBitrate(24000); err != nil {
+		t.Fatalf("Error setting bitrate: %v", err)
+	}
+	dec, err := NewDecoder(16000, 2)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	if _, err := dec.LastPacketInfo(); err == nil {
+		t.Errorf("Expected error before the first packet")
+	}
+
+	mono := make([]int16, FRAME_SIZE)
+	addSine(mono, SAMPLE_RATE, 440)
+	pcm := interleave(mono, mono)
+	data := make([]byte, 1000)
+	out := make([]int16, 2*FRAME_SIZE)
+	var info PacketInfo
+	for i := 0; i < 5; i++ {
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		if _, err := dec.Decode(data[:n], out); err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+		if info, err = dec.LastPacketInfo(); err != nil {
+			t.Fatalf("Couldn't get last packet info: %v", err)
+		}
+	}
+	duration, err := dec.LastPacketDuration()
+	if err != nil {
+		t.Fatalf("Couldn't get last packet duration: %v", err)
+	}
+	if info.Duration != duration || info.Duration != 320 {
+		t.Errorf("Unexpected duration %d (CTL reports %d)", info.Duration, duration)
+	}
+	if info.Frames != 1 {
+		t.Errorf("Unexpected frame count %d", info.Frames)
+	}
+	if info.Bandwidth < Narrowband || info.Bandwidth > Fullband {
+		t.Errorf("Unexpected bandwidth %d", info.Bandwidth)
+	}
+	if info.Channels < 1 || info.Channels > 2 {
+		t.Errorf("Unexpected channel count %d", info.Channels)
+	}
+	if !info.HasFEC {
+		t.Errorf("Expected FEC data in packet encoded with inband FEC")
+	}
+}
+
+func TestDecoder_Bandwidth(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	if _, err := dec.Bandwidth(); err == nil {
+		t.Errorf("Expected error before the first packet")
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	out := make([]int16, FRAME_SIZE)
+	for _, bw := range []Bandwidth{Narrowband, Fullband} {
+		if err := enc.SetMaxBandwidth(bw); err != nil {
+			t.Fatalf("Error setting max bandwidth: %v", err)
+		}
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		if _, err := dec.Decode(data[:n], out); err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+		got, err := dec.Bandwidth()
+		if err != nil {
+			t.Fatalf("Error getting bandwidth: %v", err)
+		}
+		if got != bw {
+			t.Errorf("Unexpected bandwidth. Got %d, but expected %d", got, bw)
+		}
+	}
+}
+
+func TestDecoder_SampleRateChannels(t *testing.T) {
+	for _, sampleRate := range []int{8000, 12000, 16000, 24000, 48000} {
+		for _, channels := range []int{1, 2} {
+			dec, err := NewDecoder(sampleRate, channels)
+			if err != nil || dec == nil {
+				t.Fatalf("Error creating new decoder: %v", err)
+			}
+			got, err := dec.SampleRate()
+			if err != nil {
+				t.Fatalf("Error getting sample rate: %v", err)
+			}
+			if got != sampleRate {
+				t.Errorf("Unexpected sample rate. Got %d, but expected %d", got, sampleRate)
+			}
+			if dec.Channels() != channels {
+				t.Errorf("Unexpected channels. Got %d, but expected %d", dec.Channels(), channels)
+			}
+		}
+	}
+	var dec Decoder
+	if _, err := dec.SampleRate(); err != errDecUninitialized {
+		t.Errorf("Expected uninitialized error, got %v", err)
+	}
+}
+
+func TestDecoder_Pitch(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 200)
+	data := make([]byte, 1000)
+	out := make([]int16, FRAME_SIZE)
+	for i := 0; i < 5; i++ {
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		if _, err := dec.Decode(data[:n], out); err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+	}
+	pitch, err := dec.Pitch()
+	if err != nil {
+		t.Fatalf("Error getting pitch: %v", err)
+	}
+	if pitch < 0 {
+		t.Errorf("Unexpected pitch %d", pitch)
+	}
+}
+
+func TestDecoder_Reset(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+
+	// A reset decoder must produce the same output as a fresh one.
+	fresh, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || fresh == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	want := make([]int16, FRAME_SIZE)
+	if _, err := fresh.Decode(data[:n], want); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	got := make([]int16, FRAME_SIZE)
+	for i := 0; i < 3; i++ {
+		if _, err := dec.Decode(data[:n], got); err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+	}
+	if err := dec.Reset(); err != nil {
+		t.Fatalf("Error resetting decoder: %v", err)
+	}
+	if _, err := dec.LastPacketInfo(); err == nil {
+		t.Errorf("Expected no last packet after reset")
+	}
+	if _, err := dec.Decode(data[:n], got); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Output after reset differs from fresh decoder at sample %d: %d != %d", i, got[i], want[i])
+		}
+	}
+
+	// OPUS_RESET_STATE, unlike re-initializing, keeps the settings.
+	if err := dec.SetGain(256); err != nil {
+		t.Fatalf("Error setting gain: %v", err)
+	}
+	if err := dec.Reset(); err != nil {
+		t.Fatalf("Error resetting decoder: %v", err)
+	}
+	if gain, err := dec.Gain(); err != nil || gain != 256 {
+		t.Errorf("Gain after reset: %d (err=%v), want 256", gain, err)
+	}
+
+	var uninit Decoder
+	if err := uninit.Reset(); err != errDecUninitialized {
+		t.Errorf("Expected uninitialized error, got %v", err)
+	}
+}
+
+func TestDecoder_SetGetComplexity(t *testing.T) {
+	dec, err := NewDecoder(48000, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	for _, want := range []int{0, 5, 10} {
+		if err := dec.SetComplexity(want); err != nil {
+			t.Fatalf("Error setting complexity: %v", err)
+		}
+		got, err := dec.Complexity()
+		if err != nil {
+			t.Fatalf("Error getting complexity: %v", err)
+		}
+		if got != want {
+			t.Errorf("Unexpected complexity. Got %d, but expected %d", got, want)
+		}
+	}
+	if err := dec.SetComplexity(11); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg for complexity 11, got %v", err)
+	}
+}
+
+func TestDecoder_NbSamples(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(24000, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	data := make([]byte, 1000)
+	for _, frameSize := range []int{120, 480, 960, 2880} {
+		pcm := make([]int16, frameSize)
+		addSine(pcm, SAMPLE_RATE, 440)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		got, err := dec.NbSamples(data[:n])
+		if err != nil {
+			t.Fatalf("Error getting sample count: %v", err)
+		}
+		if got != frameSize/2 {
+			t.Errorf("Unexpected sample count. Got %d, but expected %d", got, frameSize/2)
+		}
+		out := make([]int16, got)
+		decoded, err := dec.Decode(data[:n], out)
+		if err != nil {
+			t.Fatalf("Couldn't decode into exactly sized buffer: %v", err)
+		}
+		if decoded != got {
+			t.Errorf("Decoded %d samples, NbSamples reported %d", decoded, got)
+		}
+	}
+	if _, err := dec.NbSamples(nil); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg for empty packet, got %v", err)
+	}
+}
+
+func TestDecoder_DecodeAll(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 40 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 2)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE*2)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	out, err := dec.DecodeAll(data[:n])
+	if err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	if len(out) != FRAME_SIZE*2 {
+		t.Errorf("Decoded %d samples, want %d", len(out), FRAME_SIZE*2)
+	}
+	fout, err := dec.DecodeAllFloat32(data[:n])
+	if err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	if len(fout) != FRAME_SIZE*2 {
+		t.Errorf("Decoded %d float samples, want %d", len(fout), FRAME_SIZE*2)
+	}
+	if _, err := dec.DecodeAll(nil); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg for empty packet, got %v", err)
+	}
+}
+
+func TestDecoder_Ctl(t *testing.T) {
+	// Request codes from opus_defines.h.
+	const (
+		OPUS_GET_SAMPLE_RATE = 4029
+		OPUS_SET_GAIN        = 4034
+		OPUS_GET_GAIN        = 4045
+	)
+	var uninit Decoder
+	if _, err := uninit.Ctl(OPUS_GET_SAMPLE_RATE); err != errDecUninitialized {
+		t.Errorf("Expected uninitialized error, got %v", err)
+	}
+	dec, err := NewDecoder(16000, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	rate, err := dec.Ctl(OPUS_GET_SAMPLE_RATE)
+	if err != nil || rate != 16000 {
+		t.Errorf("Unexpected sample rate. Got %d (err=%v), but expected 16000", rate, err)
+	}
+	if _, err := dec.Ctl(OPUS_SET_GAIN, -256); err != nil {
+		t.Fatalf("Error setting gain through Ctl: %v", err)
+	}
+	gain, err := dec.Ctl(OPUS_GET_GAIN)
+	if err != nil || gain != -256 {
+		t.Errorf("Unexpected gain. Got %d (err=%v), but expected -256", gain, err)
+	}
+}
+
+func TestDecoder_ReusesBuffer(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE*2)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	data = data[:n]
+
+	dec, err := NewDecoder(SAMPLE_RATE, 2)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	out := make([]int16, maxFrameSize48k*2)
+	if _, err := dec.Decode(data, out); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	buf := dec.buf
+	for i := 0; i < 10; i++ {
+		if _, err := dec.Decode(data, out); err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+		if _, err := dec.DecodePLC(out[:FRAME_SIZE*2]); err != nil {
+			t.Fatalf("Couldn't conceal lost packet: %v", err)
+		}
+		if _, err := dec.DecodeFloat32(data, make([]float32, FRAME_SIZE*2)); err != nil {
+			t.Fatalf("Couldn't decode float data: %v", err)
+		}
+	}
+	if dec.buf != buf {
+		t.Errorf("Buffer reallocated from %+v to %+v for frames it fits", buf, dec.buf)
+	}
+	// A bigger output buffer grows it.
+	if _, err := dec.DecodeFloat32(data, make([]float32, maxFrameSize48k*2)); err != nil {
+		t.Fatalf("Couldn't decode float data: %v", err)
+	}
+	if dec.buf.size <= buf.size {
+		t.Errorf("Buffer of %d bytes not grown for float output", dec.buf.size)
+	}
+}
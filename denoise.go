@@ -0,0 +1,165 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Denoiser is a Processor that suppresses noise in PCM audio before it is
+// encoded.
+type Denoiser interface {
+	Processor
+	Close() error
+}
+
+// rnnoiseFrameSize is the number of samples RNNoise processes per call
+// (10 ms at 48 kHz).
+const rnnoiseFrameSize = 480
+
+// RNNoise is a Denoiser backed by an RNNoise WebAssembly module. The module
+// runs in the same wazero runtime as libopus and must export malloc, free,
+// rnnoise_create, rnnoise_destroy and rnnoise_process_frame with their usual
+// C signatures.
+//
+// RNNoise only supports 48 kHz mono audio. Because it works on 10 ms frames,
+// the output is delayed by 10 ms relative to the input.
+type RNNoise struct {
+	module  api.Module
+	process api.Function
+	destroy api.Function
+	free    api.Function
+	state   uint32
+	inPtr   uint32
+	outPtr  uint32
+	in      []float32 // input not yet processed
+	out     []float32 // processed output not yet returned
+	scaled  []float32
+	mu      sync.Mutex
+}
+
+var rnnoiseInstanceCounter uint64
+
+// NewRNNoise instantiates the RNNoise module wasmBinary in the shared wasm
+// runtime.
+func NewRNNoise(ctx context.Context, wasmBinary []byte) (*RNNoise, error) {
+	manager, err := initWasm(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize wasm context: %w", err)
+	}
+	rt := manager.runtime
+	if rt == nil {
+		return nil, fmt.Errorf("opus: RNNoise needs the wazero backend, not available in cgo and js builds")
+	}
+
+	compiled, err := rt.CompileModule(ctx, wasmBinary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile rnnoise module: %w", err)
+	}
+	name := fmt.Sprintf("rnnoise-%d", atomic.AddUint64(&rnnoiseInstanceCounter, 1))
+	cfg := wazero.NewModuleConfig().WithName(name).WithStartFunctions("_initialize")
+	mod, err := rt.InstantiateModule(ctx, compiled, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate rnnoise module: %w", err)
+	}
+
+	d := &RNNoise{
+		module:  mod,
+		process: mod.ExportedFunction("rnnoise_process_frame"),
+		destroy: mod.ExportedFunction("rnnoise_destroy"),
+		free:    mod.ExportedFunction("free"),
+		out:     make([]float32, rnnoiseFrameSize), // initial latency
+		scaled:  make([]float32, rnnoiseFrameSize),
+	}
+	create := mod.ExportedFunction("rnnoise_create")
+	malloc := mod.ExportedFunction("malloc")
+	if create == nil || malloc == nil || d.process == nil || d.destroy == nil || d.free == nil {
+		mod.Close(ctx)
+		return nil, fmt.Errorf("rnnoise module does not export the required functions")
+	}
+
+	results, err := create.Call(ctx, 0) // NULL selects the built-in model
+	if err != nil || results[0] == 0 {
+		mod.Close(ctx)
+		return nil, fmt.Errorf("rnnoise_create failed: %v", err)
+	}
+	d.state = uint32(results[0])
+	for _, ptr := range []*uint32{&d.inPtr, &d.outPtr} {
+		results, err := malloc.Call(ctx, 4*rnnoiseFrameSize)
+		if err != nil || results[0] == 0 {
+			d.Close()
+			return nil, fmt.Errorf("wasm malloc for rnnoise frame failed: %v", err)
+		}
+		*ptr = uint32(results[0])
+	}
+	return d, nil
+}
+
+// Process denoises pcm in place. pcm must be 48 kHz mono audio.
+func (d *RNNoise) Process(pcm []float32) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.module == nil {
+		return fmt.Errorf("opus: rnnoise denoiser closed")
+	}
+	ctx := context.Background()
+	d.in = append(d.in, pcm...)
+	for len(d.in) >= rnnoiseFrameSize {
+		// RNNoise works on floats in the int16 range.
+		scaled := d.scaled
+		for i, v := range d.in[:rnnoiseFrameSize] {
+			scaled[i] = v * 32768
+		}
+		if !d.module.Memory().Write(d.inPtr, float32SliceToByteSlice(scaled)) {
+			return fmt.Errorf("opus: failed to write rnnoise input to wasm memory")
+		}
+		if _, err := d.process.Call(ctx, uint64(d.state), uint64(d.outPtr), uint64(d.inPtr)); err != nil {
+			return fmt.Errorf("rnnoise_process_frame call failed: %w", err)
+		}
+		raw, ok := d.module.Memory().Read(d.outPtr, 4*rnnoiseFrameSize)
+		if !ok {
+			return fmt.Errorf("opus: failed to read rnnoise output from wasm memory")
+		}
+		if err := float32SliceFromByteSlice(raw, scaled); err != nil {
+			return err
+		}
+		for _, v := range scaled {
+			d.out = append(d.out, v/32768)
+		}
+		d.in = d.in[rnnoiseFrameSize:]
+	}
+	n := copy(pcm, d.out)
+	d.out = d.out[n:]
+	return nil
+}
+
+// Close releases the RNNoise state and its module instance.
+func (d *RNNoise) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.module == nil {
+		return nil
+	}
+	ctx := context.Background()
+	if d.state != 0 {
+		d.destroy.Call(ctx, uint64(d.state))
+	}
+	for _, ptr := range []uint32{d.inPtr, d.outPtr} {
+		if ptr != 0 {
+			d.free.Call(ctx, uint64(ptr))
+		}
+	}
+	err := d.module.Close(ctx)
+	d.module = nil
+	return err
+}
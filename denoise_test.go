@@ -0,0 +1,78 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"testing"
+)
+
+// muteDenoiser is a Denoiser that suppresses everything.
+type muteDenoiser struct {
+	calls int
+}
+
+func (d *muteDenoiser) Process(pcm []float32) error {
+	d.calls++
+	for i := range pcm {
+		pcm[i] = 0
+	}
+	return nil
+}
+
+func (d *muteDenoiser) Close() error { return nil }
+
+func TestEncoder_SetDenoiser(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	d := &muteDenoiser{}
+	enc.SetDenoiser(d)
+
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	orig := append([]int16(nil), pcm...)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if maxDiff(pcm, orig) != 0 {
+		t.Errorf("Denoiser modified the caller's buffer")
+	}
+	pcmf := make([]float32, FRAME_SIZE)
+	addSineFloat32(pcmf, SAMPLE_RATE, 440)
+	if _, err := enc.EncodeFloat32(pcmf, data); err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if d.calls != 2 {
+		t.Errorf("Expected 2 denoiser calls, got %d", d.calls)
+	}
+
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	out := make([]int16, FRAME_SIZE)
+	if _, err := dec.Decode(data[:n], out); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	if d := maxDiff(out, make([]int16, FRAME_SIZE)); d > 100 {
+		t.Errorf("Expected near-silent output from muted input, max sample %d", d)
+	}
+}
+
+func TestNewRNNoiseInvalidModule(t *testing.T) {
+	if _, err := NewRNNoise(context.Background(), []byte("not wasm")); err == nil {
+		t.Errorf("Expected error for invalid rnnoise module")
+	}
+	// The libopus bridge is valid wasm but doesn't export the RNNoise API.
+	if _, err := NewRNNoise(context.Background(), opusWasmBinary); err == nil {
+		t.Errorf("Expected error for module without rnnoise exports")
+	}
+}
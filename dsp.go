@@ -0,0 +1,173 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+// Interleave copies the samples of chans, one slice per channel, into dst
+// interleaved, as Encode takes them. Like copy, it stops at the shortest
+// channel or when dst is full, and returns the number of samples per channel
+// copied.
+func Interleave(dst []int16, chans ...[]int16) int {
+	return interleaveSamples(dst, chans)
+}
+
+// InterleaveFloat32 is Interleave for float32 samples.
+func InterleaveFloat32(dst []float32, chans ...[]float32) int {
+	return interleaveSamples(dst, chans)
+}
+
+// Deinterleave copies the interleaved samples of src, as Decode returns
+// them, into chans, one slice per channel. Like copy, it stops at the
+// shortest channel or at the end of src, and returns the number of samples
+// per channel copied.
+func Deinterleave(src []int16, chans ...[]int16) int {
+	return deinterleaveSamples(src, chans)
+}
+
+// DeinterleaveFloat32 is Deinterleave for float32 samples.
+func DeinterleaveFloat32(src []float32, chans ...[]float32) int {
+	return deinterleaveSamples(src, chans)
+}
+
+func interleaveSamples[T Sample](dst []T, chans [][]T) int {
+	n := frameCount(len(dst), chans)
+	for c, ch := range chans {
+		for i, v := range ch[:n] {
+			dst[i*len(chans)+c] = v
+		}
+	}
+	return n
+}
+
+func deinterleaveSamples[T Sample](src []T, chans [][]T) int {
+	n := frameCount(len(src), chans)
+	for c, ch := range chans {
+		for i := range ch[:n] {
+			ch[i] = src[i*len(chans)+c]
+		}
+	}
+	return n
+}
+
+// frameCount returns the number of samples per channel that both the
+// interleaved buffer of length interleaved and every slice of chans hold.
+func frameCount[T Sample](interleaved int, chans [][]T) int {
+	if len(chans) == 0 {
+		return 0
+	}
+	n := interleaved / len(chans)
+	for _, ch := range chans {
+		n = min(n, len(ch))
+	}
+	return n
+}
+
+// StereoToMono mixes the interleaved stereo samples of src down to mono in
+// dst by averaging both channels, which can't overflow int16. It returns the
+// number of samples written, at most len(dst) and len(src)/2.
+func StereoToMono(dst, src []int16) int {
+	n := min(len(dst), len(src)/2)
+	for i := range dst[:n] {
+		dst[i] = int16((int32(src[2*i]) + int32(src[2*i+1])) / 2)
+	}
+	return n
+}
+
+// StereoToMonoFloat32 is StereoToMono for float32 samples. Decoded float
+// audio can exceed [-1, 1], so excursions of the average beyond it are soft
+// clipped as opus_pcm_soft_clip does; audio within range is left untouched.
+func StereoToMonoFloat32(dst, src []float32) int {
+	n := min(len(dst), len(src)/2)
+	for i := range dst[:n] {
+		dst[i] = (src[2*i] + src[2*i+1]) / 2
+	}
+	softClip(dst[:n])
+	return n
+}
+
+// softClip is opus_pcm_soft_clip for mono x without state across calls: each
+// run of samples between zero crossings that exceeds [-1, 1] is bent by the
+// curve x + a*x*x that maps its peak to ±1.
+func softClip(x []float32) {
+	within := true
+	for i, v := range x {
+		x[i] = max(-2, min(2, v))
+		within = within && v >= -1 && v <= 1
+	}
+	if within {
+		return
+	}
+	x0 := x[0]
+	for curr := 0; curr < len(x); {
+		i := curr
+		for i < len(x) && x[i] >= -1 && x[i] <= 1 {
+			i++
+		}
+		if i == len(x) {
+			return
+		}
+		peakPos, start, end := i, i, i
+		maxval := abs32(x[i])
+		// Extend to the zero crossings around the excursion.
+		for start > 0 && x[i]*x[start-1] >= 0 {
+			start--
+		}
+		for end < len(x) && x[i]*x[end] >= 0 {
+			if abs32(x[end]) > maxval {
+				maxval, peakPos = abs32(x[end]), end
+			}
+			end++
+		}
+		// The excursion starts before the first zero crossing.
+		special := start == 0 && x[i]*x[0] >= 0
+		// a makes maxval + a*maxval^2 = 1, slightly boosted so rounding
+		// can't leave samples beyond ±1.
+		a := (maxval - 1) / (maxval * maxval)
+		a += a * 2.4e-7
+		if x[i] > 0 {
+			a = -a
+		}
+		for j := start; j < end; j++ {
+			x[j] += a * x[j] * x[j]
+		}
+		if special && peakPos >= 2 {
+			// Ramp from the first sample to the peak to avoid a
+			// discontinuity at the start.
+			offset := x0 - x[0]
+			delta := offset / float32(peakPos)
+			for j := curr; j < peakPos; j++ {
+				offset -= delta
+				x[j] = max(-1, min(1, x[j]+offset))
+			}
+		}
+		curr = end
+	}
+}
+
+func abs32(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// MonoToStereo copies each sample of src to both channels of the interleaved
+// stereo dst. It returns the number of samples per channel written, at most
+// len(src) and len(dst)/2.
+func MonoToStereo(dst, src []int16) int {
+	return monoToStereo(dst, src)
+}
+
+// MonoToStereoFloat32 is MonoToStereo for float32 samples.
+func MonoToStereoFloat32(dst, src []float32) int {
+	return monoToStereo(dst, src)
+}
+
+func monoToStereo[T Sample](dst, src []T) int {
+	n := min(len(src), len(dst)/2)
+	for i, v := range src[:n] {
+		dst[2*i], dst[2*i+1] = v, v
+	}
+	return n
+}
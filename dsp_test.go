@@ -0,0 +1,102 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestInterleave(t *testing.T) {
+	left, right := []int16{1, 2, 3}, []int16{-1, -2, -3}
+	dst := make([]int16, 6)
+	if n := Interleave(dst, left, right); n != 3 {
+		t.Errorf("Interleaved %d samples per channel, want 3", n)
+	}
+	if want := []int16{1, -1, 2, -2, 3, -3}; !slices.Equal(dst, want) {
+		t.Errorf("Interleave = %v, want %v", dst, want)
+	}
+
+	chans := [][]int16{make([]int16, 3), make([]int16, 3)}
+	if n := Deinterleave(dst, chans...); n != 3 {
+		t.Errorf("Deinterleaved %d samples per channel, want 3", n)
+	}
+	if !slices.Equal(chans[0], left) || !slices.Equal(chans[1], right) {
+		t.Errorf("Deinterleave = %v, want %v and %v", chans, left, right)
+	}
+
+	// Like copy, both stop at the shortest operand.
+	if n := Interleave(make([]int16, 5), left, right); n != 2 {
+		t.Errorf("Interleaved %d samples per channel into 5, want 2", n)
+	}
+	if n := Deinterleave(dst, make([]int16, 1), make([]int16, 3)); n != 1 {
+		t.Errorf("Deinterleaved %d samples per channel into a 1-sample channel, want 1", n)
+	}
+	if n := Interleave(dst); n != 0 {
+		t.Errorf("Interleaved %d samples per channel without channels, want 0", n)
+	}
+
+	fdst := make([]float32, 3)
+	if n := InterleaveFloat32(fdst, []float32{0.5}, []float32{-0.5}, []float32{0.25}); n != 1 {
+		t.Errorf("Interleaved %d float samples per channel, want 1", n)
+	}
+	fchans := [][]float32{make([]float32, 1), make([]float32, 1), make([]float32, 1)}
+	DeinterleaveFloat32(fdst, fchans...)
+	if fchans[0][0] != 0.5 || fchans[1][0] != -0.5 || fchans[2][0] != 0.25 {
+		t.Errorf("DeinterleaveFloat32 = %v", fchans)
+	}
+}
+
+func TestStereoToMono(t *testing.T) {
+	src := []int16{32767, 32767, -32768, -32768, 1000, -1000, 3, 4}
+	dst := make([]int16, 4)
+	if n := StereoToMono(dst, src); n != 4 {
+		t.Errorf("Mixed down %d samples, want 4", n)
+	}
+	if want := []int16{32767, -32768, 0, 3}; !slices.Equal(dst, want) {
+		t.Errorf("StereoToMono = %v, want %v", dst, want)
+	}
+	if n := StereoToMono(make([]int16, 10), src[:3]); n != 1 {
+		t.Errorf("Mixed down %d samples from 3 stereo samples, want 1", n)
+	}
+
+	// In-range audio, even near full scale, keeps its levels.
+	fsrc := []float32{0.95, 0.85, -0.9, -0.9, 0.2, 0.4}
+	fdst := make([]float32, 3)
+	StereoToMonoFloat32(fdst, fsrc)
+	if want := []float32{0.9, -0.9, 0.3}; !slices.Equal(fdst, want) {
+		t.Errorf("StereoToMonoFloat32 = %v, want %v", fdst, want)
+	}
+
+	// Excursions beyond ±1 are bent back into range, keeping their sign.
+	fsrc = []float32{0.5, 0.1, 1.5, 1.7, 0.5, 0.5, -3, -3, -0.2, -0.2}
+	fdst = make([]float32, 5)
+	StereoToMonoFloat32(fdst, fsrc)
+	for i, v := range fdst {
+		if v > 1 || v < -1 {
+			t.Errorf("Sample %d: StereoToMonoFloat32 = %v, want within [-1, 1]", i, v)
+		}
+		if (v < 0) != (fsrc[2*i] < 0) {
+			t.Errorf("Sample %d: StereoToMonoFloat32 = %v changed sign", i, v)
+		}
+	}
+	if fdst[1] < 0.99 || fdst[3] > -0.99 {
+		t.Errorf("StereoToMonoFloat32 = %v, want the peaks soft clipped to about ±1", fdst)
+	}
+}
+
+func TestMonoToStereo(t *testing.T) {
+	dst := make([]int16, 6)
+	if n := MonoToStereo(dst, []int16{1, -2, 3, 4}); n != 3 {
+		t.Errorf("Mixed up %d samples per channel, want 3", n)
+	}
+	if want := []int16{1, 1, -2, -2, 3, 3}; !slices.Equal(dst, want) {
+		t.Errorf("MonoToStereo = %v, want %v", dst, want)
+	}
+	fdst := make([]float32, 2)
+	if n := MonoToStereoFloat32(fdst, []float32{0.25}); n != 1 || fdst[0] != 0.25 || fdst[1] != 0.25 {
+		t.Errorf("MonoToStereoFloat32 = %v (n=%d)", fdst, n)
+	}
+}
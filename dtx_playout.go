@@ -0,0 +1,123 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"slices"
+	"time"
+)
+
+// defaultMinGap is the smallest gap DTXPlayout fills after a packet with
+// audio, unless set otherwise.
+const defaultMinGap = 100 * time.Millisecond
+
+// DTXPlayout decodes the frames of a recorded stream and inserts the audio
+// a sender using DTX (discontinuous transmission) left out. Such a sender
+// stops sending during silence, so decoding the packets back to back plays
+// the recording time-compressed, with the pauses cut out.
+//
+// The frames' PTS place them on the timeline, from RTP timestamps or from
+// arrival times. A gap after a DTX packet is always filled, since it is
+// where the sender stopped; other gaps are only filled from MinGap on, so
+// arrival jitter isn't mistaken for missing audio.
+type DTXPlayout struct {
+	dec *Decoder
+	// ComfortNoise fills gaps with the decoder's concealment, which
+	// continues the comfort noise of DTX, instead of digital silence.
+	ComfortNoise bool
+	// MinGap is the smallest gap filled after a frame with audio; 0 means
+	// 100 ms.
+	MinGap time.Duration
+
+	started  bool
+	next     time.Duration // position of the end of the audio so far
+	afterDTX bool
+	inserted time.Duration
+}
+
+// NewDTXPlayout returns a DTXPlayout decoding with dec.
+func NewDTXPlayout(dec *Decoder) *DTXPlayout {
+	return &DTXPlayout{dec: dec}
+}
+
+// Decode decodes f, preceded by the audio to fill the gap before it, and
+// appends the interleaved samples to dst. A frame marked lost is
+// concealed, and one with empty Data is a DTX gap filled for its Duration.
+func (p *DTXPlayout) Decode(f Frame, dst []int16) ([]int16, error) {
+	var err error
+	if p.started {
+		gap := f.PTS - p.next
+		minGap := p.MinGap
+		if minGap == 0 {
+			minGap = defaultMinGap
+		}
+		if gap > 0 && (p.afterDTX || gap >= minGap) {
+			if dst, err = p.fill(dst, gap); err != nil {
+				return dst, err
+			}
+			p.inserted += gap
+		}
+	} else {
+		// The timeline starts at the first frame, whatever its PTS.
+		p.next = f.PTS
+	}
+	p.started = true
+
+	if !f.Lost && len(f.Data) == 0 {
+		p.afterDTX = true
+		return p.fill(dst, f.Duration)
+	}
+	var packet []byte
+	if !f.Lost {
+		packet = f.Data
+	}
+	start := len(dst)
+	dst = slices.Grow(dst, p.samples(120*time.Millisecond))
+	n, _, err := p.dec.DecodeWithLoss(packet, nil, dst[start:cap(dst)])
+	if err != nil {
+		return dst, err
+	}
+	p.afterDTX = !f.Lost && IsDTXPacket(f.Data)
+	p.next += time.Duration(n) * time.Second / time.Duration(p.dec.sample_rate)
+	return dst[:start+n*p.dec.channels], nil
+}
+
+// fill appends d of comfort noise or silence to dst.
+func (p *DTXPlayout) fill(dst []int16, d time.Duration) ([]int16, error) {
+	total := p.samples(d)
+	p.next += d
+	// Concealment works in multiples of 2.5 ms; the rest is silence.
+	quantum := p.dec.sample_rate / 400 * p.dec.channels
+	for total >= quantum && p.ComfortNoise {
+		frameSize, err := p.dec.lostFrameSize(nil)
+		if err != nil {
+			return dst, err
+		}
+		size := min(frameSize*p.dec.channels, total/quantum*quantum)
+		start := len(dst)
+		dst = slices.Grow(dst, size)
+		n, err := p.dec.DecodePLC(dst[start : start+size : start+size])
+		if err != nil {
+			return dst, err
+		}
+		if n == 0 {
+			break
+		}
+		dst = dst[:start+n*p.dec.channels]
+		total -= n * p.dec.channels
+	}
+	return append(dst, make([]int16, total)...), nil
+}
+
+// samples returns the number of interleaved samples in d.
+func (p *DTXPlayout) samples(d time.Duration) int {
+	return int(d*time.Duration(p.dec.sample_rate)/time.Second) * p.dec.channels
+}
+
+// Inserted returns the total duration of the gaps filled so far, not
+// counting frames with empty Data.
+func (p *DTXPlayout) Inserted() time.Duration {
+	return p.inserted
+}
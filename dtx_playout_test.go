@@ -0,0 +1,109 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDTXPlayout(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const ms = time.Millisecond
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppVoIP)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	pcm = interleave(pcm, pcm)
+	var packets [][]byte
+	for i := 0; i < 4; i++ {
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		packets = append(packets, data[:n])
+	}
+	// A TOC byte alone is what the encoder sends when it enters DTX.
+	dtx := []byte{packets[0][0] &^ 3}
+	frames := []Frame{
+		{Data: packets[0], PTS: 0},
+		{Data: packets[1], PTS: 20 * ms},
+		{Data: dtx, PTS: 40 * ms},
+		{Data: packets[2], PTS: 400 * ms},
+		// Arrival jitter, not a gap.
+		{Data: packets[3], PTS: 425 * ms},
+		// A gap the sender signalled with an empty frame.
+		{PTS: 440 * ms, Duration: 60 * ms},
+	}
+
+	for _, comfortNoise := range []bool{false, true} {
+		dec, err := NewDecoder(SAMPLE_RATE, 2)
+		if err != nil {
+			t.Fatalf("Error creating new decoder: %v", err)
+		}
+		p := NewDTXPlayout(dec)
+		p.ComfortNoise = comfortNoise
+		var out []int16
+		for _, f := range frames {
+			if out, err = p.Decode(f, out); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+		}
+		if want := SAMPLE_RATE * 500 / 1000 * 2; len(out) != want {
+			t.Errorf("Comfort noise %v: got %d samples, want %d", comfortNoise, len(out), want)
+		}
+		if got := p.Inserted(); got != 340*ms {
+			t.Errorf("Comfort noise %v: inserted %v, want 340ms", comfortNoise, got)
+		}
+		if !comfortNoise {
+			gap := out[SAMPLE_RATE*60/1000*2 : SAMPLE_RATE*400/1000*2]
+			for i, v := range gap {
+				if v != 0 {
+					t.Fatalf("Sample %d of the gap is %d, want silence", i, v)
+				}
+			}
+		}
+	}
+}
+
+func TestDTXPlayout_StartPTS(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const ms = time.Millisecond
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	p := NewDTXPlayout(dec)
+	var out []int16
+	// A recording joined mid-stream: the first PTS is far from zero.
+	for i := 0; i < 3; i++ {
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		f := Frame{Data: data[:n], PTS: 10*time.Second + time.Duration(i)*20*ms}
+		if out, err = p.Decode(f, out); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+	}
+	if want := 3 * FRAME_SIZE; len(out) != want {
+		t.Errorf("Got %d samples, want %d", len(out), want)
+	}
+	if got := p.Inserted(); got != 0 {
+		t.Errorf("Inserted %v before the first frame, want 0", got)
+	}
+}
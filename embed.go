@@ -0,0 +1,12 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+//go:build !opus_cgo
+
+package opus
+
+import _ "embed"
+
+//go:embed wasm-bridge/build/wasm_bridge
+var opusWasmBinary []byte
@@ -7,10 +7,12 @@
 package opus
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/tetratelabs/wazero/api"
 )
@@ -18,21 +20,79 @@ import (
 var errEncUninitialized = fmt.Errorf("opus encoder uninitialized")
 
 // Encoder contains the state of an Opus encoder using WebAssembly.
+//
+// Each Encoder runs in a wasm instance of its own, so Encoders used from
+// different goroutines run in parallel. Calls on one Encoder are serialized
+// and may come from several goroutines.
 type Encoder struct {
 	wctx       *wasmContext // Shared Wasm context
 	encoderPtr uint32       // Pointer to the OpusEncoder struct in Wasm memory
 	channels   int
-	mu         sync.Mutex
+	// layout is set for more than two channels. Each stream then has its
+	// own OpusEncoder in streamPtrs, and streamPtrs[0] == encoderPtr.
+	layout     *ChannelMapping
+	streamPtrs []uint32
+	// sampleRate and application are kept so the watchdog can re-initialize
+	// the encoder in place.
+	sampleRate  int
+	application Application
+	// bandwidth is the bandwidth requested with SetBandwidth, which
+	// libopus doesn't report back; 0 means auto.
+	bandwidth Bandwidth
+	// bitrate is the bitrate last requested, which libopus reports back as
+	// the rate it actually uses; 0 means it was never set (auto).
+	bitrate int32
+	// maxPacketSize caps the encoded payload size in bytes (0 means no cap).
+	maxPacketSize int
+	transport     TransportProfile
+	// slotSaved holds the settings a fixed-slot transport profile replaced,
+	// restored when a variable-size profile is set again.
+	slotSaved    *slotSettings
+	watchdog     watchdogState
+	audit        auditor
+	preprocessor Processor
+	// buf holds the input PCM followed by the output packet of every
+	// Encode call, so encoding doesn't allocate wasm memory per frame.
+	buf wasmBuffer
+	mu  sync.Mutex
 }
 
 // NewEncoder allocates a new Opus encoder and initializes it.
 // wasmBinary is the []byte content of the opus.wasm file.
+// sampleRate must be 8000, 12000, 16000, 24000 or 48000.
 func NewEncoder(sampleRate int, channels int, application Application) (*Encoder, error) {
+	return newEncoder(sampleRate, channels, application, nil)
+}
+
+// NewMultistreamEncoder allocates an encoder producing multistream packets
+// laid out by mapping, e.g. one read from an Ogg Opus header. Mapping
+// family 3 needs NewProjectionEncoder instead.
+func NewMultistreamEncoder(sampleRate int, mapping ChannelMapping, application Application) (*Encoder, error) {
+	if err := mapping.Validate(); err != nil {
+		return nil, err
+	}
+	if mapping.Family == MappingFamilyProjection {
+		return nil, fmt.Errorf("opus: mapping family 3 needs a ProjectionEncoder")
+	}
+	mapping.Table = append([]byte(nil), mapping.Table...)
+	return newEncoder(sampleRate, mapping.Channels, application, &mapping)
+}
+
+// newEncoder implements NewEncoder and NewMultistreamEncoder. A nil mapping
+// selects the default one for channels.
+func newEncoder(sampleRate int, channels int, application Application, mapping *ChannelMapping) (*Encoder, error) {
+	if err := checkSampleRate(sampleRate); err != nil {
+		return nil, err
+	}
 	ctx := context.Background() // Context for initialization
 	wctx, err := GetWasmContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get wasm context for encoder: %w", err)
 	}
+	if wctx.functions.OpusEncoderInit == nil {
+		releaseWasmContext(wctx)
+		return nil, ErrEncoderUnavailable
+	}
 
 	// malloc and free are now part of wctx, no need to export them separately here for the struct
 	// if wasmModule is needed directly, it's wctx.module
@@ -43,7 +103,7 @@ func NewEncoder(sampleRate int, channels int, application Application) (*Encoder
 		// module, malloc, free are now accessed via wctx
 	}
 
-	err = enc.init(ctx, sampleRate, channels, application)
+	err = enc.init(ctx, sampleRate, channels, application, mapping)
 	if err != nil {
 		releaseWasmContext(enc.wctx)
 		return nil, err
@@ -58,13 +118,20 @@ func NewEncoder(sampleRate int, channels int, application Application) (*Encoder
 			// We also need to ensure the module memory is still valid, which implies the runtime is alive.
 			// The CloseWasmContext should be the primary mechanism for cleanup.
 			// Finalizers are a fallback.
-			// Directly call Free here as freeMemory helper returns an error we can't easily handle in a finalizer.
-			_, finErr := e.wctx.functions.Free.Call(context.Background(), uint64(e.encoderPtr))
-			if finErr != nil {
-				// Log error, as we can't return it from a finalizer
-				fmt.Printf("opus: error freeing Wasm encoder memory in finalizer: %v\n", finErr)
+			// Directly call free here as freeMemory helper returns an error we can't easily handle in a finalizer.
+			for _, ptr := range e.states() {
+				if finErr := e.wctx.free(context.Background(), ptr); finErr != nil {
+					// Log error, as we can't return it from a finalizer
+					logger().Warn("opus: error freeing Wasm encoder memory in finalizer", "err", finErr)
+				}
 			}
 			e.encoderPtr = 0 // Mark as freed
+			e.streamPtrs = nil
+		}
+		if e.wctx != nil {
+			if finErr := e.buf.free(context.Background(), e.wctx); finErr != nil {
+				logger().Warn("opus: error freeing Wasm encoder buffer in finalizer", "err", finErr)
+			}
 		}
 		if e.wctx != nil {
 			releaseWasmContext(e.wctx)
@@ -74,68 +141,172 @@ func NewEncoder(sampleRate int, channels int, application Application) (*Encoder
 	return enc, nil
 }
 
-func (enc *Encoder) init(ctx context.Context, sampleRate int, channels int, application Application) error {
+func (enc *Encoder) init(ctx context.Context, sampleRate int, channels int, application Application, mapping *ChannelMapping) error {
 	if enc.encoderPtr != 0 {
 		return fmt.Errorf("opus encoder already initialized")
 	}
-	if channels != 1 && channels != 2 {
-		return fmt.Errorf("number of channels must be 1 or 2: %d", channels)
+	if mapping == nil && (channels < 1 || channels > maxChannels) {
+		return fmt.Errorf("number of channels must be between 1 and %d: %d", maxChannels, channels)
 	}
 
 	if enc.wctx == nil || enc.wctx.module == nil {
 		return fmt.Errorf("wasm context or module not initialized in encoder")
 	}
 
+	if mapping == nil {
+		m, err := defaultMapping(channels)
+		if err != nil {
+			return err
+		}
+		mapping = &m
+	}
+	if mapping.multistream() {
+		enc.layout = mapping
+	}
+	if enc.layout == nil {
+		ptr, err := enc.newState(ctx, sampleRate, channels, application)
+		if err != nil {
+			return err
+		}
+		enc.encoderPtr = ptr
+	} else {
+		for s := 0; s < enc.layout.Streams; s++ {
+			ptr, err := enc.newState(ctx, sampleRate, enc.layout.streamChannels(s), application)
+			if err != nil {
+				for _, p := range enc.streamPtrs {
+					enc.wctx.freeMemory(ctx, p)
+				}
+				enc.streamPtrs = nil
+				return err
+			}
+			enc.streamPtrs = append(enc.streamPtrs, ptr)
+		}
+		enc.encoderPtr = enc.streamPtrs[0]
+		if err := enc.markLFELocked(ctx); err != nil {
+			for _, p := range enc.streamPtrs {
+				enc.wctx.freeMemory(ctx, p)
+			}
+			enc.encoderPtr, enc.streamPtrs = 0, nil
+			return err
+		}
+	}
+	// Size the buffer for the longest frame of float samples.
+	if _, err := enc.buf.reserve(ctx, enc.wctx, uint32(sampleRate/1000*120*channels*4+DefaultMaxPacketSize)); err != nil {
+		for _, p := range enc.states() {
+			enc.wctx.freeMemory(ctx, p)
+		}
+		enc.encoderPtr, enc.streamPtrs = 0, nil
+		return err
+	}
+	enc.sampleRate = sampleRate
+	enc.application = application
+	return nil
+}
+
+// newState allocates and initializes a single-stream OpusEncoder.
+func (enc *Encoder) newState(ctx context.Context, sampleRate int, channels int, application Application) (uint32, error) {
 	opusEncoderGetSize := enc.wctx.functions.OpusEncoderGetSize
 	if opusEncoderGetSize == nil {
-		return fmt.Errorf("opus_encoder_get_size not found in Wasm functions cache")
+		return 0, fmt.Errorf("opus_encoder_get_size not found in Wasm functions cache")
 	}
 
 	results, err := opusEncoderGetSize.Call(ctx, uint64(channels))
 	if err != nil {
-		return fmt.Errorf("opus_encoder_get_size call failed: %w", err)
+		return 0, fmt.Errorf("opus_encoder_get_size call failed: %w", err)
 	}
 	size := uint32(results[0])
 
 	// Use wctx's malloc
 	if enc.wctx.functions.Malloc == nil {
-		return fmt.Errorf("wasm malloc function not initialized in encoder")
+		return 0, fmt.Errorf("wasm malloc function not initialized in encoder")
 	}
-	results, err = enc.wctx.functions.Malloc.Call(ctx, uint64(size))
+	ptr, err := enc.wctx.malloc(ctx, size)
 	if err != nil {
-		return fmt.Errorf("wasm malloc for encoder failed: %w", err)
+		return 0, fmt.Errorf("wasm malloc for encoder failed: %w", err)
 	}
-	enc.encoderPtr = uint32(results[0])
-	if enc.encoderPtr == 0 {
-		return fmt.Errorf("wasm malloc returned NULL for encoder")
+	if ptr == 0 {
+		return 0, fmt.Errorf("wasm malloc returned NULL for encoder")
 	}
 
 	opusEncoderInit := enc.wctx.functions.OpusEncoderInit
 	if opusEncoderInit == nil {
-		enc.wctx.freeMemory(ctx, enc.encoderPtr) // Clean up allocated memory
-		enc.encoderPtr = 0
-		return fmt.Errorf("opus_encoder_init not found in Wasm functions cache")
+		enc.wctx.freeMemory(ctx, ptr) // Clean up allocated memory
+		return 0, fmt.Errorf("opus_encoder_init not found in Wasm functions cache")
 	}
 
-	results, err = opusEncoderInit.Call(ctx, uint64(enc.encoderPtr), uint64(int32(sampleRate)), uint64(int32(channels)), uint64(int32(application)))
+	results, err = opusEncoderInit.Call(ctx, uint64(ptr), uint64(int32(sampleRate)), uint64(int32(channels)), uint64(int32(application)))
 	if err != nil {
-		enc.wctx.freeMemory(ctx, enc.encoderPtr) // Clean up
-		enc.encoderPtr = 0
-		return fmt.Errorf("opus_encoder_init call failed: %w", err)
+		enc.wctx.freeMemory(ctx, ptr) // Clean up
+		return 0, fmt.Errorf("opus_encoder_init call failed: %w", err)
 	}
 	errno := int32(results[0])
 	if errno != opusOk { // opusOk is a global constant from wasm_context.go
-		enc.wctx.freeMemory(ctx, enc.encoderPtr) // Clean up
-		enc.encoderPtr = 0
-		return Error(int(errno))
+		enc.wctx.freeMemory(ctx, ptr) // Clean up
+		return 0, Error(int(errno))
 	}
-	return nil
+	return ptr, nil
+}
+
+// markLFELocked flags the stream carrying the LFE channel, if any, so its
+// encoder only codes low frequencies. Callers must hold enc.mu.
+func (enc *Encoder) markLFELocked(ctx context.Context) error {
+	if enc.layout == nil || enc.layout.lfeStream() < 0 {
+		return nil
+	}
+	return enc.wctx.callCtl(ctx, enc.wctx.functions.OpusEncoderCtl, "opus_encoder_ctl",
+		enc.streamPtrs[enc.layout.lfeStream()], lfeRequest, 1)
+}
+
+// ChannelMapping returns the channel mapping of the packets the encoder
+// produces, as needed for an Ogg Opus header.
+func (enc *Encoder) ChannelMapping() ChannelMapping {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+
+	if enc.layout == nil {
+		m, _ := defaultMapping(enc.channels)
+		return m
+	}
+	m := *enc.layout
+	m.Table = append([]byte(nil), m.Table...)
+	return m
+}
+
+// states returns the OpusEncoder of every stream.
+func (enc *Encoder) states() []uint32 {
+	if enc.layout == nil {
+		return []uint32{enc.encoderPtr}
+	}
+	return enc.streamPtrs
 }
 
 // Encode raw PCM data (int16) and store the result in the supplied buffer.
 func (enc *Encoder) Encode(pcm []int16, data []byte) (int, error) {
+	return enc.EncodeContext(context.Background(), pcm, data)
+}
+
+// EncodeContext is Encode with a context. If ctx is cancelled while libopus
+// runs, the call is interrupted and returns ctx's error; the wasm instance
+// is then closed, so the Encoder can't be used any more.
+func (enc *Encoder) EncodeContext(ctx context.Context, pcm []int16, data []byte) (int, error) {
 	enc.mu.Lock()
-	defer enc.mu.Unlock()
+	defer enc.unlockAndNotify()
+
+	// Don't start a call that would only close the wasm instance.
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if enc.preprocessor != nil && len(pcm) > 0 {
+		processed := make([]float32, len(pcm))
+		for i, v := range pcm {
+			processed[i] = float32(v) / 32768
+		}
+		if err := enc.preprocessor.Process(processed); err != nil {
+			return 0, fmt.Errorf("opus: preprocessing failed: %w", err)
+		}
+		return enc.encodeFloat32Locked(ctx, processed, data)
+	}
 
 	if enc.encoderPtr == 0 {
 		return 0, errEncUninitialized
@@ -149,47 +320,48 @@ func (enc *Encoder) Encode(pcm []int16, data []byte) (int, error) {
 	if len(pcm)%enc.channels != 0 {
 		return 0, fmt.Errorf("opus: input buffer length must be multiple of channels")
 	}
+	if slot := enc.transport.SlotSize; slot > 0 && len(data) < slot {
+		return 0, fmt.Errorf("opus: target buffer (%d bytes) smaller than transport slot (%d bytes)", len(data), slot)
+	}
+	if err := checkFrameSize(enc.sampleRate, len(pcm)/enc.channels); err != nil {
+		return 0, err
+	}
 
-	ctx := context.Background()
 	samplesPerChannel := len(pcm) / enc.channels
 	if enc.wctx == nil {
 		return 0, errEncUninitialized // Or a more specific error
 	}
-	pcmBytes := int16SliceToByteSlice(pcm) // This helper is in wasm_context.go
-	pcmPtr, err := enc.wctx.writeToMemory(ctx, pcmBytes)
-	if err != nil {
-		return 0, fmt.Errorf("failed to write PCM to Wasm memory: %w", err)
+	if enc.layout != nil {
+		return enc.encodeMultistreamLocked(ctx, enc.wctx.functions.OpusEncode, "opus_encode", int16SliceToByteSlice(pcm), 2, data)
 	}
-	defer enc.wctx.freeMemory(ctx, pcmPtr)
-
-	// For output, we need to allocate memory. The 'data' slice is the Go buffer.
-	// We need to allocate Wasm memory of the same size for Opus to write into.
-	dataWasmPtr, err := enc.wctx.writeToMemory(ctx, make([]byte, len(data))) // Allocate and get ptr
+	maxDataBytes := enc.payloadLimit(len(data))
+	pcmPtr, dataWasmPtr, err := enc.writePCMLocked(ctx, int16SliceToByteSlice(pcm), maxDataBytes)
 	if err != nil {
-		return 0, fmt.Errorf("failed to allocate Wasm memory for output data: %w", err)
+		return 0, err
 	}
-	defer enc.wctx.freeMemory(ctx, dataWasmPtr)
 
 	opusEncode := enc.wctx.functions.OpusEncode
 	if opusEncode == nil {
 		return 0, fmt.Errorf("opus_encode not found in Wasm functions cache")
 	}
 
+	start := time.Now()
 	results, err := opusEncode.Call(ctx,
 		uint64(enc.encoderPtr),
 		uint64(pcmPtr),                   // Source PCM in Wasm
 		uint64(int32(samplesPerChannel)), // Frame size
 		uint64(dataWasmPtr),              // Destination for encoded data in Wasm
-		uint64(int32(len(data))),         // max_data_bytes (size of Go buffer 'data')
+		uint64(int32(maxDataBytes)),      // max_data_bytes (size of Go buffer 'data', capped by SetMaxPacketSize)
 	)
 	if err != nil {
-		return 0, fmt.Errorf("opus_encode call failed: %w", err)
+		return 0, callError(ctx, "opus_encode", err)
 	}
 
 	encodedBytes := int32(results[0])
 	if encodedBytes < 0 {
-		return 0, Error(int(encodedBytes)) // Error is a type in wasm_context.go or defined locally
+		return 0, enc.watchdog.observe(Error(int(encodedBytes)), enc.reinitLocked)
 	}
+	enc.watchdog.succeeded()
 
 	// Read encoded data back from Wasm memory (dataWasmPtr) into the Go slice 'data'
 	if uint32(encodedBytes) > uint32(len(data)) {
@@ -200,15 +372,41 @@ func (enc *Encoder) Encode(pcm []int16, data []byte) (int, error) {
 		return 0, fmt.Errorf("failed to read encoded data from Wasm memory: %d, %d", dataWasmPtr, encodedBytes)
 	}
 	copy(data, encodedResult)
+	enc.audit.record(AuditEncode, data[:encodedBytes], samplesPerChannel, enc.sampleRate)
+	frameEncoded(data[:encodedBytes], samplesPerChannel, enc.sampleRate, enc.channels, time.Since(start))
 
 	return int(encodedBytes), nil
 }
 
 // EncodeFloat32 raw PCM data (float32) and store the result.
 func (enc *Encoder) EncodeFloat32(pcm []float32, data []byte) (int, error) {
+	return enc.EncodeFloat32Context(context.Background(), pcm, data)
+}
+
+// EncodeFloat32Context is EncodeFloat32 with a context, see EncodeContext.
+func (enc *Encoder) EncodeFloat32Context(ctx context.Context, pcm []float32, data []byte) (int, error) {
 	enc.mu.Lock()
-	defer enc.mu.Unlock()
+	defer enc.unlockAndNotify()
+
+	// Don't start a call that would only close the wasm instance.
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 
+	if enc.preprocessor != nil && len(pcm) > 0 {
+		// Don't modify the caller's buffer.
+		processed := make([]float32, len(pcm))
+		copy(processed, pcm)
+		if err := enc.preprocessor.Process(processed); err != nil {
+			return 0, fmt.Errorf("opus: preprocessing failed: %w", err)
+		}
+		pcm = processed
+	}
+	return enc.encodeFloat32Locked(ctx, pcm, data)
+}
+
+// encodeFloat32Locked implements EncodeFloat32. Callers must hold enc.mu.
+func (enc *Encoder) encodeFloat32Locked(ctx context.Context, pcm []float32, data []byte) (int, error) {
 	if enc.encoderPtr == 0 {
 		return 0, errEncUninitialized
 	}
@@ -221,45 +419,48 @@ func (enc *Encoder) EncodeFloat32(pcm []float32, data []byte) (int, error) {
 	if len(pcm)%enc.channels != 0 {
 		return 0, fmt.Errorf("opus: input buffer length must be multiple of channels")
 	}
+	if slot := enc.transport.SlotSize; slot > 0 && len(data) < slot {
+		return 0, fmt.Errorf("opus: target buffer (%d bytes) smaller than transport slot (%d bytes)", len(data), slot)
+	}
+	if err := checkFrameSize(enc.sampleRate, len(pcm)/enc.channels); err != nil {
+		return 0, err
+	}
 
-	ctx := context.Background()
 	if enc.wctx == nil {
 		return 0, errEncUninitialized
 	}
-	samplesPerChannel := len(pcm) / enc.channels
-	pcmBytes := float32SliceToByteSlice(pcm) // This helper is in wasm_context.go
-	pcmPtr, err := enc.wctx.writeToMemory(ctx, pcmBytes)
-	if err != nil {
-		return 0, fmt.Errorf("failed to write PCM to Wasm memory: %w", err)
+	if enc.layout != nil {
+		return enc.encodeMultistreamLocked(ctx, enc.wctx.functions.OpusEncodeFloat, "opus_encode_float", float32SliceToByteSlice(pcm), 4, data)
 	}
-	defer enc.wctx.freeMemory(ctx, pcmPtr)
-
-	dataWasmPtr, err := enc.wctx.writeToMemory(ctx, make([]byte, len(data))) // Allocate for output
+	samplesPerChannel := len(pcm) / enc.channels
+	maxDataBytes := enc.payloadLimit(len(data))
+	pcmPtr, dataWasmPtr, err := enc.writePCMLocked(ctx, float32SliceToByteSlice(pcm), maxDataBytes)
 	if err != nil {
-		return 0, fmt.Errorf("failed to allocate Wasm memory for output data: %w", err)
+		return 0, err
 	}
-	defer enc.wctx.freeMemory(ctx, dataWasmPtr)
 
 	opusEncodeFloat := enc.wctx.functions.OpusEncodeFloat
 	if opusEncodeFloat == nil {
 		return 0, fmt.Errorf("opus_encode_float not found in Wasm functions cache")
 	}
 
+	start := time.Now()
 	results, err := opusEncodeFloat.Call(ctx,
 		uint64(enc.encoderPtr),
 		uint64(pcmPtr),                   // Source PCM in Wasm
 		uint64(int32(samplesPerChannel)), // Frame size
 		uint64(dataWasmPtr),              // Destination for encoded data in Wasm
-		uint64(int32(len(data))),         // max_data_bytes
+		uint64(int32(maxDataBytes)),      // max_data_bytes
 	)
 	if err != nil {
-		return 0, fmt.Errorf("opus_encode_float call failed: %w", err)
+		return 0, callError(ctx, "opus_encode_float", err)
 	}
 
 	encodedBytes := int32(results[0])
 	if encodedBytes < 0 {
-		return 0, Error(int(encodedBytes))
+		return 0, enc.watchdog.observe(Error(int(encodedBytes)), enc.reinitLocked)
 	}
+	enc.watchdog.succeeded()
 
 	if uint32(encodedBytes) > uint32(len(data)) {
 		return 0, fmt.Errorf("opus_encode_float reported %d bytes, but buffer has %d", encodedBytes, len(data))
@@ -269,16 +470,80 @@ func (enc *Encoder) EncodeFloat32(pcm []float32, data []byte) (int, error) {
 		return 0, fmt.Errorf("failed to read encoded data from Wasm memory")
 	}
 	copy(data, encodedResult)
+	enc.audit.record(AuditEncode, data[:encodedBytes], samplesPerChannel, enc.sampleRate)
+	frameEncoded(data[:encodedBytes], samplesPerChannel, enc.sampleRate, enc.channels, time.Since(start))
 
 	return int(encodedBytes), nil
 }
 
+// EncodeBytes is Encode into a buffer it allocates, returning the packet
+// in a new slice sized to it. It trades an allocation per packet for not
+// having to size the buffer.
+func (enc *Encoder) EncodeBytes(pcm []int16) ([]byte, error) {
+	data := make([]byte, enc.packetBound(len(pcm)))
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(data[:n]), nil
+}
+
+// EncodeFloat32Bytes is EncodeBytes for float32 PCM.
+func (enc *Encoder) EncodeFloat32Bytes(pcm []float32) ([]byte, error) {
+	data := make([]byte, enc.packetBound(len(pcm)))
+	n, err := enc.EncodeFloat32(pcm, data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(data[:n]), nil
+}
+
+// packetBound returns a buffer size that holds any packet encoded from
+// samples interleaved samples: a maximum-size frame and its length per
+// 20 ms of each stream, plus the TOC and frame count bytes, within the
+// packet size cap and no less than a transport slot.
+func (enc *Encoder) packetBound(samples int) int {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	if enc.channels == 0 || enc.sampleRate == 0 {
+		return 1 // Encode reports the error
+	}
+	streams := 1
+	if enc.layout != nil {
+		streams = enc.layout.Streams
+	}
+	frames := max(1, (samples/enc.channels*50+enc.sampleRate-1)/enc.sampleRate)
+	bound := streams * (frames*(maxFrameBytes+2) + 2)
+	if enc.maxPacketSize > 0 {
+		bound = min(bound, enc.maxPacketSize)
+	}
+	return max(bound, enc.transport.SlotSize)
+}
+
+// writePCMLocked copies pcm into enc.buf, growing it if needed, and returns
+// the addresses of the PCM and of the maxDataBytes after it for the packet.
+// Callers must hold enc.mu.
+func (enc *Encoder) writePCMLocked(ctx context.Context, pcm []byte, maxDataBytes int) (pcmPtr, dataPtr uint32, err error) {
+	pcmPtr, err = enc.buf.reserve(ctx, enc.wctx, uint32(len(pcm)+maxDataBytes))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to allocate Wasm memory for encoding: %w", err)
+	}
+	if !enc.wctx.module.Memory().Write(pcmPtr, pcm) {
+		return 0, 0, fmt.Errorf("wasm memory write failed")
+	}
+	return pcmPtr, pcmPtr + uint32(len(pcm)), nil
+}
+
 // --- Generic CTL Getters/Setters ---
 
 func (enc *Encoder) setCtlInt32(ctlFunc api.Function, value int32) error {
 	enc.mu.Lock()
 	defer enc.mu.Unlock()
+	return enc.setCtlInt32Locked(ctlFunc, value)
+}
 
+// setCtlInt32Locked is setCtlInt32 for callers that already hold enc.mu.
+func (enc *Encoder) setCtlInt32Locked(ctlFunc api.Function, value int32) error {
 	if enc.encoderPtr == 0 || enc.wctx == nil {
 		return errEncUninitialized
 	}
@@ -286,13 +551,27 @@ func (enc *Encoder) setCtlInt32(ctlFunc api.Function, value int32) error {
 		return fmt.Errorf("ctl function is nil for setCtlInt32")
 	}
 	ctx := context.Background()
-	results, err := ctlFunc.Call(ctx, uint64(enc.encoderPtr), uint64(value))
-	if err != nil {
-		return fmt.Errorf("wasm ctl function call failed for setCtlInt32: %w", err)
+	isBitrate := ctlFunc == enc.wctx.functions.BridgeEncoderSetBitrate
+	requested := value
+	var rates []int32
+	if enc.layout != nil && isBitrate && value > 0 {
+		rates = enc.layout.streamBitrates(int(value))
 	}
-	res := int32(results[0])
-	if res != opusOk {
-		return Error(int(res))
+	for s, ptr := range enc.states() {
+		if rates != nil {
+			value = rates[s]
+		}
+		results, err := ctlFunc.Call(ctx, uint64(ptr), uint64(value))
+		if err != nil {
+			return fmt.Errorf("wasm ctl function call failed for setCtlInt32: %w", err)
+		}
+		res := int32(results[0])
+		if res != opusOk {
+			return Error(int(res))
+		}
+	}
+	if isBitrate {
+		enc.bitrate = requested
 	}
 	return nil
 }
@@ -300,14 +579,48 @@ func (enc *Encoder) setCtlInt32(ctlFunc api.Function, value int32) error {
 func (enc *Encoder) getCtlInt32(ctlFunc api.Function) (int32, error) {
 	enc.mu.Lock()
 	defer enc.mu.Unlock()
+	return enc.getCtlInt32Locked(ctlFunc)
+}
 
+// getCtlInt32Locked is getCtlInt32 for callers that already hold enc.mu.
+func (enc *Encoder) getCtlInt32Locked(ctlFunc api.Function) (int32, error) {
 	if enc.encoderPtr == 0 || enc.wctx == nil {
 		return 0, errEncUninitialized
 	}
 	if ctlFunc == nil {
 		return 0, fmt.Errorf("ctl function is nil for getCtlInt32")
 	}
+	if enc.layout == nil {
+		return enc.getStreamCtlInt32Locked(ctlFunc, enc.encoderPtr)
+	}
 
+	// Surround encoders report the first stream, except for the values
+	// that only make sense for the packet as a whole.
+	funcs := enc.wctx.functions
+	switch ctlFunc {
+	case funcs.BridgeEncoderGetBitrate, funcs.BridgeEncoderGetInDtx:
+	default:
+		return enc.getStreamCtlInt32Locked(ctlFunc, enc.encoderPtr)
+	}
+	var total int32
+	for s, ptr := range enc.streamPtrs {
+		val, err := enc.getStreamCtlInt32Locked(ctlFunc, ptr)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case ctlFunc == funcs.BridgeEncoderGetInDtx && (s == 0 || val == 0):
+			// The packet is only in DTX if every stream is.
+			total = val
+		case ctlFunc == funcs.BridgeEncoderGetBitrate:
+			total += val
+		}
+	}
+	return total, nil
+}
+
+// getStreamCtlInt32Locked calls a bridge getter on the encoder state ptr.
+func (enc *Encoder) getStreamCtlInt32Locked(ctlFunc api.Function, ptr uint32) (int32, error) {
 	ctx := context.Background()
 	valPtr, err := enc.wctx.allocateInt32Ptr(ctx) // Use method from wasmContext
 	if err != nil {
@@ -315,7 +628,7 @@ func (enc *Encoder) getCtlInt32(ctlFunc api.Function) (int32, error) {
 	}
 	defer enc.wctx.freeMemory(ctx, valPtr) // Use free from wasmContext
 
-	results, err := ctlFunc.Call(ctx, uint64(enc.encoderPtr), uint64(valPtr))
+	results, err := ctlFunc.Call(ctx, uint64(ptr), uint64(valPtr))
 	if err != nil {
 		return 0, fmt.Errorf("wasm ctl function call failed for getCtlInt32: %w", err)
 	}
@@ -330,6 +643,158 @@ func (enc *Encoder) getCtlInt32(ctlFunc api.Function) (int32, error) {
 	return int32(value), nil
 }
 
+// CTL request codes from opus_defines.h for settings that have no dedicated
+// bridge function. They are issued through the variadic opus_encoder_ctl.
+const (
+	opusSetApplicationRequest = 4000
+	opusGetApplicationRequest = 4001
+	opusSetBandwidthRequest   = 4008
+	opusGetBandwidthRequest   = 4009
+	opusGetLookaheadRequest   = 4027
+	opusGetFinalRangeRequest  = 4031
+	opusSetLSBDepthRequest    = 4036
+	opusGetLSBDepthRequest    = 4037
+
+	opusSetForceChannelsRequest      = 4022
+	opusGetForceChannelsRequest      = 4023
+	opusSetPredictionDisabledRequest = 4042
+	opusGetPredictionDisabledRequest = 4043
+)
+
+// encoderCtlLocked calls opus_encoder_ctl(st, request, args...). Setters
+// (even requests) go to every stream of a surround encoder, getters to the
+// first one. The caller must hold enc.mu.
+func (enc *Encoder) encoderCtlLocked(ctx context.Context, request int32, args ...uint32) error {
+	if enc.encoderPtr == 0 || enc.wctx == nil {
+		return errEncUninitialized
+	}
+	if request%2 != 0 {
+		return enc.streamCtlLocked(ctx, enc.encoderPtr, request, args...)
+	}
+	for _, ptr := range enc.states() {
+		if err := enc.streamCtlLocked(ctx, ptr, request, args...); err != nil {
+			return err
+		}
+	}
+	if request == opusSetBandwidthRequest && len(args) == 1 {
+		enc.bandwidth = 0
+		if v := int32(args[0]); v != opusAuto {
+			enc.bandwidth = Bandwidth(v)
+		}
+	}
+	if request == opusSetBitrateRequest && len(args) == 1 {
+		enc.bitrate = int32(args[0])
+	}
+	return nil
+}
+
+// streamCtlLocked calls opus_encoder_ctl on the encoder state ptr. The
+// caller must hold enc.mu.
+func (enc *Encoder) streamCtlLocked(ctx context.Context, ptr uint32, request int32, args ...uint32) error {
+	ctlFunc := enc.wctx.functions.OpusEncoderCtl
+	if ctlFunc == nil {
+		return fmt.Errorf("opus_encoder_ctl not found in Wasm functions cache")
+	}
+	return enc.wctx.callCtl(ctx, ctlFunc, "opus_encoder_ctl", ptr, request, args...)
+}
+
+// setRequest issues a setter CTL taking a single opus_int32 value.
+func (enc *Encoder) setRequest(request int32, value int32) error {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	return enc.setRequestLocked(request, value)
+}
+
+// setRequestLocked is setRequest for callers that already hold enc.mu.
+func (enc *Encoder) setRequestLocked(request int32, value int32) error {
+	return enc.encoderCtlLocked(context.Background(), request, uint32(value))
+}
+
+// getRequest issues a getter CTL writing a single opus_int32 value.
+func (enc *Encoder) getRequest(request int32) (int32, error) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	return enc.getRequestLocked(request)
+}
+
+// getRequestLocked is getRequest for callers that already hold enc.mu.
+func (enc *Encoder) getRequestLocked(request int32) (int32, error) {
+	if enc.encoderPtr == 0 || enc.wctx == nil {
+		return 0, errEncUninitialized
+	}
+	ctx := context.Background()
+	valPtr, err := enc.wctx.allocateInt32Ptr(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer enc.wctx.freeMemory(ctx, valPtr)
+	if request == opusGetFinalRangeRequest {
+		// The final range of a multistream packet is the XOR of its
+		// streams' final ranges, as in opus_multistream_encoder_ctl.
+		var rng uint32
+		for _, ptr := range enc.states() {
+			if err := enc.streamCtlLocked(ctx, ptr, request, valPtr); err != nil {
+				return 0, err
+			}
+			value, ok := enc.wctx.module.Memory().ReadUint32Le(valPtr)
+			if !ok {
+				return 0, fmt.Errorf("failed to read value from Wasm memory for ctl request %d", request)
+			}
+			rng ^= value
+		}
+		return int32(rng), nil
+	}
+	if err := enc.encoderCtlLocked(ctx, request, valPtr); err != nil {
+		return 0, err
+	}
+	value, ok := enc.wctx.module.Memory().ReadUint32Le(valPtr)
+	if !ok {
+		return 0, fmt.Errorf("failed to read value from Wasm memory for ctl request %d", request)
+	}
+	return int32(value), nil
+}
+
+// Ctl issues an arbitrary encoder CTL request (see opus_defines.h) for
+// settings this package does not wrap yet. args are passed as the request's
+// opus_int32 arguments. Following the libopus convention that getters have
+// odd request codes, for an odd request Ctl appends a pointer to an
+// opus_int32 and returns the value libopus stored there; otherwise it
+// returns 0. Requests taking other argument types are not supported. On a
+// multistream encoder getters without arguments are answered as the typed
+// getters do, e.g. OPUS_GET_FINAL_RANGE combines all streams.
+func (enc *Encoder) Ctl(request int32, args ...int32) (int32, error) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+
+	if enc.encoderPtr == 0 || enc.wctx == nil {
+		return 0, errEncUninitialized
+	}
+	if request%2 != 0 && len(args) == 0 {
+		return enc.getRequestLocked(request)
+	}
+	ctx := context.Background()
+	wasmArgs := make([]uint32, len(args), len(args)+1)
+	for i, arg := range args {
+		wasmArgs[i] = uint32(arg)
+	}
+	if request%2 == 0 {
+		return 0, enc.encoderCtlLocked(ctx, request, wasmArgs...)
+	}
+	valPtr, err := enc.wctx.allocateInt32Ptr(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer enc.wctx.freeMemory(ctx, valPtr)
+	if err := enc.encoderCtlLocked(ctx, request, append(wasmArgs, valPtr)...); err != nil {
+		return 0, err
+	}
+	value, ok := enc.wctx.module.Memory().ReadUint32Le(valPtr)
+	if !ok {
+		return 0, fmt.Errorf("failed to read value from Wasm memory for ctl request %d", request)
+	}
+	return int32(value), nil
+}
+
 // --- Specific CTL Functions ---
 
 // SetDTX configures the encoder's use of discontinuous transmission (DTX).
@@ -408,6 +873,24 @@ func (enc *Encoder) MaxBandwidth() (Bandwidth, error) {
 	return Bandwidth(val), err
 }
 
+// SetBandwidth pins the encoder to an exact audio bandwidth, overriding the
+// choice it would otherwise make from the bitrate. Unlike SetMaxBandwidth it
+// also prevents the encoder from going below bw.
+func (enc *Encoder) SetBandwidth(bw Bandwidth) error {
+	return enc.setRequest(opusSetBandwidthRequest, int32(bw))
+}
+
+// SetBandwidthToAuto lets the encoder choose the bandwidth again.
+func (enc *Encoder) SetBandwidthToAuto() error {
+	return enc.setRequest(opusSetBandwidthRequest, opusAuto)
+}
+
+// Bandwidth gets the bandwidth the encoder used for the most recent frame.
+func (enc *Encoder) Bandwidth() (Bandwidth, error) {
+	val, err := enc.getRequest(opusGetBandwidthRequest)
+	return Bandwidth(val), err
+}
+
 // SetInBandFEC configures the encoder's use of inband forward error correction (FEC).
 func (enc *Encoder) SetInBandFEC(fec bool) error {
 	val := int32(0)
@@ -473,6 +956,98 @@ func (enc *Encoder) VBRConstraint() (bool, error) {
 	return val != 0, nil
 }
 
+// SetApplication switches the coding mode of the encoder without
+// reallocating its state. libopus only accepts this before the first frame
+// has been encoded, or after Reset.
+func (enc *Encoder) SetApplication(application Application) error {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	if err := enc.setRequestLocked(opusSetApplicationRequest, int32(application)); err != nil {
+		return err
+	}
+	enc.application = application
+	return nil
+}
+
+// Application returns the encoder's current coding mode.
+func (enc *Encoder) Application() (Application, error) {
+	val, err := enc.getRequest(opusGetApplicationRequest)
+	return Application(val), err
+}
+
+// SetLSBDepth tells the encoder the bit depth of the source signal (8-24).
+// Lower depths let it stop spending bits on quantization noise, mostly
+// relevant for 16-bit sources near silence. The default is 24.
+func (enc *Encoder) SetLSBDepth(depth int) error {
+	return enc.setRequest(opusSetLSBDepthRequest, int32(depth))
+}
+
+// LSBDepth returns the configured bit depth of the source signal.
+func (enc *Encoder) LSBDepth() (int, error) {
+	val, err := enc.getRequest(opusGetLSBDepthRequest)
+	return int(val), err
+}
+
+// SetPredictionDisabled stops the encoder from using inter-frame prediction,
+// so every packet can be decoded without the ones before it. This makes
+// packets safe to splice and reorder at a significant cost in quality for a
+// given bitrate.
+func (enc *Encoder) SetPredictionDisabled(disabled bool) error {
+	val := int32(0)
+	if disabled {
+		val = 1
+	}
+	return enc.setRequest(opusSetPredictionDisabledRequest, val)
+}
+
+// PredictionDisabled reports whether inter-frame prediction is disabled.
+func (enc *Encoder) PredictionDisabled() (bool, error) {
+	val, err := enc.getRequest(opusGetPredictionDisabledRequest)
+	if err != nil {
+		return false, err
+	}
+	return val != 0, nil
+}
+
+// SetForceChannels forces the encoder to code the signal as mono (1) or
+// stereo (2) regardless of its input channel count. Pass 0 to let the
+// encoder decide, which is the default.
+func (enc *Encoder) SetForceChannels(channels int) error {
+	val := int32(channels)
+	if channels == 0 {
+		val = opusAuto
+	}
+	return enc.setRequest(opusSetForceChannelsRequest, val)
+}
+
+// ForceChannels returns the forced channel count, or 0 if the encoder decides.
+func (enc *Encoder) ForceChannels() (int, error) {
+	val, err := enc.getRequest(opusGetForceChannelsRequest)
+	if err != nil {
+		return 0, err
+	}
+	if val == opusAuto {
+		return 0, nil
+	}
+	return int(val), nil
+}
+
+// Lookahead returns the encoder's algorithmic delay in samples at its
+// sample rate. This is the pre-skip to write into an Ogg Opus header (scaled
+// to 48 kHz) and the offset between encoded and input timestamps.
+func (enc *Encoder) Lookahead() (int, error) {
+	val, err := enc.getRequest(opusGetLookaheadRequest)
+	return int(val), err
+}
+
+// FinalRange returns the final state of the range coder for the last
+// encoded packet. A decoder that received the packet intact ends with the
+// same value, which makes it a cheap check for bit-exact transport.
+func (enc *Encoder) FinalRange() (uint32, error) {
+	val, err := enc.getRequest(opusGetFinalRangeRequest)
+	return uint32(val), err
+}
+
 // Reset resets the codec state to be equivalent to a freshly initialized state.
 func (enc *Encoder) Reset() error {
 	enc.mu.Lock()
@@ -486,13 +1061,177 @@ func (enc *Encoder) Reset() error {
 		return fmt.Errorf("bridge_encoder_reset_state not found in Wasm functions cache")
 	}
 	ctx := context.Background()
-	results, err := resetFunc.Call(ctx, uint64(enc.encoderPtr))
+	for _, ptr := range enc.states() {
+		results, err := resetFunc.Call(ctx, uint64(ptr))
+		if err != nil {
+			return fmt.Errorf("bridge_encoder_reset_state call failed: %w", err)
+		}
+		res := int32(results[0])
+		if res != opusOk {
+			return Error(int(res))
+		}
+	}
+	return nil
+}
+
+// DefaultMaxPacketSize is a payload budget that keeps RTP/UDP/IP packets below
+// a typical 1280-1500 byte path MTU, avoiding IP fragmentation.
+const DefaultMaxPacketSize = 1200
+
+// SetMaxPacketSize caps the size of every encoded packet at maxBytes,
+// regardless of the size of the buffer passed to Encode. libopus honours the
+// cap by lowering the instantaneous bitrate of frames that would not fit.
+// A value of 0 removes the cap.
+func (enc *Encoder) SetMaxPacketSize(maxBytes int) error {
+	if maxBytes < 0 {
+		return fmt.Errorf("opus: invalid max packet size: %d", maxBytes)
+	}
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	enc.maxPacketSize = maxBytes
+	return nil
+}
+
+// MaxPacketSize returns the packet size cap set by SetMaxPacketSize, or 0 if
+// the encoder is not capped.
+func (enc *Encoder) MaxPacketSize() int {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	return enc.maxPacketSize
+}
+
+// PacketFrameSize returns the frame size (samples per channel) of the longest
+// legal Opus frame that, at the encoder's current bitrate, fits within the
+// packet size cap. Feeding the encoder frames of this size keeps packets under
+// the cap without forcing libopus to starve individual frames of bits. If no
+// cap is set the longest frame (60 ms) is returned.
+func (enc *Encoder) PacketFrameSize() (int, error) {
+	sampleRate, err := enc.SampleRate()
 	if err != nil {
-		return fmt.Errorf("bridge_encoder_reset_state call failed: %w", err)
+		return 0, err
 	}
-	res := int32(results[0])
-	if res != opusOk {
-		return Error(int(res))
+	bitrate, err := enc.Bitrate()
+	if err != nil {
+		return 0, err
 	}
+	maxBytes := enc.MaxPacketSize()
+
+	// Frame durations in units of 2.5 ms, longest first.
+	durations := []int{24, 16, 8, 4, 2, 1}
+	for _, d := range durations {
+		size := int64(bitrate) * int64(d) / (8 * 400)
+		if maxBytes == 0 || size <= int64(maxBytes) {
+			return sampleRate * d / 400, nil
+		}
+	}
+	return sampleRate / 400, nil
+}
+
+// payloadLimit returns the max_data_bytes value to pass to libopus for an
+// output buffer of bufLen bytes. Callers must hold enc.mu.
+func (enc *Encoder) payloadLimit(bufLen int) int {
+	if enc.maxPacketSize > 0 && enc.maxPacketSize < bufLen {
+		return enc.maxPacketSize
+	}
+	return bufLen
+}
+
+// TransportProfile describes the framing constraints of the transport that
+// carries the encoded packets.
+type TransportProfile struct {
+	// SlotSize is the fixed payload size in bytes of a transport that only
+	// carries constant-size slots (DECT/TETRA-like links). Zero means the
+	// transport accepts variable-size packets.
+	SlotSize int
+}
+
+// slotSettings are the settings a fixed-slot transport profile overrides.
+type slotSettings struct {
+	config        EncoderConfigDelta
+	maxPacketSize int
+}
+
+// SetTransportProfile adapts the encoder to the transport described by p.
+//
+// When p declares a fixed slot size the encoder switches to hard CBR with DTX
+// disabled and every packet produced by Encode is exactly SlotSize bytes long.
+// A profile without a slot size undoes that, restoring the DTX, VBR, bitrate
+// and packet size cap the encoder had before the first fixed-slot profile;
+// other settings are left alone. The change is applied as a unit, like Apply.
+func (enc *Encoder) SetTransportProfile(p TransportProfile) error {
+	if p.SlotSize < 0 {
+		return fmt.Errorf("opus: invalid transport slot size: %d", p.SlotSize)
+	}
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	if enc.encoderPtr == 0 || enc.wctx == nil {
+		return errEncUninitialized
+	}
+
+	if p.SlotSize == 0 {
+		if saved := enc.slotSaved; saved != nil {
+			if err := enc.applyLocked(saved.config); err != nil {
+				return err
+			}
+			enc.maxPacketSize = saved.maxPacketSize
+			enc.slotSaved = nil
+		}
+		enc.transport = p
+		return nil
+	}
+
+	saved := enc.slotSaved
+	if saved == nil {
+		config, err := enc.configLocked()
+		if err != nil {
+			return err
+		}
+		bitrate := int(enc.bitrate)
+		if bitrate == 0 {
+			bitrate = int(opusAuto)
+		}
+		saved = &slotSettings{
+			config:        EncoderConfigDelta{Bitrate: &bitrate, DTX: config.DTX, VBR: config.VBR},
+			maxPacketSize: enc.maxPacketSize,
+		}
+	}
+	// With VBR disabled and the bitrate at its maximum, libopus fills (and
+	// pads) every packet up to max_data_bytes.
+	off, bitrate := false, int(opusBitrateMax)
+	if err := enc.applyLocked(EncoderConfigDelta{Bitrate: &bitrate, DTX: &off, VBR: &off}); err != nil {
+		return err
+	}
+	enc.slotSaved = saved
+	enc.transport = p
+	enc.maxPacketSize = p.SlotSize
 	return nil
 }
+
+// TransportProfile returns the profile set by SetTransportProfile.
+func (enc *Encoder) TransportProfile() TransportProfile {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	return enc.transport
+}
+
+// SetPreprocessor installs a processor (or a ProcessorChain) that conditions
+// PCM before it is encoded. A nil p removes it. The caller's PCM buffers are
+// never modified.
+func (enc *Encoder) SetPreprocessor(p Processor) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	enc.preprocessor = p
+}
+
+// SetDenoiser installs a noise suppression stage that processes PCM before it
+// is encoded, replacing any preprocessor. A nil d removes it. The encoder does
+// not take ownership of d; the caller remains responsible for closing it. Use
+// SetPreprocessor with a ProcessorChain to combine a denoiser with other
+// processors.
+func (enc *Encoder) SetDenoiser(d Denoiser) {
+	if d == nil {
+		enc.SetPreprocessor(nil)
+		return
+	}
+	enc.SetPreprocessor(d)
+}
@@ -0,0 +1,215 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"fmt"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// EncoderConfigDelta is a set of encoder settings to change together with
+// Encoder.Apply. Nil fields are left untouched.
+type EncoderConfigDelta struct {
+	Bitrate        *int
+	Complexity     *int
+	MaxBandwidth   *Bandwidth
+	DTX            *bool
+	InBandFEC      *bool
+	PacketLossPerc *int
+	VBR            *bool
+	VBRConstraint  *bool
+}
+
+// ctlChange is a single CTL write scheduled by Apply.
+type ctlChange struct {
+	name  string
+	set   api.Function
+	get   api.Function
+	value int32
+}
+
+// Apply changes all settings in delta as a unit. The encoder lock is held for
+// the whole operation, so no Encode call can observe a partially applied
+// configuration. If any setting is rejected, the settings already changed by
+// this call are restored to their previous values and the error is returned.
+func (enc *Encoder) Apply(delta EncoderConfigDelta) error {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	return enc.applyLocked(delta)
+}
+
+// applyLocked implements Apply for callers that already hold enc.mu.
+func (enc *Encoder) applyLocked(delta EncoderConfigDelta) error {
+	if enc.encoderPtr == 0 || enc.wctx == nil {
+		return errEncUninitialized
+	}
+	changes := enc.wctx.functions.configChanges(delta)
+
+	type applied struct {
+		change ctlChange
+		old    int32
+	}
+	var done []applied
+	rollback := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			_ = enc.setCtlInt32Locked(done[i].change.set, done[i].old)
+		}
+	}
+	for _, c := range changes {
+		old, err := enc.getCtlInt32Locked(c.get)
+		if err != nil {
+			rollback()
+			return fmt.Errorf("opus: failed to read %s: %w", c.name, err)
+		}
+		if err := enc.setCtlInt32Locked(c.set, c.value); err != nil {
+			rollback()
+			return fmt.Errorf("opus: failed to set %s: %w", c.name, err)
+		}
+		done = append(done, applied{change: c, old: old})
+	}
+	return nil
+}
+
+// encoderSnapshot holds the settings reinitLocked restores after
+// opus_encoder_init: those covered by EncoderConfigDelta and those set by
+// CTL request.
+type encoderSnapshot struct {
+	config   EncoderConfigDelta
+	requests []requestValue
+}
+
+// requestValue is a setter CTL request and its opus_int32 argument.
+type requestValue struct {
+	request int32
+	value   int32
+}
+
+// snapshotRequests pairs the getter of each setting without a bridge
+// function with its setter.
+var snapshotRequests = []struct{ get, set int32 }{
+	{opusGetLSBDepthRequest, opusSetLSBDepthRequest},
+	{opusGetPredictionDisabledRequest, opusSetPredictionDisabledRequest},
+	{opusGetForceChannelsRequest, opusSetForceChannelsRequest},
+}
+
+// snapshotLocked reads every setting of the encoder that opus_encoder_init
+// would reset. Callers must hold enc.mu.
+func (enc *Encoder) snapshotLocked() (encoderSnapshot, error) {
+	config, err := enc.configLocked()
+	if err != nil {
+		return encoderSnapshot{}, err
+	}
+	snap := encoderSnapshot{config: config}
+	for _, r := range snapshotRequests {
+		v, err := enc.getRequestLocked(r.get)
+		if err != nil {
+			return snap, err
+		}
+		snap.requests = append(snap.requests, requestValue{request: r.set, value: v})
+	}
+	// OPUS_GET_BANDWIDTH reports the bandwidth of the last frame, not the
+	// one requested, so the request is remembered instead.
+	if enc.bandwidth != 0 {
+		snap.requests = append(snap.requests, requestValue{request: opusSetBandwidthRequest, value: int32(enc.bandwidth)})
+	}
+	return snap, nil
+}
+
+// restoreLocked writes back the settings of snap. Callers must hold enc.mu.
+func (enc *Encoder) restoreLocked(snap encoderSnapshot) error {
+	if err := enc.applyLocked(snap.config); err != nil {
+		return err
+	}
+	for _, r := range snap.requests {
+		if err := enc.setRequestLocked(r.request, r.value); err != nil {
+			return fmt.Errorf("opus: failed to restore ctl request %d: %w", r.request, err)
+		}
+	}
+	return nil
+}
+
+// configLocked reads every setting covered by EncoderConfigDelta. Callers
+// must hold enc.mu.
+func (enc *Encoder) configLocked() (EncoderConfigDelta, error) {
+	funcs := &enc.wctx.functions
+	readInt := func(get api.Function) (*int, error) {
+		v, err := enc.getCtlInt32Locked(get)
+		n := int(v)
+		return &n, err
+	}
+	readBool := func(get api.Function) (*bool, error) {
+		v, err := enc.getCtlInt32Locked(get)
+		b := v != 0
+		return &b, err
+	}
+
+	var snap EncoderConfigDelta
+	var err error
+	if snap.Bitrate, err = readInt(funcs.BridgeEncoderGetBitrate); err != nil {
+		return snap, err
+	}
+	if snap.Complexity, err = readInt(funcs.BridgeEncoderGetComplexity); err != nil {
+		return snap, err
+	}
+	maxBw, err := enc.getCtlInt32Locked(funcs.BridgeEncoderGetMaxBandwidth)
+	if err != nil {
+		return snap, err
+	}
+	bw := Bandwidth(maxBw)
+	snap.MaxBandwidth = &bw
+	if snap.DTX, err = readBool(funcs.BridgeEncoderGetDtx); err != nil {
+		return snap, err
+	}
+	if snap.InBandFEC, err = readBool(funcs.BridgeEncoderGetInbandFec); err != nil {
+		return snap, err
+	}
+	if snap.PacketLossPerc, err = readInt(funcs.BridgeEncoderGetPacketLossPerc); err != nil {
+		return snap, err
+	}
+	if snap.VBR, err = readBool(funcs.BridgeEncoderGetVbr); err != nil {
+		return snap, err
+	}
+	if snap.VBRConstraint, err = readBool(funcs.BridgeEncoderGetVbrConstraint); err != nil {
+		return snap, err
+	}
+	return snap, nil
+}
+
+// configChanges translates delta into the CTL writes needed to apply it.
+func (funcs *WasmFunctions) configChanges(delta EncoderConfigDelta) []ctlChange {
+	var changes []ctlChange
+	addInt := func(name string, set, get api.Function, v *int) {
+		if v != nil {
+			changes = append(changes, ctlChange{name: name, set: set, get: get, value: int32(*v)})
+		}
+	}
+	addBool := func(name string, set, get api.Function, v *bool) {
+		if v != nil {
+			val := int32(0)
+			if *v {
+				val = 1
+			}
+			changes = append(changes, ctlChange{name: name, set: set, get: get, value: val})
+		}
+	}
+
+	addInt("bitrate", funcs.BridgeEncoderSetBitrate, funcs.BridgeEncoderGetBitrate, delta.Bitrate)
+	addInt("complexity", funcs.BridgeEncoderSetComplexity, funcs.BridgeEncoderGetComplexity, delta.Complexity)
+	if delta.MaxBandwidth != nil {
+		changes = append(changes, ctlChange{
+			name:  "max bandwidth",
+			set:   funcs.BridgeEncoderSetMaxBandwidth,
+			get:   funcs.BridgeEncoderGetMaxBandwidth,
+			value: int32(*delta.MaxBandwidth),
+		})
+	}
+	addBool("DTX", funcs.BridgeEncoderSetDtx, funcs.BridgeEncoderGetDtx, delta.DTX)
+	addBool("inband FEC", funcs.BridgeEncoderSetInbandFec, funcs.BridgeEncoderGetInbandFec, delta.InBandFEC)
+	addInt("packet loss percentage", funcs.BridgeEncoderSetPacketLossPerc, funcs.BridgeEncoderGetPacketLossPerc, delta.PacketLossPerc)
+	addBool("VBR", funcs.BridgeEncoderSetVbr, funcs.BridgeEncoderGetVbr, delta.VBR)
+	addBool("VBR constraint", funcs.BridgeEncoderSetVbrConstraint, funcs.BridgeEncoderGetVbrConstraint, delta.VBRConstraint)
+	return changes
+}
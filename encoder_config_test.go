@@ -0,0 +1,53 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import "testing"
+
+func TestEncoder_Apply(t *testing.T) {
+	enc, err := NewEncoder(48000, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	bitrate, complexity, fec := 24000, 5, true
+	err = enc.Apply(EncoderConfigDelta{
+		Bitrate:    &bitrate,
+		Complexity: &complexity,
+		InBandFEC:  &fec,
+	})
+	if err != nil {
+		t.Fatalf("Error applying config: %v", err)
+	}
+	if br, _ := enc.Bitrate(); br != bitrate {
+		t.Errorf("Unexpected bitrate. Got %d, but expected %d", br, bitrate)
+	}
+	if cpx, _ := enc.Complexity(); cpx != complexity {
+		t.Errorf("Unexpected complexity. Got %d, but expected %d", cpx, complexity)
+	}
+	if got, _ := enc.InBandFEC(); got != fec {
+		t.Errorf("Unexpected inband FEC. Got %t, but expected %t", got, fec)
+	}
+}
+
+func TestEncoder_ApplyRollback(t *testing.T) {
+	enc, err := NewEncoder(48000, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetBitrate(16000); err != nil {
+		t.Fatalf("Error setting bitrate: %v", err)
+	}
+	bitrate, complexity := 64000, 42
+	err = enc.Apply(EncoderConfigDelta{
+		Bitrate:    &bitrate,
+		Complexity: &complexity,
+	})
+	if err == nil {
+		t.Fatalf("Expected error for invalid complexity %d", complexity)
+	}
+	if br, _ := enc.Bitrate(); br != 16000 {
+		t.Errorf("Bitrate not rolled back. Got %d, but expected %d", br, 16000)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import "time"
+
+// EncoderOption configures the encoder that NewStreamWriter creates.
+type EncoderOption func(*encoderOptions) error
+
+// encoderOptions collects the settings of the EncoderOptions.
+type encoderOptions struct {
+	application Application
+	frameSize   time.Duration
+	config      EncoderConfigDelta
+	comments    []string
+	// inputSampleRate is the rate of the audio before resampling, for the
+	// OpusHead header.
+	inputSampleRate int
+}
+
+func defaultEncoderOptions() encoderOptions {
+	return encoderOptions{application: AppAudio, frameSize: 20 * time.Millisecond}
+}
+
+// newEncoderOptions applies opts on top of the defaults.
+func newEncoderOptions(opts []EncoderOption) (encoderOptions, error) {
+	o := defaultEncoderOptions()
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return encoderOptions{}, err
+		}
+	}
+	return o, nil
+}
+
+// WithApplication sets the encoder's application; the default is AppAudio.
+func WithApplication(application Application) EncoderOption {
+	return func(o *encoderOptions) error {
+		o.application = application
+		return nil
+	}
+}
+
+// WithBitrate sets the target bitrate in bits per second.
+func WithBitrate(bitrate int) EncoderOption {
+	return func(o *encoderOptions) error {
+		o.config.Bitrate = &bitrate
+		return nil
+	}
+}
+
+// WithComplexity sets the encoder's computational complexity (0-10).
+func WithComplexity(complexity int) EncoderOption {
+	return func(o *encoderOptions) error {
+		o.config.Complexity = &complexity
+		return nil
+	}
+}
+
+// WithEncoderConfig applies the non-nil settings of delta to the encoder.
+func WithEncoderConfig(delta EncoderConfigDelta) EncoderOption {
+	return func(o *encoderOptions) error {
+		mergeSetting(&o.config.Bitrate, delta.Bitrate)
+		mergeSetting(&o.config.Complexity, delta.Complexity)
+		mergeSetting(&o.config.MaxBandwidth, delta.MaxBandwidth)
+		mergeSetting(&o.config.DTX, delta.DTX)
+		mergeSetting(&o.config.InBandFEC, delta.InBandFEC)
+		mergeSetting(&o.config.PacketLossPerc, delta.PacketLossPerc)
+		mergeSetting(&o.config.VBR, delta.VBR)
+		mergeSetting(&o.config.VBRConstraint, delta.VBRConstraint)
+		return nil
+	}
+}
+
+// mergeSetting sets *dst to src unless src is nil.
+func mergeSetting[T any](dst **T, src *T) {
+	if src != nil {
+		*dst = src
+	}
+}
+
+// WithFrameDuration sets the duration of each encoded frame: 2.5, 5, 10, 20
+// (the default), 40 or 60 ms.
+func WithFrameDuration(d time.Duration) EncoderOption {
+	return func(o *encoderOptions) error {
+		if err := validFrameDuration(d); err != nil {
+			return err
+		}
+		o.frameSize = d
+		return nil
+	}
+}
+
+// WithComment adds a "name=value" comment, such as TITLE or ARTIST, to the
+// OpusTags header of an Ogg Opus stream.
+func WithComment(name, value string) EncoderOption {
+	return func(o *encoderOptions) error {
+		o.comments = append(o.comments, name+"="+value)
+		return nil
+	}
+}
+
+// withInputSampleRate records the rate the audio had before it was
+// resampled for the encoder.
+func withInputSampleRate(rate int) EncoderOption {
+	return func(o *encoderOptions) error {
+		o.inputSampleRate = rate
+		return nil
+	}
+}
@@ -4,7 +4,12 @@
 
 package opus
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
 
 func TestEncoderNew(t *testing.T) {
 	enc, err := NewEncoder(48000, 1, AppVoIP)
@@ -15,6 +20,9 @@ func TestEncoderNew(t *testing.T) {
 	if err == nil || enc != nil {
 		t.Errorf("Expected error for illegal samplerate 12345")
 	}
+	if err != nil && !strings.Contains(err.Error(), "8000, 12000, 16000, 24000 or 48000") {
+		t.Errorf("Error for illegal samplerate doesn't list the legal ones: %v", err)
+	}
 }
 
 func TestEncoderUnitialized(t *testing.T) {
@@ -433,3 +441,526 @@ func TestEncoder_SetGetVBRConstraint(t *testing.T) {
 		}
 	}
 }
+
+func TestEncoder_MaxPacketSize(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetBitrate(256000); err != nil {
+		t.Fatalf("Error setting bitrate: %v", err)
+	}
+	if err := enc.SetMaxPacketSize(-1); err == nil {
+		t.Errorf("Expected error for negative max packet size")
+	}
+	const maxBytes = 100
+	if err := enc.SetMaxPacketSize(maxBytes); err != nil {
+		t.Fatalf("Error setting max packet size: %v", err)
+	}
+	if got := enc.MaxPacketSize(); got != maxBytes {
+		t.Errorf("Unexpected max packet size. Got %d, but expected %d", got, maxBytes)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 4000)
+	for i := 0; i < 5; i++ {
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		if n > maxBytes {
+			t.Errorf("Packet exceeds cap: %d > %d bytes", n, maxBytes)
+		}
+	}
+}
+
+func TestEncoder_PacketFrameSize(t *testing.T) {
+	enc, err := NewEncoder(48000, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	tests := []struct {
+		bitrate  int
+		maxBytes int
+		want     int
+	}{
+		{64000, 0, 2880},
+		{64000, DefaultMaxPacketSize, 2880},
+		{256000, DefaultMaxPacketSize, 960},
+		{256000, 200, 240},
+	}
+	for _, tt := range tests {
+		if err := enc.SetBitrate(tt.bitrate); err != nil {
+			t.Fatalf("Error setting bitrate: %v", err)
+		}
+		if err := enc.SetMaxPacketSize(tt.maxBytes); err != nil {
+			t.Fatalf("Error setting max packet size: %v", err)
+		}
+		got, err := enc.PacketFrameSize()
+		if err != nil {
+			t.Fatalf("Error getting packet frame size: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("PacketFrameSize(bitrate=%d, max=%d) = %d, want %d",
+				tt.bitrate, tt.maxBytes, got, tt.want)
+		}
+	}
+}
+
+func TestEncoder_TransportProfile(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const SLOT = 80
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetTransportProfile(TransportProfile{SlotSize: SLOT}); err != nil {
+		t.Fatalf("Error setting transport profile: %v", err)
+	}
+	vbr, err := enc.VBR()
+	if err != nil || vbr {
+		t.Errorf("Expected VBR disabled for fixed slot transport (err=%v)", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	silent := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	for _, in := range [][]int16{pcm, silent, pcm} {
+		n, err := enc.Encode(in, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		if n != SLOT {
+			t.Errorf("Expected %d byte packet, got %d", SLOT, n)
+		}
+	}
+	if _, err := enc.Encode(pcm, data[:SLOT-1]); err == nil {
+		t.Errorf("Expected error for buffer smaller than slot")
+	}
+
+	if err := enc.SetTransportProfile(TransportProfile{}); err != nil {
+		t.Fatalf("Error resetting transport profile: %v", err)
+	}
+	vbr, err = enc.VBR()
+	if err != nil || !vbr {
+		t.Errorf("Expected VBR enabled after fallback (err=%v)", err)
+	}
+	if enc.MaxPacketSize() != 0 {
+		t.Errorf("Expected packet size cap to be cleared")
+	}
+}
+
+func TestEncoder_TransportProfileKeepsConfig(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetMaxPacketSize(200); err != nil {
+		t.Fatalf("SetMaxPacketSize: %v", err)
+	}
+	if err := enc.SetBitrate(24000); err != nil {
+		t.Fatalf("SetBitrate: %v", err)
+	}
+	if err := enc.SetVBRConstraint(true); err != nil {
+		t.Fatalf("SetVBRConstraint: %v", err)
+	}
+	if err := enc.SetDTX(true); err != nil {
+		t.Fatalf("SetDTX: %v", err)
+	}
+	// A variable-size profile on its own changes nothing.
+	if err := enc.SetTransportProfile(TransportProfile{}); err != nil {
+		t.Fatalf("SetTransportProfile: %v", err)
+	}
+	if got := enc.MaxPacketSize(); got != 200 {
+		t.Errorf("MaxPacketSize() = %d, want 200", got)
+	}
+	// Going through two fixed-slot profiles and back restores the settings
+	// from before the first one.
+	for _, slot := range []int{80, 60, 0} {
+		if err := enc.SetTransportProfile(TransportProfile{SlotSize: slot}); err != nil {
+			t.Fatalf("SetTransportProfile(%d): %v", slot, err)
+		}
+	}
+	if got := enc.MaxPacketSize(); got != 200 {
+		t.Errorf("MaxPacketSize() = %d, want 200", got)
+	}
+	if got, err := enc.Bitrate(); err != nil || got != 24000 {
+		t.Errorf("Bitrate() = %d, %v; want 24000", got, err)
+	}
+	if dtx, err := enc.DTX(); err != nil || !dtx {
+		t.Errorf("DTX() = %v, %v; want true", dtx, err)
+	}
+	if vbr, err := enc.VBR(); err != nil || !vbr {
+		t.Errorf("VBR() = %v, %v; want true", vbr, err)
+	}
+	if c, err := enc.VBRConstraint(); err != nil || !c {
+		t.Errorf("VBRConstraint() = %v, %v; want true", c, err)
+	}
+}
+
+func TestEncoder_SetGetApplication(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	app, err := enc.Application()
+	if err != nil {
+		t.Fatalf("Error getting application: %v", err)
+	}
+	if app != AppAudio {
+		t.Errorf("Unexpected application. Got %d, but expected %d", app, AppAudio)
+	}
+	if err := enc.SetApplication(AppVoIP); err != nil {
+		t.Fatalf("Error setting application: %v", err)
+	}
+	app, err = enc.Application()
+	if err != nil || app != AppVoIP {
+		t.Errorf("Unexpected application. Got %d (err=%v), but expected %d", app, err, AppVoIP)
+	}
+
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	if _, err := enc.Encode(pcm, data); err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if err := enc.SetApplication(AppAudio); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg changing application mid-stream, got %v", err)
+	}
+	if err := enc.Reset(); err != nil {
+		t.Fatalf("Error resetting encoder: %v", err)
+	}
+	if err := enc.SetApplication(AppAudio); err != nil {
+		t.Errorf("Error setting application after reset: %v", err)
+	}
+}
+
+func TestEncoder_SetGetLSBDepth(t *testing.T) {
+	enc, err := NewEncoder(48000, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	depth, err := enc.LSBDepth()
+	if err != nil {
+		t.Fatalf("Error getting LSB depth: %v", err)
+	}
+	if depth != 24 {
+		t.Errorf("Unexpected default LSB depth. Got %d, but expected 24", depth)
+	}
+	for _, want := range []int{8, 16, 24} {
+		if err := enc.SetLSBDepth(want); err != nil {
+			t.Fatalf("Error setting LSB depth: %v", err)
+		}
+		depth, err := enc.LSBDepth()
+		if err != nil {
+			t.Fatalf("Error getting LSB depth: %v", err)
+		}
+		if depth != want {
+			t.Errorf("Unexpected LSB depth. Got %d, but expected %d", depth, want)
+		}
+	}
+	for _, bad := range []int{7, 25} {
+		if err := enc.SetLSBDepth(bad); err != ErrBadArg {
+			t.Errorf("Expected ErrBadArg for LSB depth %d, got %v", bad, err)
+		}
+	}
+}
+
+func TestEncoder_SetGetPredictionDisabled(t *testing.T) {
+	enc, err := NewEncoder(48000, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	disabled, err := enc.PredictionDisabled()
+	if err != nil {
+		t.Fatalf("Error getting prediction disabled: %v", err)
+	}
+	if disabled {
+		t.Errorf("Expected prediction to be enabled by default")
+	}
+	for _, want := range []bool{true, false} {
+		if err := enc.SetPredictionDisabled(want); err != nil {
+			t.Fatalf("Error setting prediction disabled: %v", err)
+		}
+		got, err := enc.PredictionDisabled()
+		if err != nil {
+			t.Fatalf("Error getting prediction disabled: %v", err)
+		}
+		if got != want {
+			t.Errorf("Unexpected prediction disabled. Got %v, but expected %v", got, want)
+		}
+	}
+}
+
+func TestEncoder_SetGetForceChannels(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	n, err := enc.ForceChannels()
+	if err != nil || n != 0 {
+		t.Errorf("Expected automatic channel selection by default, got %d (err=%v)", n, err)
+	}
+	if err := enc.SetForceChannels(1); err != nil {
+		t.Fatalf("Error forcing channels: %v", err)
+	}
+	n, err = enc.ForceChannels()
+	if err != nil || n != 1 {
+		t.Errorf("Unexpected forced channels. Got %d (err=%v), but expected 1", n, err)
+	}
+
+	pcm := make([]int16, FRAME_SIZE*2)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	if _, err := enc.Encode(pcm, data); err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if tocChannels(data[0]) != 1 {
+		t.Errorf("Expected a mono packet while forcing one channel")
+	}
+
+	if err := enc.SetForceChannels(3); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg forcing 3 channels, got %v", err)
+	}
+	if err := enc.SetForceChannels(0); err != nil {
+		t.Fatalf("Error restoring automatic channels: %v", err)
+	}
+	n, err = enc.ForceChannels()
+	if err != nil || n != 0 {
+		t.Errorf("Expected automatic channel selection, got %d (err=%v)", n, err)
+	}
+}
+
+func TestEncoder_Lookahead(t *testing.T) {
+	// 2.5 ms of delay compensation plus the 4 ms CELT overlap for the
+	// audio application, 2.5 ms for restricted low delay.
+	tests := []struct {
+		sampleRate  int
+		application Application
+		want        int
+	}{
+		{48000, AppAudio, 312},
+		{16000, AppAudio, 104},
+		{48000, AppRestrictedLowdelay, 120},
+	}
+	for _, tt := range tests {
+		enc, err := NewEncoder(tt.sampleRate, 1, tt.application)
+		if err != nil || enc == nil {
+			t.Fatalf("Error creating new encoder: %v", err)
+		}
+		got, err := enc.Lookahead()
+		if err != nil {
+			t.Fatalf("Error getting lookahead: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Lookahead(%d Hz, app %d) = %d, want %d", tt.sampleRate, tt.application, got, tt.want)
+		}
+	}
+}
+
+func TestEncoder_FinalRange(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+
+	var ranges [2]uint32
+	for i := range ranges {
+		enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+		if err != nil || enc == nil {
+			t.Fatalf("Error creating new encoder: %v", err)
+		}
+		if _, err := enc.Encode(pcm, data); err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		ranges[i], err = enc.FinalRange()
+		if err != nil {
+			t.Fatalf("Error getting final range: %v", err)
+		}
+	}
+	if ranges[0] == 0 {
+		t.Errorf("Expected a non-zero final range after encoding")
+	}
+	if ranges[0] != ranges[1] {
+		t.Errorf("Identical encoders disagree on final range: %#x != %#x", ranges[0], ranges[1])
+	}
+}
+
+func TestEncoder_SetGetBandwidth(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetBitrate(128000); err != nil {
+		t.Fatalf("Error setting bitrate: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	for _, bw := range []Bandwidth{Wideband, Narrowband, Fullband} {
+		if err := enc.SetBandwidth(bw); err != nil {
+			t.Fatalf("Error setting bandwidth: %v", err)
+		}
+		if _, err := enc.Encode(pcm, data); err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		if got := tocBandwidth(data[0]); got != bw {
+			t.Errorf("Unexpected packet bandwidth. Got %d, but expected %d", got, bw)
+		}
+		got, err := enc.Bandwidth()
+		if err != nil {
+			t.Fatalf("Error getting bandwidth: %v", err)
+		}
+		if got != bw {
+			t.Errorf("Unexpected bandwidth. Got %d, but expected %d", got, bw)
+		}
+	}
+	if err := enc.SetBandwidthToAuto(); err != nil {
+		t.Errorf("Error setting bandwidth to auto: %v", err)
+	}
+}
+
+func TestEncoder_Ctl(t *testing.T) {
+	// Request codes from opus_defines.h.
+	const (
+		OPUS_SET_COMPLEXITY = 4010
+		OPUS_GET_COMPLEXITY = 4011
+		OPUS_RESET_STATE    = 4028
+	)
+	enc, err := NewEncoder(48000, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if _, err := enc.Ctl(OPUS_SET_COMPLEXITY, 3); err != nil {
+		t.Fatalf("Error setting complexity through Ctl: %v", err)
+	}
+	complexity, err := enc.Complexity()
+	if err != nil || complexity != 3 {
+		t.Errorf("Unexpected complexity. Got %d (err=%v), but expected 3", complexity, err)
+	}
+	got, err := enc.Ctl(OPUS_GET_COMPLEXITY)
+	if err != nil || got != 3 {
+		t.Errorf("Unexpected Ctl complexity. Got %d (err=%v), but expected 3", got, err)
+	}
+	if _, err := enc.Ctl(OPUS_SET_COMPLEXITY, 11); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg for invalid complexity, got %v", err)
+	}
+	if _, err := enc.Ctl(OPUS_RESET_STATE); err != nil {
+		t.Errorf("Error resetting through Ctl: %v", err)
+	}
+	if _, err := enc.Ctl(1); err != ErrUnimplemented {
+		t.Errorf("Expected ErrUnimplemented for unknown request, got %v", err)
+	}
+}
+
+func TestEncoderReusesBuffer(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE*2)
+	addSine(pcm, SAMPLE_RATE, 440)
+	buf := enc.buf
+	data := make([]byte, 1000)
+	for i := 0; i < 10; i++ {
+		if _, err := enc.Encode(pcm, data); err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		if _, err := enc.EncodeFloat32(make([]float32, FRAME_SIZE*6*2), data); err != nil {
+			t.Fatalf("Couldn't encode 120 ms of float data: %v", err)
+		}
+	}
+	if enc.buf != buf {
+		t.Errorf("Buffer reallocated from %+v to %+v for frames it fits", buf, enc.buf)
+	}
+	// A bigger output buffer grows it.
+	if _, err := enc.EncodeFloat32(make([]float32, FRAME_SIZE*6*2), make([]byte, 8000)); err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if enc.buf.size <= buf.size {
+		t.Errorf("Buffer of %d bytes not grown for 8000 byte packets", enc.buf.size)
+	}
+}
+
+func TestEncoder_EncodeBytes(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 60 / 1000
+	newEnc := func() *Encoder {
+		enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+		if err != nil {
+			t.Fatalf("Error creating new encoder: %v", err)
+		}
+		if err := enc.SetBitrateToMax(); err != nil {
+			t.Fatalf("SetBitrateToMax: %v", err)
+		}
+		return enc
+	}
+	enc, ref := newEnc(), newEnc()
+	pcm := make([]int16, FRAME_SIZE*2)
+	addSine(pcm, SAMPLE_RATE, 440)
+
+	// The allocated buffer doesn't constrain the encoder.
+	packet, err := enc.EncodeBytes(pcm)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	data := make([]byte, 8000)
+	n, err := ref.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if !bytes.Equal(packet, data[:n]) {
+		t.Errorf("EncodeBytes returned %d bytes, Encode %d", len(packet), n)
+	}
+
+	fpcm := make([]float32, FRAME_SIZE*2)
+	for i, v := range pcm {
+		fpcm[i] = float32(v) / 32768
+	}
+	if err := enc.SetMaxPacketSize(100); err != nil {
+		t.Fatalf("SetMaxPacketSize: %v", err)
+	}
+	if packet, err := enc.EncodeFloat32Bytes(fpcm); err != nil || len(packet) == 0 || len(packet) > 100 {
+		t.Errorf("EncodeFloat32Bytes returned %d bytes (err=%v), want 1 to 100", len(packet), err)
+	}
+	if _, err := enc.EncodeBytes(nil); err == nil {
+		t.Errorf("Expected an error encoding no PCM")
+	}
+}
+
+func TestEncoder_FrameSizeError(t *testing.T) {
+	enc, err := NewEncoder(16000, 2, AppVoIP)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	_, err = enc.Encode(make([]int16, 2*300), make([]byte, 1000))
+	var fsErr *FrameSizeError
+	if !errors.As(err, &fsErr) || fsErr.Samples != 300 || fsErr.SampleRate != 16000 {
+		t.Fatalf("Expected a FrameSizeError for 300 samples at 16 kHz, got %v", err)
+	}
+	if !errors.Is(err, ErrBadArg) {
+		t.Errorf("FrameSizeError should match ErrBadArg")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "40, 80, 160, 320, 640, 960, 1280, 1600 or 1920") {
+		t.Errorf("Error message doesn't list the legal frame sizes: %q", msg)
+	}
+	if _, err := enc.EncodeFloat32(make([]float32, 2*100), make([]byte, 1000)); !errors.As(err, &fsErr) {
+		t.Errorf("Expected a FrameSizeError from EncodeFloat32, got %v", err)
+	}
+	if _, err := enc.Encode(make([]int16, 2*1920), make([]byte, 1000)); err != nil {
+		t.Errorf("Couldn't encode a 120 ms frame: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// Engine selects how wazero runs the libopus module.
+type Engine int
+
+const (
+	// EngineAuto compiles the module to native code where wazero supports
+	// it and interprets it elsewhere. It is the default.
+	EngineAuto Engine = iota
+	// EngineCompiler always compiles the module to native code. The first
+	// encoder or decoder takes longer to create, but codec calls run
+	// several times faster than interpreted. Initialization fails on
+	// platforms wazero can't compile for.
+	EngineCompiler
+	// EngineInterpreter interprets the module. It works on every platform,
+	// starts fast and doesn't map executable memory, at the cost of much
+	// slower codec calls. The compiler stays linked into the binary.
+	EngineInterpreter
+)
+
+// String returns the name of the engine.
+func (e Engine) String() string {
+	switch e {
+	case EngineAuto:
+		return "auto"
+	case EngineCompiler:
+		return "compiler"
+	case EngineInterpreter:
+		return "interpreter"
+	}
+	return fmt.Sprintf("Engine(%d)", int(e))
+}
+
+// SetEngine selects the engine the wasm runtime is created with. It must be
+// called before the first encoder or decoder is created, or after
+// CloseWasmContext; it returns an error while the runtime is running.
+func SetEngine(e Engine) error {
+	if e < EngineAuto || e > EngineInterpreter {
+		return fmt.Errorf("opus: unknown engine %v", e)
+	}
+	return Configure(func(o *runtimeOptions) { o.engine = e })
+}
+
+// engineRuntimeConfig returns the runtime configuration selecting e.
+func engineRuntimeConfig(e Engine) wazero.RuntimeConfig {
+	switch e {
+	case EngineCompiler:
+		return wazero.NewRuntimeConfigCompiler()
+	case EngineInterpreter:
+		return wazero.NewRuntimeConfigInterpreter()
+	}
+	return wazero.NewRuntimeConfig()
+}
@@ -0,0 +1,54 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSetEngine(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	if _, err := NewEncoder(SAMPLE_RATE, 1, AppAudio); err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := SetEngine(EngineInterpreter); err == nil {
+		t.Fatalf("Expected an error changing the engine of a running runtime")
+	}
+	if err := SetEngine(Engine(7)); err == nil {
+		t.Errorf("Expected an error for an unknown engine")
+	}
+
+	if err := CloseWasmContext(context.Background()); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	if err := SetEngine(EngineInterpreter); err != nil {
+		t.Fatalf("SetEngine: %v", err)
+	}
+	defer func() {
+		CloseWasmContext(context.Background())
+		SetEngine(EngineAuto)
+	}()
+
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	if n, err := dec.Decode(data[:n], make([]int16, FRAME_SIZE)); err != nil || n != FRAME_SIZE {
+		t.Errorf("Decoded %d samples (err=%v), want %d", n, err, FRAME_SIZE)
+	}
+}
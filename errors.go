@@ -7,7 +7,6 @@ package opus
 import (
 	"context" // Needed for GetWasmContext
 	"fmt"
-	"log" // Add log for potential errors getting context
 )
 
 type Error int
@@ -32,7 +31,7 @@ func (e Error) Error() string {
 	wctx, err := GetWasmContext(ctx) // Assuming GetWasmContext is accessible
 	if err != nil {
 		// Handle error getting context, perhaps return a default error string
-		log.Printf("Failed to get wasm context for strerror: %v", err)
+		logger().Warn("opus: failed to get wasm context for strerror", "err", err)
 		return fmt.Sprintf("opus: error getting WASM context (%d)", e)
 	}
 	defer releaseWasmContext(wctx)
@@ -40,14 +39,14 @@ func (e Error) Error() string {
 	opusStrError := wctx.module.ExportedFunction("opus_strerror")
 	if opusStrError == nil {
 		// Handle case where function is not exported
-		log.Printf("opus_strerror function not found in WASM module")
+		logger().Warn("opus: opus_strerror not found in wasm module")
 		return fmt.Sprintf("opus: opus_strerror not available in WASM (%d)", e)
 	}
 
 	// Call the WASM function
 	results, err := opusStrError.Call(ctx, uint64(e)) // Pass error code as uint64
 	if err != nil {
-		log.Printf("Failed to call opus_strerror: %v", err)
+		logger().Warn("opus: failed to call opus_strerror", "err", err)
 		return fmt.Sprintf("opus: failed calling opus_strerror (%d)", e)
 	}
 
@@ -57,7 +56,7 @@ func (e Error) Error() string {
 	// Read the C string from WASM memory
 	errorString, err := readCString(wctx.module.Memory(), uint32(ptrErrorString)) // Assuming readCString is accessible
 	if err != nil {
-		log.Printf("Failed to read error string from WASM memory: %v", err)
+		logger().Warn("opus: failed to read error string from wasm memory", "err", err)
 		return fmt.Sprintf("opus: failed reading error string from WASM (%d)", e)
 	}
 
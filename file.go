@@ -0,0 +1,258 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/godeps/opus/oggopus"
+)
+
+// fileSampleRate is the rate File decodes at. Ogg Opus streams always
+// describe their timing at 48 kHz.
+const fileSampleRate = 48000
+
+// maxPacketSamples is the longest Opus packet, 120 ms, in samples per
+// channel at 48 kHz.
+const maxPacketSamples = 5760
+
+// GainPolicy selects the gain File applies to the decoded audio.
+type GainPolicy int
+
+const (
+	// GainHeader applies only the OpusHead output gain, as RFC 7845
+	// requires of players. It is the default.
+	GainHeader GainPolicy = iota
+	// GainTrack adds the R128_TRACK_GAIN tag to the output gain, for
+	// playback normalized per track.
+	GainTrack
+	// GainAlbum adds the R128_ALBUM_GAIN tag to the output gain, falling
+	// back to R128_TRACK_GAIN if the stream has no album gain.
+	GainAlbum
+	// GainOff applies no gain at all.
+	GainOff
+)
+
+// File decodes an Ogg Opus file to 48 kHz PCM, like libopusfile's
+// OggOpusFile: it combines an oggopus.Reader with a decoder for the
+// stream's channel mapping, and handles pre-skip, end trimming and seeking.
+type File struct {
+	r        *oggopus.Reader
+	dec      oggopus.FloatPacketDecoder
+	closer   io.Closer
+	channels int
+	buf      []float32
+	pending  []float32 // decoded samples not returned yet
+	// scale is the linear gain applied in Go when the decoder can't apply
+	// it itself.
+	scale float32
+}
+
+// OpenFile opens the Ogg Opus file at path for decoding. Close the File to
+// release the file.
+func OpenFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := OpenReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	file.closer = f
+	return file, nil
+}
+
+// OpenReader reads the headers of the Ogg Opus stream in r and returns a
+// File decoding it.
+func OpenReader(r io.ReadSeeker) (*File, error) {
+	return newFile(r)
+}
+
+// newFile returns a File decoding r. Seek and Duration return
+// oggopus.ErrNotSeekable unless r is an io.Seeker.
+func newFile(r io.Reader) (*File, error) {
+	or, err := oggopus.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := newHeadDecoder(or.Head())
+	if err != nil {
+		return nil, err
+	}
+	channels := or.Head().Channels
+	f := &File{
+		r:        or,
+		dec:      dec,
+		channels: channels,
+		buf:      make([]float32, maxPacketSamples*channels),
+		scale:    1,
+	}
+	if err := f.SetGainPolicy(GainHeader); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// newHeadDecoder creates a 48 kHz decoder for the channel mapping in h.
+func newHeadDecoder(h oggopus.OpusHead) (oggopus.FloatPacketDecoder, error) {
+	switch MappingFamily(h.MappingFamily) {
+	case MappingFamilyRTP:
+		dec, err := NewDecoder(fileSampleRate, h.Channels)
+		if err != nil {
+			return nil, err
+		}
+		return dec, nil
+	case MappingFamilyProjection:
+		dec, err := NewProjectionDecoder(fileSampleRate, h.Channels, h.StreamCount, h.CoupledCount, h.ChannelMapping)
+		if err != nil {
+			return nil, err
+		}
+		return dec, nil
+	}
+	dec, err := NewMultistreamDecoder(fileSampleRate, ChannelMapping{
+		Family:         MappingFamily(h.MappingFamily),
+		Channels:       h.Channels,
+		Streams:        h.StreamCount,
+		CoupledStreams: h.CoupledCount,
+		Table:          h.ChannelMapping,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dec, nil
+}
+
+// Channels returns the number of interleaved output channels.
+func (f *File) Channels() int {
+	return f.channels
+}
+
+// Head returns the stream's OpusHead header.
+func (f *File) Head() oggopus.OpusHead {
+	return f.r.Head()
+}
+
+// Tags returns the stream's comments.
+func (f *File) Tags() oggopus.OpusTags {
+	return f.r.Tags()
+}
+
+// SetGainPolicy selects the gain applied to the decoded audio from the
+// OpusHead output gain and the R128 loudness tags. The gain is applied by the
+// decoder's gain CTL where the wasm module supports it, and in Go otherwise.
+// It takes effect from the next decoded packet.
+func (f *File) SetGainPolicy(p GainPolicy) error {
+	head, tags := f.r.Head(), f.r.Tags()
+	gain := head.OutputGain
+	switch p {
+	case GainHeader:
+	case GainTrack:
+		if g, ok := tags.TrackGain(); ok {
+			gain += g
+		}
+	case GainAlbum:
+		if g, ok := tags.AlbumGain(); ok {
+			gain += g
+		} else if g, ok := tags.TrackGain(); ok {
+			gain += g
+		}
+	case GainOff:
+		gain = 0
+	default:
+		return fmt.Errorf("opus: invalid gain policy %d", p)
+	}
+	return f.setGain(max(min(gain, math.MaxInt16), math.MinInt16))
+}
+
+// setGain applies gainQ8, in Q7.8 dB, to the decoded output.
+func (f *File) setGain(gainQ8 int) error {
+	if dec, ok := f.dec.(*Decoder); ok {
+		err := dec.SetGain(gainQ8)
+		if err == nil {
+			f.scale = 1
+			return nil
+		}
+		if !errors.Is(err, ErrDecoderCtlUnavailable) {
+			return err
+		}
+	}
+	f.scale = float32(math.Pow(10, float64(gainQ8)/(20*256)))
+	return nil
+}
+
+// Read decodes interleaved 48 kHz int16 PCM into pcm and returns the number
+// of samples per channel. It returns at most one packet of audio per call,
+// and io.EOF at the end of the stream.
+func (f *File) Read(pcm []int16) (int, error) {
+	if err := f.fill(len(pcm)); err != nil {
+		return 0, err
+	}
+	n := min(len(pcm), len(f.pending)) / f.channels
+	for i, v := range f.pending[:n*f.channels] {
+		pcm[i] = floatToInt16(v)
+	}
+	f.pending = f.pending[n*f.channels:]
+	return n, nil
+}
+
+// ReadFloat decodes interleaved 48 kHz float32 PCM into pcm, see Read.
+func (f *File) ReadFloat(pcm []float32) (int, error) {
+	if err := f.fill(len(pcm)); err != nil {
+		return 0, err
+	}
+	n := min(len(pcm), len(f.pending)) / f.channels
+	copy(pcm, f.pending[:n*f.channels])
+	f.pending = f.pending[n*f.channels:]
+	return n, nil
+}
+
+// fill decodes packets until there are samples pending.
+func (f *File) fill(bufLen int) error {
+	if bufLen < f.channels {
+		return fmt.Errorf("opus: PCM buffer holds less than one sample per channel")
+	}
+	for len(f.pending) == 0 {
+		n, err := f.r.DecodeNextFloat32(f.dec, f.buf)
+		if err != nil {
+			return err
+		}
+		f.pending = f.buf[:n*f.channels]
+		if f.scale != 1 {
+			for i := range f.pending {
+				f.pending[i] *= f.scale
+			}
+		}
+	}
+	return nil
+}
+
+// Seek moves the read position to pos from the start of the stream.
+func (f *File) Seek(pos time.Duration) error {
+	f.pending = nil
+	return f.r.SeekTime(pos)
+}
+
+// Duration returns the playback duration of the stream.
+func (f *File) Duration() (time.Duration, error) {
+	return f.r.Duration()
+}
+
+// Close closes the file opened by OpenFile. It does nothing for a File
+// created by OpenReader.
+func (f *File) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	err := f.closer.Close()
+	f.closer = nil
+	return err
+}
@@ -0,0 +1,125 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	f, err := OpenFile("testdata/speech_8.opus")
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	defer f.Close()
+	if f.Channels() != 1 {
+		t.Errorf("Got %d channels, want 1", f.Channels())
+	}
+	tags := f.Tags()
+	if enc, ok := tags.Get("ENCODER"); !ok || enc == "" {
+		t.Errorf("Missing ENCODER tag")
+	}
+	duration, err := f.Duration()
+	if err != nil {
+		t.Fatalf("Duration: %v", err)
+	}
+	readAll := func() (total int, energy float64) {
+		pcm := make([]int16, 1000) // smaller than a packet
+		for {
+			n, err := f.Read(pcm)
+			if err == io.EOF {
+				return total, energy
+			}
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			for _, v := range pcm[:n] {
+				energy += float64(v) * float64(v)
+			}
+			total += n
+		}
+	}
+	total, energy := readAll()
+	if want := int(duration * 48000 / time.Second); total != want {
+		t.Errorf("Read %d samples, want %d", total, want)
+	}
+	if energy == 0 {
+		t.Errorf("Decoded only silence")
+	}
+
+	if err := f.Seek(2 * time.Second); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	pcm := make([]float32, maxPacketSamples)
+	rest := 0
+	for {
+		n, err := f.ReadFloat(pcm)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFloat: %v", err)
+		}
+		rest += n
+	}
+	if rest != total-2*48000 {
+		t.Errorf("Read %d samples after seeking to 2s, want %d", rest, total-2*48000)
+	}
+	if _, err := f.Read(make([]int16, 0)); err == nil {
+		t.Errorf("Expected error for an empty buffer")
+	}
+	if _, err := OpenFile("testdata/speech_8.wav"); err == nil {
+		t.Errorf("Expected error opening a WAV file")
+	}
+}
+
+func TestFileGain(t *testing.T) {
+	readFirst := func(gainQ8 int) []float32 {
+		f, err := OpenFile("testdata/speech_8.opus")
+		if err != nil {
+			t.Fatalf("Error opening file: %v", err)
+		}
+		defer f.Close()
+		if err := f.setGain(gainQ8); err != nil {
+			t.Fatalf("setGain: %v", err)
+		}
+		// The decoder applies the gain with OPUS_SET_GAIN, not File.
+		if got, err := f.dec.(*Decoder).Gain(); err != nil || got != gainQ8 || f.scale != 1 {
+			t.Fatalf("Decoder gain %d (err=%v) and scale %v for gain %d", got, err, f.scale, gainQ8)
+		}
+		pcm := make([]float32, maxPacketSamples)
+		n, err := f.ReadFloat(pcm)
+		if err != nil {
+			t.Fatalf("ReadFloat: %v", err)
+		}
+		return pcm[:n]
+	}
+	// -6.02 dB halves the amplitude.
+	plain, quiet := readFirst(0), readFirst(-1541)
+	if len(plain) != len(quiet) {
+		t.Fatalf("Read %d and %d samples", len(plain), len(quiet))
+	}
+	for i := range plain {
+		if d := quiet[i] - plain[i]/2; d > 1e-4 || d < -1e-4 {
+			t.Fatalf("Sample %d: got %v with gain, %v without", i, quiet[i], plain[i])
+		}
+	}
+
+	f, err := OpenFile("testdata/speech_8.opus")
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	defer f.Close()
+	for _, p := range []GainPolicy{GainHeader, GainTrack, GainAlbum, GainOff} {
+		if err := f.SetGainPolicy(p); err != nil {
+			t.Errorf("SetGainPolicy(%d): %v", p, err)
+		}
+	}
+	if err := f.SetGainPolicy(GainPolicy(-1)); err == nil {
+		t.Errorf("Expected error for an invalid gain policy")
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+// EncodeFloat64 is EncodeFloat32 for float64 PCM, as much Go DSP code
+// produces. The samples are converted to a float32 copy on every call; use
+// EncodeFloat32 to avoid the allocation.
+func (enc *Encoder) EncodeFloat64(pcm []float64, data []byte) (int, error) {
+	return enc.EncodeFloat32(float64sToFloat32s(pcm), data)
+}
+
+// DecodeFloat64 is DecodeFloat32 into float64 PCM. It decodes into a
+// temporary float32 buffer of the size of pcm on every call; use
+// DecodeFloat32 to avoid the allocation.
+func (dec *Decoder) DecodeFloat64(data []byte, pcm []float64) (int, error) {
+	buf := make([]float32, len(pcm))
+	n, err := dec.DecodeFloat32(data, buf)
+	if err != nil {
+		return 0, err
+	}
+	for i, v := range buf[:n*dec.Channels()] {
+		pcm[i] = float64(v)
+	}
+	return n, nil
+}
+
+func float64sToFloat32s(s []float64) []float32 {
+	out := make([]float32, len(s))
+	for i, v := range s {
+		out[i] = float32(v)
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestFloat64(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	pcm := make([]float64, FRAME_SIZE)
+	pcm32 := make([]float32, FRAME_SIZE)
+	for i := range pcm {
+		pcm[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/SAMPLE_RATE)
+		pcm32[i] = float32(pcm[i])
+	}
+
+	// Both variants produce the same packets and samples.
+	var packets [2][]byte
+	var decoded [2][]float64
+	for i := range packets {
+		enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+		if err != nil {
+			t.Fatalf("Error creating new encoder: %v", err)
+		}
+		dec, err := NewDecoder(SAMPLE_RATE, 1)
+		if err != nil {
+			t.Fatalf("Error creating new decoder: %v", err)
+		}
+		data := make([]byte, 1000)
+		var n int
+		if i == 0 {
+			n, err = enc.EncodeFloat64(pcm, data)
+		} else {
+			n, err = enc.EncodeFloat32(pcm32, data)
+		}
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		packets[i] = data[:n]
+
+		out := make([]float64, FRAME_SIZE)
+		if i == 0 {
+			n, err = dec.DecodeFloat64(packets[i], out)
+		} else {
+			out32 := make([]float32, FRAME_SIZE)
+			n, err = dec.DecodeFloat32(packets[i], out32)
+			for j, v := range out32 {
+				out[j] = float64(v)
+			}
+		}
+		if err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+		if n != FRAME_SIZE {
+			t.Errorf("Decoded %d samples, want %d", n, FRAME_SIZE)
+		}
+		decoded[i] = out
+	}
+	if !bytes.Equal(packets[0], packets[1]) {
+		t.Errorf("EncodeFloat64 and EncodeFloat32 produced different packets")
+	}
+	for j := range decoded[0] {
+		if decoded[0][j] != decoded[1][j] {
+			t.Fatalf("Sample %d: DecodeFloat64 got %v, DecodeFloat32 %v", j, decoded[0][j], decoded[1][j])
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"time"
+)
+
+// Frame is a single Opus packet together with its position on the stream
+// timeline. It is the unit exchanged between the packetizer, the jitter
+// buffer and the streaming decoder, so those components can be chained
+// without converting between their own packet representations.
+type Frame struct {
+	// Data holds the encoded Opus packet. It is empty for lost frames and
+	// may be empty for DTX gaps.
+	Data []byte
+	// PTS is the presentation timestamp of the first sample in the frame,
+	// relative to the start of the stream.
+	PTS time.Duration
+	// Duration is the amount of audio the frame covers.
+	Duration time.Duration
+	// Lost marks a frame that never arrived. Consumers should conceal it
+	// (PLC or FEC) instead of decoding Data.
+	Lost bool
+}
+
+// End returns the presentation timestamp immediately following the frame.
+func (f Frame) End() time.Duration {
+	return f.PTS + f.Duration
+}
@@ -0,0 +1,138 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"fmt"
+	"time"
+)
+
+// Sample is a PCM sample type the codecs accept: int16, float32 or a type
+// based on them.
+type Sample interface {
+	~int16 | ~float32
+}
+
+// FrameBuffer collects interleaved PCM of any length and hands it out in
+// frames of exactly the size the encoder accepts, keeping the leftover
+// samples for the next write.
+type FrameBuffer[T Sample] struct {
+	channels  int
+	frameSize int // samples per channel
+	buf       []T
+	start     int // index of the first buffered sample in buf
+}
+
+// NewFrameBuffer returns a FrameBuffer cutting audio at sampleRate with the
+// given number of channels into frames of frameDuration: 2.5, 5, 10, 20, 40
+// or 60 ms.
+func NewFrameBuffer[T Sample](sampleRate, channels int, frameDuration time.Duration) (*FrameBuffer[T], error) {
+	if err := validFrameDuration(frameDuration); err != nil {
+		return nil, err
+	}
+	if sampleRate <= 0 || channels <= 0 {
+		return nil, fmt.Errorf("opus: invalid sample rate %d or channel count %d", sampleRate, channels)
+	}
+	frameSize := time.Duration(sampleRate) * frameDuration / time.Second
+	if frameSize*time.Second != time.Duration(sampleRate)*frameDuration {
+		return nil, fmt.Errorf("opus: %v is not a whole number of samples at %d Hz", frameDuration, sampleRate)
+	}
+	return &FrameBuffer[T]{channels: channels, frameSize: int(frameSize)}, nil
+}
+
+// validFrameDuration checks that d is a frame duration Opus can encode.
+func validFrameDuration(d time.Duration) error {
+	switch d {
+	case 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+		20 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond:
+		return nil
+	}
+	return fmt.Errorf("opus: invalid frame duration %v", d)
+}
+
+// FrameSize returns the number of samples per channel in a frame.
+func (b *FrameBuffer[T]) FrameSize() int {
+	return b.frameSize
+}
+
+// Buffered returns the number of complete samples per channel buffered.
+func (b *FrameBuffer[T]) Buffered() int {
+	return (len(b.buf) - b.start) / b.channels
+}
+
+// Write appends interleaved samples. They need not be a whole number of
+// frames, or even of samples per channel.
+func (b *FrameBuffer[T]) Write(pcm []T) {
+	b.compact()
+	b.buf = append(b.buf, pcm...)
+}
+
+// WritePlanar appends one slice of samples per channel, interleaving them.
+// The slices must have the same length.
+func (b *FrameBuffer[T]) WritePlanar(planes ...[]T) error {
+	if len(planes) != b.channels {
+		return fmt.Errorf("opus: got %d channels, want %d", len(planes), b.channels)
+	}
+	if (len(b.buf)-b.start)%b.channels != 0 {
+		return fmt.Errorf("opus: buffer holds a partial interleaved sample")
+	}
+	n := len(planes[0])
+	for _, p := range planes[1:] {
+		if len(p) != n {
+			return fmt.Errorf("opus: channels have different lengths")
+		}
+	}
+	b.compact()
+	for i := 0; i < n; i++ {
+		for _, p := range planes {
+			b.buf = append(b.buf, p[i])
+		}
+	}
+	return nil
+}
+
+// Next returns the next complete frame, or false if less than a frame is
+// buffered. The frame is only valid until the next call to a FrameBuffer
+// method.
+func (b *FrameBuffer[T]) Next() ([]T, bool) {
+	size := b.frameSize * b.channels
+	if len(b.buf)-b.start < size {
+		return nil, false
+	}
+	frame := b.buf[b.start : b.start+size : b.start+size]
+	b.start += size
+	return frame, true
+}
+
+// Flush returns the buffered samples padded with silence to a full frame,
+// for the end of a stream, or false if nothing is buffered. A trailing
+// partial interleaved sample is dropped.
+func (b *FrameBuffer[T]) Flush() ([]T, bool) {
+	n := b.Buffered() * b.channels
+	if n == 0 {
+		b.Reset()
+		return nil, false
+	}
+	b.buf = b.buf[:b.start+n]
+	for len(b.buf)-b.start < b.frameSize*b.channels {
+		b.buf = append(b.buf, 0)
+	}
+	frame, _ := b.Next()
+	return frame, true
+}
+
+// Reset discards the buffered samples.
+func (b *FrameBuffer[T]) Reset() {
+	b.buf, b.start = b.buf[:0], 0
+}
+
+// compact moves the buffered samples to the front of buf once the consumed
+// part dominates, so the buffer doesn't grow without bound.
+func (b *FrameBuffer[T]) compact() {
+	if b.start > 0 && b.start >= len(b.buf)-b.start {
+		n := copy(b.buf, b.buf[b.start:])
+		b.buf, b.start = b.buf[:n], 0
+	}
+}
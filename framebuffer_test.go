@@ -0,0 +1,100 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrameBuffer(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	fb, err := NewFrameBuffer[int16](SAMPLE_RATE, 2, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error creating frame buffer: %v", err)
+	}
+	if fb.FrameSize() != FRAME_SIZE {
+		t.Errorf("FrameSize() = %d, want %d", fb.FrameSize(), FRAME_SIZE)
+	}
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+
+	// Feed odd-sized chunks, including one that splits a stereo sample,
+	// and check that the frames carry the samples in order.
+	next := int16(0)
+	fed := 0
+	frames := 0
+	data := make([]byte, 1000)
+	for _, n := range []int{1, 333, 1919, 7, 4000, 2} {
+		chunk := make([]int16, n)
+		for i := range chunk {
+			chunk[i] = int16(fed + i)
+		}
+		fed += n
+		fb.Write(chunk)
+		for {
+			frame, ok := fb.Next()
+			if !ok {
+				break
+			}
+			if len(frame) != 2*FRAME_SIZE {
+				t.Fatalf("Frame has %d samples, want %d", len(frame), 2*FRAME_SIZE)
+			}
+			for i, v := range frame {
+				if v != next {
+					t.Fatalf("Frame %d sample %d is %d, want %d", frames, i, v, next)
+				}
+				next++
+			}
+			if _, err := enc.Encode(frame, data); err != nil {
+				t.Fatalf("Couldn't encode data: %v", err)
+			}
+			frames++
+		}
+	}
+	if want := fed / (2 * FRAME_SIZE); frames != want {
+		t.Errorf("Got %d frames, want %d", frames, want)
+	}
+	if want := (fed % (2 * FRAME_SIZE)) / 2; fb.Buffered() != want {
+		t.Errorf("Buffered() = %d, want %d", fb.Buffered(), want)
+	}
+	if err := fb.WritePlanar([]int16{1}, []int16{2}); err != nil {
+		t.Errorf("WritePlanar: %v", err)
+	}
+	frame, ok := fb.Flush()
+	if !ok || len(frame) != 2*FRAME_SIZE {
+		t.Fatalf("Flush returned %d samples, %v", len(frame), ok)
+	}
+	tail := fed%(2*FRAME_SIZE) + 2
+	if frame[tail-2] != 1 || frame[tail-1] != 2 || frame[tail] != 0 {
+		t.Errorf("Unexpected end of flushed frame: %v", frame[tail-2:tail+1])
+	}
+	if _, ok := fb.Flush(); ok {
+		t.Errorf("Flush returned a frame from an empty buffer")
+	}
+
+	ffb, err := NewFrameBuffer[float32](16000, 3, 2500*time.Microsecond)
+	if err != nil {
+		t.Fatalf("Error creating frame buffer: %v", err)
+	}
+	if err := ffb.WritePlanar(make([]float32, 40), make([]float32, 40), make([]float32, 40)); err != nil {
+		t.Fatalf("WritePlanar: %v", err)
+	}
+	if frame, ok := ffb.Next(); !ok || len(frame) != 3*40 {
+		t.Errorf("Next returned %d samples, %v", len(frame), ok)
+	}
+	if err := ffb.WritePlanar(make([]float32, 1)); err == nil {
+		t.Errorf("Expected error for a missing channel")
+	}
+	if _, err := NewFrameBuffer[int16](SAMPLE_RATE, 2, 15*time.Millisecond); err == nil {
+		t.Errorf("Expected error for an invalid frame duration")
+	}
+	if _, err := NewFrameBuffer[int16](44100, 2, 2500*time.Microsecond); err == nil {
+		t.Errorf("Expected error for a fractional frame size")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The legal durations of an Opus frame.
+const (
+	Frame2_5ms = 2500 * time.Microsecond
+	Frame5ms   = 5 * time.Millisecond
+	Frame10ms  = 10 * time.Millisecond
+	Frame20ms  = 20 * time.Millisecond
+	Frame40ms  = 40 * time.Millisecond
+	Frame60ms  = 60 * time.Millisecond
+	Frame80ms  = 80 * time.Millisecond
+	Frame100ms = 100 * time.Millisecond
+	Frame120ms = 120 * time.Millisecond
+)
+
+// frameUnits are the legal Opus frame durations in units of 2.5 ms.
+var frameUnits = []int{1, 2, 4, 8, 16, 24, 32, 40, 48}
+
+// FrameSizeSamples returns the number of samples per channel of a frame of
+// duration d at sampleRate, e.g. 960 for Frame20ms at 48 kHz. d must be one
+// of the legal durations.
+func FrameSizeSamples(sampleRate int, d time.Duration) (int, error) {
+	if err := checkSampleRate(sampleRate); err != nil {
+		return 0, err
+	}
+	for _, u := range frameUnits {
+		if d == time.Duration(u)*Frame2_5ms {
+			return sampleRate * u / 400, nil
+		}
+	}
+	return 0, fmt.Errorf("opus: %v is not a legal frame duration; use 2.5ms, 5ms, 10ms, 20ms, 40ms, 60ms, 80ms, 100ms or 120ms", d)
+}
+
+// FrameDuration returns the duration of a frame of samples per channel at
+// sampleRate. It returns a FrameSizeError unless that is a legal frame size.
+func FrameDuration(sampleRate, samples int) (time.Duration, error) {
+	if err := checkSampleRate(sampleRate); err != nil {
+		return 0, err
+	}
+	if err := checkFrameSize(sampleRate, samples); err != nil {
+		return 0, err
+	}
+	return time.Duration(samples) * time.Second / time.Duration(sampleRate), nil
+}
+
+// FrameSizeError is returned by Encode for PCM whose length isn't a legal
+// Opus frame duration at the encoder's sample rate. It matches ErrBadArg,
+// the error libopus would report, with errors.Is.
+type FrameSizeError struct {
+	Samples    int // samples per channel passed to Encode
+	SampleRate int
+}
+
+func (e *FrameSizeError) Error() string {
+	sizes := legalFrameSizes(e.SampleRate)
+	list := make([]string, len(sizes))
+	for i, n := range sizes {
+		list[i] = strconv.Itoa(n)
+	}
+	return fmt.Sprintf("opus: %d samples per channel is not a legal frame size at %d Hz; use %s or %s",
+		e.Samples, e.SampleRate, strings.Join(list[:len(list)-1], ", "), list[len(list)-1])
+}
+
+// Is reports whether target is ErrBadArg.
+func (e *FrameSizeError) Is(target error) bool {
+	return target == ErrBadArg
+}
+
+// legalFrameSizes returns the legal frame sizes in samples per channel at
+// sampleRate, shortest first.
+func legalFrameSizes(sampleRate int) []int {
+	sizes := make([]int, len(frameUnits))
+	for i, u := range frameUnits {
+		sizes[i] = sampleRate * u / 400
+	}
+	return sizes
+}
+
+// checkFrameSize returns a FrameSizeError unless samples per channel is a
+// legal frame size at sampleRate.
+func checkFrameSize(sampleRate, samples int) error {
+	for _, u := range frameUnits {
+		if samples*400 == sampleRate*u {
+			return nil
+		}
+	}
+	return &FrameSizeError{Samples: samples, SampleRate: sampleRate}
+}
@@ -0,0 +1,47 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFrameSizeSamples(t *testing.T) {
+	tests := []struct {
+		sampleRate int
+		d          time.Duration
+		samples    int
+	}{
+		{48000, Frame20ms, 960},
+		{48000, Frame2_5ms, 120},
+		{48000, Frame120ms, 5760},
+		{16000, Frame10ms, 160},
+		{8000, Frame60ms, 480},
+		{12000, Frame5ms, 60},
+	}
+	for _, tt := range tests {
+		n, err := FrameSizeSamples(tt.sampleRate, tt.d)
+		if err != nil || n != tt.samples {
+			t.Errorf("FrameSizeSamples(%d, %v) = %d, %v, want %d", tt.sampleRate, tt.d, n, err, tt.samples)
+		}
+		d, err := FrameDuration(tt.sampleRate, tt.samples)
+		if err != nil || d != tt.d {
+			t.Errorf("FrameDuration(%d, %d) = %v, %v, want %v", tt.sampleRate, tt.samples, d, err, tt.d)
+		}
+	}
+
+	if _, err := FrameSizeSamples(48000, 30*time.Millisecond); err == nil {
+		t.Errorf("Expected an error for a 30 ms frame")
+	}
+	if _, err := FrameSizeSamples(0, Frame20ms); err == nil {
+		t.Errorf("Expected an error for sample rate 0")
+	}
+	var fsErr *FrameSizeError
+	if _, err := FrameDuration(48000, 1000); !errors.As(err, &fsErr) {
+		t.Errorf("Expected a FrameSizeError for 1000 samples, got %v", err)
+	}
+}
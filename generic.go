@@ -0,0 +1,55 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import "unsafe"
+
+// Encode encodes pcm with enc, calling Encoder.Encode or EncodeFloat32 for
+// the sample type, so code can be generic over it, e.g. along with a
+// FrameBuffer[T].
+func Encode[T Sample](enc *Encoder, pcm []T, data []byte) (int, error) {
+	if isInt16[T]() {
+		return enc.Encode(castSamples[T, int16](pcm), data)
+	}
+	return enc.EncodeFloat32(castSamples[T, float32](pcm), data)
+}
+
+// Decode decodes data with dec into pcm, calling Decoder.Decode or
+// DecodeFloat32 for the sample type.
+func Decode[T Sample](dec *Decoder, data []byte, pcm []T) (int, error) {
+	if isInt16[T]() {
+		return dec.Decode(data, castSamples[T, int16](pcm))
+	}
+	return dec.DecodeFloat32(data, castSamples[T, float32](pcm))
+}
+
+// DecodeFEC is Decode calling Decoder.DecodeFEC or DecodeFECFloat32.
+func DecodeFEC[T Sample](dec *Decoder, data []byte, pcm []T) (int, error) {
+	if isInt16[T]() {
+		return dec.DecodeFEC(data, castSamples[T, int16](pcm))
+	}
+	return dec.DecodeFECFloat32(data, castSamples[T, float32](pcm))
+}
+
+// DecodePLC is Decode calling Decoder.DecodePLC or DecodePLCFloat32.
+func DecodePLC[T Sample](dec *Decoder, pcm []T) (int, error) {
+	if isInt16[T]() {
+		return dec.DecodePLC(castSamples[T, int16](pcm))
+	}
+	return dec.DecodePLCFloat32(castSamples[T, float32](pcm))
+}
+
+// isInt16 reports whether T is based on int16 rather than float32, the
+// only Sample type of its size.
+func isInt16[T Sample]() bool {
+	var zero T
+	return unsafe.Sizeof(zero) == 2
+}
+
+// castSamples returns s as a slice of U, sharing its memory. T and U must
+// have the same underlying type.
+func castSamples[T, U Sample](s []T) []U {
+	return unsafe.Slice((*U)(unsafe.Pointer(unsafe.SliceData(s))), len(s))
+}
@@ -0,0 +1,64 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"math"
+	"testing"
+)
+
+// level is a sample type of user code, based on float32.
+type level float32
+
+func TestGenericCodec(t *testing.T) {
+	testGenericCodec[int16](t, 8000)
+	testGenericCodec[float32](t, 0.25)
+	testGenericCodec[level](t, 0.25)
+}
+
+func testGenericCodec[T Sample](t *testing.T, amplitude T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	pcm := make([]T, FRAME_SIZE)
+	for i := range pcm {
+		pcm[i] = T(float64(amplitude) * math.Sin(2*math.Pi*440*float64(i)/SAMPLE_RATE))
+	}
+	data := make([]byte, 1000)
+	out := make([]T, FRAME_SIZE)
+	var peak float64
+	for i := 0; i < 5; i++ {
+		n, err := Encode(enc, pcm, data)
+		if err != nil {
+			t.Fatalf("%T: Couldn't encode data: %v", pcm, err)
+		}
+		if n, err := Decode(dec, data[:n], out); err != nil || n != FRAME_SIZE {
+			t.Fatalf("%T: Decoded %d samples (err=%v), want %d", pcm, n, err, FRAME_SIZE)
+		}
+	}
+	for _, v := range out {
+		peak = max(peak, math.Abs(float64(v)))
+	}
+	if peak < float64(amplitude)/2 {
+		t.Errorf("%T: Decoded peak %v, want about %v", pcm, peak, amplitude)
+	}
+	if n, err := DecodePLC(dec, out); err != nil || n != FRAME_SIZE {
+		t.Errorf("%T: Concealed %d samples (err=%v), want %d", pcm, n, err, FRAME_SIZE)
+	}
+	n, err := Encode(enc, pcm, data)
+	if err != nil {
+		t.Fatalf("%T: Couldn't encode data: %v", pcm, err)
+	}
+	if n, err := DecodeFEC(dec, data[:n], out); err != nil || n != FRAME_SIZE {
+		t.Errorf("%T: Recovered %d samples (err=%v), want %d", pcm, n, err, FRAME_SIZE)
+	}
+}
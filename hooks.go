@@ -0,0 +1,138 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EncodedFrameInfo describes a frame encoded or decoded by any Encoder or
+// Decoder, as passed to the hooks registered with OnEncode and OnDecode.
+type EncodedFrameInfo struct {
+	// Packet is the Opus packet produced or consumed; nil for a concealed
+	// frame. It is only valid during the hook call, copy it to keep it.
+	Packet               []byte
+	SampleRate, Channels int
+	// Samples is the number of samples per channel of the frame.
+	Samples int
+	// Duration is the amount of audio in the frame.
+	Duration time.Duration
+	// FEC marks a decoded frame recovered from the in-band FEC of Packet,
+	// the packet following the lost one; PLC marks a concealed frame.
+	FEC, PLC bool
+	// Latency is the time libopus took.
+	Latency time.Duration
+}
+
+// codecHooks is a copy-on-write list of hooks.
+type codecHooks struct {
+	mu    sync.Mutex
+	next  int
+	hooks atomic.Pointer[[]codecHook]
+}
+
+type codecHook struct {
+	id int
+	f  func(EncodedFrameInfo)
+}
+
+var encodeHooks, decodeHooks codecHooks
+
+// OnEncode registers f to be called after every frame encoded by any
+// Encoder, e.g. to log, count or dump the bitstream without wrapping each
+// call site. Like an AuditSink, f runs synchronously with the encoder
+// locked, so it must not call back into it and should be quick. The
+// returned function unregisters f.
+func OnEncode(f func(EncodedFrameInfo)) (remove func()) {
+	return encodeHooks.add(f)
+}
+
+// OnDecode is OnEncode for frames decoded, recovered or concealed by any
+// Decoder.
+func OnDecode(f func(EncodedFrameInfo)) (remove func()) {
+	return decodeHooks.add(f)
+}
+
+func (h *codecHooks) add(f func(EncodedFrameInfo)) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.next++
+	id := h.next
+	var hooks []codecHook
+	if old := h.hooks.Load(); old != nil {
+		hooks = append(hooks, *old...)
+	}
+	hooks = append(hooks, codecHook{id, f})
+	h.hooks.Store(&hooks)
+	return func() { h.remove(id) }
+}
+
+func (h *codecHooks) remove(id int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old := h.hooks.Load()
+	if old == nil {
+		return
+	}
+	var hooks []codecHook
+	for _, hook := range *old {
+		if hook.id != id {
+			hooks = append(hooks, hook)
+		}
+	}
+	if len(hooks) == 0 {
+		h.hooks.Store(nil)
+		return
+	}
+	h.hooks.Store(&hooks)
+}
+
+// run calls the hooks with info, built only if there are any.
+func (h *codecHooks) run(info func() EncodedFrameInfo) {
+	hooks := h.hooks.Load()
+	if hooks == nil {
+		return
+	}
+	i := info()
+	for _, hook := range *hooks {
+		hook.f(i)
+	}
+}
+
+// frameEncoded accounts for packet, samples per channel encoded in d, in
+// the stats and the hooks.
+func frameEncoded(packet []byte, samples, sampleRate, channels int, d time.Duration) {
+	stats.encoded(len(packet), d)
+	encodeHooks.run(func() EncodedFrameInfo {
+		return EncodedFrameInfo{
+			Packet:     packet,
+			SampleRate: sampleRate,
+			Channels:   channels,
+			Samples:    samples,
+			Duration:   time.Duration(samples) * time.Second / time.Duration(sampleRate),
+			Latency:    d,
+		}
+	})
+}
+
+// frameDecoded is frameEncoded for a call to opus_decode with packet, or
+// none for PLC.
+func frameDecoded(packet []byte, decodeFEC int, samples, sampleRate, channels int, d time.Duration) {
+	stats.decoded(len(packet), decodeFEC, d)
+	decodeHooks.run(func() EncodedFrameInfo {
+		return EncodedFrameInfo{
+			Packet:     packet,
+			SampleRate: sampleRate,
+			Channels:   channels,
+			Samples:    samples,
+			Duration:   time.Duration(samples) * time.Second / time.Duration(sampleRate),
+			FEC:        decodeFEC != 0,
+			PLC:        decodeFEC == 0 && len(packet) == 0,
+			Latency:    d,
+		}
+	})
+}
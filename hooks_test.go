@@ -0,0 +1,71 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+
+	var encoded, decoded []EncodedFrameInfo
+	removeEnc := OnEncode(func(i EncodedFrameInfo) {
+		i.Packet = append([]byte(nil), i.Packet...)
+		encoded = append(encoded, i)
+	})
+	removeDec := OnDecode(func(i EncodedFrameInfo) {
+		decoded = append(decoded, i)
+	})
+
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if _, err := dec.Decode(data[:n], pcm); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	if _, err := dec.DecodePLC(pcm); err != nil {
+		t.Fatalf("Couldn't conceal frame: %v", err)
+	}
+	packet := append([]byte(nil), data[:n]...)
+	removeEnc()
+	removeDec()
+	if _, err := enc.Encode(pcm, data); err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+
+	if len(encoded) != 1 {
+		t.Fatalf("Expected 1 encode hook call, got %d", len(encoded))
+	}
+	e := encoded[0]
+	if !bytes.Equal(e.Packet, packet) || e.Samples != FRAME_SIZE || e.Duration != 20*time.Millisecond ||
+		e.SampleRate != SAMPLE_RATE || e.Channels != 1 {
+		t.Errorf("Unexpected encode info: %+v", e)
+	}
+	if len(decoded) != 2 {
+		t.Fatalf("Expected 2 decode hook calls, got %d", len(decoded))
+	}
+	if decoded[0].PLC || decoded[0].FEC || decoded[0].Samples != FRAME_SIZE {
+		t.Errorf("Unexpected decode info: %+v", decoded[0])
+	}
+	if !decoded[1].PLC || decoded[1].Packet != nil {
+		t.Errorf("Expected a PLC frame, got %+v", decoded[1])
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// JitterStats counts how the frames played out by a JitterBuffer were
+// produced, and the packets it discarded.
+type JitterStats struct {
+	// Decoded, FEC and PLC count the frames decoded from their packet,
+	// recovered from the in-band FEC of the following packet, and
+	// concealed.
+	Decoded, FEC, PLC int
+	// Late counts packets that arrived after their playout slot or twice,
+	// Dropped packets discarded to keep the delay bounded.
+	Late, Dropped int
+}
+
+// maxJitterJump is the largest PTS jump accepted as the same stream; a
+// bigger one is taken as a restart of the sender.
+const maxJitterJump = 20 * time.Second
+
+// JitterBuffer reorders frames arriving from the network, e.g. over RTP
+// through the rtp Depayloader, and decodes them into a steady stream of
+// PCM. Each call to Pop plays out the next frame: the packet itself if it
+// arrived in time, the in-band FEC of the following packet if it carries
+// some, or PLC otherwise.
+//
+// Frames are ordered by PTS. Frames marked Lost, as the rtp Depayloader
+// reports for sequence number gaps, are concealed, and replaced by the real
+// frame if it turns up before its playout slot. A gap in the PTS without
+// lost frames is a DTX pause, during which the sender transmits nothing; it
+// is filled with PLC.
+//
+// Push and Pop may be called from different goroutines, typically a network
+// reader and an audio callback.
+type JitterBuffer struct {
+	dec   *Decoder
+	depth int
+
+	mu        sync.Mutex
+	frames    []Frame // buffered frames by PTS
+	started   bool
+	buffering bool
+	next      time.Duration // PTS of the next sample to play
+	played    time.Duration // end of the last frame taken out of frames
+	stats     JitterStats
+}
+
+// NewJitterBuffer returns a jitter buffer decoding with dec that waits for
+// depth frames (at least 1) before it starts playing out, trading latency
+// for tolerance to jitter. When frames pile up beyond twice the depth,
+// e.g. because the sender's clock runs fast, the oldest are dropped.
+func NewJitterBuffer(dec *Decoder, depth int) *JitterBuffer {
+	return &JitterBuffer{
+		dec:   dec,
+		depth: max(depth, 1),
+	}
+}
+
+// Push adds f, placed by its PTS. f.Data is copied. Frames older than the
+// one being played out are counted as late and discarded, as are frames
+// already buffered.
+func (jb *JitterBuffer) Push(f Frame) {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	if !jb.started || f.PTS-jb.next > maxJitterJump || jb.next-f.PTS > maxJitterJump {
+		jb.restart(f.PTS)
+	}
+	if f.PTS < jb.played {
+		if !f.Lost {
+			jb.stats.Late++
+		}
+		return
+	}
+	overlaps := func(g Frame) bool { return g.PTS < f.End() && f.PTS < g.End() || g.PTS == f.PTS }
+	if f.Lost {
+		if slices.ContainsFunc(jb.frames, overlaps) {
+			return
+		}
+	} else {
+		// The frame replaces the ones reported lost in its place.
+		jb.frames = slices.DeleteFunc(jb.frames, func(g Frame) bool { return g.Lost && overlaps(g) })
+		if slices.ContainsFunc(jb.frames, overlaps) {
+			jb.stats.Late++
+			return
+		}
+		f.Data = append([]byte(nil), f.Data...)
+	}
+	i, _ := slices.BinarySearchFunc(jb.frames, f.PTS, func(g Frame, pts time.Duration) int {
+		return cmp.Compare(g.PTS, pts)
+	})
+	jb.frames = slices.Insert(jb.frames, i, f)
+}
+
+// restart forgets the buffered frames and starts buffering the stream
+// from pts.
+func (jb *JitterBuffer) restart(pts time.Duration) {
+	jb.frames = jb.frames[:0]
+	jb.started = true
+	jb.buffering = true
+	jb.next, jb.played = pts, pts
+}
+
+// Pop plays out the next frame into pcm and returns the number of samples
+// per channel written. pcm must hold the longest packet the sender uses, up
+// to 120 ms. Pop returns 0 while the buffer fills up at the start of the
+// stream.
+//
+// When no frame is buffered at all, the sender is taken to be in DTX or
+// the network to be stalled: the frame is concealed but nothing is counted
+// as lost, so playback resumes with the next frame that arrives.
+func (jb *JitterBuffer) Pop(pcm []int16) (int, error) {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	if !jb.started {
+		return 0, nil
+	}
+	if jb.buffering {
+		if len(jb.frames) < jb.depth {
+			return 0, nil
+		}
+		jb.buffering = false
+	}
+	for len(jb.frames) > 2*jb.depth {
+		f := jb.frames[0]
+		jb.frames = jb.frames[1:]
+		if !f.Lost {
+			jb.stats.Dropped++
+		}
+		jb.next, jb.played = f.End(), f.End()
+	}
+
+	if len(jb.frames) == 0 {
+		// Nothing to play: fill the gap.
+		n, err := jb.decode(Frame{}, nil, pcm)
+		if err != nil {
+			return 0, err
+		}
+		jb.next += jb.duration(n)
+		return n, nil
+	}
+	f := jb.frames[0]
+	if gap := f.PTS - jb.next; gap >= jb.duration(1) {
+		// A DTX pause before the next frame: fill it without
+		// overshooting the frame.
+		n, err := jb.decode(Frame{}, nil, pcm)
+		if err != nil {
+			return 0, err
+		}
+		n = min(n, int(gap*time.Duration(jb.dec.sample_rate)/time.Second))
+		jb.next += jb.duration(n)
+		return n, nil
+	}
+
+	// Play the frame, even if it is a little behind schedule after a
+	// stall. A lost frame may be recovered from the FEC of the next one.
+	jb.frames = jb.frames[1:]
+	var next []byte
+	if f.Lost && len(jb.frames) > 0 && !jb.frames[0].Lost && jb.frames[0].PTS == f.End() {
+		next = jb.frames[0].Data
+	}
+	n, err := jb.decode(f, next, pcm)
+	if err != nil {
+		return 0, err
+	}
+	jb.next = f.PTS + jb.duration(n)
+	jb.played = f.End()
+	return n, nil
+}
+
+// decode decodes or recovers a frame with DecodeFrame and counts how.
+func (jb *JitterBuffer) decode(f Frame, next []byte, pcm []int16) (int, error) {
+	n, method, err := jb.dec.DecodeFrame(f, next, pcm)
+	if err != nil {
+		return 0, err
+	}
+	switch method {
+	case DecodedPacket:
+		jb.stats.Decoded++
+	case DecodedFEC:
+		jb.stats.FEC++
+	case DecodedPLC:
+		jb.stats.PLC++
+	}
+	return n, nil
+}
+
+// duration converts samples per channel at the decoder's rate to a
+// duration.
+func (jb *JitterBuffer) duration(samples int) time.Duration {
+	return time.Duration(samples) * time.Second / time.Duration(jb.dec.sample_rate)
+}
+
+// Stats returns the counts of frames played out and packets discarded so
+// far.
+func (jb *JitterBuffer) Stats() JitterStats {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	return jb.stats
+}
+
+// Reset forgets all buffered frames; the next frame pushed starts a new
+// stream. It doesn't reset the decoder.
+func (jb *JitterBuffer) Reset() {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	jb.frames = jb.frames[:0]
+	jb.started = false
+}
@@ -0,0 +1,161 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitterBuffer(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const frames = 20
+	const lost = 10
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetInBandFEC(true); err != nil {
+		t.Fatalf("Error setting FEC: %v", err)
+	}
+	if err := enc.SetPacketLossPerc(30); err != nil {
+		t.Fatalf("Error setting packet loss: %v", err)
+	}
+	if err := enc.SetBitrate(24000); err != nil {
+		t.Fatalf("Error setting bitrate: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	var packets [][]byte
+	for i := 0; i <= frames; i++ {
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		packets = append(packets, data[:n])
+	}
+	if fec, _ := PacketHasFEC(packets[lost+1]); !fec {
+		t.Fatalf("Packet after the lost one carries no FEC")
+	}
+
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	jb := NewJitterBuffer(dec, 3)
+	out := make([]int16, maxFrameSize48k)
+	if n, err := jb.Pop(out); n != 0 || err != nil {
+		t.Fatalf("Pop before any packet returned %d, %v", n, err)
+	}
+	const frameDuration = 20 * time.Millisecond
+	const pts0 = 5 * time.Second
+	push := func(i int, slot int) {
+		jb.Push(Frame{Data: packets[i], PTS: pts0 + time.Duration(slot)*frameDuration, Duration: frameDuration})
+	}
+	played := 0
+	pop := func() {
+		t.Helper()
+		n, err := jb.Pop(out)
+		if err != nil {
+			t.Fatalf("Pop: %v", err)
+		}
+		if n != FRAME_SIZE {
+			t.Fatalf("Pop returned %d samples, want %d", n, FRAME_SIZE)
+		}
+		played++
+	}
+
+	// Swap pairs of packets after the first and lose one, which is
+	// reported lost as the rtp Depayloader does.
+	order := []int{0}
+	for i := 1; i+1 < frames; i += 2 {
+		order = append(order, i+1, i)
+	}
+	order = append(order, frames-1)
+	for i, j := range order {
+		if j != lost {
+			push(j, j)
+		} else {
+			jb.Push(Frame{PTS: pts0 + time.Duration(j)*frameDuration, Duration: frameDuration, Lost: true})
+		}
+		if i >= 2 {
+			pop()
+		}
+	}
+	pop()
+	pop()
+	// Duplicates and packets behind the playout position are late.
+	push(frames-1, frames-1)
+	push(lost, lost)
+	if played != frames {
+		t.Fatalf("Played %d frames, want %d", played, frames)
+	}
+	want := JitterStats{Decoded: frames - 1, FEC: 1, Late: 2}
+	if got := jb.Stats(); got != want {
+		t.Errorf("Got stats %+v, want %+v", got, want)
+	}
+
+	// A DTX pause of 4 frames is concealed before the next packet plays.
+	push(frames, frames+4)
+	for i := 0; i < 5; i++ {
+		pop()
+	}
+	want.PLC, want.Decoded = 4, frames
+	if got := jb.Stats(); got != want {
+		t.Errorf("Got stats %+v after DTX, want %+v", got, want)
+	}
+
+	// Too many packets at once drops the oldest, keeping twice the depth.
+	jb.Reset()
+	for i := 0; i < 10; i++ {
+		push(i, i)
+	}
+	pop()
+	if got := jb.Stats().Dropped; got != 4 {
+		t.Errorf("Dropped %d packets, want 4", got)
+	}
+}
+
+func TestJitterBuffer_LostFrameReplaced(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const frameDuration = 20 * time.Millisecond
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	frame := func(i int) Frame {
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		return Frame{Data: data[:n], PTS: time.Duration(i) * frameDuration, Duration: frameDuration}
+	}
+	jb := NewJitterBuffer(dec, 2)
+	f0, f1, f2 := frame(0), frame(1), frame(2)
+	jb.Push(f0)
+	// Frame 1 is reported lost, then arrives before its slot.
+	jb.Push(Frame{PTS: f1.PTS, Duration: f1.Duration, Lost: true})
+	jb.Push(f2)
+	jb.Push(f1)
+	out := make([]int16, maxFrameSize48k)
+	for i := 0; i < 3; i++ {
+		if n, err := jb.Pop(out); err != nil || n != FRAME_SIZE {
+			t.Fatalf("Pop %d = %d, %v", i, n, err)
+		}
+	}
+	if got, want := jb.Stats(), (JitterStats{Decoded: 3}); got != want {
+		t.Errorf("Got stats %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"cmp"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// WasmAllocation is a block of wasm memory allocated by the package and not
+// freed yet, as reported by the leak detector.
+type WasmAllocation struct {
+	// Instance is the name of the wasm instance holding the block.
+	Instance  string
+	Ptr, Size uint32
+	// Stack is the Go call stack that allocated the block, innermost
+	// first, one "function file:line" per line.
+	Stack string
+}
+
+// WithLeakDetector(true) records every wasm malloc and free made by the
+// package, with the Go call stack of the allocation. CloseWasmContext then
+// logs each block still allocated at warning level through the logger set
+// by SetLogger, and OutstandingAllocations lists them at any time. Blocks
+// of codecs that are still in use are reported too, so close or drop all
+// of them first. Capturing the stacks slows down allocations; use it while
+// debugging only.
+func WithLeakDetector(enabled bool) Option {
+	return func(o *runtimeOptions) { o.leakDetector = enabled }
+}
+
+// OutstandingAllocations returns the blocks of wasm memory allocated and
+// not freed since the runtime was initialized, ordered by instance and
+// address. It returns nil unless the runtime runs WithLeakDetector(true).
+func OutstandingAllocations() []WasmAllocation {
+	wasmMu.Lock()
+	m := globalWasmManager
+	wasmMu.Unlock()
+	if m == nil {
+		return nil
+	}
+	return m.allocs.outstanding()
+}
+
+type allocKey struct {
+	instance string
+	ptr      uint32
+}
+
+// allocTracker records the live allocations of a runtime. A nil
+// *allocTracker, the default, records nothing.
+type allocTracker struct {
+	mu   sync.Mutex
+	live map[allocKey]WasmAllocation
+}
+
+func newAllocTracker() *allocTracker {
+	return &allocTracker{live: make(map[allocKey]WasmAllocation)}
+}
+
+// add records an allocation, with the stack of the caller of
+// wasmContext.malloc.
+func (t *allocTracker) add(instance string, ptr, size uint32) {
+	if t == nil {
+		return
+	}
+	var pcs [16]uintptr
+	n := runtime.Callers(3, pcs[:])
+	var stack strings.Builder
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(&stack, "%s %s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			break
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.live[allocKey{instance, ptr}] = WasmAllocation{Instance: instance, Ptr: ptr, Size: size, Stack: stack.String()}
+}
+
+func (t *allocTracker) remove(instance string, ptr uint32) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.live, allocKey{instance, ptr})
+}
+
+// forget drops the allocations of a closed instance, whose memory is gone
+// with it.
+func (t *allocTracker) forget(instance string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k := range t.live {
+		if k.instance == instance {
+			delete(t.live, k)
+		}
+	}
+}
+
+func (t *allocTracker) outstanding() []WasmAllocation {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	allocs := make([]WasmAllocation, 0, len(t.live))
+	for _, a := range t.live {
+		allocs = append(allocs, a)
+	}
+	t.mu.Unlock()
+	slices.SortFunc(allocs, func(a, b WasmAllocation) int {
+		return cmp.Or(cmp.Compare(a.Instance, b.Instance), cmp.Compare(a.Ptr, b.Ptr))
+	})
+	return allocs
+}
+
+// report logs the outstanding allocations.
+func (t *allocTracker) report() {
+	for _, a := range t.outstanding() {
+		logger().Warn("opus: wasm allocation not freed",
+			"instance", a.Instance, "ptr", a.Ptr, "size", a.Size, "stack", a.Stack)
+	}
+}
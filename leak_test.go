@@ -0,0 +1,70 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLeakDetector(t *testing.T) {
+	ctx := context.Background()
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	if err := Configure(WithLeakDetector(true)); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	var buf bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer func() {
+		SetLogger(nil)
+		CloseWasmContext(ctx)
+		Configure(WithLeakDetector(false))
+	}()
+
+	enc, err := NewEncoder(48000, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	allocs := OutstandingAllocations()
+	if len(allocs) == 0 {
+		t.Fatalf("Encoder state not tracked")
+	}
+	for _, a := range allocs {
+		if a.Ptr == 0 || a.Size == 0 || a.Instance == "" || !strings.Contains(a.Stack, "NewEncoder") {
+			t.Errorf("Unexpected allocation: %+v", a)
+		}
+	}
+
+	// A block freed again is no longer outstanding; one that isn't is.
+	freed, err := enc.wctx.writeToMemory(ctx, make([]byte, 16))
+	if err != nil {
+		t.Fatalf("writeToMemory: %v", err)
+	}
+	if err := enc.wctx.freeMemory(ctx, freed); err != nil {
+		t.Fatalf("freeMemory: %v", err)
+	}
+	leaked, err := enc.wctx.writeToMemory(ctx, make([]byte, 24))
+	if err != nil {
+		t.Fatalf("writeToMemory: %v", err)
+	}
+	if got := len(OutstandingAllocations()); got != len(allocs)+1 {
+		t.Errorf("Expected %d outstanding allocations, got %d", len(allocs)+1, got)
+	}
+
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, "allocation not freed") || !strings.Contains(out, "size=24") {
+		t.Errorf("Leak of block %d not reported: %q", leaked, out)
+	}
+	if OutstandingAllocations() != nil {
+		t.Errorf("Expected no tracking after CloseWasmContext")
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// discardHandler drops every record; it is the handler of the default
+// logger, so the package stays silent unless SetLogger is called.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var (
+	discardLogger = slog.New(discardHandler{})
+	pkgLogger     atomic.Pointer[slog.Logger]
+)
+
+// SetLogger sets the logger receiving the package's diagnostics: failures
+// that can't be returned, like those of finalizers, and details of the wasm
+// runtime initialization. By default nothing is logged; nil restores that.
+func SetLogger(l *slog.Logger) {
+	pkgLogger.Store(l)
+}
+
+// logger returns the logger set by SetLogger, or one discarding everything.
+func logger() *slog.Logger {
+	if l := pkgLogger.Load(); l != nil {
+		return l
+	}
+	return discardLogger
+}
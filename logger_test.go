@@ -0,0 +1,47 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetLogger(t *testing.T) {
+	ctx := context.Background()
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	var buf bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer func() {
+		SetLogger(nil)
+		CloseWasmContext(ctx)
+		Configure(WithWasmBinary(nil))
+	}()
+	if err := Configure(WithWasmBinary([]byte("\x00asm\x01\x00\x00\x00"))); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if _, err := NewDecoder(48000, 1); err == nil {
+		t.Fatalf("Expected an error creating a decoder from a broken module")
+	}
+	if out := buf.String(); !strings.Contains(out, "level=ERROR") || !strings.Contains(out, "initialization failed") {
+		t.Errorf("Initialization failure not logged: %q", out)
+	}
+
+	// Without a logger, nothing is written.
+	buf.Reset()
+	SetLogger(nil)
+	CloseWasmContext(ctx)
+	if _, err := NewDecoder(48000, 1); err == nil {
+		t.Fatalf("Expected an error creating a decoder from a broken module")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output, got %q", buf.String())
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DecodeMethod tells how Decoder.DecodeWithLoss produced a frame.
+type DecodeMethod int
+
+const (
+	// DecodedPacket means the packet itself was decoded.
+	DecodedPacket DecodeMethod = iota
+	// DecodedFEC means a lost packet was recovered from the in-band FEC
+	// of the next packet.
+	DecodedFEC
+	// DecodedPLC means a lost packet was concealed with PLC.
+	DecodedPLC
+)
+
+func (m DecodeMethod) String() string {
+	switch m {
+	case DecodedPacket:
+		return "packet"
+	case DecodedFEC:
+		return "FEC"
+	case DecodedPLC:
+		return "PLC"
+	}
+	return "unknown"
+}
+
+// DecodeWithLoss decodes the audio of one packet of a stream with losses.
+// packet is the packet to decode, or nil if it was lost; next is the packet
+// that follows it, or nil if it hasn't arrived (yet).
+//
+// A lost packet is recovered from next's in-band FEC when next carries
+// some, and concealed with PLC otherwise. Either way the frame gets the
+// duration of the last packet decoded, which is what libopus expects, so
+// pcm must hold the longest packet the stream uses, up to 120 ms. Decode
+// next normally afterwards: FEC only recovers the packet before it.
+//
+// It returns the number of samples per channel decoded and the method used.
+func (dec *Decoder) DecodeWithLoss(packet, next []byte, pcm []int16) (int, DecodeMethod, error) {
+	return dec.decodeWithLoss(context.Background(), packet, next, pcm)
+}
+
+func (dec *Decoder) decodeWithLoss(ctx context.Context, packet, next []byte, pcm []int16) (int, DecodeMethod, error) {
+	if len(packet) > 0 {
+		n, err := dec.DecodeContext(ctx, packet, pcm)
+		return n, DecodedPacket, err
+	}
+	frameSize, err := dec.lostFrameSize(next)
+	if err != nil {
+		return 0, DecodedPLC, err
+	}
+	size := frameSize * dec.channels
+	if size > len(pcm) {
+		return 0, DecodedPLC, fmt.Errorf("opus: PCM buffer of %d samples too short for a %d sample frame", len(pcm), size)
+	}
+	// DecodeFEC and DecodePLC size the frame by the capacity of pcm.
+	frame := pcm[:size:size]
+	if len(next) > 0 {
+		if fec, _ := PacketHasFEC(next); fec {
+			n, err := dec.DecodeFEC(next, frame)
+			return n, DecodedFEC, err
+		}
+	}
+	n, err := dec.DecodePLC(frame)
+	return n, DecodedPLC, err
+}
+
+// DecodeFrame decodes f into pcm, e.g. a frame played out of a jitter
+// buffer. A lost frame is recovered from the in-band FEC of next, the data
+// of the frame right after it, when next carries some, and concealed with
+// PLC otherwise; a frame without data that isn't lost, such as a DTX gap,
+// is concealed with PLC. Concealment covers f.Duration rounded down to
+// 2.5 ms and limited to what pcm holds, or the duration of the last packet
+// if f.Duration is 0.
+//
+// It returns the number of samples per channel decoded and the method used.
+func (dec *Decoder) DecodeFrame(f Frame, next []byte, pcm []int16) (int, DecodeMethod, error) {
+	if !f.Lost && len(f.Data) > 0 {
+		return dec.decodeWithLoss(context.Background(), f.Data, nil, pcm)
+	}
+	if !f.Lost {
+		next = nil
+	}
+	if f.Duration <= 0 {
+		return dec.decodeWithLoss(context.Background(), nil, next, pcm)
+	}
+	step := dec.sample_rate / 400
+	frameSize := int(f.Duration * time.Duration(dec.sample_rate) / time.Second)
+	frameSize = min(frameSize, len(pcm)/dec.channels, 48*step) / step * step
+	if frameSize == 0 {
+		return 0, DecodedPLC, nil
+	}
+	size := frameSize * dec.channels
+	// DecodeFEC and DecodePLC size the frame by the capacity of pcm.
+	frame := pcm[:size:size]
+	if len(next) > 0 {
+		if fec, _ := PacketHasFEC(next); fec {
+			n, err := dec.DecodeFEC(next, frame)
+			return n, DecodedFEC, err
+		}
+	}
+	n, err := dec.DecodePLC(frame)
+	return n, DecodedPLC, err
+}
+
+// lostFrameSize returns the duration in samples per channel of a lost
+// packet: that of the last packet decoded, or of next, or 20 ms.
+func (dec *Decoder) lostFrameSize(next []byte) (int, error) {
+	frameSize, err := dec.LastPacketDuration()
+	if err != nil {
+		return 0, err
+	}
+	if frameSize > 0 {
+		return frameSize, nil
+	}
+	if len(next) > 0 {
+		if n, err := dec.NbSamples(next); err == nil {
+			return n, nil
+		}
+	}
+	return dec.sample_rate / 50, nil
+}
@@ -0,0 +1,69 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+)
+
+func TestDecodeWithLoss(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetInBandFEC(true); err != nil {
+		t.Fatalf("Error setting FEC: %v", err)
+	}
+	if err := enc.SetPacketLossPerc(30); err != nil {
+		t.Fatalf("Error setting packet loss: %v", err)
+	}
+	if err := enc.SetBitrate(24000); err != nil {
+		t.Fatalf("Error setting bitrate: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	var packets [][]byte
+	for i := 0; i < 6; i++ {
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		packets = append(packets, data[:n])
+	}
+
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	// pcm holds 120 ms, more than a frame, yet FEC and PLC produce exactly
+	// the lost frame.
+	out := make([]int16, maxFrameSize48k)
+	for i, tt := range []struct {
+		packet, next []byte
+		want         DecodeMethod
+	}{
+		{packets[0], packets[1], DecodedPacket},
+		{packets[1], nil, DecodedPacket},
+		{nil, packets[3], DecodedFEC},
+		{packets[3], packets[4], DecodedPacket},
+		{nil, nil, DecodedPLC},
+		{nil, []byte{0xf8, 0xff}, DecodedPLC}, // CELT carries no FEC
+		{packets[5], nil, DecodedPacket},
+	} {
+		n, method, err := dec.DecodeWithLoss(tt.packet, tt.next, out)
+		if err != nil {
+			t.Fatalf("Frame %d: DecodeWithLoss: %v", i, err)
+		}
+		if method != tt.want || n != FRAME_SIZE {
+			t.Errorf("Frame %d: got %d samples by %v, want %d by %v", i, n, method, FRAME_SIZE, tt.want)
+		}
+	}
+	if _, _, err := dec.DecodeWithLoss(nil, nil, out[:FRAME_SIZE/2]); err == nil {
+		t.Errorf("Expected error for a buffer shorter than the lost frame")
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+//
+// Multistream (surround) support for Encoder and Decoder. A multistream
+// packet is the concatenation of one Opus packet per stream, all but the last
+// self-delimited (RFC 7845, section 5.1.1). Each stream has its own
+// single-stream libopus state in the wasm module, so surround audio works
+// with the plain opus_encode/opus_decode exports.
+
+package opus
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// maxChannels is the largest channel count accepted by NewEncoder and
+// NewDecoder.
+const maxChannels = 8
+
+// lfeRequest is OPUS_SET_LFE_REQUEST from opus_private.h. It tells a stream
+// encoder that it carries the low-frequency effects channel.
+const lfeRequest = 10024
+
+// streamBitrates splits a total bitrate over the streams. Coupled streams
+// get twice the rate of mono streams and the LFE stream an eighth.
+func (l *ChannelMapping) streamBitrates(total int) []int32 {
+	weights := make([]int, l.Streams)
+	sum := 0
+	for s := range weights {
+		switch {
+		case s == l.lfeStream():
+			weights[s] = 1
+		case s < l.CoupledStreams:
+			weights[s] = 16
+		default:
+			weights[s] = 8
+		}
+		sum += weights[s]
+	}
+	rates := make([]int32, l.Streams)
+	for s, w := range weights {
+		rates[s] = int32(total * w / sum)
+	}
+	return rates
+}
+
+// gatherStream copies the samples of stream s out of interleaved pcm, whose
+// samples are sampleSize bytes wide, into an interleaved buffer for that
+// stream.
+func (l *ChannelMapping) gatherStream(s int, pcm []byte, channels, sampleSize int, out []byte) {
+	streamCh := l.streamChannels(s)
+	frameSize := len(pcm) / (channels * sampleSize)
+	for i := range out[:frameSize*streamCh*sampleSize] {
+		out[i] = 0
+	}
+	for ch, m := range l.Table {
+		if m == 255 {
+			continue
+		}
+		stream, c := l.codedChannel(int(m))
+		if stream != s {
+			continue
+		}
+		for i := 0; i < frameSize; i++ {
+			src := (i*channels + ch) * sampleSize
+			dst := (i*streamCh + c) * sampleSize
+			copy(out[dst:dst+sampleSize], pcm[src:src+sampleSize])
+		}
+	}
+}
+
+// scatterStream copies the decoded samples of stream s into the channels of
+// interleaved pcm that map to it.
+func (l *ChannelMapping) scatterStream(s int, decoded []byte, channels, sampleSize int, pcm []byte) {
+	streamCh := l.streamChannels(s)
+	frameSize := len(decoded) / (streamCh * sampleSize)
+	for ch, m := range l.Table {
+		if m == 255 {
+			continue
+		}
+		stream, c := l.codedChannel(int(m))
+		if stream != s {
+			continue
+		}
+		for i := 0; i < frameSize; i++ {
+			src := (i*streamCh + c) * sampleSize
+			dst := (i*channels + ch) * sampleSize
+			copy(pcm[dst:dst+sampleSize], decoded[src:src+sampleSize])
+		}
+	}
+}
+
+// splitMultistream splits a multistream packet into one regular Opus packet
+// per stream.
+func (l *ChannelMapping) splitMultistream(data []byte) ([][]byte, error) {
+	packets := make([][]byte, l.Streams)
+	for s := 0; s < l.Streams; s++ {
+		if len(data) == 0 {
+			return nil, ErrInvalidPacket
+		}
+		if s == l.Streams-1 {
+			packets[s] = data
+			break
+		}
+		p, err := parseSelfDelimited(data)
+		if err != nil {
+			return nil, err
+		}
+		// Without the self-delimiting length the packet only gets shorter.
+		rp := repacketizer{toc: p.toc, frames: p.frames}
+		if packets[s], err = rp.out(0, len(p.frames), p.packetOffset, false); err != nil {
+			return nil, err
+		}
+		data = data[p.packetOffset:]
+	}
+	return packets, nil
+}
+
+// encodeMultistreamLocked encodes interleaved pcm, made of sampleSize byte
+// samples, with one stream encoder per stream of enc.layout and stores the
+// multistream packet in data. Callers must hold enc.mu.
+func (enc *Encoder) encodeMultistreamLocked(ctx context.Context, encodeFunc api.Function, name string, pcm []byte, sampleSize int, data []byte) (int, error) {
+	l := enc.layout
+	frameSize := len(pcm) / (enc.channels * sampleSize)
+	maxDataBytes := enc.payloadLimit(len(data))
+
+	// Room for the PCM of a coupled stream, then the stream's packet.
+	streamPCM := make([]byte, frameSize*2*sampleSize)
+	pcmPtr, err := enc.buf.reserve(ctx, enc.wctx, uint32(len(streamPCM)+maxDataBytes))
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate Wasm memory for encoding: %w", err)
+	}
+	dataPtr := pcmPtr + uint32(len(streamPCM))
+
+	packet := make([]byte, 0, maxDataBytes)
+	start := time.Now()
+	for s, statePtr := range enc.streamPtrs {
+		last := s == l.Streams-1
+		l.gatherStream(s, pcm, enc.channels, sampleSize, streamPCM)
+		if !enc.wctx.module.Memory().Write(pcmPtr, streamPCM[:frameSize*l.streamChannels(s)*sampleSize]) {
+			return 0, fmt.Errorf("wasm memory write failed")
+		}
+		// Reserve room for the streams still to come, and for the
+		// self-delimiting length of this one.
+		currMax := maxDataBytes - len(packet) - max(0, 2*(l.Streams-s-1)-1)
+		if !last {
+			currMax -= 2
+		}
+		if currMax < 1 {
+			return 0, ErrBufferTooSmall
+		}
+		results, err := encodeFunc.Call(ctx,
+			uint64(statePtr),
+			uint64(pcmPtr),
+			uint64(int32(frameSize)),
+			uint64(dataPtr),
+			uint64(int32(currMax)),
+		)
+		if err != nil {
+			return 0, callError(ctx, name, err)
+		}
+		encodedBytes := int32(results[0])
+		if encodedBytes < 0 {
+			return 0, enc.watchdog.observe(Error(int(encodedBytes)), enc.reinitLocked)
+		}
+		encoded, ok := enc.wctx.module.Memory().Read(dataPtr, uint32(encodedBytes))
+		if !ok {
+			return 0, fmt.Errorf("failed to read encoded data from Wasm memory")
+		}
+		var rp repacketizer
+		if err := rp.cat(encoded); err != nil {
+			return 0, err
+		}
+		framed, err := rp.outRange(0, len(rp.frames), maxDataBytes-len(packet), !last, false)
+		if err != nil {
+			return 0, err
+		}
+		packet = append(packet, framed...)
+	}
+	enc.watchdog.succeeded()
+	n := copy(data, packet)
+	enc.audit.record(AuditEncode, data[:n], frameSize, enc.sampleRate)
+	frameEncoded(data[:n], frameSize, enc.sampleRate, enc.channels, time.Since(start))
+	return n, nil
+}
+
+// decodeMultistreamLocked decodes a multistream packet (or conceals a lost
+// one if data is empty) with one stream decoder per stream of dec.layout
+// into the first pcmBytes of dec.buf, like decodeInternal. Callers must hold
+// dec.mu.
+func (dec *Decoder) decodeMultistreamLocked(ctx context.Context, data []byte, pcmBytes int, frameSize int, decodeFEC int, isFloat bool) (int, uint32, error) {
+	l := dec.layout
+	decodeFunc, name, sampleSize := dec.wctx.functions.OpusDecode, "opus_decode", 2
+	if isFloat {
+		decodeFunc, name, sampleSize = dec.wctx.functions.OpusDecodeFloat, "opus_decode_float", 4
+	}
+	packets := make([][]byte, l.Streams)
+	if len(data) > 0 {
+		var err error
+		if packets, err = l.splitMultistream(data); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Each stream decodes into the space after the output PCM, followed by
+	// room for its packet, which is never longer than data.
+	streamBytes := frameSize * 2 * sampleSize
+	pcmPtr, outPtr, err := dec.reserveLocked(ctx, pcmBytes, streamBytes+len(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	packetPtr := outPtr + uint32(streamBytes)
+
+	pcm := make([]byte, frameSize*dec.channels*sampleSize)
+	samples := -1
+	start := time.Now()
+	for s, statePtr := range dec.streamPtrs {
+		var dataPtr uint32
+		if len(packets[s]) > 0 {
+			if !dec.wctx.module.Memory().Write(packetPtr, packets[s]) {
+				return 0, 0, fmt.Errorf("failed to write input data to Wasm memory")
+			}
+			dataPtr = packetPtr
+		}
+		results, err := decodeFunc.Call(ctx,
+			uint64(statePtr),
+			uint64(dataPtr),
+			uint64(int32(len(packets[s]))),
+			uint64(outPtr),
+			uint64(int32(frameSize)),
+			uint64(int32(decodeFEC)),
+		)
+		if err != nil {
+			return 0, 0, callError(ctx, name, err)
+		}
+		n := int(int32(results[0]))
+		if n < 0 {
+			return 0, 0, dec.watchdog.observe(Error(n), dec.reinitLocked)
+		}
+		if samples >= 0 && n != samples {
+			// All streams must cover the same duration.
+			return 0, 0, ErrInvalidPacket
+		}
+		samples = n
+		decoded, ok := dec.wctx.module.Memory().Read(outPtr, uint32(n*l.streamChannels(s)*sampleSize))
+		if !ok {
+			return 0, 0, fmt.Errorf("failed to read decoded PCM from Wasm memory")
+		}
+		l.scatterStream(s, decoded, dec.channels, sampleSize, pcm)
+	}
+	if !dec.wctx.module.Memory().Write(pcmPtr, pcm[:samples*dec.channels*sampleSize]) {
+		return 0, 0, fmt.Errorf("wasm memory write failed")
+	}
+	dec.watchdog.succeeded()
+	if decodeFEC == 0 && len(data) > 0 {
+		// The first stream stands in for the packet metrics.
+		dec.lastPacket = append(dec.lastPacket[:0], packets[0]...)
+		dec.audit.record(AuditDecode, data, samples, dec.sample_rate)
+	}
+	frameDecoded(data, decodeFEC, samples, dec.sample_rate, dec.channels, time.Since(start))
+	return samples, pcmPtr, nil
+}
@@ -0,0 +1,149 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+)
+
+func TestEncodeDecodeSurround(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const channels = 6
+	const frames = 10
+	for target := 0; target < channels; target++ {
+		enc, err := NewEncoder(SAMPLE_RATE, channels, AppAudio)
+		if err != nil || enc == nil {
+			t.Fatalf("Error creating new encoder: %v", err)
+		}
+		dec, err := NewDecoder(SAMPLE_RATE, channels)
+		if err != nil || dec == nil {
+			t.Fatalf("Error creating new decoder: %v", err)
+		}
+		// A low tone in a single channel, so the LFE channel carries it too.
+		mono := make([]int16, FRAME_SIZE*frames)
+		addSine(mono, SAMPLE_RATE, 150)
+		energy := make([]float64, channels)
+		for f := 0; f < frames; f++ {
+			pcm := make([]int16, FRAME_SIZE*channels)
+			for i := 0; i < FRAME_SIZE; i++ {
+				pcm[i*channels+target] = mono[f*FRAME_SIZE+i] / 2
+			}
+			data := make([]byte, 4000)
+			n, err := enc.Encode(pcm, data)
+			if err != nil {
+				t.Fatalf("target %d: couldn't encode data: %v", target, err)
+			}
+			data = data[:n]
+			if ns, err := dec.NbSamples(data); err != nil || ns != FRAME_SIZE {
+				t.Fatalf("target %d: NbSamples = %d, %v; want %d", target, ns, err, FRAME_SIZE)
+			}
+			out := make([]int16, FRAME_SIZE*channels)
+			n, err = dec.Decode(data, out)
+			if err != nil {
+				t.Fatalf("target %d: couldn't decode data: %v", target, err)
+			}
+			if n != FRAME_SIZE {
+				t.Fatalf("target %d: decoded %d samples, want %d", target, n, FRAME_SIZE)
+			}
+			if f < frames/2 {
+				continue
+			}
+			for i := 0; i < FRAME_SIZE; i++ {
+				for c := 0; c < channels; c++ {
+					v := float64(out[i*channels+c])
+					energy[c] += v * v
+				}
+			}
+		}
+		for c := 0; c < channels; c++ {
+			if c != target && energy[c]*100 > energy[target] {
+				t.Errorf("target %d: channel %d has energy %g, target has %g", target, c, energy[c], energy[target])
+			}
+		}
+	}
+}
+
+func TestEncodeDecodeSurroundFloat32(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const channels = 3
+	enc, err := NewEncoder(SAMPLE_RATE, channels, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, channels)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	pcm := make([]float32, FRAME_SIZE*channels)
+	addSineFloat32(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 4000)
+	n, err := enc.EncodeFloat32(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	out := make([]float32, FRAME_SIZE*channels)
+	n, err = dec.DecodeFloat32(data[:n], out)
+	if err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	if n != FRAME_SIZE {
+		t.Fatalf("Decoded %d samples, want %d", n, FRAME_SIZE)
+	}
+	// Packet loss concealment covers every stream.
+	n, err = dec.DecodePLCFloat32(out)
+	if err != nil || n != FRAME_SIZE {
+		t.Fatalf("DecodePLCFloat32 = %d, %v; want %d", n, err, FRAME_SIZE)
+	}
+}
+
+func TestEncoderSurroundCtl(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	enc, err := NewEncoder(SAMPLE_RATE, 8, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	const bitrate = 256000
+	if err := enc.SetBitrate(bitrate); err != nil {
+		t.Fatalf("SetBitrate: %v", err)
+	}
+	// Splitting the rate over the streams may round a little off.
+	got, err := enc.Bitrate()
+	if err != nil {
+		t.Fatalf("Bitrate: %v", err)
+	}
+	if got > bitrate || got < bitrate-len(enc.streamPtrs) {
+		t.Errorf("Bitrate() = %d, want about %d", got, bitrate)
+	}
+	if err := enc.SetComplexity(5); err != nil {
+		t.Fatalf("SetComplexity: %v", err)
+	}
+	if c, err := enc.Complexity(); err != nil || c != 5 {
+		t.Errorf("Complexity() = %d, %v; want 5", c, err)
+	}
+	// Ctl getters combine the streams like the typed ones.
+	pcm := make([]int16, SAMPLE_RATE/50*8)
+	addSine(pcm, SAMPLE_RATE, 440)
+	if _, err := enc.Encode(pcm, make([]byte, 4000)); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	rng, err := enc.FinalRange()
+	if err != nil {
+		t.Fatalf("FinalRange: %v", err)
+	}
+	if v, err := enc.Ctl(opusGetFinalRangeRequest); err != nil || uint32(v) != rng {
+		t.Errorf("Ctl(OPUS_GET_FINAL_RANGE) = %d, %v; want %d", uint32(v), err, rng)
+	}
+	if err := enc.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if _, err := NewEncoder(SAMPLE_RATE, maxChannels+1, AppAudio); err == nil {
+		t.Errorf("Expected error for %d channels", maxChannels+1)
+	}
+	if _, err := NewDecoder(SAMPLE_RATE, 0); err == nil {
+		t.Errorf("Expected error for 0 channels")
+	}
+}
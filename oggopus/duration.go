@@ -0,0 +1,74 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package oggopus
+
+import (
+	"io"
+	"time"
+)
+
+// Duration returns the playback duration of the Ogg Opus stream in r: the
+// granule position of its last page minus the pre-skip. Only the headers
+// and the end of the stream are read, nothing is decoded.
+func Duration(r io.ReadSeeker) (time.Duration, error) {
+	or, err := NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	return or.Duration()
+}
+
+// Duration returns the playback duration of the stream, see the Duration
+// function. The source must be seekable; the read position is restored
+// afterwards.
+func (r *Reader) Duration() (time.Duration, error) {
+	rs, ok := r.r.(io.ReadSeeker)
+	if !ok {
+		return 0, ErrNotSeekable
+	}
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	granule, err := r.lastGranule(rs)
+	if _, serr := rs.Seek(pos, io.SeekStart); err == nil {
+		err = serr
+	}
+	if err != nil {
+		return 0, err
+	}
+	samples := max(granule-int64(r.head.PreSkip), 0)
+	return time.Duration(samples) * time.Second / 48000, nil
+}
+
+// lastGranule returns the granule position of the last page of the stream,
+// scanning backwards from the end in blocks.
+func (r *Reader) lastGranule(rs io.ReadSeeker) (int64, error) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	limit := size
+	for limit > r.dataStart {
+		start := max(limit-bisectLimit, r.dataStart)
+		granule := int64(-1)
+		for off := start; ; {
+			pg, end, err := r.nextGranulePage(rs, off, limit)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			granule, off = pg.granule, end
+		}
+		if granule >= 0 {
+			return granule, nil
+		}
+		limit = start
+	}
+	// No audio pages.
+	return 0, nil
+}
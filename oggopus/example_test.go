@@ -0,0 +1,44 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package oggopus_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/godeps/opus"
+	"github.com/godeps/opus/oggopus"
+)
+
+func ExampleReader() {
+	f, err := os.Open("../testdata/speech_8.opus")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	r, err := oggopus.NewReader(f)
+	if err != nil {
+		panic(err)
+	}
+	dec, err := opus.NewDecoder(48000, r.Head().Channels)
+	if err != nil {
+		panic(err)
+	}
+	pcm := make([]int16, 5760*r.Head().Channels) // 120 ms, the longest packet
+	samples := 0
+	for {
+		n, err := r.DecodeNext(dec, pcm)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
+		samples += n
+	}
+	fmt.Println(r.Head().Channels, "channel(s),", samples > 0)
+	// Output: 1 channel(s), true
+}
@@ -0,0 +1,259 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package oggopus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// OpusHead is the identification header of an Ogg Opus stream (RFC 7845,
+// section 5.1).
+type OpusHead struct {
+	// Version is the encapsulation version. Only the major version (the
+	// upper four bits) must be 0 for the stream to be readable.
+	Version uint8
+	// Channels is the number of output channels.
+	Channels int
+	// PreSkip is the number of samples at 48 kHz to discard from the
+	// decoder output at the start of the stream.
+	PreSkip int
+	// InputSampleRate is the sample rate of the original input, for
+	// information only; 0 means unspecified. Opus always decodes at one of
+	// its own rates, typically 48 kHz.
+	InputSampleRate int
+	// OutputGain is the gain to apply to the decoded output, in Q7.8 dB.
+	OutputGain int
+	// MappingFamily selects the channel layout. Family 0 (mono or stereo
+	// in a single stream) has no stream counts or mapping table.
+	MappingFamily int
+	// StreamCount and CoupledCount are the number of Opus streams per
+	// packet and how many of them are stereo.
+	StreamCount  int
+	CoupledCount int
+	// ChannelMapping maps each output channel to a coded channel, or holds
+	// the demixing matrix for family 3.
+	ChannelMapping []byte
+}
+
+var headMagic = []byte("OpusHead")
+
+// UnmarshalBinary parses and validates an OpusHead packet. Data after the
+// channel mapping table is ignored, as RFC 7845 requires.
+func (h *OpusHead) UnmarshalBinary(data []byte) error {
+	if len(data) < 19 || !bytes.HasPrefix(data, headMagic) {
+		return ErrBadHead
+	}
+	v := OpusHead{
+		Version:         data[8],
+		Channels:        int(data[9]),
+		PreSkip:         int(binary.LittleEndian.Uint16(data[10:])),
+		InputSampleRate: int(binary.LittleEndian.Uint32(data[12:])),
+		OutputGain:      int(int16(binary.LittleEndian.Uint16(data[16:]))),
+		MappingFamily:   int(data[18]),
+	}
+	if v.Version>>4 != 0 {
+		return ErrBadHead
+	}
+	if v.MappingFamily == 0 {
+		v.StreamCount, v.CoupledCount = 1, v.Channels-1
+	} else {
+		if len(data) < 21 {
+			return ErrBadHead
+		}
+		v.StreamCount, v.CoupledCount = int(data[19]), int(data[20])
+		if n := v.tableLen(); len(data) >= 21+n {
+			v.ChannelMapping = append([]byte(nil), data[21:21+n]...)
+		}
+	}
+	if err := v.validate(); err != nil {
+		return err
+	}
+	*h = v
+	return nil
+}
+
+// MarshalBinary serializes the header as an OpusHead packet. A zero Version
+// is written as 1, the version defined by RFC 7845.
+func (h OpusHead) MarshalBinary() ([]byte, error) {
+	if h.MappingFamily == 0 {
+		// The stream counts are implied; fill them in for validate.
+		h.StreamCount, h.CoupledCount = 1, h.Channels-1
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	if h.Version == 0 {
+		h.Version = 1
+	}
+	if h.PreSkip < 0 || h.PreSkip > 0xffff || h.InputSampleRate < 0 || int64(h.InputSampleRate) > 0xffffffff ||
+		h.OutputGain < -0x8000 || h.OutputGain > 0x7fff {
+		return nil, ErrBadHead
+	}
+	b := make([]byte, 19, 21+len(h.ChannelMapping))
+	copy(b, headMagic)
+	b[8] = h.Version
+	b[9] = byte(h.Channels)
+	binary.LittleEndian.PutUint16(b[10:], uint16(h.PreSkip))
+	binary.LittleEndian.PutUint32(b[12:], uint32(h.InputSampleRate))
+	binary.LittleEndian.PutUint16(b[16:], uint16(int16(h.OutputGain)))
+	b[18] = byte(h.MappingFamily)
+	if h.MappingFamily != 0 {
+		b = append(b, byte(h.StreamCount), byte(h.CoupledCount))
+		b = append(b, h.ChannelMapping...)
+	}
+	return b, nil
+}
+
+// tableLen returns the size of the channel mapping table for the header's
+// mapping family and stream counts.
+func (h *OpusHead) tableLen() int {
+	if h.MappingFamily == 3 {
+		return 2 * h.Channels * (h.StreamCount + h.CoupledCount)
+	}
+	return h.Channels
+}
+
+// validate checks the header fields for consistency.
+func (h *OpusHead) validate() error {
+	if h.Version>>4 != 0 || h.Channels < 1 || h.Channels > 255 || h.MappingFamily < 0 || h.MappingFamily > 255 {
+		return ErrBadHead
+	}
+	if h.MappingFamily == 0 {
+		if h.Channels > 2 || len(h.ChannelMapping) != 0 {
+			return ErrBadHead
+		}
+		return nil
+	}
+	if h.StreamCount < 1 || h.CoupledCount < 0 || h.CoupledCount > h.StreamCount || h.StreamCount+h.CoupledCount > 255 {
+		return ErrBadHead
+	}
+	if len(h.ChannelMapping) != h.tableLen() {
+		return ErrBadHead
+	}
+	if h.MappingFamily != 3 {
+		for _, c := range h.ChannelMapping {
+			if c != 255 && int(c) >= h.StreamCount+h.CoupledCount {
+				return ErrBadHead
+			}
+		}
+	}
+	return nil
+}
+
+// OpusTags is the comment header of an Ogg Opus stream (RFC 7845, section
+// 5.2), in the Vorbis comment format.
+type OpusTags struct {
+	// Vendor identifies the encoder, e.g. "libopus 1.5".
+	Vendor string
+	// Comments holds the user comments as "NAME=value" strings. Names are
+	// case-insensitive ASCII.
+	Comments []string
+}
+
+var tagsMagic = []byte("OpusTags")
+
+// UnmarshalBinary parses an OpusTags packet. Binary data after the
+// comments, which RFC 7845 allows, is ignored.
+func (t *OpusTags) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, tagsMagic) {
+		return ErrBadTags
+	}
+	data = data[len(tagsMagic):]
+	next := func() (string, bool) {
+		if len(data) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			return "", false
+		}
+		s := string(data[4 : 4+n])
+		data = data[4+n:]
+		return s, true
+	}
+	vendor, ok := next()
+	if !ok || len(data) < 4 {
+		return ErrBadTags
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	// Each comment takes at least 4 bytes, which bounds count.
+	if uint64(count) > uint64(len(data)/4) {
+		return ErrBadTags
+	}
+	comments := make([]string, 0, count)
+	for i := uint32(0); i < count; i++ {
+		c, ok := next()
+		if !ok {
+			return ErrBadTags
+		}
+		comments = append(comments, c)
+	}
+	t.Vendor, t.Comments = vendor, comments
+	return nil
+}
+
+// MarshalBinary serializes the tags as an OpusTags packet.
+func (t OpusTags) MarshalBinary() ([]byte, error) {
+	size := len(tagsMagic) + 8 + len(t.Vendor)
+	for _, c := range t.Comments {
+		size += 4 + len(c)
+	}
+	b := make([]byte, 0, size)
+	b = append(b, tagsMagic...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(t.Vendor)))
+	b = append(b, t.Vendor...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(t.Comments)))
+	for _, c := range t.Comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+		b = append(b, c...)
+	}
+	return b, nil
+}
+
+// Get returns the value of the first comment called name, compared
+// case-insensitively.
+func (t *OpusTags) Get(name string) (string, bool) {
+	for _, c := range t.Comments {
+		if k, v, ok := strings.Cut(c, "="); ok && strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Add appends a "name=value" comment.
+func (t *OpusTags) Add(name, value string) {
+	t.Comments = append(t.Comments, name+"="+value)
+}
+
+// TrackGain returns the R128_TRACK_GAIN comment: the gain, in Q7.8 dB, that
+// normalizes the track to -23 LUFS on top of the OpusHead output gain.
+func (t *OpusTags) TrackGain() (int, bool) {
+	return t.gain("R128_TRACK_GAIN")
+}
+
+// AlbumGain returns the R128_ALBUM_GAIN comment, the album equivalent of
+// TrackGain.
+func (t *OpusTags) AlbumGain() (int, bool) {
+	return t.gain("R128_ALBUM_GAIN")
+}
+
+// gain parses a Q7.8 gain comment, which RFC 7845 defines as a signed
+// decimal integer in the int16 range.
+func (t *OpusTags) gain(name string) (int, bool) {
+	v, ok := t.Get(name)
+	if !ok {
+		return 0, false
+	}
+	gain, err := strconv.ParseInt(v, 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return int(gain), true
+}
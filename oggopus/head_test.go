@@ -0,0 +1,116 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package oggopus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestOpusHeadRoundTrip(t *testing.T) {
+	heads := []OpusHead{
+		{Version: 1, Channels: 1, PreSkip: 312, InputSampleRate: 44100, OutputGain: -256, StreamCount: 1},
+		{Version: 1, Channels: 2, PreSkip: 3840, StreamCount: 1, CoupledCount: 1},
+		{Version: 1, Channels: 6, PreSkip: 312, InputSampleRate: 48000, MappingFamily: 1,
+			StreamCount: 4, CoupledCount: 2, ChannelMapping: []byte{0, 4, 1, 2, 3, 5}},
+		{Version: 1, Channels: 4, MappingFamily: 3, StreamCount: 2, CoupledCount: 2, ChannelMapping: make([]byte, 32)},
+	}
+	for _, h := range heads {
+		b, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%+v: MarshalBinary: %v", h, err)
+		}
+		var got OpusHead
+		if err := got.UnmarshalBinary(b); err != nil {
+			t.Fatalf("%+v: UnmarshalBinary: %v", h, err)
+		}
+		if !reflect.DeepEqual(got, h) {
+			t.Errorf("Round trip changed %+v into %+v", h, got)
+		}
+	}
+}
+
+func TestOpusHeadMarshal(t *testing.T) {
+	// The header of testdata/speech_8.opus.
+	want := []byte("OpusHead\x01\x01\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	b, err := OpusHead{Channels: 1, PreSkip: 312, InputSampleRate: 48000}.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("Got %q, want %q", b, want)
+	}
+}
+
+func TestOpusHeadInvalid(t *testing.T) {
+	heads := []OpusHead{
+		{Channels: 0},
+		{Channels: 3},
+		{Channels: 1, ChannelMapping: []byte{0}},
+		{Channels: 2, MappingFamily: 1, StreamCount: 1, CoupledCount: 1, ChannelMapping: []byte{0}},
+		{Channels: 2, MappingFamily: 1, StreamCount: 1, CoupledCount: 1, ChannelMapping: []byte{0, 2}},
+		{Channels: 2, MappingFamily: 1, StreamCount: 1, CoupledCount: 2, ChannelMapping: []byte{0, 1}},
+		{Channels: 1, PreSkip: 70000},
+		{Channels: 1, Version: 0x10},
+	}
+	for _, h := range heads {
+		if _, err := h.MarshalBinary(); err != ErrBadHead {
+			t.Errorf("%+v: expected ErrBadHead, got %v", h, err)
+		}
+	}
+	var h OpusHead
+	for _, b := range [][]byte{
+		nil,
+		[]byte("OpusHeax\x01\x01\x38\x01\x80\xbb\x00\x00\x00\x00\x00"),
+		[]byte("OpusHead\x01\x06\x38\x01\x80\xbb\x00\x00\x00\x00\x01\x04\x02\x00\x04"),
+	} {
+		if err := h.UnmarshalBinary(b); err != ErrBadHead {
+			t.Errorf("%q: expected ErrBadHead, got %v", b, err)
+		}
+	}
+}
+
+func TestOpusTags(t *testing.T) {
+	tags := OpusTags{Vendor: "libopus 1.5"}
+	tags.Add("TITLE", "Test")
+	tags.Add("R128_TRACK_GAIN", "-512")
+	b, err := tags.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	var got OpusTags
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if !reflect.DeepEqual(got, tags) {
+		t.Errorf("Round trip changed %+v into %+v", tags, got)
+	}
+	if v, ok := got.Get("r128_track_gain"); !ok || v != "-512" {
+		t.Errorf("Get(r128_track_gain) = %q, %v", v, ok)
+	}
+	if _, ok := got.Get("ARTIST"); ok {
+		t.Errorf("Get(ARTIST) found a missing tag")
+	}
+	if g, ok := got.TrackGain(); !ok || g != -512 {
+		t.Errorf("TrackGain() = %d, %v", g, ok)
+	}
+	if _, ok := got.AlbumGain(); ok {
+		t.Errorf("AlbumGain() found a missing tag")
+	}
+	got.Add("R128_ALBUM_GAIN", "+40000")
+	if _, ok := got.AlbumGain(); ok {
+		t.Errorf("AlbumGain() accepted a gain outside the int16 range")
+	}
+	// Padding after the comments is allowed.
+	if err := got.UnmarshalBinary(append(b, 0, 0, 0)); err != nil {
+		t.Errorf("UnmarshalBinary with padding: %v", err)
+	}
+	for _, bad := range [][]byte{b[:len(b)-1], []byte("OpusTags\xff\xff\xff\xff"), []byte("OpusHead")} {
+		if err := got.UnmarshalBinary(bad); err != ErrBadTags {
+			t.Errorf("%q: expected ErrBadTags, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package oggopus
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Ogg page header_type flags (RFC 3533, section 6).
+const (
+	pageContinued = 0x01
+	pageBOS       = 0x02
+	pageEOS       = 0x04
+)
+
+// pageHeaderSize is the size of an Ogg page header without its lacing
+// values.
+const pageHeaderSize = 27
+
+// page is a single Ogg page.
+type page struct {
+	headerType byte
+	granule    int64
+	serial     uint32
+	sequence   uint32
+	// segments holds the lacing values; body is the concatenated segment
+	// data they describe.
+	segments []byte
+	body     []byte
+}
+
+// crcTable is the lookup table for the Ogg CRC-32: polynomial 0x04c11db7,
+// unreflected, zero initial value and no final XOR.
+var crcTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+func oggCRC(crc uint32, b []byte) uint32 {
+	for _, v := range b {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+// readPage reads the Ogg page starting at the current position of r and
+// verifies its checksum. It returns io.EOF only if r ends before the page.
+func readPage(r io.Reader) (*page, error) {
+	var hdr [pageHeaderSize + 255]byte
+	if _, err := io.ReadFull(r, hdr[:pageHeaderSize]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrBadPage
+		}
+		return nil, err
+	}
+	if string(hdr[:4]) != "OggS" || hdr[4] != 0 {
+		return nil, ErrBadPage
+	}
+	nsegs := int(hdr[26])
+	if _, err := io.ReadFull(r, hdr[pageHeaderSize:pageHeaderSize+nsegs]); err != nil {
+		return nil, ErrBadPage
+	}
+	p := &page{
+		headerType: hdr[5],
+		granule:    int64(binary.LittleEndian.Uint64(hdr[6:])),
+		serial:     binary.LittleEndian.Uint32(hdr[14:]),
+		sequence:   binary.LittleEndian.Uint32(hdr[18:]),
+		segments:   append([]byte(nil), hdr[pageHeaderSize:pageHeaderSize+nsegs]...),
+	}
+	size := 0
+	for _, lace := range p.segments {
+		size += int(lace)
+	}
+	p.body = make([]byte, size)
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, ErrBadPage
+	}
+
+	want := binary.LittleEndian.Uint32(hdr[22:])
+	// The checksum is computed with the CRC field set to zero.
+	hdr[22], hdr[23], hdr[24], hdr[25] = 0, 0, 0, 0
+	crc := oggCRC(0, hdr[:pageHeaderSize+nsegs])
+	if oggCRC(crc, p.body) != want {
+		return nil, ErrBadCRC
+	}
+	return p, nil
+}
+
+// appendPage appends p, serialized with its checksum, to b.
+func appendPage(b []byte, p *page) []byte {
+	start := len(b)
+	b = append(b, "OggS"...)
+	b = append(b, 0, p.headerType)
+	b = binary.LittleEndian.AppendUint64(b, uint64(p.granule))
+	b = binary.LittleEndian.AppendUint32(b, p.serial)
+	b = binary.LittleEndian.AppendUint32(b, p.sequence)
+	b = append(b, 0, 0, 0, 0, byte(len(p.segments)))
+	b = append(b, p.segments...)
+	b = append(b, p.body...)
+	binary.LittleEndian.PutUint32(b[start+22:], oggCRC(0, b[start:]))
+	return b
+}
@@ -0,0 +1,302 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+// Package oggopus reads and writes Ogg Opus streams (RFC 7845), such as the
+// .opus files produced by opusenc. It only handles the container; coding is
+// left to the caller, e.g. a PacketDecoder such as *opus.Decoder, so this
+// package doesn't depend on the wasm codec.
+package oggopus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrNotOpus is returned when a stream has no Opus logical stream.
+	ErrNotOpus = errors.New("oggopus: not an Ogg Opus stream")
+	// ErrBadPage is returned for truncated or malformed Ogg pages.
+	ErrBadPage = errors.New("oggopus: malformed Ogg page")
+	// ErrBadCRC is returned when an Ogg page fails its checksum.
+	ErrBadCRC = errors.New("oggopus: Ogg page checksum mismatch")
+	// ErrBadHead is returned for an invalid OpusHead header.
+	ErrBadHead = errors.New("oggopus: invalid OpusHead header")
+	// ErrBadTags is returned for an invalid OpusTags header.
+	ErrBadTags = errors.New("oggopus: invalid OpusTags header")
+)
+
+// Packet is an Opus packet read from an Ogg stream.
+type Packet struct {
+	Data []byte
+	// Granule is the granule position of the page the packet ends on if it
+	// is the last packet completed on that page, and -1 otherwise. For Opus
+	// it counts 48 kHz samples, including the pre-skip, up to the end of
+	// the packet.
+	Granule int64
+	// EOS marks the last packet of the stream.
+	EOS bool
+}
+
+// PacketDecoder decodes a single Opus packet into interleaved int16 PCM.
+// *opus.Decoder implements it.
+type PacketDecoder interface {
+	Decode(data []byte, pcm []int16) (int, error)
+}
+
+// FloatPacketDecoder decodes a single Opus packet into interleaved float32
+// PCM. *opus.Decoder implements it.
+type FloatPacketDecoder interface {
+	DecodeFloat32(data []byte, pcm []float32) (int, error)
+}
+
+// Reader reads the packets of the first Opus stream in an Ogg stream. Pages
+// of other multiplexed logical streams are skipped. Chained streams are not
+// followed: the reader stops at the end of the first one.
+type Reader struct {
+	r      io.Reader
+	serial uint32
+	head   OpusHead
+	tags   OpusTags
+
+	pg  *page
+	seg int // next lacing value of pg
+	off int // offset of that segment's data in pg.body
+	// partial holds a packet continued on the next page.
+	partial []byte
+	// skipContinued drops data continuing a packet from a page before pg.
+	skipContinued bool
+
+	// decoded is the granule position DecodeNext has decoded up to, and
+	// skipTo the position before which it drops output: the pre-skip, or a
+	// seek target.
+	decoded int64
+	skipTo  int64
+	// dataStart is the byte offset of the first audio page if the source
+	// is seekable.
+	dataStart int64
+}
+
+// NewReader reads the Ogg Opus headers from r and returns a Reader
+// positioned at the first audio packet.
+func NewReader(r io.Reader) (*Reader, error) {
+	or := &Reader{r: r}
+	// Look for the beginning of an Opus stream among the leading BOS pages.
+	for {
+		pg, err := readPage(r)
+		if err == io.EOF {
+			return nil, ErrNotOpus
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pg.headerType&pageBOS == 0 {
+			return nil, ErrNotOpus
+		}
+		if bytes.HasPrefix(pg.body, headMagic) {
+			or.serial = pg.serial
+			or.pg = pg
+			break
+		}
+	}
+	head, err := or.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := or.head.UnmarshalBinary(head.Data); err != nil {
+		return nil, err
+	}
+	tags, err := or.Next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("oggopus: missing OpusTags header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := or.tags.UnmarshalBinary(tags.Data); err != nil {
+		return nil, err
+	}
+	or.skipTo = int64(or.head.PreSkip)
+	if rs, ok := r.(io.Seeker); ok {
+		if or.dataStart, err = rs.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+	return or, nil
+}
+
+// Head returns the stream's identification header.
+func (r *Reader) Head() OpusHead {
+	return r.head
+}
+
+// Tags returns the stream's comment header.
+func (r *Reader) Tags() OpusTags {
+	return r.tags
+}
+
+// Next returns the next packet of the stream, or io.EOF after the last one.
+func (r *Reader) Next() (Packet, error) {
+	for {
+		if r.pg == nil || r.seg >= len(r.pg.segments) {
+			if r.pg != nil && r.pg.headerType&pageEOS != 0 {
+				return Packet{}, io.EOF
+			}
+			if err := r.nextPage(); err != nil {
+				return Packet{}, err
+			}
+			continue
+		}
+		lace := int(r.pg.segments[r.seg])
+		r.partial = append(r.partial, r.pg.body[r.off:r.off+lace]...)
+		r.off += lace
+		r.seg++
+		if lace == 255 {
+			continue
+		}
+		data := r.partial
+		r.partial = nil
+		if r.skipContinued {
+			r.skipContinued = false
+			continue
+		}
+		pkt := Packet{Data: data, Granule: -1}
+		if r.lastOnPage() {
+			pkt.Granule = r.pg.granule
+			pkt.EOS = r.pg.headerType&pageEOS != 0
+		}
+		return pkt, nil
+	}
+}
+
+// ReadPacket returns the data of the next packet, so a Reader can be used
+// where a packet source such as opus.PacketReader is expected.
+func (r *Reader) ReadPacket() ([]byte, error) {
+	pkt, err := r.Next()
+	return pkt.Data, err
+}
+
+// DecodeNext reads the next packet and decodes it into pcm with dec, which
+// must produce Head().Channels interleaved channels. The pre-skip at the
+// start of the stream (or the pre-roll after a seek) and the samples past
+// the final granule position are trimmed, so it can return 0 samples. It
+// returns io.EOF after the last packet.
+func (r *Reader) DecodeNext(dec PacketDecoder, pcm []int16) (int, error) {
+	pkt, err := r.Next()
+	if err != nil {
+		return 0, err
+	}
+	n, err := dec.Decode(pkt.Data, pcm)
+	if err != nil {
+		return 0, err
+	}
+	from, to := r.trim(pkt.Data, n)
+	ch := r.head.Channels
+	copy(pcm, pcm[from*ch:to*ch])
+	return to - from, nil
+}
+
+// DecodeNextFloat32 is DecodeNext for float32 output.
+func (r *Reader) DecodeNextFloat32(dec FloatPacketDecoder, pcm []float32) (int, error) {
+	pkt, err := r.Next()
+	if err != nil {
+		return 0, err
+	}
+	n, err := dec.DecodeFloat32(pkt.Data, pcm)
+	if err != nil {
+		return 0, err
+	}
+	from, to := r.trim(pkt.Data, n)
+	ch := r.head.Channels
+	copy(pcm, pcm[from*ch:to*ch])
+	return to - from, nil
+}
+
+// trim accounts for a packet that decoded to n samples per channel and
+// returns the range of those samples to keep.
+func (r *Reader) trim(data []byte, n int) (from, to int) {
+	duration := int64(packetDuration(data))
+	start := r.decoded
+	r.decoded += duration
+	if duration == 0 || n == 0 {
+		return 0, n
+	}
+
+	// Work out the part of the packet to keep in 48 kHz samples, then
+	// scale it to the decoder's rate.
+	from48, to48 := int64(0), duration
+	if skip := r.skipTo - start; skip > 0 {
+		from48 = min(skip, duration)
+	}
+	// The granule position of the last page marks the end of the stream,
+	// which may fall before the end of any packet completed on that page.
+	if end := r.pg.granule; r.pg.headerType&pageEOS != 0 && end >= 0 && end < start+duration {
+		to48 = max(end-start, from48)
+	}
+	return int(from48 * int64(n) / duration), int(to48 * int64(n) / duration)
+}
+
+// nextPage reads the next page of the stream into r.pg.
+func (r *Reader) nextPage() error {
+	for {
+		pg, err := readPage(r.r)
+		if err == io.EOF && len(r.partial) > 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if pg.serial != r.serial {
+			continue
+		}
+		continued := pg.headerType&pageContinued != 0
+		if len(r.partial) > 0 && !continued {
+			return fmt.Errorf("oggopus: page %d doesn't continue the previous packet", pg.sequence)
+		}
+		if len(r.partial) == 0 && continued && r.pg == nil {
+			r.skipContinued = true
+		}
+		r.pg, r.seg, r.off = pg, 0, 0
+		return nil
+	}
+}
+
+// lastOnPage reports whether no other packet completes on the current page.
+func (r *Reader) lastOnPage() bool {
+	for _, lace := range r.pg.segments[r.seg:] {
+		if lace < 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// packetDuration returns the duration of an Opus packet in 48 kHz samples,
+// or 0 if it is malformed.
+func packetDuration(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	toc := data[0]
+	var frameSize int
+	switch config := int(toc >> 3); {
+	case config < 12: // SILK: 10, 20, 40, 60 ms
+		frameSize = []int{480, 960, 1920, 2880}[config&3]
+	case config < 16: // Hybrid: 10, 20 ms
+		frameSize = 480 << (config & 1)
+	default: // CELT: 2.5, 5, 10, 20 ms
+		frameSize = 120 << (config & 3)
+	}
+	switch toc & 3 {
+	case 0:
+		return frameSize
+	case 1, 2:
+		return 2 * frameSize
+	}
+	if len(data) < 2 {
+		return 0
+	}
+	return int(data[1]&0x3f) * frameSize
+}
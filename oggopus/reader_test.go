@@ -0,0 +1,204 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package oggopus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+)
+
+// buildPage serializes an Ogg page holding body with the given lacing
+// values.
+func buildPage(headerType byte, granule int64, serial, seq uint32, segments, body []byte) []byte {
+	b := make([]byte, pageHeaderSize, pageHeaderSize+len(segments)+len(body))
+	copy(b, "OggS")
+	b[5] = headerType
+	binary.LittleEndian.PutUint64(b[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(b[14:], serial)
+	binary.LittleEndian.PutUint32(b[18:], seq)
+	b[26] = byte(len(segments))
+	b = append(b, segments...)
+	b = append(b, body...)
+	binary.LittleEndian.PutUint32(b[22:], oggCRC(0, b))
+	return b
+}
+
+// lacing returns the lacing values for a packet of n bytes.
+func lacing(n int) []byte {
+	l := bytes.Repeat([]byte{255}, n/255)
+	return append(l, byte(n%255))
+}
+
+func testHead() []byte {
+	return []byte("OpusHead\x01\x01\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+}
+
+func testTags() []byte {
+	b, _ := OpusTags{Vendor: "test"}.MarshalBinary()
+	return b
+}
+
+func TestReaderFile(t *testing.T) {
+	f, err := os.Open("../testdata/speech_8.opus")
+	if err != nil {
+		t.Fatalf("Error opening test file: %v", err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatalf("Error creating reader: %v", err)
+	}
+	head := r.Head()
+	if head.Channels != 1 || head.PreSkip != 312 || head.InputSampleRate != 48000 || head.MappingFamily != 0 {
+		t.Errorf("Unexpected head %+v", head)
+	}
+	tags := r.Tags()
+	if tags.Vendor != "libopus 1.1" {
+		t.Errorf("Got vendor %q, want %q", tags.Vendor, "libopus 1.1")
+	}
+	if enc, ok := tags.Get("encoder"); !ok || enc != "opusenc from opus-tools 0.1.9" {
+		t.Errorf("Got ENCODER tag %q, %v", enc, ok)
+	}
+	var packets int
+	var total, granule int64
+	var last Packet
+	for {
+		pkt, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading packet %d: %v", packets, err)
+		}
+		packets++
+		total += int64(packetDuration(pkt.Data))
+		if pkt.Granule >= 0 {
+			if pkt.Granule < granule {
+				t.Errorf("Granule position went back from %d to %d", granule, pkt.Granule)
+			}
+			granule = pkt.Granule
+			if !pkt.EOS && pkt.Granule != total {
+				t.Errorf("Packet %d: granule %d, want %d", packets, pkt.Granule, total)
+			}
+		}
+		last = pkt
+	}
+	if packets == 0 {
+		t.Fatalf("No packets read")
+	}
+	if !last.EOS || last.Granule < 0 {
+		t.Errorf("Last packet has EOS %v, granule %d", last.EOS, last.Granule)
+	}
+	if last.Granule > total {
+		t.Errorf("Final granule %d past the %d samples in the stream", last.Granule, total)
+	}
+}
+
+func TestReaderContinuedPacket(t *testing.T) {
+	const serial = 7
+	big := bytes.Repeat([]byte{0xfc}, 600) // CELT 20 ms, one frame
+	var stream []byte
+	stream = append(stream, buildPage(pageBOS, 0, 99, 0, lacing(4), []byte("junk"))...)
+	stream = append(stream, buildPage(pageBOS, 0, serial, 0, lacing(19), testHead())...)
+	stream = append(stream, buildPage(0, 0, serial, 1, lacing(len(testTags())), testTags())...)
+	// The big packet starts on one page and ends on the next one.
+	stream = append(stream, buildPage(0, -1, serial, 2, []byte{255, 255}, big[:510])...)
+	stream = append(stream, buildPage(0, 0, 99, 1, lacing(4), []byte("junk"))...)
+	stream = append(stream, buildPage(pageContinued|pageEOS, 960, serial, 3, lacing(90), big[510:])...)
+
+	r, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("Error creating reader: %v", err)
+	}
+	pkt, err := r.Next()
+	if err != nil {
+		t.Fatalf("Error reading packet: %v", err)
+	}
+	if !bytes.Equal(pkt.Data, big) {
+		t.Errorf("Got a %d byte packet, want %d bytes", len(pkt.Data), len(big))
+	}
+	if pkt.Granule != 960 || !pkt.EOS {
+		t.Errorf("Got granule %d, EOS %v; want 960, true", pkt.Granule, pkt.EOS)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	hdr := buildPage(pageBOS, 0, 1, 0, lacing(19), testHead())
+	if _, err := NewReader(bytes.NewReader([]byte("not an ogg stream at all......."))); err != ErrBadPage {
+		t.Errorf("Expected ErrBadPage, got %v", err)
+	}
+	corrupt := append([]byte(nil), hdr...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := NewReader(bytes.NewReader(corrupt)); err != ErrBadCRC {
+		t.Errorf("Expected ErrBadCRC, got %v", err)
+	}
+	vorbis := buildPage(pageBOS, 0, 1, 0, lacing(7), []byte("\x01vorbis"))
+	if _, err := NewReader(bytes.NewReader(vorbis)); err != ErrNotOpus {
+		t.Errorf("Expected ErrNotOpus, got %v", err)
+	}
+	badHead := testHead()
+	badHead[9] = 3 // 3 channels need a mapping table
+	page := buildPage(pageBOS, 0, 1, 0, lacing(19), badHead)
+	if _, err := NewReader(bytes.NewReader(page)); err != ErrBadHead {
+		t.Errorf("Expected ErrBadHead, got %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(hdr)); err == nil {
+		t.Errorf("Expected error for missing OpusTags")
+	}
+}
+
+// fakeDecoder "decodes" packets into their 48 kHz duration of mono samples
+// numbered from 0.
+type fakeDecoder struct{ next int16 }
+
+func (d *fakeDecoder) Decode(data []byte, pcm []int16) (int, error) {
+	n := packetDuration(data)
+	for i := 0; i < n; i++ {
+		pcm[i] = d.next
+		d.next++
+	}
+	return n, nil
+}
+
+func TestReaderDecodeNext(t *testing.T) {
+	const serial = 1
+	frame := []byte{0xf8, 0} // CELT 20 ms
+	var stream []byte
+	stream = append(stream, buildPage(pageBOS, 0, serial, 0, lacing(19), testHead())...)
+	stream = append(stream, buildPage(0, 0, serial, 1, lacing(len(testTags())), testTags())...)
+	body := bytes.Repeat(frame, 3)
+	// 3 frames of 960 samples, ending 1000 samples early.
+	stream = append(stream, buildPage(pageEOS, 3*960-1000, serial, 2, []byte{2, 2, 2}, body)...)
+
+	r, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("Error creating reader: %v", err)
+	}
+	dec := &fakeDecoder{}
+	pcm := make([]int16, 960)
+	var got []int16
+	for {
+		n, err := r.DecodeNext(dec, pcm)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		got = append(got, pcm[:n]...)
+	}
+	if want := 3*960 - 1000 - 312; len(got) != want {
+		t.Fatalf("Decoded %d samples, want %d", len(got), want)
+	}
+	if got[0] != 312 {
+		t.Errorf("First sample is %d, want 312 after pre-skip", got[0])
+	}
+}
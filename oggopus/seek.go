@@ -0,0 +1,174 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package oggopus
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotSeekable is returned by the seek methods when the Reader's source
+// doesn't implement io.Seeker.
+var ErrNotSeekable = errors.New("oggopus: source is not seekable")
+
+// preRoll is the amount of audio, in 48 kHz samples, decoded and discarded
+// before a seek target so the decoder state converges (RFC 7845, section
+// 4.6).
+const preRoll = 3840
+
+// bisectLimit is the byte range below which seeking scans pages linearly
+// instead of bisecting further.
+const bisectLimit = 64 << 10
+
+// SeekTime positions the reader so that the next samples returned by
+// DecodeNext start at d from the beginning of the stream. See SeekSample.
+func (r *Reader) SeekTime(d time.Duration) error {
+	if d < 0 {
+		return errors.New("oggopus: negative seek position")
+	}
+	return r.SeekSample(int64(d * 48000 / time.Second))
+}
+
+// SeekSample positions the reader so that the next samples returned by
+// DecodeNext start at sample n, counted at 48 kHz from the beginning of the
+// stream (after the pre-skip). It bisects over the page granule positions
+// and resumes reading 80 ms before the target; DecodeNext decodes and drops
+// that pre-roll so the decoder has converged by the target. Next and
+// ReadPacket return the pre-roll packets as usual.
+func (r *Reader) SeekSample(n int64) error {
+	rs, ok := r.r.(io.ReadSeeker)
+	if !ok {
+		return ErrNotSeekable
+	}
+	if n < 0 {
+		return errors.New("oggopus: negative seek position")
+	}
+	target := n + int64(r.head.PreSkip)
+	start := max(target-preRoll, 0)
+
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	lo, hi := r.dataStart, size
+	for hi-lo > bisectLimit {
+		mid := lo + (hi-lo)/2
+		pg, _, err := r.nextGranulePage(rs, mid, hi)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if pg != nil && pg.granule <= start {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	// Scan from lo for the last page ending at or before start.
+	var best *page
+	var bestEnd int64
+	off := lo
+	for {
+		pg, end, err := r.nextGranulePage(rs, off, size)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if pg.granule > start {
+			break
+		}
+		best, bestEnd, off = pg, end, end
+	}
+
+	r.partial, r.skipContinued = nil, false
+	r.skipTo = max(target, int64(r.head.PreSkip))
+	if best == nil {
+		// The target is within the first page: start over.
+		if _, err := rs.Seek(r.dataStart, io.SeekStart); err != nil {
+			return err
+		}
+		r.pg, r.seg, r.off, r.decoded = nil, 0, 0, 0
+		return nil
+	}
+	if _, err := rs.Seek(bestEnd, io.SeekStart); err != nil {
+		return err
+	}
+	// Resume after the last packet completed on the page, so a packet
+	// continuing onto the next page is read in full.
+	r.pg, r.seg, r.off = best, 0, 0
+	for i, lace := range best.segments {
+		if lace < 255 {
+			r.seg = i + 1
+		}
+	}
+	for _, lace := range best.segments[:r.seg] {
+		r.off += int(lace)
+	}
+	r.decoded = best.granule
+	return nil
+}
+
+// nextGranulePage returns the first page of the stream at or after byte
+// offset off (and starting before limit) on which a packet completes, with
+// the offset just past it. It returns io.EOF if there is none.
+func (r *Reader) nextGranulePage(rs io.ReadSeeker, off, limit int64) (*page, int64, error) {
+	for off < limit {
+		pg, end, err := syncPage(rs, off, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		if pg.serial == r.serial && pg.granule != -1 {
+			return pg, end, nil
+		}
+		off = end
+	}
+	return nil, 0, io.EOF
+}
+
+// syncPage finds the first valid page starting at or after byte offset off
+// and before limit, skipping data that isn't a page or fails its checksum.
+// It returns the page and the offset just past it.
+func syncPage(rs io.ReadSeeker, off, limit int64) (*page, int64, error) {
+	buf := make([]byte, 4096)
+	for off < limit {
+		if _, err := rs.Seek(off, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		n, err := io.ReadFull(rs, buf)
+		if n < 4 {
+			if err == nil || err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return nil, 0, err
+		}
+		i := bytes.Index(buf[:n], []byte("OggS"))
+		if i < 0 {
+			// Keep the last 3 bytes in case the pattern straddles blocks.
+			off += int64(n - 3)
+			continue
+		}
+		if off+int64(i) >= limit {
+			break
+		}
+		if _, err := rs.Seek(off+int64(i), io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		pg, err := readPage(rs)
+		switch err {
+		case nil:
+			end, err := rs.Seek(0, io.SeekCurrent)
+			return pg, end, err
+		case ErrBadPage, ErrBadCRC, io.EOF:
+			off += int64(i) + 1
+		default:
+			return nil, 0, err
+		}
+	}
+	return nil, 0, io.EOF
+}
@@ -0,0 +1,175 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package oggopus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// indexDecoder decodes test packets made of a CELT 20 ms TOC byte and a
+// big-endian packet index into mono samples holding their 48 kHz position
+// in the stream, modulo 30000.
+type indexDecoder struct{}
+
+func (indexDecoder) Decode(data []byte, pcm []int16) (int, error) {
+	idx := int(binary.BigEndian.Uint16(data[1:]))
+	for i := 0; i < 960; i++ {
+		pcm[i] = int16((idx*960 + i) % 30000)
+	}
+	return 960, nil
+}
+
+// buildIndexedStream returns a mono stream of 20 ms packets for
+// indexDecoder, padded to make the stream a few hundred kilobytes.
+func buildIndexedStream(packets int) []byte {
+	const serial = 3
+	const perPage = 10
+	var stream []byte
+	stream = append(stream, buildPage(pageBOS, 0, serial, 0, lacing(19), testHead())...)
+	stream = append(stream, buildPage(0, 0, serial, 1, lacing(len(testTags())), testTags())...)
+	seq := uint32(2)
+	for first := 0; first < packets; first += perPage {
+		var segments, body []byte
+		last := min(first+perPage, packets)
+		for idx := first; idx < last; idx++ {
+			pkt := make([]byte, 200)
+			pkt[0] = 0xf8
+			binary.BigEndian.PutUint16(pkt[1:], uint16(idx))
+			segments = append(segments, lacing(len(pkt))...)
+			body = append(body, pkt...)
+		}
+		var flags byte
+		if last == packets {
+			flags = pageEOS
+		}
+		stream = append(stream, buildPage(flags, int64(last*960), serial, seq, segments, body)...)
+		seq++
+	}
+	return stream
+}
+
+func TestReaderSeekSample(t *testing.T) {
+	const packets = 2000
+	stream := buildIndexedStream(packets)
+	r, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("Error creating reader: %v", err)
+	}
+	pcm := make([]int16, 960)
+	for _, n := range []int64{0, 100, 48000, 10 * 48000, 30*48000 + 17, 5, int64(packets*960 - 400)} {
+		if err := r.SeekSample(n); err != nil {
+			t.Fatalf("SeekSample(%d): %v", n, err)
+		}
+		for {
+			got, err := r.DecodeNext(indexDecoder{}, pcm)
+			if err != nil {
+				t.Fatalf("SeekSample(%d): DecodeNext: %v", n, err)
+			}
+			if got == 0 {
+				continue
+			}
+			if want := int16((n + 312) % 30000); pcm[0] != want {
+				t.Errorf("SeekSample(%d): first sample is %d, want %d", n, pcm[0], want)
+			}
+			break
+		}
+	}
+	if err := r.SeekTime(-time.Second); err == nil {
+		t.Errorf("Expected error for a negative position")
+	}
+}
+
+func TestReaderSeekFile(t *testing.T) {
+	data, err := os.ReadFile("../testdata/speech_8.opus")
+	if err != nil {
+		t.Fatalf("Error reading test file: %v", err)
+	}
+	count := func(r *Reader) int {
+		pcm := make([]int16, 5760)
+		total := 0
+		for {
+			n, err := r.DecodeNext(&fakeDecoder{}, pcm)
+			if err == io.EOF {
+				return total
+			}
+			if err != nil {
+				t.Fatalf("DecodeNext: %v", err)
+			}
+			total += n
+		}
+	}
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Error creating reader: %v", err)
+	}
+	total := count(r)
+	if err := r.SeekTime(time.Second); err != nil {
+		t.Fatalf("SeekTime: %v", err)
+	}
+	if got := count(r); got != total-48000 {
+		t.Errorf("Got %d samples after seeking to 1s, want %d", got, total-48000)
+	}
+	if err := r.SeekSample(0); err != nil {
+		t.Fatalf("SeekSample: %v", err)
+	}
+	if got := count(r); got != total {
+		t.Errorf("Got %d samples after seeking to the start, want %d", got, total)
+	}
+
+	unseekable, err := NewReader(io.MultiReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("Error creating reader: %v", err)
+	}
+	if err := unseekable.SeekSample(0); err != ErrNotSeekable {
+		t.Errorf("Expected ErrNotSeekable, got %v", err)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	const packets = 2000
+	stream := buildIndexedStream(packets)
+	d, err := Duration(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("Duration: %v", err)
+	}
+	if want := time.Duration(packets*960-312) * time.Second / 48000; d != want {
+		t.Errorf("Got duration %v, want %v", d, want)
+	}
+
+	f, err := os.Open("../testdata/speech_8.opus")
+	if err != nil {
+		t.Fatalf("Error opening test file: %v", err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatalf("Error creating reader: %v", err)
+	}
+	d, err = r.Duration()
+	if err != nil {
+		t.Fatalf("Duration: %v", err)
+	}
+	// Reading must carry on from where it was.
+	pcm := make([]int16, 5760)
+	total := 0
+	for {
+		n, err := r.DecodeNext(&fakeDecoder{}, pcm)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("DecodeNext: %v", err)
+		}
+		total += n
+	}
+	if want := time.Duration(total) * time.Second / 48000; d != want {
+		t.Errorf("Got duration %v, want %v", d, want)
+	}
+}
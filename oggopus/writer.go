@@ -0,0 +1,163 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package oggopus
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+)
+
+// Limits on the audio buffered before Writer emits a page: a page is ended
+// once it holds maxPageBody bytes or maxPageDuration (in 48 kHz samples) of
+// audio, which keeps the overhead low without delaying streaming readers
+// too much.
+const (
+	maxPageBody     = 4096
+	maxPageDuration = 48000
+)
+
+// errWriterClosed is returned when writing to a closed Writer.
+var errWriterClosed = errors.New("oggopus: write to closed Writer")
+
+// Writer writes an Ogg Opus stream: the headers, then Opus packets grouped
+// into pages. It is the counterpart of Reader.
+type Writer struct {
+	w        io.Writer
+	serial   uint32
+	sequence uint32
+
+	// pending holds the packets of the pages not written yet.
+	pending     []Packet
+	pendingSize int
+	// pageStart is the granule position at the start of pending.
+	pageStart int64
+	granule   int64
+	closed    bool
+	buf       []byte
+}
+
+// NewWriter writes the OpusHead and OpusTags headers to w, each starting a
+// page as RFC 7845 requires, and returns a Writer for the audio packets.
+func NewWriter(w io.Writer, head OpusHead, tags OpusTags) (*Writer, error) {
+	headData, err := head.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	tagsData, err := tags.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	ow := &Writer{w: w, serial: rand.Uint32()}
+	if err := ow.writePages([]Packet{{Data: headData}}, pageBOS); err != nil {
+		return nil, err
+	}
+	if err := ow.writePages([]Packet{{Data: tagsData}}, 0); err != nil {
+		return nil, err
+	}
+	return ow, nil
+}
+
+// WritePacket adds a packet to the stream. granule is the granule position
+// at the end of the packet: 48 kHz samples including the pre-skip. The
+// packet is buffered until its page is complete; the granule position of
+// the last packet, written by Close, may fall before the end of its audio
+// to trim the padding of the final frame.
+func (w *Writer) WritePacket(data []byte, granule int64) error {
+	if w.closed {
+		return errWriterClosed
+	}
+	if len(w.pending) > 0 && (w.pendingSize+len(data) > maxPageBody || granule-w.pageStart > maxPageDuration) {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(w.pending) == 0 {
+		w.pageStart = w.granule
+	}
+	w.pending = append(w.pending, Packet{Data: append([]byte(nil), data...), Granule: granule})
+	w.pendingSize += len(data)
+	w.granule = granule
+	return nil
+}
+
+// Flush writes the buffered packets out as complete pages, so a reader
+// sees everything written so far.
+func (w *Writer) Flush() error {
+	if w.closed {
+		return errWriterClosed
+	}
+	if len(w.pending) == 0 {
+		return nil
+	}
+	return w.flush(0)
+}
+
+// Close writes the buffered packets and marks the last page as the end of
+// the stream. It doesn't close the underlying io.Writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	err := w.flush(pageEOS)
+	w.closed = true
+	return err
+}
+
+func (w *Writer) flush(flags byte) error {
+	err := w.writePages(w.pending, flags)
+	w.pending, w.pendingSize = w.pending[:0], 0
+	return err
+}
+
+// writePages writes packets on as many pages as their lacing needs. flags
+// is set on the first page if it is pageBOS, and on the last one otherwise.
+// A page on which no packet completes gets granule position -1.
+func (w *Writer) writePages(packets []Packet, flags byte) error {
+	pg := &page{serial: w.serial, granule: -1}
+	if flags == pageBOS {
+		pg.headerType = pageBOS
+	}
+	emit := func(last bool) error {
+		if last && flags != pageBOS {
+			pg.headerType |= flags
+		}
+		if pg.granule == -1 && len(pg.segments) == 0 {
+			// An empty EOS page carries the final position.
+			pg.granule = w.granule
+		}
+		pg.sequence = w.sequence
+		w.sequence++
+		w.buf = appendPage(w.buf[:0], pg)
+		_, err := w.w.Write(w.buf)
+		return err
+	}
+	for _, pkt := range packets {
+		data := pkt.Data
+		// continued is set while a packet's lacing runs on.
+		continued := false
+		for {
+			if len(pg.segments) == 255 {
+				if err := emit(false); err != nil {
+					return err
+				}
+				pg = &page{serial: w.serial, granule: -1}
+				if continued {
+					pg.headerType = pageContinued
+				}
+			}
+			n := min(len(data), 255)
+			pg.segments = append(pg.segments, byte(n))
+			pg.body = append(pg.body, data[:n]...)
+			data = data[n:]
+			if n < 255 {
+				pg.granule = pkt.Granule
+				break
+			}
+			continued = true
+		}
+	}
+	return emit(true)
+}
@@ -0,0 +1,158 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package oggopus
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	f, err := os.Open("../testdata/speech_8.opus")
+	if err != nil {
+		t.Fatalf("Error opening test file: %v", err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatalf("Error creating reader: %v", err)
+	}
+	want, err := r.Duration()
+	if err != nil {
+		t.Fatalf("Duration: %v", err)
+	}
+
+	// Re-mux the file, tracking the granule position of every packet.
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, r.Head(), r.Tags())
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	var packets [][]byte
+	var granule int64
+	for {
+		pkt, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		granule += int64(packetDuration(pkt.Data))
+		if pkt.EOS {
+			// Keep the end trimming.
+			granule = pkt.Granule
+		}
+		if err := w.WritePacket(pkt.Data, granule); err != nil {
+			t.Fatalf("WritePacket: %v", err)
+		}
+		packets = append(packets, pkt.Data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.WritePacket([]byte{0}, 0); err == nil {
+		t.Errorf("Expected error writing to a closed Writer")
+	}
+
+	r2, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Error reading the written stream: %v", err)
+	}
+	if r2.Head().PreSkip != r.Head().PreSkip || r2.Tags().Vendor != r.Tags().Vendor {
+		t.Errorf("Headers changed: %+v, %+v", r2.Head(), r2.Tags())
+	}
+	for i, data := range packets {
+		pkt, err := r2.Next()
+		if err != nil {
+			t.Fatalf("Packet %d: %v", i, err)
+		}
+		if !bytes.Equal(pkt.Data, data) {
+			t.Fatalf("Packet %d differs", i)
+		}
+		if pkt.EOS != (i == len(packets)-1) {
+			t.Errorf("Packet %d: EOS is %v", i, pkt.EOS)
+		}
+	}
+	if _, err := r2.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if got, err := r2.Duration(); err != nil || got != want {
+		t.Errorf("Duration() = %v, %v; want %v", got, err, want)
+	}
+}
+
+func TestWriterLacing(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, OpusHead{Channels: 2, PreSkip: 312}, OpusTags{Vendor: "test"})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	// Sizes around the lacing boundaries, and packets spanning pages.
+	sizes := []int{0, 1, 254, 255, 256, 510, 70000, 3, 255 * 255, 10}
+	for i, n := range sizes {
+		data := bytes.Repeat([]byte{byte(i)}, n)
+		if err := w.WritePacket(data, int64(i+1)*960); err != nil {
+			t.Fatalf("WritePacket: %v", err)
+		}
+		if i == 1 {
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("Second Close: %v", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	for i, n := range sizes {
+		pkt, err := r.Next()
+		if err != nil {
+			t.Fatalf("Packet %d: %v", i, err)
+		}
+		if !bytes.Equal(pkt.Data, bytes.Repeat([]byte{byte(i)}, n)) {
+			t.Errorf("Packet %d: got %d bytes, want %d", i, len(pkt.Data), n)
+		}
+		if pkt.Granule != -1 && pkt.Granule != int64(i+1)*960 {
+			t.Errorf("Packet %d: granule %d", i, pkt.Granule)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if d, err := r.Duration(); err != nil || d != time.Duration(len(sizes)*960-312)*time.Second/48000 {
+		t.Errorf("Duration() = %v, %v", d, err)
+	}
+
+	// A stream closed without audio still ends with an EOS page.
+	buf.Reset()
+	w, err = NewWriter(&buf, OpusHead{Channels: 1}, OpusTags{})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	r, err = NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if _, err := NewWriter(&buf, OpusHead{Channels: 3}, OpusTags{}); err != ErrBadHead {
+		t.Errorf("Expected ErrBadHead, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// runtimeOptions configure how the wasm runtime is set up.
+type runtimeOptions struct {
+	engine       Engine
+	runtime      wazero.Runtime
+	wasm         []byte
+	wasmFile     string
+	codecs       Codecs
+	noWASI       bool
+	leakDetector bool
+	memoryPages  uint32
+	warmup       bool
+}
+
+// Option configures the wasm runtime, see Configure.
+type Option func(*runtimeOptions)
+
+// options are guarded by wasmMu.
+var options runtimeOptions
+
+// Configure applies opts to the wasm runtime. Like SetEngine, it must be
+// called before the first encoder or decoder is created, or after
+// CloseWasmContext; it returns an error while the runtime is running.
+// Options not passed keep their current setting. A failed initialization is
+// retried with the new options.
+func Configure(opts ...Option) error {
+	wasmMu.Lock()
+	defer wasmMu.Unlock()
+	if globalWasmManager != nil {
+		return fmt.Errorf("opus: runtime options can't change while the wasm runtime is running")
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	wasmInitOnce = sync.Once{}
+	wasmInitErr = nil
+	return nil
+}
+
+// WithRuntime runs libopus in rt, a runtime owned by the application,
+// instead of one created by the package, so a process already using wazero
+// compiles and hosts everything in one place. WASI is instantiated in rt
+// unless it already is or the module doesn't need it. CloseWasmContext
+// closes the libopus instances but leaves rt open; the engine set by
+// SetEngine doesn't apply.
+//
+// Context cancellation only interrupts libopus calls if rt was configured
+// with WithCloseOnContextDone(true). A nil rt restores the default.
+func WithRuntime(rt wazero.Runtime) Option {
+	return func(o *runtimeOptions) { o.runtime = rt }
+}
+
+// WithWasmBinary runs wasm, a libopus build made from wasm-bridge/, instead
+// of the embedded module, e.g. a different libopus version or one built with
+// other optimization flags. Initialization fails if it lacks one of the
+// exports the package needs or their signatures don't match. A nil wasm
+// restores the embedded module.
+func WithWasmBinary(wasm []byte) Option {
+	return func(o *runtimeOptions) { o.wasm, o.wasmFile = wasm, "" }
+}
+
+// WithWasmFile is WithWasmBinary with a module read from path when the
+// runtime is initialized.
+func WithWasmFile(path string) Option {
+	return func(o *runtimeOptions) { o.wasm, o.wasmFile = nil, path }
+}
+
+// WithWASI(false) runs libopus without WASI. The module's WASI imports, the
+// stderr writes and entropy calls of its C library, are satisfied by stubs
+// failing with ENOSYS, so libopus gets no access to the host at all and the
+// WASI host module isn't set up. With WithWASI(true), the default, WASI is
+// instantiated if the module imports it. Either way a module without WASI
+// imports runs without it.
+//
+// The setting only applies to a runtime that has no wasi_snapshot_preview1
+// module yet. If the runtime passed to WithRuntime already has one, e.g.
+// instantiated by the application or by an earlier initialization with the
+// other setting, libopus uses that module as is.
+func WithWASI(enabled bool) Option {
+	return func(o *runtimeOptions) { o.noWASI = !enabled }
+}
+
+// wasmPageSize is the size of a page of wasm memory.
+const wasmPageSize = 65536
+
+// WithInitialMemoryPages grows the memory of every wasm instance to pages
+// of 64 KiB when it is created. libopus otherwise starts with the memory
+// the module declares and grows it when an allocation doesn't fit, which
+// copies the whole memory in the middle of a call, e.g. the first decode
+// of a long frame; latency-sensitive applications can pay for that up
+// front instead. Instances that already are as large are left alone; 0,
+// the default, disables pre-growing.
+func WithInitialMemoryPages(pages uint32) Option {
+	return func(o *runtimeOptions) { o.memoryPages = pages }
+}
+
+// Codecs selects the halves of libopus the runtime is set up for.
+type Codecs int
+
+const (
+	// AllCodecs sets up encoding and decoding. It is the default.
+	AllCodecs Codecs = iota
+	// DecoderOnly sets up decoding only; NewEncoder fails with
+	// ErrEncoderUnavailable.
+	DecoderOnly
+	// EncoderOnly sets up encoding only; NewDecoder fails with
+	// ErrDecoderUnavailable.
+	EncoderOnly
+)
+
+// ErrEncoderUnavailable and ErrDecoderUnavailable are returned for codecs
+// the runtime wasn't set up for, see WithCodecs.
+var (
+	ErrEncoderUnavailable = errors.New("opus: wasm runtime set up without the encoder")
+	ErrDecoderUnavailable = errors.New("opus: wasm runtime set up without the decoder")
+)
+
+// WithCodecs sets up the runtime for encoding, decoding or both. With one
+// half only, the exports of the other are neither resolved nor required, so
+// a slimmer libopus build without them, passed to WithWasmBinary, loads.
+func WithCodecs(c Codecs) Option {
+	return func(o *runtimeOptions) { o.codecs = c }
+}
+
+// InitDecoderOnly initializes the runtime for decoding only, for receive-only
+// clients; see WithCodecs. It returns an error if the runtime is already
+// running with the encoder.
+func InitDecoderOnly(ctx context.Context) error {
+	return initCodecs(ctx, DecoderOnly)
+}
+
+// InitEncoderOnly initializes the runtime for encoding only, see
+// InitDecoderOnly.
+func InitEncoderOnly(ctx context.Context) error {
+	return initCodecs(ctx, EncoderOnly)
+}
+
+// initCodecs implements InitDecoderOnly and InitEncoderOnly.
+func initCodecs(ctx context.Context, c Codecs) error {
+	if err := Configure(WithCodecs(c)); err != nil {
+		wasmMu.Lock()
+		m := globalWasmManager
+		wasmMu.Unlock()
+		if m != nil && m.codecs == c {
+			return nil
+		}
+		return err
+	}
+	_, err := initWasm(ctx)
+	return err
+}
+
+// wasmBinary returns the libopus module to run, the embedded one unless
+// set otherwise.
+func (o runtimeOptions) wasmBinary() ([]byte, error) {
+	switch {
+	case o.wasmFile != "":
+		wasm, err := os.ReadFile(o.wasmFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read wasm module: %w", err)
+		}
+		return wasm, nil
+	case o.wasm != nil:
+		return o.wasm, nil
+	}
+	return opusWasmBinary, nil
+}
@@ -0,0 +1,93 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInitDecoderOnly(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	ctx := context.Background()
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	defer func() {
+		CloseWasmContext(ctx)
+		Configure(WithCodecs(AllCodecs))
+	}()
+	if err := InitDecoderOnly(ctx); err != nil {
+		t.Fatalf("InitDecoderOnly: %v", err)
+	}
+	// Repeating it is harmless, switching isn't possible.
+	if err := InitDecoderOnly(ctx); err != nil {
+		t.Errorf("InitDecoderOnly again: %v", err)
+	}
+	if err := InitEncoderOnly(ctx); err == nil {
+		t.Errorf("Expected an error switching a running runtime to the encoder")
+	}
+	if _, err := NewEncoder(SAMPLE_RATE, 1, AppAudio); err != ErrEncoderUnavailable {
+		t.Errorf("Got %v creating an encoder, want ErrEncoderUnavailable", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	if _, err := dec.DecodePLC(make([]int16, SAMPLE_RATE/50)); err != nil {
+		t.Errorf("DecodePLC: %v", err)
+	}
+}
+
+func TestWithInitialMemoryPages(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 60 / 1000
+	ctx := context.Background()
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	if err := Configure(WithInitialMemoryPages(64)); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	defer func() {
+		CloseWasmContext(ctx)
+		Configure(WithInitialMemoryPages(0))
+	}()
+
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 2)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	size := dec.wctx.module.Memory().Size()
+	if size < 64*wasmPageSize {
+		t.Fatalf("Memory has %d bytes, want at least 64 pages", size)
+	}
+	pcm := make([]int16, FRAME_SIZE*2)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 4000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if _, err := dec.Decode(data[:n], pcm); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	if got := dec.wctx.module.Memory().Size(); got != size {
+		t.Errorf("Memory grew from %d to %d bytes while decoding", size, got)
+	}
+
+	// More than wasm32 can address fails.
+	CloseWasmContext(ctx)
+	if err := Configure(WithInitialMemoryPages(1 << 17)); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if _, err := NewDecoder(SAMPLE_RATE, 2); err == nil {
+		t.Errorf("Expected an error growing the memory beyond 4 GiB")
+	}
+}
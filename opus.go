@@ -7,7 +7,6 @@ package opus
 import (
 	"context"
 	"fmt"
-	"log"
 
 	"github.com/tetratelabs/wazero/api"
 )
@@ -23,41 +22,59 @@ const (
 	AppRestrictedLowdelay = Application(2051) // OPUS_APPLICATION_RESTRICTED_LOWDELAY
 )
 
+// checkSampleRate returns an error listing the supported sample rates unless
+// libopus works at sampleRate, so constructors fail before any wasm call.
+func checkSampleRate(sampleRate int) error {
+	if !isOpusSampleRate(sampleRate) {
+		return fmt.Errorf("opus: unsupported sample rate %d Hz; use 8000, 12000, 16000, 24000 or 48000", sampleRate)
+	}
+	return nil
+}
+
+// Version returns the libopus version string, or "" if the wasm runtime
+// can't be initialized; VersionErr reports why.
 func Version() string {
+	version, _ := VersionErr()
+	return version
+}
+
+// VersionErr returns the libopus version string, e.g. "libopus 1.5.2".
+func VersionErr() (string, error) {
 	ctx := context.Background() // Context for initialization
 	wctx, err := GetWasmContext(ctx)
 	if err != nil {
-		log.Fatalf("Failed to get wasm context for decoder: %v", err)
+		return "", err
 	}
 	defer releaseWasmContext(wctx)
 
 	opusGetVersionString := wctx.module.ExportedFunction("opus_get_version_string")
+	if opusGetVersionString == nil {
+		return "", fmt.Errorf("opus: wasm module does not export opus_get_version_string")
+	}
 
 	results, err := opusGetVersionString.Call(ctx)
 	if err != nil {
-		log.Fatalf("Failed to call opus_get_version_string: %v", err)
+		return "", callError(ctx, "opus_get_version_string", err)
 	}
 
-	ptrVersion := results[0]
-	version, err := readCString(wctx.module.Memory(), uint32(ptrVersion))
+	version, err := readCString(wctx.module.Memory(), uint32(results[0]))
 	if err != nil {
-		log.Fatalf("Failed to read version string: %v", err)
+		return "", fmt.Errorf("failed to read version string: %w", err)
 	}
-
-	return version
+	return version, nil
 }
 
 func readCString(memory api.Memory, offset uint32) (string, error) {
 	var buffer []byte
 	for {
-		b, ok := memory.ReadByte(offset)
+		b, ok := memory.Read(offset, 1)
 		if !ok {
 			return "", fmt.Errorf("failed to read byte at offset %d", offset)
 		}
-		if b == 0 {
+		if b[0] == 0 {
 			break
 		}
-		buffer = append(buffer, b)
+		buffer = append(buffer, b[0])
 		offset++
 	}
 	return string(buffer), nil
@@ -5,7 +5,11 @@
 package opus
 
 import (
+	"bytes"
+	"context"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -13,6 +17,30 @@ func TestVersion(t *testing.T) {
 	if ver := Version(); !strings.HasPrefix(ver, "libopus") {
 		t.Errorf("Unexpected linked libopus version: %s", ver)
 	}
+	if ver, err := VersionErr(); err != nil || !strings.HasPrefix(ver, "libopus") {
+		t.Errorf("Unexpected linked libopus version: %s (err=%v)", ver, err)
+	}
+}
+
+func TestVersionInitFailure(t *testing.T) {
+	ctx := context.Background()
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	defer func() {
+		CloseWasmContext(ctx)
+		Configure(WithWasmBinary(nil))
+	}()
+	if err := Configure(WithWasmBinary([]byte("\x00asm\x01\x00\x00\x00"))); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	// A broken module is reported, not fatal.
+	if ver, err := VersionErr(); err == nil {
+		t.Errorf("Got version %q, want an error", ver)
+	}
+	if ver := Version(); ver != "" {
+		t.Errorf("Got version %q, want none", ver)
+	}
 }
 
 func TestOpusErrstr(t *testing.T) {
@@ -642,3 +670,77 @@ func TestBufferSize(t *testing.T) {
 		decodeFecFloat32(t, encodeFrame(t), FRAME_SIZE+1, false)
 	})
 }
+
+func TestSampleBytes(t *testing.T) {
+	// Wasm memory is little-endian whatever the host is.
+	pcm := []int16{0x0102, -2}
+	if got, want := int16SliceToByteSlice(pcm), []byte{2, 1, 0xfe, 0xff}; !bytes.Equal(got, want) {
+		t.Errorf("Got int16 bytes %v, want %v", got, want)
+	}
+	back := make([]int16, 2)
+	if err := int16SliceFromByteSlice([]byte{2, 1, 0xfe, 0xff}, back); err != nil || !slices.Equal(back, pcm) {
+		t.Errorf("Got int16 samples %v (err=%v), want %v", back, err, pcm)
+	}
+	f := []float32{1, -0.5}
+	if got, want := float32SliceToByteSlice(f), []byte{0, 0, 0x80, 0x3f, 0, 0, 0, 0xbf}; !bytes.Equal(got, want) {
+		t.Errorf("Got float32 bytes %v, want %v", got, want)
+	}
+	fback := make([]float32, 2)
+	if err := float32SliceFromByteSlice([]byte{0, 0, 0x80, 0x3f, 0, 0, 0, 0xbf}, fback); err != nil || !slices.Equal(fback, f) {
+		t.Errorf("Got float32 samples %v (err=%v), want %v", fback, err, f)
+	}
+	if err := int16SliceFromByteSlice(make([]byte, 6), back); err == nil {
+		t.Errorf("Expected an error for a too short destination")
+	}
+}
+
+func TestConcurrentCodecs(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	// Start from scratch so the goroutines race to initialize the runtime.
+	if err := CloseWasmContext(context.Background()); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	var shared *Encoder
+	var once sync.Once
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+			if err != nil {
+				t.Errorf("Error creating new encoder: %v", err)
+				return
+			}
+			once.Do(func() { shared = enc })
+			dec, err := NewDecoder(SAMPLE_RATE, 1)
+			if err != nil {
+				t.Errorf("Error creating new decoder: %v", err)
+				return
+			}
+			data := make([]byte, 1000)
+			out := make([]int16, FRAME_SIZE)
+			for i := 0; i < 10; i++ {
+				// Alternate between an encoder of our own and one shared
+				// by all goroutines.
+				e := enc
+				if i%2 == 1 {
+					e = shared
+				}
+				n, err := e.Encode(pcm, data)
+				if err != nil {
+					t.Errorf("Couldn't encode data: %v", err)
+					return
+				}
+				if _, err := dec.Decode(data[:n], out); err != nil {
+					t.Errorf("Couldn't decode data: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
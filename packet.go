@@ -0,0 +1,516 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+//
+// Go ports of the libopus packet inspection helpers (opus_packet_*). They
+// only look at the packet framing, so they don't need the wasm runtime.
+
+package opus
+
+import (
+	"fmt"
+	"time"
+)
+
+// Bandwidth values as defined by opus_defines.h. The exported Bandwidth
+// variables are loaded from the wasm module and equal these values.
+const (
+	bandwidthNarrowband    = 1101 // OPUS_BANDWIDTH_NARROWBAND
+	bandwidthMediumband    = 1102 // OPUS_BANDWIDTH_MEDIUMBAND
+	bandwidthWideband      = 1103 // OPUS_BANDWIDTH_WIDEBAND
+	bandwidthSuperWideband = 1104 // OPUS_BANDWIDTH_SUPERWIDEBAND
+	bandwidthFullband      = 1105 // OPUS_BANDWIDTH_FULLBAND
+)
+
+// maxFrameBytes is the largest legal size of a single Opus frame.
+const maxFrameBytes = 1275
+
+// tocBandwidth decodes the audio bandwidth from a TOC byte.
+func tocBandwidth(toc byte) Bandwidth {
+	switch {
+	case toc&0x80 != 0: // CELT-only
+		bw := bandwidthMediumband + int((toc>>5)&0x3)
+		if bw == bandwidthMediumband {
+			bw = bandwidthNarrowband
+		}
+		return Bandwidth(bw)
+	case toc&0x60 == 0x60: // Hybrid
+		if toc&0x10 != 0 {
+			return Bandwidth(bandwidthFullband)
+		}
+		return Bandwidth(bandwidthSuperWideband)
+	default: // SILK-only
+		return Bandwidth(bandwidthNarrowband + int((toc>>5)&0x3))
+	}
+}
+
+// tocChannels returns the number of channels coded in a TOC byte.
+func tocChannels(toc byte) int {
+	if toc&0x4 != 0 {
+		return 2
+	}
+	return 1
+}
+
+// tocSamplesPerFrame returns the number of samples per frame at sampleRate.
+func tocSamplesPerFrame(toc byte, sampleRate int) int {
+	switch {
+	case toc&0x80 != 0:
+		return (sampleRate << ((toc >> 3) & 0x3)) / 400
+	case toc&0x60 == 0x60:
+		if toc&0x08 != 0 {
+			return sampleRate / 50
+		}
+		return sampleRate / 100
+	default:
+		size := int((toc >> 3) & 0x3)
+		if size == 3 {
+			return sampleRate * 60 / 1000
+		}
+		return (sampleRate << size) / 100
+	}
+}
+
+// tocIsCELTOnly reports whether a TOC byte selects the CELT-only mode.
+func tocIsCELTOnly(toc byte) bool {
+	return toc&0x80 != 0
+}
+
+// packetFrameCount returns the number of frames in a packet.
+func packetFrameCount(data []byte) (int, error) {
+	if len(data) < 1 {
+		return 0, ErrBadArg
+	}
+	switch data[0] & 0x3 {
+	case 0:
+		return 1, nil
+	case 1, 2:
+		return 2, nil
+	}
+	if len(data) < 2 {
+		return 0, ErrInvalidPacket
+	}
+	return int(data[1] & 0x3f), nil
+}
+
+// packetSampleCount returns the number of samples per channel in a packet.
+func packetSampleCount(data []byte, sampleRate int) (int, error) {
+	count, err := packetFrameCount(data)
+	if err != nil {
+		return 0, err
+	}
+	samples := count * tocSamplesPerFrame(data[0], sampleRate)
+	// Can't have more than 120 ms.
+	if samples*25 > sampleRate*3 {
+		return 0, ErrInvalidPacket
+	}
+	return samples, nil
+}
+
+// parseFrameSize decodes a frame length coded on one or two bytes.
+func parseFrameSize(data []byte) (size int, n int) {
+	if len(data) < 1 {
+		return -1, -1
+	}
+	if data[0] < 252 {
+		return int(data[0]), 1
+	}
+	if len(data) < 2 {
+		return -1, -1
+	}
+	return 4*int(data[1]) + int(data[0]), 2
+}
+
+// parsedPacket is the result of parsePacket.
+type parsedPacket struct {
+	toc     byte
+	frames  [][]byte
+	padding int // number of padding bytes
+	// payloadOffset is the offset of the first frame in the packet.
+	payloadOffset int
+	// packetOffset is the total size of the packet, including padding. It
+	// is smaller than the input only for self-delimited packets.
+	packetOffset int
+}
+
+// parsePacket splits a packet into its frames, following
+// opus_packet_parse_impl.
+func parsePacket(data []byte) (parsedPacket, error) {
+	return parsePacketImpl(data, false)
+}
+
+// parseSelfDelimited parses a self-delimited packet (RFC 6716, appendix B)
+// at the start of data, as used for all but the last stream of a
+// multistream packet.
+func parseSelfDelimited(data []byte) (parsedPacket, error) {
+	return parsePacketImpl(data, true)
+}
+
+func parsePacketImpl(data []byte, selfDelimited bool) (parsedPacket, error) {
+	var p parsedPacket
+	if len(data) == 0 {
+		return p, ErrInvalidPacket
+	}
+	frameSize := tocSamplesPerFrame(data[0], 48000)
+	p.toc = data[0]
+	pos := 1
+	remaining := len(data) - 1
+	lastSize := remaining
+	var sizes []int
+	cbr := false
+
+	switch p.toc & 0x3 {
+	case 0: // One frame
+		sizes = make([]int, 1)
+	case 1: // Two CBR frames
+		cbr = true
+		sizes = make([]int, 2)
+		if !selfDelimited {
+			if remaining&1 != 0 {
+				return p, ErrInvalidPacket
+			}
+			lastSize = remaining / 2
+			sizes[0] = lastSize
+		}
+	case 2: // Two VBR frames
+		size, n := parseFrameSize(data[pos:])
+		if n < 0 {
+			return p, ErrInvalidPacket
+		}
+		remaining -= n
+		if size > remaining {
+			return p, ErrInvalidPacket
+		}
+		pos += n
+		lastSize = remaining - size
+		sizes = []int{size, 0}
+	default: // Multiple CBR/VBR frames
+		if remaining < 1 {
+			return p, ErrInvalidPacket
+		}
+		ch := data[pos]
+		pos++
+		remaining--
+		count := int(ch & 0x3f)
+		if count <= 0 || frameSize*count > maxFrameSize48k {
+			return p, ErrInvalidPacket
+		}
+		if ch&0x40 != 0 { // Padding
+			for {
+				if remaining <= 0 {
+					return p, ErrInvalidPacket
+				}
+				b := int(data[pos])
+				pos++
+				remaining--
+				tmp := b
+				if b == 255 {
+					tmp = 254
+				}
+				remaining -= tmp
+				p.padding += tmp
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if remaining < 0 {
+			return p, ErrInvalidPacket
+		}
+		sizes = make([]int, count)
+		if ch&0x80 != 0 { // VBR
+			lastSize = remaining
+			for i := 0; i < count-1; i++ {
+				size, n := parseFrameSize(data[pos : pos+remaining])
+				if n < 0 {
+					return p, ErrInvalidPacket
+				}
+				remaining -= n
+				if size > remaining {
+					return p, ErrInvalidPacket
+				}
+				pos += n
+				sizes[i] = size
+				lastSize -= n + size
+			}
+			if lastSize < 0 {
+				return p, ErrInvalidPacket
+			}
+		} else { // CBR
+			cbr = true
+			if !selfDelimited {
+				lastSize = remaining / count
+				if lastSize*count != remaining {
+					return p, ErrInvalidPacket
+				}
+				for i := 0; i < count-1; i++ {
+					sizes[i] = lastSize
+				}
+			}
+		}
+	}
+	if selfDelimited {
+		// The size of the last frame is coded after the other sizes.
+		size, n := parseFrameSize(data[pos : pos+remaining])
+		if n < 0 {
+			return p, ErrInvalidPacket
+		}
+		remaining -= n
+		if size > remaining {
+			return p, ErrInvalidPacket
+		}
+		pos += n
+		if cbr {
+			// For CBR packets, apply the size to all the frames.
+			if size*len(sizes) > remaining {
+				return p, ErrInvalidPacket
+			}
+			for i := range sizes {
+				sizes[i] = size
+			}
+		} else if n+size > lastSize {
+			return p, ErrInvalidPacket
+		}
+		sizes[len(sizes)-1] = size
+	} else {
+		if lastSize > maxFrameBytes {
+			return p, ErrInvalidPacket
+		}
+		sizes[len(sizes)-1] = lastSize
+	}
+
+	p.payloadOffset = pos
+	p.frames = make([][]byte, len(sizes))
+	for i, size := range sizes {
+		p.frames[i] = data[pos : pos+size : pos+size]
+		pos += size
+	}
+	p.packetOffset = pos + p.padding
+	return p, nil
+}
+
+// packetHasLBRR reports whether a packet carries SILK low bit-rate redundancy
+// (in-band FEC) data, following opus_packet_has_lbrr from libopus 1.5. The
+// LBRR flags are coded with probability 1/2 right at the start of the range
+// coded SILK payload, so they can be read off the first byte of the frame.
+func packetHasLBRR(data []byte) (bool, error) {
+	p, err := parsePacket(data)
+	if err != nil {
+		return false, err
+	}
+	if tocIsCELTOnly(p.toc) {
+		return false, nil
+	}
+	silkFrames := 1
+	if fs := tocSamplesPerFrame(p.toc, 48000); fs > 960 {
+		silkFrames = fs / 960
+	}
+	first := p.frames[0]
+	if len(first) == 0 {
+		return false, nil
+	}
+	lbrr := (first[0]>>(7-silkFrames))&0x1 != 0
+	if tocChannels(p.toc) == 2 {
+		lbrr = lbrr || (first[0]>>(6-2*silkFrames))&0x1 != 0
+	}
+	return lbrr, nil
+}
+
+// PacketBandwidth returns the audio bandwidth an Opus packet was coded with,
+// like opus_packet_get_bandwidth. Only the TOC byte is inspected, so it
+// doesn't need a decoder or the wasm runtime.
+func PacketBandwidth(data []byte) (Bandwidth, error) {
+	if len(data) < 1 {
+		return 0, ErrBadArg
+	}
+	return tocBandwidth(data[0]), nil
+}
+
+// PacketFrames returns the number of Opus frames in a packet, like
+// opus_packet_get_nb_frames.
+func PacketFrames(data []byte) (int, error) {
+	return packetFrameCount(data)
+}
+
+// PacketSamples returns the number of samples per channel a packet decodes
+// to at sampleRate, like opus_packet_get_nb_samples. It returns
+// ErrInvalidPacket for packets longer than 120 ms.
+func PacketSamples(data []byte, sampleRate int) (int, error) {
+	if !isOpusSampleRate(sampleRate) {
+		return 0, ErrBadArg
+	}
+	return packetSampleCount(data, sampleRate)
+}
+
+// Mode is the coding mode of an Opus frame.
+type Mode int
+
+const (
+	ModeSILK   Mode = iota // SILK-only, for speech
+	ModeHybrid             // SILK for low frequencies, CELT above 8 kHz
+	ModeCELT               // CELT-only, for music and low delay
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeSILK:
+		return "SILK"
+	case ModeHybrid:
+		return "Hybrid"
+	case ModeCELT:
+		return "CELT"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// TOC holds the fields of the table-of-contents byte that starts every Opus
+// packet (RFC 6716, section 3.1).
+type TOC struct {
+	// Config is the configuration number (0-31), which selects the coding
+	// mode, bandwidth and frame duration.
+	Config int
+	// Mode, Bandwidth and FrameDuration are decoded from Config.
+	Mode          Mode
+	Bandwidth     Bandwidth
+	FrameDuration time.Duration
+	// Stereo is set when the frames are coded as stereo.
+	Stereo bool
+	// FrameCountCode (0-3) selects how many frames the packet holds and how
+	// their sizes are coded.
+	FrameCountCode int
+}
+
+// ParseTOC decodes a TOC byte. It is plain Go and doesn't initialize the
+// wasm runtime, so it is cheap enough for classifying forwarded packets.
+func ParseTOC(b byte) TOC {
+	mode := ModeSILK
+	switch {
+	case tocIsCELTOnly(b):
+		mode = ModeCELT
+	case b&0x60 == 0x60:
+		mode = ModeHybrid
+	}
+	// At 48 kHz there are 48 samples per millisecond.
+	duration := time.Duration(tocSamplesPerFrame(b, 48000)) * time.Millisecond / 48
+	return TOC{
+		Config:         int(b >> 3),
+		Mode:           mode,
+		Bandwidth:      tocBandwidth(b),
+		FrameDuration:  duration,
+		Stereo:         b&0x4 != 0,
+		FrameCountCode: int(b & 0x3),
+	}
+}
+
+// ParsePacket splits an Opus packet into its TOC and individual frames, like
+// opus_packet_parse. The frames are subslices of data, not copies; padding
+// is not included in any of them.
+func ParsePacket(data []byte) (toc TOC, frames [][]byte, err error) {
+	p, err := parsePacket(data)
+	if err != nil {
+		return TOC{}, nil, err
+	}
+	return ParseTOC(p.toc), p.frames, nil
+}
+
+// PadPacket returns data padded to exactly newLen bytes, like
+// opus_packet_pad. The padding is ignored by decoders, so it can be used to
+// send every packet with the same size. data itself is not modified.
+func PadPacket(data []byte, newLen int) ([]byte, error) {
+	if len(data) < 1 || len(data) > newLen {
+		return nil, ErrBadArg
+	}
+	if len(data) == newLen {
+		return append([]byte(nil), data...), nil
+	}
+	var rp repacketizer
+	if err := rp.cat(data); err != nil {
+		return nil, err
+	}
+	return rp.out(0, len(rp.frames), newLen, true)
+}
+
+// UnpadPacket returns data with all padding removed, like
+// opus_packet_unpad. The result is never longer than data, which is not
+// modified.
+func UnpadPacket(data []byte) ([]byte, error) {
+	if len(data) < 1 {
+		return nil, ErrBadArg
+	}
+	var rp repacketizer
+	if err := rp.cat(data); err != nil {
+		return nil, err
+	}
+	return rp.out(0, len(rp.frames), len(data), false)
+}
+
+// IsDTXPacket reports whether data carries no audio: an empty packet (a
+// lost or skipped frame) or a packet of at most 2 bytes, which is what the
+// encoder emits during discontinuous transmission. Decoding such packets
+// produces silence or comfort noise.
+func IsDTXPacket(data []byte) bool {
+	return len(data) <= 2
+}
+
+// PacketHasFEC reports whether a packet carries in-band FEC (SILK LBRR) data,
+// like opus_packet_has_lbrr from libopus 1.5. Only when it does can
+// Decoder.DecodeFEC recover the packet that preceded it.
+func PacketHasFEC(data []byte) (bool, error) {
+	return packetHasLBRR(data)
+}
+
+// MergePackets concatenates the frames of consecutive packets into a single
+// multi-frame packet of at most maxLen bytes (0 means no limit). All packets
+// must share the same mode, bandwidth and frame duration, and together last
+// no longer than 120 ms; otherwise ErrInvalidPacket is returned.
+func MergePackets(packets [][]byte, maxLen int) ([]byte, error) {
+	if len(packets) == 0 {
+		return nil, ErrBadArg
+	}
+	var rp repacketizer
+	size := 0
+	for _, p := range packets {
+		if err := rp.cat(p); err != nil {
+			return nil, err
+		}
+		size += len(p)
+	}
+	if maxLen <= 0 {
+		// Code 3 framing needs at most two bytes per frame plus the TOC
+		// and frame count bytes.
+		maxLen = size + 2*len(rp.frames) + 2
+	}
+	return rp.out(0, len(rp.frames), maxLen, false)
+}
+
+// SplitPacket breaks a multi-frame packet into single-frame packets, one per
+// frame, for transports that limit the duration of a payload. Padding is
+// dropped.
+func SplitPacket(packet []byte) ([][]byte, error) {
+	var rp repacketizer
+	if err := rp.cat(packet); err != nil {
+		return nil, err
+	}
+	out := make([][]byte, len(rp.frames))
+	for i, f := range rp.frames {
+		p, err := rp.out(i, i+1, len(f)+1, false)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = p
+	}
+	return out, nil
+}
+
+// PadToCBR re-frames packet to exactly targetLen bytes, so that every packet
+// on the wire has the same size regardless of its content. Unlike PadPacket
+// it first drops any existing padding, so already padded packets can be
+// brought to a smaller target. It returns ErrBufferTooSmall if the audio
+// data doesn't fit in targetLen bytes.
+func PadToCBR(packet []byte, targetLen int) ([]byte, error) {
+	var rp repacketizer
+	if err := rp.cat(packet); err != nil {
+		return nil, err
+	}
+	return rp.out(0, len(rp.frames), targetLen, true)
+}
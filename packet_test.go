@@ -0,0 +1,497 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParsePacketFraming(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		frames  [][]byte
+		padding int
+	}{
+		{"code 0", []byte{0x00, 1, 2, 3}, [][]byte{{1, 2, 3}}, 0},
+		{"code 1", []byte{0x01, 1, 2, 3, 4}, [][]byte{{1, 2}, {3, 4}}, 0},
+		{"code 2", []byte{0x02, 1, 9, 8, 7}, [][]byte{{9}, {8, 7}}, 0},
+		{"code 3 cbr", []byte{0x03, 0x03, 1, 2, 3}, [][]byte{{1}, {2}, {3}}, 0},
+		{"code 3 vbr", []byte{0x03, 0x82, 2, 1, 2, 3}, [][]byte{{1, 2}, {3}}, 0},
+		{"code 3 padded", []byte{0x03, 0x41, 2, 5, 6, 0, 0}, [][]byte{{5, 6}}, 2},
+	}
+	for _, tt := range tests {
+		p, err := parsePacket(tt.data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if len(p.frames) != len(tt.frames) || p.padding != tt.padding {
+			t.Errorf("%s: got %d frames, %d padding bytes", tt.name, len(p.frames), p.padding)
+			continue
+		}
+		for i := range tt.frames {
+			if !bytes.Equal(p.frames[i], tt.frames[i]) {
+				t.Errorf("%s: frame %d = %v, want %v", tt.name, i, p.frames[i], tt.frames[i])
+			}
+		}
+	}
+
+	invalid := [][]byte{
+		{},
+		{0x01, 1, 2, 3},       // odd CBR payload
+		{0x02, 5, 1},          // VBR size beyond packet
+		{0x03},                // missing frame count
+		{0x03, 0x00},          // zero frames
+		{0x1b, 0x03, 1, 2, 3}, // 3 x 60 ms exceeds 120 ms
+		{0x03, 0x02, 1, 2, 3}, // CBR payload not divisible
+	}
+	for _, data := range invalid {
+		if _, err := parsePacket(data); err != ErrInvalidPacket {
+			t.Errorf("parsePacket(%v): expected ErrInvalidPacket, got %v", data, err)
+		}
+	}
+}
+
+func TestPacketBandwidth(t *testing.T) {
+	tests := []struct {
+		toc  byte
+		want int
+	}{
+		{0x00, bandwidthNarrowband},    // SILK NB
+		{0x28, bandwidthMediumband},    // SILK MB
+		{0x48, bandwidthWideband},      // SILK WB
+		{0x60, bandwidthSuperWideband}, // Hybrid SWB
+		{0x78, bandwidthFullband},      // Hybrid FB
+		{0x80, bandwidthNarrowband},    // CELT NB
+		{0xa0, bandwidthWideband},      // CELT WB
+		{0xc0, bandwidthSuperWideband}, // CELT SWB
+		{0xfc, bandwidthFullband},      // CELT FB, stereo, code 0
+	}
+	for _, tt := range tests {
+		got, err := PacketBandwidth([]byte{tt.toc, 0})
+		if err != nil {
+			t.Errorf("PacketBandwidth(%#x): unexpected error: %v", tt.toc, err)
+			continue
+		}
+		if got != Bandwidth(tt.want) {
+			t.Errorf("PacketBandwidth(%#x) = %d, want %d", tt.toc, got, tt.want)
+		}
+	}
+	if _, err := PacketBandwidth(nil); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg for empty packet, got %v", err)
+	}
+}
+
+func TestPacketFramesSamples(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		frames  int
+		samples int // at 48 kHz
+	}{
+		{[]byte{0x00, 1}, 1, 480},           // SILK NB 10 ms
+		{[]byte{0x51, 1, 2}, 2, 2 * 1920},   // SILK WB 40 ms, two CBR frames
+		{[]byte{0x18, 1}, 1, 2880},          // SILK NB 60 ms
+		{[]byte{0x83, 0x04, 0}, 4, 4 * 120}, // CELT 2.5 ms x 4
+		{[]byte{0xfb, 0x06, 0}, 6, 6 * 960}, // CELT 20 ms x 6
+	}
+	for _, tt := range tests {
+		frames, err := PacketFrames(tt.data)
+		if err != nil || frames != tt.frames {
+			t.Errorf("PacketFrames(%v) = %d, %v; want %d", tt.data, frames, err, tt.frames)
+		}
+		samples, err := PacketSamples(tt.data, 48000)
+		if err != nil || samples != tt.samples {
+			t.Errorf("PacketSamples(%v, 48000) = %d, %v; want %d", tt.data, samples, err, tt.samples)
+		}
+		samples, err = PacketSamples(tt.data, 16000)
+		if err != nil || samples != tt.samples/3 {
+			t.Errorf("PacketSamples(%v, 16000) = %d, %v; want %d", tt.data, samples, err, tt.samples/3)
+		}
+	}
+	if _, err := PacketFrames(nil); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg for empty packet, got %v", err)
+	}
+	if _, err := PacketSamples([]byte{0x1b, 3, 0}, 48000); err != ErrInvalidPacket {
+		t.Errorf("Expected ErrInvalidPacket for 180 ms packet, got %v", err)
+	}
+	if _, err := PacketSamples([]byte{0x00, 1}, 44100); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg for 44.1 kHz, got %v", err)
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	data := []byte{0xff, 0x82, 2, 1, 2, 3}
+	toc, frames, err := ParsePacket(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := TOC{
+		Config:         31,
+		Mode:           ModeCELT,
+		Bandwidth:      Fullband,
+		FrameDuration:  20 * time.Millisecond,
+		Stereo:         true,
+		FrameCountCode: 3,
+	}
+	if toc != want {
+		t.Errorf("Unexpected TOC %+v, want %+v", toc, want)
+	}
+	if len(frames) != 2 || !bytes.Equal(frames[0], []byte{1, 2}) || !bytes.Equal(frames[1], []byte{3}) {
+		t.Errorf("Unexpected frames %v", frames)
+	}
+	if _, _, err := ParsePacket([]byte{0x01, 1, 2, 3}); err != ErrInvalidPacket {
+		t.Errorf("Expected ErrInvalidPacket, got %v", err)
+	}
+}
+
+func TestPadUnpadPacket(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	packet := data[:n]
+
+	for _, newLen := range []int{n, n + 1, n + 300, 1275} {
+		padded, err := PadPacket(packet, newLen)
+		if err != nil {
+			t.Fatalf("PadPacket(%d): unexpected error: %v", newLen, err)
+		}
+		if len(padded) != newLen {
+			t.Errorf("PadPacket(%d) returned %d bytes", newLen, len(padded))
+		}
+		unpadded, err := UnpadPacket(padded)
+		if err != nil {
+			t.Fatalf("UnpadPacket: unexpected error: %v", err)
+		}
+		if !bytes.Equal(unpadded, packet) {
+			t.Errorf("UnpadPacket(PadPacket(p, %d)) != p", newLen)
+		}
+
+		var outputs [2][]int16
+		for i, p := range [][]byte{packet, padded} {
+			dec, err := NewDecoder(SAMPLE_RATE, 1)
+			if err != nil || dec == nil {
+				t.Fatalf("Error creating new decoder: %v", err)
+			}
+			outputs[i] = make([]int16, FRAME_SIZE)
+			if _, err := dec.Decode(p, outputs[i]); err != nil {
+				t.Fatalf("Couldn't decode data: %v", err)
+			}
+		}
+		for i := range outputs[0] {
+			if outputs[0][i] != outputs[1][i] {
+				t.Fatalf("Padded packet decodes differently at sample %d", i)
+			}
+		}
+	}
+	if _, err := PadPacket(packet, n-1); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg shrinking a packet, got %v", err)
+	}
+	if _, err := UnpadPacket(nil); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg for empty packet, got %v", err)
+	}
+}
+
+func TestParseTOC(t *testing.T) {
+	tests := []struct {
+		toc      byte
+		mode     Mode
+		bw       int
+		duration time.Duration
+		stereo   bool
+	}{
+		{0x00, ModeSILK, bandwidthNarrowband, 10 * time.Millisecond, false},
+		{0x1c, ModeSILK, bandwidthNarrowband, 60 * time.Millisecond, true},
+		{0x48, ModeSILK, bandwidthWideband, 20 * time.Millisecond, false},
+		{0x60, ModeHybrid, bandwidthSuperWideband, 10 * time.Millisecond, false},
+		{0x7c, ModeHybrid, bandwidthFullband, 20 * time.Millisecond, true},
+		{0x80, ModeCELT, bandwidthNarrowband, 2500 * time.Microsecond, false},
+		{0xb0, ModeCELT, bandwidthWideband, 10 * time.Millisecond, false},
+		{0xf8, ModeCELT, bandwidthFullband, 20 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		got := ParseTOC(tt.toc)
+		if got.Mode != tt.mode || got.Bandwidth != Bandwidth(tt.bw) ||
+			got.FrameDuration != tt.duration || got.Stereo != tt.stereo {
+			t.Errorf("ParseTOC(%#x) = %+v, want mode %v, bandwidth %d, duration %v, stereo %v",
+				tt.toc, got, tt.mode, tt.bw, tt.duration, tt.stereo)
+		}
+		if got.Config != int(tt.toc>>3) {
+			t.Errorf("ParseTOC(%#x).Config = %d", tt.toc, got.Config)
+		}
+	}
+	if ModeHybrid.String() != "Hybrid" {
+		t.Errorf("Unexpected mode name %q", ModeHybrid.String())
+	}
+}
+
+func TestIsDTXPacket(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetDTX(true); err != nil {
+		t.Fatalf("Error enabling DTX: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	silence := make([]int16, FRAME_SIZE)
+	data := make([]byte, 1000)
+
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if IsDTXPacket(data[:n]) {
+		t.Errorf("Speech packet of %d bytes reported as DTX", n)
+	}
+	sawDTX := false
+	for i := 0; i < 20; i++ {
+		n, err := enc.Encode(silence, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		sawDTX = sawDTX || IsDTXPacket(data[:n])
+	}
+	if !sawDTX {
+		t.Errorf("No DTX packet detected while encoding silence")
+	}
+	if !IsDTXPacket(nil) {
+		t.Errorf("Expected empty packet to count as DTX")
+	}
+}
+
+func TestPacketHasFEC(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	for _, fec := range []bool{false, true} {
+		enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+		if err != nil || enc == nil {
+			t.Fatalf("Error creating new encoder: %v", err)
+		}
+		if err := enc.SetInBandFEC(fec); err != nil {
+			t.Fatalf("Error setting FEC: %v", err)
+		}
+		if err := enc.SetPacketLossPerc(30); err != nil {
+			t.Fatalf("Error setting packet loss: %v", err)
+		}
+		if err := enc.SetBitrate(24000); err != nil {
+			t.Fatalf("Error setting bitrate: %v", err)
+		}
+		var got bool
+		for i := 0; i < 5; i++ {
+			n, err := enc.Encode(pcm, data)
+			if err != nil {
+				t.Fatalf("Couldn't encode data: %v", err)
+			}
+			if got, err = PacketHasFEC(data[:n]); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		if got != fec {
+			t.Errorf("PacketHasFEC = %v for encoder with FEC %v", got, fec)
+		}
+	}
+	// CELT-only packets never carry LBRR data.
+	if got, err := PacketHasFEC([]byte{0xf8, 0xff}); err != nil || got {
+		t.Errorf("PacketHasFEC(CELT) = %v, %v", got, err)
+	}
+	if _, err := PacketHasFEC(nil); err != ErrInvalidPacket {
+		t.Errorf("Expected ErrInvalidPacket for empty packet, got %v", err)
+	}
+}
+
+func TestMergePackets(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetBandwidth(Fullband); err != nil {
+		t.Fatalf("Error setting bandwidth: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	var packets [][]byte
+	for i := 0; i < 6; i++ {
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		packets = append(packets, data[:n])
+	}
+
+	merged, err := MergePackets(packets[:3], 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	samples, err := PacketSamples(merged, SAMPLE_RATE)
+	if err != nil || samples != 3*FRAME_SIZE {
+		t.Errorf("Merged packet has %d samples (err=%v), want %d", samples, err, 3*FRAME_SIZE)
+	}
+	_, frames, err := ParsePacket(merged)
+	if err != nil {
+		t.Fatalf("Can't parse merged packet: %v", err)
+	}
+	for i, f := range frames {
+		if !bytes.Equal(f, packets[i][1:]) {
+			t.Errorf("Frame %d differs from the original packet payload", i)
+		}
+	}
+
+	if _, err := MergePackets(packets, 0); err != nil {
+		t.Errorf("Expected 120 ms of packets to merge, got %v", err)
+	}
+	if _, err := MergePackets(append(packets, packets[0]), 0); err != ErrInvalidPacket {
+		t.Errorf("Expected ErrInvalidPacket beyond 120 ms, got %v", err)
+	}
+	if _, err := MergePackets(packets[:3], 10); err != ErrBufferTooSmall {
+		t.Errorf("Expected ErrBufferTooSmall, got %v", err)
+	}
+	if _, err := MergePackets(nil, 0); err != ErrBadArg {
+		t.Errorf("Expected ErrBadArg for no packets, got %v", err)
+	}
+}
+
+func TestSplitPacket(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	// A 60 ms CELT packet holds three 20 ms frames.
+	if err := enc.SetBandwidth(Fullband); err != nil {
+		t.Fatalf("Error setting bandwidth: %v", err)
+	}
+	pcm := make([]int16, 3*FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 4000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	packet := data[:n]
+	count, err := PacketFrames(packet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	parts, err := SplitPacket(packet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count < 2 || len(parts) != count {
+		t.Fatalf("Got %d packets from a %d frame packet", len(parts), count)
+	}
+
+	// Decoding the parts must give the same audio as the whole packet.
+	whole, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || whole == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	want := make([]int16, 3*FRAME_SIZE)
+	if _, err := whole.Decode(packet, want); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	var got []int16
+	for _, p := range parts {
+		if f, _ := PacketFrames(p); f != 1 {
+			t.Errorf("Split packet has %d frames", f)
+		}
+		out := make([]int16, 3*FRAME_SIZE)
+		n, err := dec.Decode(p, out)
+		if err != nil {
+			t.Fatalf("Couldn't decode split packet: %v", err)
+		}
+		got = append(got, out[:n]...)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Split packets decode to %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Split packets decode differently at sample %d", i)
+		}
+	}
+
+	merged, err := MergePackets(parts, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if unpadded, _ := UnpadPacket(packet); !bytes.Equal(merged, unpadded) {
+		t.Errorf("Merging split packets doesn't restore the original")
+	}
+	if _, err := SplitPacket(nil); err != ErrInvalidPacket {
+		t.Errorf("Expected ErrInvalidPacket for empty packet, got %v", err)
+	}
+}
+
+func TestPadToCBR(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const TARGET = 200
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetBitrate(32000); err != nil {
+		t.Fatalf("Error setting bitrate: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	silence := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	for _, in := range [][]int16{pcm, silence, pcm} {
+		n, err := enc.Encode(in, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		cbr, err := PadToCBR(data[:n], TARGET)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(cbr) != TARGET {
+			t.Errorf("Got %d byte packet, want %d", len(cbr), TARGET)
+		}
+		// Re-targeting a padded packet works from its audio data alone.
+		smaller, err := PadToCBR(cbr, n+10)
+		if err != nil {
+			t.Fatalf("Unexpected error re-padding: %v", err)
+		}
+		if len(smaller) != n+10 {
+			t.Errorf("Got %d byte packet, want %d", len(smaller), n+10)
+		}
+		unpadded, err := UnpadPacket(smaller)
+		if err != nil || !bytes.Equal(unpadded, data[:n]) {
+			t.Errorf("Padding changed the packet contents (err=%v)", err)
+		}
+		if _, err := PadToCBR(data[:n], n-1); err != ErrBufferTooSmall {
+			t.Errorf("Expected ErrBufferTooSmall, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+)
+
+// PacketChan adapts a channel of packets to the PacketReader interface.
+// ReadPacket blocks for the next packet and returns io.EOF once the channel
+// is closed and drained.
+type PacketChan <-chan []byte
+
+// ReadPacket receives the next packet from c.
+func (c PacketChan) ReadPacket() ([]byte, error) {
+	packet, ok := <-c
+	if !ok {
+		return nil, io.EOF
+	}
+	return packet, nil
+}
+
+// PCMReader decodes packets from a PacketReader and exposes the audio as an
+// io.Reader of interleaved signed 16-bit little-endian PCM ("s16le"), so it
+// can be piped into io-based sinks such as the stdin of aplay or ffmpeg.
+// An empty packet marks a lost one and is concealed with PLC.
+type PCMReader struct {
+	dec     *Decoder
+	src     PacketReader
+	pcm     []int16
+	buf     []byte
+	pending []byte // decoded bytes not read yet
+	err     error
+}
+
+// NewPCMReader returns a reader of the audio that dec decodes from the
+// packets of src. Read returns io.EOF after src does.
+func NewPCMReader(dec *Decoder, src PacketReader) *PCMReader {
+	return &PCMReader{
+		dec: dec,
+		src: src,
+		pcm: make([]int16, maxFrameSize48k*dec.channels),
+	}
+}
+
+// Read reads decoded PCM into p. Samples are never split between calls
+// unless p is shorter than a sample.
+func (r *PCMReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.decodeNext()
+	}
+	if len(p) >= 2 {
+		p = p[:len(p)&^1] // whole samples only
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// decodeNext decodes the next packet into pending.
+func (r *PCMReader) decodeNext() error {
+	packet, err := r.src.ReadPacket()
+	if err != nil {
+		return err
+	}
+	n, _, err := r.dec.decodeWithLoss(context.Background(), packet, nil, r.pcm)
+	if err != nil {
+		return err
+	}
+	r.buf = r.buf[:0]
+	for _, v := range r.pcm[:n*r.dec.channels] {
+		r.buf = binary.LittleEndian.AppendUint16(r.buf, uint16(v))
+	}
+	r.pending = r.buf
+	return nil
+}
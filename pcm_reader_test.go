@@ -0,0 +1,96 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPCMReader(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 2)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	mono := make([]int16, FRAME_SIZE)
+	addSine(mono, SAMPLE_RATE, 440)
+	pcm := interleave(mono, mono)
+
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		for i := 0; i < 10; i++ {
+			if i == 4 {
+				ch <- nil // lost
+				continue
+			}
+			data := make([]byte, 1000)
+			n, err := enc.Encode(pcm, data)
+			if err != nil {
+				t.Errorf("Couldn't encode data: %v", err)
+				return
+			}
+			ch <- data[:n]
+		}
+	}()
+	out, err := io.ReadAll(NewPCMReader(dec, PacketChan(ch)))
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if want := 10 * FRAME_SIZE * 2 * 2; len(out) != want {
+		t.Fatalf("Read %d bytes, want %d", len(out), want)
+	}
+	var energy float64
+	for i := 0; i < len(out); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(out[i:])))
+		energy += v * v
+	}
+	if energy == 0 {
+		t.Errorf("Decoded only silence")
+	}
+
+	// Errors from the source are passed on; tiny reads still work.
+	dec2, err := NewDecoder(SAMPLE_RATE, 2)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	errSource := errors.New("source failed")
+	sent := false
+	r := NewPCMReader(dec2, PacketReaderFunc(func() ([]byte, error) {
+		if sent {
+			return nil, errSource
+		}
+		sent = true
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		return data[:n], err
+	}))
+	b := make([]byte, 3)
+	total := 0
+	for {
+		n, err := r.Read(b)
+		if n%2 != 0 {
+			t.Fatalf("Read %d bytes into a 3-byte buffer, splitting a sample", n)
+		}
+		total += n
+		if err != nil {
+			if err != errSource {
+				t.Errorf("Expected the source error, got %v", err)
+			}
+			break
+		}
+	}
+	if total != FRAME_SIZE*2*2 {
+		t.Errorf("Read %d bytes, want %d", total, FRAME_SIZE*2*2)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Pipeline encodes or decodes streams of frames arriving on channels, each
+// with its own Encoder or Decoder, and delivers the results of each stream
+// in input order.
+//
+// Opus carries prediction state from one frame to the next, so the frames of
+// a stream are always coded in order by the same codec. Parallelism comes
+// from coding several streams at once: stream s is pinned to worker
+// s % Workers, which codes the frames of its streams one at a time.
+type Pipeline struct {
+	// Workers is the number of parallel workers for EncodeStreams and
+	// DecodeStreams; 0 means 1. Encode and Decode code a single stream and
+	// need 1.
+	Workers int
+	// NewEncoder creates the encoder of each stream for Encode.
+	NewEncoder func() (*Encoder, error)
+	// NewDecoder creates the decoder of each stream for Decode.
+	NewDecoder func() (*Decoder, error)
+}
+
+// Encode encodes each PCM frame received from in, which must be a valid
+// frame size for the encoder, and sends the packets to out in the same
+// order. It returns once in is closed and every packet has been sent, or
+// after the first error, and closes out either way. After an error the rest
+// of in is drained in the background so producers don't block.
+func (p *Pipeline) Encode(in <-chan []int16, out chan<- []byte) error {
+	return p.EncodeContext(context.Background(), in, out)
+}
+
+// EncodeContext is Encode with a context. Cancelling ctx stops the pipeline
+// promptly, interrupting the frames being encoded, and EncodeContext returns
+// ctx's error.
+func (p *Pipeline) EncodeContext(ctx context.Context, in <-chan []int16, out chan<- []byte) error {
+	if err := p.checkSingleStream(); err != nil {
+		close(out)
+		go drain(in)
+		return err
+	}
+	return p.EncodeStreamsContext(ctx, []<-chan []int16{in}, []chan<- []byte{out})
+}
+
+// EncodeStreams encodes the streams ins[s] into outs[s] in parallel, each
+// with its own encoder, see Encode. The first error stops every stream.
+func (p *Pipeline) EncodeStreams(ins []<-chan []int16, outs []chan<- []byte) error {
+	return p.EncodeStreamsContext(context.Background(), ins, outs)
+}
+
+// EncodeStreamsContext is EncodeStreams with a context, see EncodeContext.
+func (p *Pipeline) EncodeStreamsContext(ctx context.Context, ins []<-chan []int16, outs []chan<- []byte) error {
+	if p.NewEncoder == nil {
+		stopStreams(ins, outs)
+		return fmt.Errorf("opus: Pipeline.NewEncoder is not set")
+	}
+	return runStreams(ctx, ins, outs, p.workers(), func() (func(context.Context, []int16) ([]byte, error), error) {
+		enc, err := p.NewEncoder()
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, DefaultMaxPacketSize)
+		return func(ctx context.Context, pcm []int16) ([]byte, error) {
+			n, err := enc.EncodeContext(ctx, pcm, data)
+			if err != nil {
+				return nil, err
+			}
+			return append([]byte(nil), data[:n]...), nil
+		}, nil
+	})
+}
+
+// Decode decodes each packet received from in and sends the interleaved
+// PCM to out in the same order, see Encode. An empty packet marks a lost
+// one and is concealed with PLC.
+func (p *Pipeline) Decode(in <-chan []byte, out chan<- []int16) error {
+	return p.DecodeContext(context.Background(), in, out)
+}
+
+// DecodeContext is Decode with a context, see EncodeContext.
+func (p *Pipeline) DecodeContext(ctx context.Context, in <-chan []byte, out chan<- []int16) error {
+	if err := p.checkSingleStream(); err != nil {
+		close(out)
+		go drain(in)
+		return err
+	}
+	return p.DecodeStreamsContext(ctx, []<-chan []byte{in}, []chan<- []int16{out})
+}
+
+// DecodeStreams decodes the streams ins[s] into outs[s] in parallel, each
+// with its own decoder, see Decode and EncodeStreams.
+func (p *Pipeline) DecodeStreams(ins []<-chan []byte, outs []chan<- []int16) error {
+	return p.DecodeStreamsContext(context.Background(), ins, outs)
+}
+
+// DecodeStreamsContext is DecodeStreams with a context, see EncodeContext.
+func (p *Pipeline) DecodeStreamsContext(ctx context.Context, ins []<-chan []byte, outs []chan<- []int16) error {
+	if p.NewDecoder == nil {
+		stopStreams(ins, outs)
+		return fmt.Errorf("opus: Pipeline.NewDecoder is not set")
+	}
+	return runStreams(ctx, ins, outs, p.workers(), func() (func(context.Context, []byte) ([]int16, error), error) {
+		dec, err := p.NewDecoder()
+		if err != nil {
+			return nil, err
+		}
+		pcm := make([]int16, maxFrameSize48k*dec.channels)
+		return func(ctx context.Context, packet []byte) ([]int16, error) {
+			n, _, err := dec.decodeWithLoss(ctx, packet, nil, pcm)
+			if err != nil {
+				return nil, err
+			}
+			return append([]int16(nil), pcm[:n*dec.channels]...), nil
+		}, nil
+	})
+}
+
+func (p *Pipeline) workers() int {
+	return max(p.Workers, 1)
+}
+
+// checkSingleStream rejects more than one worker for a single stream, which
+// could only be split by coding frames without their predecessors' state.
+func (p *Pipeline) checkSingleStream() error {
+	if p.workers() > 1 {
+		return fmt.Errorf("opus: a single stream can't be split across %d workers; use EncodeStreams or DecodeStreams", p.Workers)
+	}
+	return nil
+}
+
+// runStreams codes the values of each ins[s] in order with a coder made by
+// newCoder for the stream, holding the lock of worker s % workers for each
+// value, and sends the results to outs[s].
+func runStreams[In, Out any](ctx context.Context, ins []<-chan In, outs []chan<- Out, workers int, newCoder func() (func(context.Context, In) (Out, error), error)) error {
+	if len(ins) != len(outs) {
+		stopStreams(ins, outs)
+		return fmt.Errorf("opus: %d input streams for %d output streams", len(ins), len(outs))
+	}
+	coders := make([]func(context.Context, In) (Out, error), len(ins))
+	for s := range coders {
+		f, err := newCoder()
+		if err != nil {
+			stopStreams(ins, outs)
+			return err
+		}
+		coders[s] = f
+	}
+
+	// Cancelling ctx stops every stream and interrupts the coders.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	slots := make([]sync.Mutex, workers)
+	for s := range ins {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			defer close(outs[s])
+			// Keep producers from blocking after an error; once in is
+			// closed this returns at once.
+			defer func() { go drain(ins[s]) }()
+			slot := &slots[s%workers]
+			for seq := 0; ; seq++ {
+				var v In
+				select {
+				case in, ok := <-ins[s]:
+					if !ok {
+						return
+					}
+					v = in
+				case <-ctx.Done():
+					return
+				}
+				slot.Lock()
+				r, err := coders[s](ctx, v)
+				slot.Unlock()
+				if err != nil {
+					if ctx.Err() == nil {
+						fail(fmt.Errorf("opus: pipeline stream %d frame %d: %w", s, seq, err))
+					}
+					return
+				}
+				select {
+				case outs[s] <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(s)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// stopStreams closes outs and drains ins in the background, for a pipeline
+// that fails before it starts.
+func stopStreams[In, Out any](ins []<-chan In, outs []chan<- Out) {
+	for _, out := range outs {
+		close(out)
+	}
+	for _, in := range ins {
+		go drain(in)
+	}
+}
+
+// drain discards the rest of ch until it is closed.
+func drain[T any](ch <-chan T) {
+	for range ch {
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const frames = 30
+	const streams = 3
+	newEncoder := func() (*Encoder, error) { return NewEncoder(SAMPLE_RATE, 1, AppAudio) }
+	input := make([][][]int16, streams)
+	for s := range input {
+		input[s] = make([][]int16, frames)
+		for i := range input[s] {
+			input[s][i] = make([]int16, FRAME_SIZE)
+			addSine(input[s][i], SAMPLE_RATE, float64(200+100*s+20*i))
+		}
+	}
+
+	// Each stream keeps its own encoder, so the expected packets come from
+	// one encoder per stream fed in order.
+	want := make([][][]byte, streams)
+	for s := range want {
+		enc, err := newEncoder()
+		if err != nil {
+			t.Fatalf("Error creating new encoder: %v", err)
+		}
+		for _, pcm := range input[s] {
+			data := make([]byte, 1000)
+			n, err := enc.Encode(pcm, data)
+			if err != nil {
+				t.Fatalf("Couldn't encode data: %v", err)
+			}
+			want[s] = append(want[s], data[:n])
+		}
+	}
+
+	p := Pipeline{Workers: 2, NewEncoder: newEncoder, NewDecoder: func() (*Decoder, error) {
+		return NewDecoder(SAMPLE_RATE, 1)
+	}}
+	ins := make([]<-chan []int16, streams)
+	outs := make([]chan<- []byte, streams)
+	results := make([]chan []byte, streams)
+	for s := range ins {
+		in := make(chan []int16)
+		go func(s int) {
+			for _, pcm := range input[s] {
+				in <- pcm
+			}
+			close(in)
+		}(s)
+		ins[s] = in
+		results[s] = make(chan []byte, frames)
+		outs[s] = results[s]
+	}
+	if err := p.EncodeStreams(ins, outs); err != nil {
+		t.Fatalf("EncodeStreams: %v", err)
+	}
+	packets := make([][][]byte, streams)
+	for s := range results {
+		for packet := range results[s] {
+			packets[s] = append(packets[s], packet)
+		}
+		if len(packets[s]) != frames {
+			t.Fatalf("Stream %d: got %d packets, want %d", s, len(packets[s]), frames)
+		}
+		for i := range packets[s] {
+			if !bytes.Equal(packets[s][i], want[s][i]) {
+				t.Errorf("Stream %d: packet %d is out of order or differs", s, i)
+			}
+		}
+	}
+
+	// Decode them back, with one lost packet.
+	packets[1][5] = nil
+	pins := make([]<-chan []byte, streams)
+	pouts := make([]chan<- []int16, streams)
+	pcms := make([]chan []int16, streams)
+	for s := range pins {
+		pin := make(chan []byte, frames)
+		for _, packet := range packets[s] {
+			pin <- packet
+		}
+		close(pin)
+		pins[s] = pin
+		pcms[s] = make(chan []int16, frames)
+		pouts[s] = pcms[s]
+	}
+	if err := p.DecodeStreams(pins, pouts); err != nil {
+		t.Fatalf("DecodeStreams: %v", err)
+	}
+	for s := range pcms {
+		n := 0
+		for pcm := range pcms[s] {
+			if len(pcm) != FRAME_SIZE {
+				t.Errorf("Stream %d: frame %d has %d samples, want %d", s, n, len(pcm), FRAME_SIZE)
+			}
+			n++
+		}
+		if n != frames {
+			t.Errorf("Stream %d: got %d frames, want %d", s, n, frames)
+		}
+	}
+
+	// A single stream is coded in order by one encoder.
+	single := Pipeline{NewEncoder: newEncoder}
+	in := make(chan []int16)
+	out := make(chan []byte, frames)
+	go func() {
+		for _, pcm := range input[0] {
+			in <- pcm
+		}
+		close(in)
+	}()
+	if err := single.Encode(in, out); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	i := 0
+	for packet := range out {
+		if !bytes.Equal(packet, want[0][i]) {
+			t.Errorf("Packet %d is out of order or differs", i)
+		}
+		i++
+	}
+	// It can't be split across workers.
+	if err := p.Encode(make(chan []int16), make(chan []byte)); err == nil {
+		t.Errorf("Expected error for a single stream with %d workers", p.Workers)
+	}
+
+	// An invalid frame stops the pipeline; the producer doesn't block.
+	in = make(chan []int16)
+	out = make(chan []byte, frames)
+	go func() {
+		for i := 0; i < frames; i++ {
+			pcm := input[0][i]
+			if i == 2 {
+				pcm = pcm[:100]
+			}
+			in <- pcm
+		}
+		close(in)
+	}()
+	if err := single.Encode(in, out); err == nil {
+		t.Errorf("Expected error for an invalid frame size")
+	}
+	if got := len(out); got > 2 {
+		t.Errorf("Got %d packets before the failing frame, want at most 2", got)
+	}
+	if err := (&Pipeline{}).Encode(make(chan []int16), make(chan []byte)); err == nil {
+		t.Errorf("Expected error without NewEncoder")
+	}
+}
+
+func TestPipelineContext(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	p := Pipeline{NewEncoder: func() (*Encoder, error) { return NewEncoder(SAMPLE_RATE, 1, AppAudio) }}
+
+	// The producer never stops; cancelling ctx ends the pipeline.
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan []int16)
+	out := make(chan []byte)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case in <- pcm:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	errc := make(chan error, 1)
+	go func() { errc <- p.EncodeContext(ctx, in, out) }()
+	for i := 0; i < 5; i++ {
+		<-out
+	}
+	cancel()
+	for range out {
+	}
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// A cancelled context doesn't start a call, and leaves the encoder usable.
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	data := make([]byte, 1000)
+	if _, err := enc.EncodeContext(ctx, pcm, data); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := enc.Encode(pcm, data); err != nil {
+		t.Errorf("Couldn't encode data after a cancelled call: %v", err)
+	}
+}
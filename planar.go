@@ -0,0 +1,89 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import "fmt"
+
+// EncodePlanar is Encode for planar PCM, one slice per channel of the same
+// length, as kept by audio engines that store channels separately. The
+// channels are interleaved into a copy on every call.
+func (enc *Encoder) EncodePlanar(pcm [][]int16, data []byte) (int, error) {
+	return encodePlanar(enc, pcm, data)
+}
+
+// EncodePlanarFloat32 is EncodePlanar for float32 PCM.
+func (enc *Encoder) EncodePlanarFloat32(pcm [][]float32, data []byte) (int, error) {
+	return encodePlanar(enc, pcm, data)
+}
+
+// DecodePlanar is Decode into planar PCM, one slice per channel of the same
+// length. It returns the number of samples decoded per channel, as Decode.
+func (dec *Decoder) DecodePlanar(data []byte, pcm [][]int16) (int, error) {
+	return decodePlanar(dec, data, pcm)
+}
+
+// DecodePlanarFloat32 is DecodePlanar for float32 PCM.
+func (dec *Decoder) DecodePlanarFloat32(data []byte, pcm [][]float32) (int, error) {
+	return decodePlanar(dec, data, pcm)
+}
+
+func encodePlanar[T Sample](enc *Encoder, pcm [][]T, data []byte) (int, error) {
+	interleaved, err := interleavePlanes(pcm, enc.channelCount())
+	if err != nil {
+		return 0, err
+	}
+	return Encode(enc, interleaved, data)
+}
+
+func decodePlanar[T Sample](dec *Decoder, data []byte, pcm [][]T) (int, error) {
+	buf, err := interleavedBuffer(pcm, dec.Channels())
+	if err != nil {
+		return 0, err
+	}
+	n, err := Decode(dec, data, buf)
+	if err != nil {
+		return 0, err
+	}
+	deinterleaveSamples(buf[:n*len(pcm)], pcm)
+	return n, nil
+}
+
+// channelCount returns the number of interleaved input channels.
+func (enc *Encoder) channelCount() int {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	return enc.channels
+}
+
+// checkPlanes checks that planes has channels slices of the same length.
+func checkPlanes[T Sample](planes [][]T, channels int) error {
+	if channels == 0 || len(planes) != channels {
+		return fmt.Errorf("opus: got %d channel planes, want %d", len(planes), channels)
+	}
+	for c, p := range planes {
+		if len(p) != len(planes[0]) {
+			return fmt.Errorf("opus: channel plane %d has %d samples, plane 0 has %d", c, len(p), len(planes[0]))
+		}
+	}
+	return nil
+}
+
+// interleavePlanes returns the samples of planes interleaved.
+func interleavePlanes[T Sample](planes [][]T, channels int) ([]T, error) {
+	if err := checkPlanes(planes, channels); err != nil {
+		return nil, err
+	}
+	out := make([]T, len(planes[0])*channels)
+	interleaveSamples(out, planes)
+	return out, nil
+}
+
+// interleavedBuffer returns a buffer for the samples of planes interleaved.
+func interleavedBuffer[T Sample](planes [][]T, channels int) ([]T, error) {
+	if err := checkPlanes(planes, channels); err != nil {
+		return nil, err
+	}
+	return make([]T, len(planes[0])*channels), nil
+}
@@ -0,0 +1,78 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPlanar(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	left, right := make([]int16, FRAME_SIZE), make([]int16, FRAME_SIZE)
+	addSine(left, SAMPLE_RATE, 440)
+	addSine(right, SAMPLE_RATE, 660)
+	pcm := interleave(left, right)
+
+	var packets [2][]byte
+	for i := range packets {
+		enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+		if err != nil {
+			t.Fatalf("Error creating new encoder: %v", err)
+		}
+		data := make([]byte, 1000)
+		var n int
+		if i == 0 {
+			n, err = enc.EncodePlanar([][]int16{left, right}, data)
+		} else {
+			n, err = enc.Encode(pcm, data)
+		}
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		packets[i] = data[:n]
+	}
+	if !bytes.Equal(packets[0], packets[1]) {
+		t.Errorf("EncodePlanar and Encode produced different packets")
+	}
+
+	dec, err := NewDecoder(SAMPLE_RATE, 2)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	ref, err := NewDecoder(SAMPLE_RATE, 2)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	planes := [][]int16{make([]int16, FRAME_SIZE), make([]int16, FRAME_SIZE)}
+	n, err := dec.DecodePlanar(packets[0], planes)
+	if err != nil || n != FRAME_SIZE {
+		t.Fatalf("Decoded %d samples (err=%v), want %d", n, err, FRAME_SIZE)
+	}
+	if _, err := ref.Decode(packets[0], pcm); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	for i := 0; i < FRAME_SIZE; i++ {
+		if planes[0][i] != pcm[2*i] || planes[1][i] != pcm[2*i+1] {
+			t.Fatalf("Sample %d: planar (%d, %d), interleaved (%d, %d)", i, planes[0][i], planes[1][i], pcm[2*i], pcm[2*i+1])
+		}
+	}
+
+	fplanes := [][]float32{make([]float32, FRAME_SIZE), make([]float32, FRAME_SIZE)}
+	if n, err := dec.DecodePlanarFloat32(packets[1], fplanes); err != nil || n != FRAME_SIZE {
+		t.Errorf("Decoded %d float samples (err=%v), want %d", n, err, FRAME_SIZE)
+	}
+	if _, err := dec.DecodePlanar(packets[1], planes[:1]); err == nil {
+		t.Errorf("Expected an error for a missing channel plane")
+	}
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if _, err := enc.EncodePlanarFloat32([][]float32{fplanes[0], fplanes[1][1:]}, make([]byte, 1000)); err == nil {
+		t.Errorf("Expected an error for planes of different lengths")
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// ErrPoolClosed is returned for jobs given to a Pool after Close.
+var ErrPoolClosed = errors.New("opus: Pool is closed")
+
+// EncodeJob is a clip encoded by Pool.Encode.
+type EncodeJob struct {
+	SampleRate, Channels int
+	// Application defaults to AppAudio.
+	Application Application
+	// Config is applied to the encoder before the clip is encoded.
+	Config EncoderConfigDelta
+	// FrameSize is the number of samples per channel in each packet; 0
+	// means 20 ms.
+	FrameSize int
+	// PCM is the interleaved clip. A final partial frame is padded with
+	// silence.
+	PCM []int16
+}
+
+// DecodeJob is a stream decoded by Pool.Decode.
+type DecodeJob struct {
+	SampleRate, Channels int
+	// Packets are decoded in order. An empty packet marks a lost one,
+	// recovered from the FEC of the next packet if it has any and
+	// concealed otherwise.
+	Packets [][]byte
+}
+
+// Pool spreads independent encode and decode jobs, such as the files of a
+// transcoding farm, over a fixed number of workers. Each worker keeps its
+// Encoder and Decoder, and thus their wasm instances, from one job to the
+// next, resetting their state in between; a job with another format or
+// configuration than the worker's last one gets a new codec.
+//
+// Like a Pipeline, a Pool runs each stream on a single worker, so it is coded
+// with its full history; unlike one, it takes whole jobs rather than frames
+// from channels.
+type Pool struct {
+	jobs chan func(*poolWorker)
+	wg   sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewPool starts a Pool of size workers; 0 means one per CPU.
+func NewPool(size int) *Pool {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	p := &Pool{jobs: make(chan func(*poolWorker))}
+	p.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go p.run()
+	}
+	return p
+}
+
+func (p *Pool) run() {
+	defer p.wg.Done()
+	var w poolWorker
+	for job := range p.jobs {
+		job(&w)
+	}
+}
+
+// Encode waits for a free worker and encodes job.PCM on it, returning one
+// packet per frame. Cancelling ctx abandons the wait or interrupts the
+// encoding.
+func (p *Pool) Encode(ctx context.Context, job EncodeJob) ([][]byte, error) {
+	var packets [][]byte
+	err := p.do(ctx, func(w *poolWorker) error {
+		var err error
+		packets, err = w.encode(ctx, &job)
+		return err
+	})
+	return packets, err
+}
+
+// Decode waits for a free worker and decodes job.Packets on it, returning
+// the interleaved PCM. Cancelling ctx abandons the wait or interrupts the
+// decoding.
+func (p *Pool) Decode(ctx context.Context, job DecodeJob) ([]int16, error) {
+	var pcm []int16
+	err := p.do(ctx, func(w *poolWorker) error {
+		var err error
+		pcm, err = w.decode(ctx, &job)
+		return err
+	})
+	return pcm, err
+}
+
+// do runs f on the next free worker and waits for it.
+func (p *Pool) do(ctx context.Context, f func(*poolWorker) error) error {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return ErrPoolClosed
+	}
+	done := make(chan error, 1)
+	select {
+	case p.jobs <- func(w *poolWorker) { done <- f(w) }:
+		p.mu.RUnlock()
+	case <-ctx.Done():
+		p.mu.RUnlock()
+		return ctx.Err()
+	}
+	return <-done
+}
+
+// Close waits for the running jobs to finish and stops the workers. Jobs
+// given to the Pool afterwards fail with ErrPoolClosed.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// poolEncoderKey identifies the setup of a worker's Encoder.
+type poolEncoderKey struct {
+	sampleRate, channels int
+	application          Application
+	config               EncoderConfigDelta
+}
+
+// poolWorker holds the codecs of a Pool worker.
+type poolWorker struct {
+	enc    *Encoder
+	encKey poolEncoderKey
+	dec    *Decoder
+	decKey [2]int
+}
+
+// encoder returns an encoder for job, reusing the last one if it was set up
+// the same way.
+func (w *poolWorker) encoder(job *EncodeJob) (*Encoder, error) {
+	key := poolEncoderKey{job.SampleRate, job.Channels, job.Application, job.Config}
+	if key.application == 0 {
+		key.application = AppAudio
+	}
+	// Reset keeps the settings, so the config must match exactly.
+	if w.enc != nil && reflect.DeepEqual(key, w.encKey) {
+		if err := w.enc.Reset(); err == nil {
+			return w.enc, nil
+		}
+	}
+	w.enc = nil
+	enc, err := NewEncoder(job.SampleRate, job.Channels, key.application)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Apply(job.Config); err != nil {
+		return nil, err
+	}
+	w.enc, w.encKey = enc, key
+	return enc, nil
+}
+
+func (w *poolWorker) encode(ctx context.Context, job *EncodeJob) ([][]byte, error) {
+	enc, err := w.encoder(job)
+	if err != nil {
+		return nil, err
+	}
+	frameSize := job.FrameSize
+	if frameSize == 0 {
+		frameSize = job.SampleRate / 50
+	}
+	step := frameSize * job.Channels
+	var packets [][]byte
+	data := make([]byte, DefaultMaxPacketSize)
+	for off := 0; off < len(job.PCM); off += step {
+		frame := job.PCM[off:min(off+step, len(job.PCM))]
+		if len(frame) < step {
+			frame = append(make([]int16, 0, step), frame...)
+			frame = frame[:step]
+		}
+		n, err := enc.EncodeContext(ctx, frame, data)
+		if err != nil {
+			// A cancelled call closes the encoder's wasm instance.
+			w.enc = nil
+			return nil, err
+		}
+		packets = append(packets, append([]byte(nil), data[:n]...))
+	}
+	return packets, nil
+}
+
+// decoder returns a decoder for job, reusing the last one if it has the
+// same format.
+func (w *poolWorker) decoder(job *DecodeJob) (*Decoder, error) {
+	key := [2]int{job.SampleRate, job.Channels}
+	if w.dec != nil && key == w.decKey {
+		if err := w.dec.Reset(); err == nil {
+			return w.dec, nil
+		}
+	}
+	w.dec = nil
+	dec, err := NewDecoder(job.SampleRate, job.Channels)
+	if err != nil {
+		return nil, err
+	}
+	w.dec, w.decKey = dec, key
+	return dec, nil
+}
+
+func (w *poolWorker) decode(ctx context.Context, job *DecodeJob) ([]int16, error) {
+	dec, err := w.decoder(job)
+	if err != nil {
+		return nil, err
+	}
+	var pcm []int16
+	buf := make([]int16, maxFrameSize48k*job.Channels)
+	for i, packet := range job.Packets {
+		var next []byte
+		if i+1 < len(job.Packets) {
+			next = job.Packets[i+1]
+		}
+		n, _, err := dec.decodeWithLoss(ctx, packet, next, buf)
+		if err != nil {
+			w.dec = nil
+			return nil, err
+		}
+		pcm = append(pcm, buf[:n*job.Channels]...)
+	}
+	return pcm, nil
+}
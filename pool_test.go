@@ -0,0 +1,73 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	pool := NewPool(3)
+	pcm := make([]int16, FRAME_SIZE*10+100)
+	addSine(pcm, SAMPLE_RATE, 440)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			packets, err := pool.Encode(ctx, EncodeJob{SampleRate: SAMPLE_RATE, Channels: 1, PCM: pcm})
+			if err != nil {
+				t.Errorf("Couldn't encode data: %v", err)
+				return
+			}
+			// The partial frame at the end is padded.
+			if len(packets) != 11 {
+				t.Errorf("Got %d packets, want 11", len(packets))
+				return
+			}
+			packets[3] = nil
+			out, err := pool.Decode(ctx, DecodeJob{SampleRate: SAMPLE_RATE, Channels: 1, Packets: packets})
+			if err != nil {
+				t.Errorf("Couldn't decode data: %v", err)
+				return
+			}
+			if len(out) != FRAME_SIZE*11 {
+				t.Errorf("Decoded %d samples, want %d", len(out), FRAME_SIZE*11)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Workers reuse their codecs, so the same job gives the same packets.
+	a, err := pool.Encode(ctx, EncodeJob{SampleRate: SAMPLE_RATE, Channels: 1, PCM: pcm})
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	b, err := pool.Encode(ctx, EncodeJob{SampleRate: SAMPLE_RATE, Channels: 1, PCM: pcm})
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	for i := range a {
+		if string(a[i]) != string(b[i]) {
+			t.Fatalf("Packet %d differs between identical jobs", i)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := pool.Encode(cancelled, EncodeJob{SampleRate: SAMPLE_RATE, Channels: 1, PCM: pcm}); err == nil {
+		t.Errorf("Expected an error for a cancelled job")
+	}
+	pool.Close()
+	if _, err := pool.Encode(ctx, EncodeJob{SampleRate: SAMPLE_RATE, Channels: 1, PCM: pcm}); err != ErrPoolClosed {
+		t.Errorf("Got %v after Close, want ErrPoolClosed", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+//go:build opus_precompiled
+
+//go:generate go run ./cmd/opus-precompile -o precompiled
+
+package opus
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// precompiledFS holds the compilation cache produced by cmd/opus-precompile.
+//
+//go:embed all:precompiled
+var precompiledFS embed.FS
+
+// newRuntimeConfig returns a compiler runtime configuration backed by the
+// embedded compilation cache. wazero only reads the cache from disk, so the
+// embedded files are extracted to a temporary directory which the returned
+// cleanup function removes once the module has been compiled. The
+// interpreter has no use for the cache.
+func newRuntimeConfig(e Engine) (wazero.RuntimeConfig, func(), error) {
+	if e == EngineInterpreter {
+		return engineRuntimeConfig(e), func() {}, nil
+	}
+	dir, err := os.MkdirTemp("", "opus-precompiled-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create compilation cache directory: %w", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	root, err := fs.Sub(precompiledFS, "precompiled")
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	err = fs.WalkDir(root, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o700)
+		}
+		data, err := fs.ReadFile(root, path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0o600)
+	})
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to extract precompiled module: %w", err)
+	}
+
+	cache, err := wazero.NewCompilationCacheWithDir(dir)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to open compilation cache: %w", err)
+	}
+	return wazero.NewRuntimeConfigCompiler().WithCompilationCache(cache), cleanup, nil
+}
@@ -0,0 +1,143 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// warmupFrames is the number of 20 ms frames WithWarmup runs through the
+// codecs.
+const warmupFrames = 50
+
+// Preload starts the runtime now rather than in the first NewEncoder or
+// NewDecoder call: it compiles libopus, instantiates it and loads its
+// constants, which takes 100 ms or more. Servers can call it at startup to
+// pay that cost before serving, and to get initialization errors there
+// rather than wrapped in the first codec's. Configure the runtime, e.g.
+// WithWarmup, before calling it. Once the runtime is running, Preload
+// returns at once; after a failed start it returns the same error.
+func Preload(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := initWasm(ctx)
+	return err
+}
+
+// WithWarmup(true) encodes and decodes a second of a test tone when the
+// runtime starts, whether by Preload or by the first codec. That grows the
+// memory of the first instance to its working size and, on engines that
+// optimize hot code lazily, such as browsers', gets libopus optimized
+// before the first real frame. Only the codecs enabled by WithCodecs run,
+// a decoder alone concealing lost frames. The frames aren't counted in
+// Stats nor passed to the OnEncode and OnDecode hooks.
+func WithWarmup(enabled bool) Option {
+	return func(o *runtimeOptions) { o.warmup = enabled }
+}
+
+// warmup runs warmupFrames mono frames through a scratch encoder and
+// decoder in wc, freeing all it allocates.
+func (wc *wasmContext) warmup(ctx context.Context) error {
+	const sampleRate = 48000
+	const frameSize = sampleRate * 20 / 1000
+	f := &wc.functions
+
+	var ptrs []uint32
+	defer func() {
+		for _, ptr := range ptrs {
+			wc.freeMemory(ctx, ptr)
+		}
+	}()
+	alloc := func(size uint32) (uint32, error) {
+		ptr, err := wc.malloc(ctx, size)
+		if err != nil {
+			return 0, fmt.Errorf("wasm malloc failed: %w", err)
+		}
+		if ptr == 0 {
+			return 0, fmt.Errorf("wasm malloc returned NULL")
+		}
+		ptrs = append(ptrs, ptr)
+		return ptr, nil
+	}
+	call := func(fn api.Function, name string, args ...uint64) (int32, error) {
+		results, err := fn.Call(ctx, args...)
+		if err != nil {
+			return 0, callError(ctx, name, err)
+		}
+		ret := int32(results[0])
+		if ret < 0 {
+			return 0, fmt.Errorf("%s: %w", name, Error(int(ret)))
+		}
+		return ret, nil
+	}
+
+	tone := make([]int16, frameSize)
+	for i := range tone {
+		tone[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(i)/sampleRate))
+	}
+	pcmPtr, err := wc.writeToMemory(ctx, int16SliceToByteSlice(tone))
+	if err != nil {
+		return err
+	}
+	ptrs = append(ptrs, pcmPtr)
+	outPtr, err := alloc(frameSize * 2)
+	if err != nil {
+		return err
+	}
+	dataPtr, err := alloc(DefaultMaxPacketSize)
+	if err != nil {
+		return err
+	}
+
+	var enc, dec uint32
+	if f.OpusEncoderInit != nil {
+		size, err := call(f.OpusEncoderGetSize, "opus_encoder_get_size", 1)
+		if err != nil {
+			return err
+		}
+		if enc, err = alloc(uint32(size)); err != nil {
+			return err
+		}
+		if _, err := call(f.OpusEncoderInit, "opus_encoder_init", uint64(enc), sampleRate, 1, uint64(AppAudio)); err != nil {
+			return err
+		}
+	}
+	if f.OpusDecoderInit != nil {
+		size, err := call(f.OpusDecoderGetSize, "opus_decoder_get_size", 1)
+		if err != nil {
+			return err
+		}
+		if dec, err = alloc(uint32(size)); err != nil {
+			return err
+		}
+		if _, err := call(f.OpusDecoderInit, "opus_decoder_init", uint64(dec), sampleRate, 1); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < warmupFrames; i++ {
+		var n int32
+		if enc != 0 {
+			if n, err = call(f.OpusEncode, "opus_encode", uint64(enc), uint64(pcmPtr), frameSize, uint64(dataPtr), DefaultMaxPacketSize); err != nil {
+				return err
+			}
+		}
+		if dec != 0 {
+			data := uint64(dataPtr)
+			if n == 0 {
+				data = 0 // PLC
+			}
+			if _, err := call(f.OpusDecode, "opus_decode", uint64(dec), data, uint64(n), uint64(outPtr), frameSize, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
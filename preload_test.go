@@ -0,0 +1,72 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPreload(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	ctx := context.Background()
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	defer func() {
+		CloseWasmContext(ctx)
+		Configure(WithWarmup(false), WithLeakDetector(false), WithCodecs(AllCodecs))
+	}()
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := Preload(cancelled); err != context.Canceled {
+		t.Errorf("Got %v preloading with a cancelled context, want context.Canceled", err)
+	}
+
+	if err := Configure(WithWarmup(true), WithLeakDetector(true)); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	before := Stats()
+	if err := Preload(ctx); err != nil {
+		t.Fatalf("Preload: %v", err)
+	}
+	if err := Preload(ctx); err != nil {
+		t.Errorf("Preload again: %v", err)
+	}
+	after := Stats()
+	if after.FramesEncoded != before.FramesEncoded || after.FramesDecoded != before.FramesDecoded {
+		t.Errorf("Warm-up frames counted in Stats: %+v, then %+v", before, after)
+	}
+	if allocs := OutstandingAllocations(); len(allocs) != 0 {
+		t.Errorf("Warm-up left %d allocations: %+v", len(allocs), allocs)
+	}
+	if _, err := NewEncoder(SAMPLE_RATE, 1, AppAudio); err != nil {
+		t.Errorf("Error creating new encoder: %v", err)
+	}
+
+	// A decoder alone warms up on concealed frames.
+	CloseWasmContext(ctx)
+	if err := Configure(WithCodecs(DecoderOnly)); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if err := Preload(ctx); err != nil {
+		t.Fatalf("Preload: %v", err)
+	}
+
+	// Initialization errors are returned, not only logged. The cgo backend
+	// doesn't load wasm.
+	if opusWasmBinary == nil {
+		return
+	}
+	CloseWasmContext(ctx)
+	if err := Configure(WithWasmBinary([]byte("not wasm"))); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	defer Configure(WithWasmBinary(nil))
+	if err := Preload(ctx); err == nil {
+		t.Errorf("Expected an error preloading a broken module")
+	}
+}
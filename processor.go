@@ -0,0 +1,159 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"fmt"
+	"math"
+)
+
+// Processor is an audio conditioning step applied to PCM before encoding.
+// Process receives interleaved float32 samples in [-1, 1] and modifies them
+// in place.
+type Processor interface {
+	Process(pcm []float32) error
+}
+
+// ProcessorFunc adapts an ordinary function to the Processor interface.
+type ProcessorFunc func(pcm []float32) error
+
+// Process calls f(pcm).
+func (f ProcessorFunc) Process(pcm []float32) error {
+	return f(pcm)
+}
+
+// ProcessorChain runs processors in order, e.g.
+//
+//	ProcessorChain{hpf, agc, denoiser, SoftClip{}}
+type ProcessorChain []Processor
+
+// Process runs every processor of the chain on pcm, stopping at the first
+// error.
+func (c ProcessorChain) Process(pcm []float32) error {
+	for i, p := range c {
+		if err := p.Process(pcm); err != nil {
+			return fmt.Errorf("opus: processor %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// HighPassFilter removes DC offset and low-frequency rumble with a first
+// order high-pass filter.
+type HighPassFilter struct {
+	channels int
+	alpha    float32
+	prevIn   []float32
+	prevOut  []float32
+}
+
+// NewHighPassFilter creates a high-pass filter with the given -3 dB cutoff
+// frequency.
+func NewHighPassFilter(cutoff float64, sampleRate int, channels int) (*HighPassFilter, error) {
+	if cutoff <= 0 || sampleRate <= 0 || cutoff >= float64(sampleRate)/2 {
+		return nil, fmt.Errorf("opus: invalid high-pass cutoff %g Hz at %d Hz", cutoff, sampleRate)
+	}
+	if channels < 1 {
+		return nil, fmt.Errorf("opus: invalid number of channels: %d", channels)
+	}
+	rc := 1 / (2 * math.Pi * cutoff)
+	dt := 1 / float64(sampleRate)
+	return &HighPassFilter{
+		channels: channels,
+		alpha:    float32(rc / (rc + dt)),
+		prevIn:   make([]float32, channels),
+		prevOut:  make([]float32, channels),
+	}, nil
+}
+
+// Process filters pcm in place.
+func (f *HighPassFilter) Process(pcm []float32) error {
+	for i, x := range pcm {
+		c := i % f.channels
+		y := f.alpha * (f.prevOut[c] + x - f.prevIn[c])
+		f.prevIn[c] = x
+		f.prevOut[c] = y
+		pcm[i] = y
+	}
+	return nil
+}
+
+// AGC is a simple automatic gain control that steers the RMS level of each
+// buffer towards a target, limiting the gain and smoothing its changes.
+type AGC struct {
+	// Target is the desired RMS level in [0, 1].
+	Target float32
+	// MaxGain caps the applied gain (linear).
+	MaxGain float32
+	// Smoothing in [0, 1) controls how slowly the gain follows the input;
+	// higher values react more slowly.
+	Smoothing float32
+	// Floor is the RMS level below which the input is considered silence and
+	// the gain is left unchanged, so background noise isn't amplified.
+	Floor float32
+
+	gain float32
+}
+
+// NewAGC returns an AGC with reasonable defaults for speech.
+func NewAGC() *AGC {
+	return &AGC{Target: 0.1, MaxGain: 10, Smoothing: 0.9, Floor: 0.001}
+}
+
+// Process applies the gain to pcm in place.
+func (a *AGC) Process(pcm []float32) error {
+	if len(pcm) == 0 {
+		return nil
+	}
+	if a.gain == 0 {
+		a.gain = 1
+	}
+	var sum float64
+	for _, v := range pcm {
+		sum += float64(v) * float64(v)
+	}
+	rms := float32(math.Sqrt(sum / float64(len(pcm))))
+	if rms > a.Floor {
+		want := a.Target / rms
+		if a.MaxGain > 0 && want > a.MaxGain {
+			want = a.MaxGain
+		}
+		a.gain = a.Smoothing*a.gain + (1-a.Smoothing)*want
+	}
+	for i := range pcm {
+		pcm[i] *= a.gain
+	}
+	return nil
+}
+
+// SoftClip limits samples to [-1, 1], compressing peaks above Threshold
+// smoothly instead of clipping them hard. A zero Threshold defaults to 0.8.
+type SoftClip struct {
+	Threshold float32
+}
+
+// Process clips pcm in place.
+func (s SoftClip) Process(pcm []float32) error {
+	th := s.Threshold
+	if th <= 0 || th >= 1 {
+		th = 0.8
+	}
+	knee := 1 - th
+	for i, v := range pcm {
+		a := v
+		if a < 0 {
+			a = -a
+		}
+		if a <= th {
+			continue
+		}
+		c := th + knee*float32(math.Tanh(float64((a-th)/knee)))
+		if v < 0 {
+			c = -c
+		}
+		pcm[i] = c
+	}
+	return nil
+}
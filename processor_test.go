@@ -0,0 +1,109 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestProcessorChainOrder(t *testing.T) {
+	var order []int
+	step := func(i int) Processor {
+		return ProcessorFunc(func(pcm []float32) error {
+			order = append(order, i)
+			return nil
+		})
+	}
+	chain := ProcessorChain{step(1), step(2), step(3)}
+	if err := chain.Process(make([]float32, 4)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Errorf("Processors ran out of order: %v", order)
+	}
+
+	boom := errors.New("boom")
+	chain = ProcessorChain{ProcessorFunc(func([]float32) error { return boom }), step(4)}
+	if err := chain.Process(nil); !errors.Is(err, boom) {
+		t.Errorf("Expected wrapped processor error, got %v", err)
+	}
+	if len(order) != 3 {
+		t.Errorf("Chain continued after an error")
+	}
+}
+
+func TestHighPassFilterRemovesDC(t *testing.T) {
+	hpf, err := NewHighPassFilter(100, 48000, 1)
+	if err != nil {
+		t.Fatalf("Error creating filter: %v", err)
+	}
+	pcm := make([]float32, 4800)
+	for i := range pcm {
+		pcm[i] = 0.5
+	}
+	hpf.Process(pcm)
+	if v := pcm[len(pcm)-1]; math.Abs(float64(v)) > 0.01 {
+		t.Errorf("DC not removed, last sample %g", v)
+	}
+	if _, err := NewHighPassFilter(30000, 48000, 1); err == nil {
+		t.Errorf("Expected error for cutoff above Nyquist")
+	}
+}
+
+func TestAGCAndSoftClip(t *testing.T) {
+	agc := NewAGC()
+	agc.Smoothing = 0
+	pcm := make([]float32, 960)
+	addSineFloat32(pcm, 48000, 440)
+	for i := range pcm {
+		pcm[i] *= 0.02
+	}
+	chain := ProcessorChain{agc, SoftClip{}}
+	if err := chain.Process(pcm); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var sum float64
+	for _, v := range pcm {
+		sum += float64(v) * float64(v)
+		if v > 1 || v < -1 {
+			t.Fatalf("Sample out of range after soft clip: %g", v)
+		}
+	}
+	if rms := math.Sqrt(sum / float64(len(pcm))); math.Abs(rms-0.1) > 0.01 {
+		t.Errorf("AGC missed target level: rms %g", rms)
+	}
+
+	loud := []float32{2, -2, 0.5}
+	SoftClip{}.Process(loud)
+	if loud[0] <= 0.8 || loud[0] > 1 || loud[1] != -loud[0] || loud[2] != 0.5 {
+		t.Errorf("Unexpected soft clip output: %v", loud)
+	}
+}
+
+func TestEncoder_SetPreprocessor(t *testing.T) {
+	enc, err := NewEncoder(48000, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	calls := 0
+	enc.SetPreprocessor(ProcessorChain{
+		ProcessorFunc(func([]float32) error { calls++; return nil }),
+		SoftClip{},
+	})
+	pcm := make([]int16, 960)
+	data := make([]byte, 1000)
+	if _, err := enc.Encode(pcm, data); err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected chain to run once, ran %d times", calls)
+	}
+	enc.SetPreprocessor(nil)
+	if _, err := enc.Encode(pcm, data); err != nil || calls != 1 {
+		t.Errorf("Preprocessor not removed (calls=%d, err=%v)", calls, err)
+	}
+}
@@ -0,0 +1,483 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// ErrProjectionUnavailable is returned by the projection codec constructors
+// when the loaded wasm module doesn't export the opus_projection_* API.
+var ErrProjectionUnavailable = errors.New("opus: wasm module does not export the projection API")
+
+var errProjectionUninitialized = fmt.Errorf("opus projection codec uninitialized")
+
+// CTL request codes from opus_projection.h.
+const (
+	opusSetBitrateRequest                      = 4002
+	opusProjectionGetDemixingMatrixGainRequest = 6001
+	opusProjectionGetDemixingMatrixSizeRequest = 6003
+	opusProjectionGetDemixingMatrixRequest     = 6005
+)
+
+// ProjectionEncoder encodes ambisonics audio with channel mapping family 3.
+// The input is mixed down by a projection matrix into Opus streams; the
+// matching demixing matrix must be passed to the decoder, usually through
+// the Ogg Opus header.
+type ProjectionEncoder struct {
+	wctx           *wasmContext
+	encoderPtr     uint32
+	sampleRate     int
+	channels       int
+	streams        int
+	coupledStreams int
+	mu             sync.Mutex
+}
+
+// NewProjectionEncoder allocates an ambisonics encoder for channels input
+// channels, which must be a full ambisonics order, (order+1)^2, optionally
+// plus 2 non-diegetic stereo channels. It returns ErrProjectionUnavailable
+// if the wasm module was built without projection support.
+func NewProjectionEncoder(sampleRate int, channels int, application Application) (*ProjectionEncoder, error) {
+	if err := checkSampleRate(sampleRate); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	wctx, err := GetWasmContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wasm context for projection encoder: %w", err)
+	}
+	enc := &ProjectionEncoder{
+		wctx:       wctx,
+		sampleRate: sampleRate,
+		channels:   channels,
+	}
+	if err := enc.init(ctx, application); err != nil {
+		releaseWasmContext(wctx)
+		return nil, err
+	}
+	runtime.SetFinalizer(enc, func(e *ProjectionEncoder) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		releaseWasmState(e.wctx, e.encoderPtr, "projection encoder")
+		e.encoderPtr = 0
+		e.wctx = nil
+	})
+	return enc, nil
+}
+
+func (enc *ProjectionEncoder) init(ctx context.Context, application Application) error {
+	funcs := &enc.wctx.functions
+	if funcs.OpusProjectionAmbisonicsEncoderGetSize == nil || funcs.OpusProjectionAmbisonicsEncoderInit == nil ||
+		funcs.OpusProjectionEncode == nil || funcs.OpusProjectionEncodeFloat == nil || funcs.OpusProjectionEncoderCtl == nil {
+		return ErrProjectionUnavailable
+	}
+	results, err := funcs.OpusProjectionAmbisonicsEncoderGetSize.Call(ctx,
+		uint64(int32(enc.channels)), uint64(MappingFamilyProjection))
+	if err != nil {
+		return fmt.Errorf("opus_projection_ambisonics_encoder_get_size call failed: %w", err)
+	}
+	size := uint32(results[0])
+	if size == 0 {
+		return fmt.Errorf("opus: unsupported ambisonics channel count: %d", enc.channels)
+	}
+	ptr, err := enc.wctx.writeToMemory(ctx, make([]byte, size))
+	if err != nil {
+		return fmt.Errorf("failed to allocate Wasm memory for projection encoder: %w", err)
+	}
+	// streams and coupled_streams are written back by the init call.
+	countsPtr, err := enc.wctx.writeToMemory(ctx, make([]byte, 8))
+	if err != nil {
+		enc.wctx.freeMemory(ctx, ptr)
+		return err
+	}
+	defer enc.wctx.freeMemory(ctx, countsPtr)
+
+	results, err = funcs.OpusProjectionAmbisonicsEncoderInit.Call(ctx,
+		uint64(ptr), uint64(int32(enc.sampleRate)), uint64(int32(enc.channels)), uint64(MappingFamilyProjection),
+		uint64(countsPtr), uint64(countsPtr+4), uint64(int32(application)))
+	if err != nil {
+		enc.wctx.freeMemory(ctx, ptr)
+		return fmt.Errorf("opus_projection_ambisonics_encoder_init call failed: %w", err)
+	}
+	if errno := int32(results[0]); errno != opusOk {
+		enc.wctx.freeMemory(ctx, ptr)
+		return Error(int(errno))
+	}
+	streams, _ := enc.wctx.module.Memory().ReadUint32Le(countsPtr)
+	coupled, _ := enc.wctx.module.Memory().ReadUint32Le(countsPtr + 4)
+	enc.encoderPtr = ptr
+	enc.streams = int(streams)
+	enc.coupledStreams = int(coupled)
+	return nil
+}
+
+// Channels returns the number of input channels.
+func (enc *ProjectionEncoder) Channels() int {
+	return enc.channels
+}
+
+// Streams returns the total number of Opus streams in each packet.
+func (enc *ProjectionEncoder) Streams() int {
+	return enc.streams
+}
+
+// CoupledStreams returns how many of the streams are coupled (stereo).
+func (enc *ProjectionEncoder) CoupledStreams() int {
+	return enc.coupledStreams
+}
+
+// Encode interleaved int16 PCM and store the multistream packet in data.
+func (enc *ProjectionEncoder) Encode(pcm []int16, data []byte) (int, error) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	if enc.wctx == nil {
+		return 0, errProjectionUninitialized
+	}
+	return enc.encodeLocked(enc.wctx.functions.OpusProjectionEncode, "opus_projection_encode",
+		int16SliceToByteSlice(pcm), len(pcm), data)
+}
+
+// EncodeFloat32 encodes interleaved float32 PCM and stores the multistream
+// packet in data.
+func (enc *ProjectionEncoder) EncodeFloat32(pcm []float32, data []byte) (int, error) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	if enc.wctx == nil {
+		return 0, errProjectionUninitialized
+	}
+	return enc.encodeLocked(enc.wctx.functions.OpusProjectionEncodeFloat, "opus_projection_encode_float",
+		float32SliceToByteSlice(pcm), len(pcm), data)
+}
+
+func (enc *ProjectionEncoder) encodeLocked(encodeFunc api.Function, name string, pcmBytes []byte, samples int, data []byte) (int, error) {
+	if enc.encoderPtr == 0 {
+		return 0, errProjectionUninitialized
+	}
+	if samples == 0 {
+		return 0, fmt.Errorf("opus: no PCM data supplied")
+	}
+	if len(data) == 0 {
+		return 0, fmt.Errorf("opus: no target buffer for encoded data")
+	}
+	if samples%enc.channels != 0 {
+		return 0, fmt.Errorf("opus: input buffer length must be multiple of channels")
+	}
+	ctx := context.Background()
+	pcmPtr, err := enc.wctx.writeToMemory(ctx, pcmBytes)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write PCM to Wasm memory: %w", err)
+	}
+	defer enc.wctx.freeMemory(ctx, pcmPtr)
+	dataPtr, err := enc.wctx.writeToMemory(ctx, make([]byte, len(data)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate Wasm memory for output data: %w", err)
+	}
+	defer enc.wctx.freeMemory(ctx, dataPtr)
+
+	results, err := encodeFunc.Call(ctx,
+		uint64(enc.encoderPtr),
+		uint64(pcmPtr),
+		uint64(int32(samples/enc.channels)),
+		uint64(dataPtr),
+		uint64(int32(len(data))),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s call failed: %w", name, err)
+	}
+	encodedBytes := int32(results[0])
+	if encodedBytes < 0 {
+		return 0, Error(int(encodedBytes))
+	}
+	encoded, ok := enc.wctx.module.Memory().Read(dataPtr, uint32(encodedBytes))
+	if !ok {
+		return 0, fmt.Errorf("failed to read encoded data from Wasm memory")
+	}
+	return copy(data, encoded), nil
+}
+
+// SetBitrate sets the total bitrate across all streams.
+func (enc *ProjectionEncoder) SetBitrate(bitrate int) error {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	return enc.ctlLocked(context.Background(), opusSetBitrateRequest, uint32(int32(bitrate)))
+}
+
+// DemixingMatrixGain returns the output gain, in Q8 dB, to write into the
+// Ogg Opus header along with DemixingMatrix.
+func (enc *ProjectionEncoder) DemixingMatrixGain() (int, error) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	val, err := enc.getLocked(opusProjectionGetDemixingMatrixGainRequest)
+	return int(val), err
+}
+
+// DemixingMatrix returns the demixing matrix a decoder needs to restore the
+// ambisonics channels, serialized as in the channel mapping table of an Ogg
+// Opus header for mapping family 3: little-endian int16 coefficients in
+// column-major order.
+func (enc *ProjectionEncoder) DemixingMatrix() ([]byte, error) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+
+	size, err := enc.getLocked(opusProjectionGetDemixingMatrixSizeRequest)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	matrixPtr, err := enc.wctx.writeToMemory(ctx, make([]byte, size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate Wasm memory for demixing matrix: %w", err)
+	}
+	defer enc.wctx.freeMemory(ctx, matrixPtr)
+	if err := enc.ctlLocked(ctx, opusProjectionGetDemixingMatrixRequest, matrixPtr, uint32(size)); err != nil {
+		return nil, err
+	}
+	matrix, ok := enc.wctx.module.Memory().Read(matrixPtr, uint32(size))
+	if !ok {
+		return nil, fmt.Errorf("failed to read demixing matrix from Wasm memory")
+	}
+	return append([]byte(nil), matrix...), nil
+}
+
+// ChannelMapping returns the mapping family 3 channel mapping of the
+// encoder's packets, with the demixing matrix as its table.
+func (enc *ProjectionEncoder) ChannelMapping() (ChannelMapping, error) {
+	matrix, err := enc.DemixingMatrix()
+	if err != nil {
+		return ChannelMapping{}, err
+	}
+	return ChannelMapping{
+		Family:         MappingFamilyProjection,
+		Channels:       enc.channels,
+		Streams:        enc.streams,
+		CoupledStreams: enc.coupledStreams,
+		Table:          matrix,
+	}, nil
+}
+
+func (enc *ProjectionEncoder) ctlLocked(ctx context.Context, request int32, args ...uint32) error {
+	if enc.encoderPtr == 0 || enc.wctx == nil {
+		return errProjectionUninitialized
+	}
+	return enc.wctx.callCtl(ctx, enc.wctx.functions.OpusProjectionEncoderCtl, "opus_projection_encoder_ctl",
+		enc.encoderPtr, request, args...)
+}
+
+func (enc *ProjectionEncoder) getLocked(request int32) (int32, error) {
+	if enc.encoderPtr == 0 || enc.wctx == nil {
+		return 0, errProjectionUninitialized
+	}
+	ctx := context.Background()
+	valPtr, err := enc.wctx.allocateInt32Ptr(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer enc.wctx.freeMemory(ctx, valPtr)
+	if err := enc.ctlLocked(ctx, request, valPtr); err != nil {
+		return 0, err
+	}
+	value, ok := enc.wctx.module.Memory().ReadUint32Le(valPtr)
+	if !ok {
+		return 0, fmt.Errorf("failed to read value from Wasm memory for ctl request %d", request)
+	}
+	return int32(value), nil
+}
+
+// ProjectionDecoder decodes packets produced by a ProjectionEncoder back to
+// ambisonics channels.
+type ProjectionDecoder struct {
+	wctx       *wasmContext
+	decoderPtr uint32
+	channels   int
+	mu         sync.Mutex
+}
+
+// NewProjectionDecoder allocates a decoder for a mapping family 3 stream.
+// streams, coupledStreams and demixingMatrix come from the encoder (or the
+// Ogg Opus header). It returns ErrProjectionUnavailable if the wasm module
+// was built without projection support.
+func NewProjectionDecoder(sampleRate, channels, streams, coupledStreams int, demixingMatrix []byte) (*ProjectionDecoder, error) {
+	if err := checkSampleRate(sampleRate); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	wctx, err := GetWasmContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wasm context for projection decoder: %w", err)
+	}
+	dec := &ProjectionDecoder{
+		wctx:     wctx,
+		channels: channels,
+	}
+	if err := dec.init(ctx, sampleRate, streams, coupledStreams, demixingMatrix); err != nil {
+		releaseWasmContext(wctx)
+		return nil, err
+	}
+	runtime.SetFinalizer(dec, func(d *ProjectionDecoder) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		releaseWasmState(d.wctx, d.decoderPtr, "projection decoder")
+		d.decoderPtr = 0
+		d.wctx = nil
+	})
+	return dec, nil
+}
+
+func (dec *ProjectionDecoder) init(ctx context.Context, sampleRate, streams, coupledStreams int, demixingMatrix []byte) error {
+	funcs := &dec.wctx.functions
+	if funcs.OpusProjectionDecoderGetSize == nil || funcs.OpusProjectionDecoderInit == nil ||
+		funcs.OpusProjectionDecode == nil || funcs.OpusProjectionDecodeFloat == nil {
+		return ErrProjectionUnavailable
+	}
+	results, err := funcs.OpusProjectionDecoderGetSize.Call(ctx,
+		uint64(int32(dec.channels)), uint64(int32(streams)), uint64(int32(coupledStreams)))
+	if err != nil {
+		return fmt.Errorf("opus_projection_decoder_get_size call failed: %w", err)
+	}
+	size := uint32(results[0])
+	if size == 0 {
+		return ErrBadArg
+	}
+	ptr, err := dec.wctx.writeToMemory(ctx, make([]byte, size))
+	if err != nil {
+		return fmt.Errorf("failed to allocate Wasm memory for projection decoder: %w", err)
+	}
+	matrixPtr, err := dec.wctx.writeToMemory(ctx, demixingMatrix)
+	if err != nil {
+		dec.wctx.freeMemory(ctx, ptr)
+		return fmt.Errorf("failed to write demixing matrix to Wasm memory: %w", err)
+	}
+	defer dec.wctx.freeMemory(ctx, matrixPtr)
+
+	results, err = funcs.OpusProjectionDecoderInit.Call(ctx,
+		uint64(ptr), uint64(int32(sampleRate)), uint64(int32(dec.channels)),
+		uint64(int32(streams)), uint64(int32(coupledStreams)),
+		uint64(matrixPtr), uint64(int32(len(demixingMatrix))))
+	if err != nil {
+		dec.wctx.freeMemory(ctx, ptr)
+		return fmt.Errorf("opus_projection_decoder_init call failed: %w", err)
+	}
+	if errno := int32(results[0]); errno != opusOk {
+		dec.wctx.freeMemory(ctx, ptr)
+		return Error(int(errno))
+	}
+	dec.decoderPtr = ptr
+	return nil
+}
+
+// Channels returns the number of output channels.
+func (dec *ProjectionDecoder) Channels() int {
+	return dec.channels
+}
+
+// Decode a packet into interleaved int16 PCM. A nil packet conceals a lost
+// one. Returns the number of decoded samples per channel.
+func (dec *ProjectionDecoder) Decode(data []byte, pcm []int16) (int, error) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+	if dec.wctx == nil {
+		return 0, errProjectionUninitialized
+	}
+	out := make([]byte, 2*len(pcm))
+	n, err := dec.decodeLocked(dec.wctx.functions.OpusProjectionDecode, "opus_projection_decode", data, out, len(pcm))
+	if err != nil {
+		return 0, err
+	}
+	if err := int16SliceFromByteSlice(out[:2*n*dec.channels], pcm[:n*dec.channels]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// DecodeFloat32 decodes a packet into interleaved float32 PCM. A nil packet
+// conceals a lost one. Returns the number of decoded samples per channel.
+func (dec *ProjectionDecoder) DecodeFloat32(data []byte, pcm []float32) (int, error) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+	if dec.wctx == nil {
+		return 0, errProjectionUninitialized
+	}
+	out := make([]byte, 4*len(pcm))
+	n, err := dec.decodeLocked(dec.wctx.functions.OpusProjectionDecodeFloat, "opus_projection_decode_float", data, out, len(pcm))
+	if err != nil {
+		return 0, err
+	}
+	if err := float32SliceFromByteSlice(out[:4*n*dec.channels], pcm[:n*dec.channels]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// decodeLocked decodes data into out, a byte view of a PCM buffer holding
+// samples values, and returns the number of samples per channel.
+func (dec *ProjectionDecoder) decodeLocked(decodeFunc api.Function, name string, data, out []byte, samples int) (int, error) {
+	if dec.decoderPtr == 0 {
+		return 0, errProjectionUninitialized
+	}
+	if samples == 0 {
+		return 0, fmt.Errorf("opus: target PCM buffer empty")
+	}
+	if samples%dec.channels != 0 {
+		return 0, fmt.Errorf("opus: target PCM buffer length must be multiple of channels")
+	}
+	ctx := context.Background()
+	var dataPtr uint32
+	if len(data) > 0 {
+		var err error
+		dataPtr, err = dec.wctx.writeToMemory(ctx, data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write input data to Wasm memory: %w", err)
+		}
+		defer dec.wctx.freeMemory(ctx, dataPtr)
+	}
+	pcmPtr, err := dec.wctx.writeToMemory(ctx, out)
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate Wasm memory for PCM output: %w", err)
+	}
+	defer dec.wctx.freeMemory(ctx, pcmPtr)
+
+	results, err := decodeFunc.Call(ctx,
+		uint64(dec.decoderPtr),
+		uint64(dataPtr),
+		uint64(int32(len(data))),
+		uint64(pcmPtr),
+		uint64(int32(samples/dec.channels)),
+		0, // decode_fec
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s call failed: %w", name, err)
+	}
+	n := int32(results[0])
+	if n < 0 {
+		return 0, Error(int(n))
+	}
+	decoded, ok := dec.wctx.module.Memory().Read(pcmPtr, uint32(len(out)))
+	if !ok {
+		return 0, fmt.Errorf("failed to read decoded PCM from Wasm memory")
+	}
+	copy(out, decoded)
+	return int(n), nil
+}
+
+// releaseWasmState frees a codec state allocated in wctx and returns wctx to
+// the pool. It is meant for finalizers, so errors are only logged.
+func releaseWasmState(wctx *wasmContext, ptr uint32, what string) {
+	if wctx == nil {
+		return
+	}
+	if ptr != 0 && wctx.functions.Free != nil {
+		if err := wctx.free(context.Background(), ptr); err != nil {
+			logger().Warn("opus: error freeing Wasm "+what+" memory in finalizer", "err", err)
+		}
+	}
+	releaseWasmContext(wctx)
+}
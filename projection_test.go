@@ -0,0 +1,74 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+)
+
+func TestProjectionRoundTrip(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const CHANNELS = 4 // first order ambisonics
+	enc, err := NewProjectionEncoder(SAMPLE_RATE, CHANNELS, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new projection encoder: %v", err)
+	}
+	if enc.Streams() < 1 || enc.CoupledStreams() > enc.Streams() ||
+		enc.Streams()+enc.CoupledStreams() != CHANNELS {
+		t.Errorf("Unexpected stream layout: %d streams, %d coupled", enc.Streams(), enc.CoupledStreams())
+	}
+	matrix, err := enc.DemixingMatrix()
+	if err != nil {
+		t.Fatalf("Error getting demixing matrix: %v", err)
+	}
+	if want := 2 * CHANNELS * (enc.Streams() + enc.CoupledStreams()); len(matrix) != want {
+		t.Errorf("Demixing matrix has %d bytes, want %d", len(matrix), want)
+	}
+	if m, err := enc.ChannelMapping(); err != nil {
+		t.Errorf("Error getting channel mapping: %v", err)
+	} else if err := m.Validate(); err != nil {
+		t.Errorf("Invalid channel mapping: %v", err)
+	}
+	if _, err := enc.DemixingMatrixGain(); err != nil {
+		t.Errorf("Error getting demixing matrix gain: %v", err)
+	}
+
+	dec, err := NewProjectionDecoder(SAMPLE_RATE, CHANNELS, enc.Streams(), enc.CoupledStreams(), matrix)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new projection decoder: %v", err)
+	}
+	mono := make([]int16, FRAME_SIZE)
+	addSine(mono, SAMPLE_RATE, 440)
+	pcm := make([]int16, FRAME_SIZE*CHANNELS)
+	for i, v := range mono {
+		pcm[i*CHANNELS] = v // omnidirectional W channel only
+	}
+	data := make([]byte, 4000)
+	out := make([]int16, FRAME_SIZE*CHANNELS)
+	for i := 0; i < 3; i++ {
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		decoded, err := dec.Decode(data[:n], out)
+		if err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+		if decoded != FRAME_SIZE {
+			t.Errorf("Decoded %d samples, want %d", decoded, FRAME_SIZE)
+		}
+	}
+	if _, err := dec.Decode(nil, out); err != nil {
+		t.Errorf("Couldn't conceal lost packet: %v", err)
+	}
+}
+
+func TestProjectionUnsupportedChannels(t *testing.T) {
+	_, err := NewProjectionEncoder(48000, 5, AppAudio)
+	if err == nil {
+		t.Errorf("Expected error for 5 channel ambisonics")
+	}
+}
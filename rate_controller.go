@@ -0,0 +1,154 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateFeedback is a report about the network path, e.g. from RTCP receiver
+// reports and a bandwidth estimator.
+type RateFeedback struct {
+	// Loss is the fraction of packets lost since the last report, from 0
+	// to 1 (RTCP's fraction lost divided by 256).
+	Loss float64
+	// RTT is the round-trip time, 0 if unknown.
+	RTT time.Duration
+	// Bandwidth is the estimated bitrate available to the Opus payload in
+	// bit/s, without packet overhead; 0 if unknown.
+	Bandwidth int
+}
+
+// RateDecision is the encoder configuration chosen by a RateController.
+type RateDecision struct {
+	Bitrate        int
+	PacketLossPerc int
+	InBandFEC      bool
+}
+
+// Thresholds of RateController. Loss is smoothed before it is compared.
+const (
+	rateLossSmoothing = 0.3
+	// Above rateLossHigh or rateRTTHigh the path is congested and the
+	// bitrate is cut; below rateLossLow it is raised.
+	rateLossHigh = 0.10
+	rateLossLow  = 0.02
+	rateRTTHigh  = 400 * time.Millisecond
+	// FEC is turned on from rateFECOn and off again below rateFECOff.
+	rateFECOn  = 0.02
+	rateFECOff = 0.005
+	// rateBandwidthShare is the part of the estimated bandwidth used.
+	rateBandwidthShare = 0.9
+)
+
+// RateController adapts an Encoder to network conditions. Each report
+// passed to Update may lower the bitrate on loss, high RTT or a lower
+// bandwidth estimate, raise it slowly while the path is clean, and tune
+// in-band FEC and the expected packet loss to the smoothed loss rate.
+//
+// Changes have hysteresis, so noisy reports don't make the encoder
+// oscillate: bitrate changes under 5% are ignored, the expected loss moves
+// in steps of at least 2 points, and FEC turns off at a lower loss rate
+// than it turns on.
+type RateController struct {
+	enc                    *Encoder
+	minBitrate, maxBitrate int
+
+	mu       sync.Mutex
+	started  bool
+	loss     float64 // smoothed loss fraction
+	decision RateDecision
+}
+
+// NewRateController returns a controller keeping the bitrate of enc
+// between minBitrate and maxBitrate, starting from its current bitrate.
+func NewRateController(enc *Encoder, minBitrate, maxBitrate int) (*RateController, error) {
+	bitrate, err := enc.Bitrate()
+	if err != nil {
+		return nil, err
+	}
+	fec, err := enc.InBandFEC()
+	if err != nil {
+		return nil, err
+	}
+	lossPerc, err := enc.PacketLossPerc()
+	if err != nil {
+		return nil, err
+	}
+	return &RateController{
+		enc:        enc,
+		minBitrate: minBitrate,
+		maxBitrate: maxBitrate,
+		decision: RateDecision{
+			Bitrate:        min(max(bitrate, minBitrate), maxBitrate),
+			PacketLossPerc: lossPerc,
+			InBandFEC:      fec,
+		},
+	}, nil
+}
+
+// Update takes a feedback report into account, applies the resulting
+// configuration to the encoder if it changed, and returns it.
+func (c *RateController) Update(fb RateFeedback) (RateDecision, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	loss := min(max(fb.Loss, 0), 1)
+	if c.started {
+		c.loss += rateLossSmoothing * (loss - c.loss)
+	} else {
+		c.loss = loss
+	}
+
+	next := c.decision
+	target := float64(next.Bitrate)
+	switch {
+	case c.loss > rateLossHigh || fb.RTT > rateRTTHigh:
+		target *= 0.85
+	case c.loss < rateLossLow:
+		target *= 1.08
+	}
+	if fb.Bandwidth > 0 {
+		target = min(target, float64(fb.Bandwidth)*rateBandwidthShare)
+	}
+	bitrate := min(max(int(target), c.minBitrate), c.maxBitrate)
+	if diff := bitrate - next.Bitrate; diff*20 >= next.Bitrate || -diff*20 >= next.Bitrate ||
+		bitrate == c.minBitrate || bitrate == c.maxBitrate {
+		next.Bitrate = bitrate
+	}
+
+	switch {
+	case c.loss >= rateFECOn:
+		next.InBandFEC = true
+	case c.loss < rateFECOff:
+		next.InBandFEC = false
+	}
+	lossPerc := int(math.Round(c.loss * 100))
+	if d := lossPerc - next.PacketLossPerc; d >= 2 || d <= -2 || lossPerc == 0 {
+		next.PacketLossPerc = lossPerc
+	}
+
+	if !c.started || next != c.decision {
+		err := c.enc.Apply(EncoderConfigDelta{
+			Bitrate:        &next.Bitrate,
+			InBandFEC:      &next.InBandFEC,
+			PacketLossPerc: &next.PacketLossPerc,
+		})
+		if err != nil {
+			return c.decision, err
+		}
+		c.decision = next
+		c.started = true
+	}
+	return c.decision, nil
+}
+
+// Decision returns the configuration last applied.
+func (c *RateController) Decision() RateDecision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.decision
+}
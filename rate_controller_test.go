@@ -0,0 +1,79 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateController(t *testing.T) {
+	enc, err := NewEncoder(48000, 1, AppVoIP)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetBitrate(32000); err != nil {
+		t.Fatalf("Error setting bitrate: %v", err)
+	}
+	c, err := NewRateController(enc, 8000, 64000)
+	if err != nil {
+		t.Fatalf("NewRateController: %v", err)
+	}
+	update := func(fb RateFeedback) RateDecision {
+		t.Helper()
+		d, err := c.Update(fb)
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		bitrate, _ := enc.Bitrate()
+		fec, _ := enc.InBandFEC()
+		lossPerc, _ := enc.PacketLossPerc()
+		if got := (RateDecision{bitrate, lossPerc, fec}); got != d {
+			t.Fatalf("Encoder has %+v, controller decided %+v", got, d)
+		}
+		return d
+	}
+
+	// A clean path raises the bitrate up to the maximum.
+	var d RateDecision
+	for i := 0; i < 30; i++ {
+		d = update(RateFeedback{RTT: 50 * time.Millisecond})
+	}
+	if d.Bitrate != 64000 || d.InBandFEC || d.PacketLossPerc != 0 {
+		t.Errorf("Clean path: %+v", d)
+	}
+	// A bandwidth estimate caps it.
+	if d = update(RateFeedback{Bandwidth: 40000}); d.Bitrate != 36000 {
+		t.Errorf("Bandwidth cap: %+v", d)
+	}
+	// Heavy loss cuts the bitrate and enables FEC.
+	for i := 0; i < 5; i++ {
+		d = update(RateFeedback{Loss: 0.2})
+	}
+	if d.Bitrate >= 36000 || !d.InBandFEC || d.PacketLossPerc < 10 {
+		t.Errorf("Heavy loss: %+v", d)
+	}
+	// Moderate loss keeps FEC on but the bitrate steady.
+	for i := 0; i < 10; i++ {
+		d = update(RateFeedback{Loss: 0.04})
+	}
+	steady := d.Bitrate
+	if d = update(RateFeedback{Loss: 0.04}); d.Bitrate != steady || !d.InBandFEC {
+		t.Errorf("Moderate loss: %+v, bitrate was %d", d, steady)
+	}
+	// Hysteresis: FEC stays on at 1% loss and turns off at 0.
+	for i := 0; i < 10; i++ {
+		d = update(RateFeedback{Loss: 0.01})
+	}
+	if !d.InBandFEC {
+		t.Errorf("FEC turned off at 1%% loss")
+	}
+	for i := 0; i < 20; i++ {
+		d = update(RateFeedback{})
+	}
+	if d.InBandFEC || d.PacketLossPerc != 0 {
+		t.Errorf("No loss: %+v", d)
+	}
+}
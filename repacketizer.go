@@ -0,0 +1,171 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+//
+// Go port of the libopus repacketizer (src/repacketizer.c), without
+// self-delimited framing.
+
+package opus
+
+// repacketizer collects frames from packets sharing the same TOC
+// configuration and writes them out as new packets.
+type repacketizer struct {
+	toc       byte
+	frames    [][]byte
+	framesize int // samples per frame at 8 kHz
+}
+
+// cat adds all frames of data, like opus_repacketizer_cat. The frames are
+// referenced, not copied, so data must not change until the output is
+// written.
+func (rp *repacketizer) cat(data []byte) error {
+	if len(data) < 1 {
+		return ErrInvalidPacket
+	}
+	if len(rp.frames) == 0 {
+		rp.toc = data[0]
+		rp.framesize = tocSamplesPerFrame(data[0], 8000)
+	} else if rp.toc&0xfc != data[0]&0xfc {
+		return ErrInvalidPacket
+	}
+	count, err := packetFrameCount(data)
+	if err != nil {
+		return ErrInvalidPacket
+	}
+	// Check the 120 ms maximum packet size.
+	if (count+len(rp.frames))*rp.framesize > 960 {
+		return ErrInvalidPacket
+	}
+	p, err := parsePacket(data)
+	if err != nil {
+		return err
+	}
+	rp.frames = append(rp.frames, p.frames...)
+	return nil
+}
+
+// out writes frames [begin, end) as a single packet of at most maxLen bytes,
+// following opus_repacketizer_out_range_impl. With pad set the packet is
+// padded to exactly maxLen bytes.
+func (rp *repacketizer) out(begin, end, maxLen int, pad bool) ([]byte, error) {
+	return rp.outRange(begin, end, maxLen, false, pad)
+}
+
+// outRange is out with optional self-delimited framing (RFC 6716,
+// appendix B), which multistream packets use for all but the last stream.
+func (rp *repacketizer) outRange(begin, end, maxLen int, selfDelimited, pad bool) ([]byte, error) {
+	if begin < 0 || begin >= end || end > len(rp.frames) {
+		return nil, ErrBadArg
+	}
+	frames := rp.frames[begin:end]
+	count := len(frames)
+	data := make([]byte, 0, maxLen)
+	sdSize := 0
+	if selfDelimited {
+		sdSize = 1
+		if len(frames[count-1]) >= 252 {
+			sdSize = 2
+		}
+	}
+	totSize := sdSize
+
+	if count == 1 {
+		// Code 0
+		totSize += len(frames[0]) + 1
+		if totSize > maxLen {
+			return nil, ErrBufferTooSmall
+		}
+		data = append(data, rp.toc&0xfc)
+	} else if count == 2 {
+		if len(frames[1]) == len(frames[0]) {
+			// Code 1
+			totSize += 2*len(frames[0]) + 1
+			if totSize > maxLen {
+				return nil, ErrBufferTooSmall
+			}
+			data = append(data, rp.toc&0xfc|0x1)
+		} else {
+			// Code 2
+			totSize += len(frames[0]) + len(frames[1]) + 2
+			if len(frames[0]) >= 252 {
+				totSize++
+			}
+			if totSize > maxLen {
+				return nil, ErrBufferTooSmall
+			}
+			data = append(data, rp.toc&0xfc|0x2)
+			data = appendFrameSize(data, len(frames[0]))
+		}
+	}
+	if count > 2 || (pad && totSize < maxLen) {
+		// Code 3. Restart the process for the padding case.
+		data = data[:0]
+		vbr := false
+		for _, f := range frames[1:] {
+			if len(f) != len(frames[0]) {
+				vbr = true
+				break
+			}
+		}
+		if vbr {
+			totSize = sdSize + 2 + len(frames[count-1])
+			for _, f := range frames[:count-1] {
+				totSize += 1 + len(f)
+				if len(f) >= 252 {
+					totSize++
+				}
+			}
+			if totSize > maxLen {
+				return nil, ErrBufferTooSmall
+			}
+			data = append(data, rp.toc&0xfc|0x3, byte(count)|0x80)
+		} else {
+			totSize = sdSize + count*len(frames[0]) + 2
+			if totSize > maxLen {
+				return nil, ErrBufferTooSmall
+			}
+			data = append(data, rp.toc&0xfc|0x3, byte(count))
+		}
+		padAmount := 0
+		if pad {
+			padAmount = maxLen - totSize
+		}
+		if padAmount != 0 {
+			data[1] |= 0x40
+			nb255s := (padAmount - 1) / 255
+			for i := 0; i < nb255s; i++ {
+				data = append(data, 255)
+			}
+			data = append(data, byte(padAmount-255*nb255s-1))
+			totSize += padAmount
+		}
+		if vbr {
+			for _, f := range frames[:count-1] {
+				data = appendFrameSize(data, len(f))
+			}
+		}
+	}
+	if selfDelimited {
+		data = appendFrameSize(data, len(frames[count-1]))
+	}
+	for _, f := range frames {
+		data = append(data, f...)
+	}
+	if pad {
+		// Fill padding with zeros.
+		for len(data) < maxLen {
+			data = append(data, 0)
+		}
+	}
+	return data[:totSize], nil
+}
+
+// appendFrameSize appends a frame length in the one or two byte coding of
+// RFC 6716, section 3.2.1.
+func appendFrameSize(data []byte, size int) []byte {
+	if size < 252 {
+		return append(data, byte(size))
+	}
+	first := 252 + size&0x3
+	return append(data, byte(first), byte((size-first)>>2))
+}
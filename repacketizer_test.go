@@ -0,0 +1,111 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRepacketizerOut(t *testing.T) {
+	frame := func(size int, fill byte) []byte {
+		return bytes.Repeat([]byte{fill}, size)
+	}
+	tests := []struct {
+		name   string
+		frames [][]byte
+		code   byte
+	}{
+		{"one frame", [][]byte{frame(10, 1)}, 0},
+		{"two equal frames", [][]byte{frame(10, 1), frame(10, 2)}, 1},
+		{"two frames", [][]byte{frame(300, 1), frame(10, 2)}, 2},
+		{"three cbr frames", [][]byte{frame(5, 1), frame(5, 2), frame(5, 3)}, 3},
+		{"three vbr frames", [][]byte{frame(260, 1), frame(5, 2), frame(7, 3)}, 3},
+	}
+	for _, tt := range tests {
+		rp := repacketizer{toc: 0x80, frames: tt.frames, framesize: 20}
+		for _, pad := range []bool{false, true} {
+			maxLen := 1000
+			data, err := rp.out(0, len(tt.frames), maxLen, pad)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.name, err)
+			}
+			if pad && len(data) != maxLen {
+				t.Errorf("%s: padded packet has %d bytes, want %d", tt.name, len(data), maxLen)
+			}
+			if !pad && data[0]&0x3 != tt.code {
+				t.Errorf("%s: got frame count code %d, want %d", tt.name, data[0]&0x3, tt.code)
+			}
+			p, err := parsePacket(data)
+			if err != nil {
+				t.Fatalf("%s: can't parse output: %v", tt.name, err)
+			}
+			if len(p.frames) != len(tt.frames) {
+				t.Fatalf("%s: got %d frames, want %d", tt.name, len(p.frames), len(tt.frames))
+			}
+			for i := range tt.frames {
+				if !bytes.Equal(p.frames[i], tt.frames[i]) {
+					t.Errorf("%s: frame %d differs after repacketizing", tt.name, i)
+				}
+			}
+		}
+		if _, err := rp.out(0, len(tt.frames), 3, false); err != ErrBufferTooSmall {
+			t.Errorf("%s: expected ErrBufferTooSmall, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestRepacketizerCat(t *testing.T) {
+	var rp repacketizer
+	if err := rp.cat([]byte{0xf8, 1, 2}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := rp.cat([]byte{0xf9, 3, 4}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rp.frames) != 3 {
+		t.Errorf("Expected 3 frames, got %d", len(rp.frames))
+	}
+	if err := rp.cat([]byte{0xe8, 5}); err != ErrInvalidPacket {
+		t.Errorf("Expected ErrInvalidPacket for different configuration, got %v", err)
+	}
+	// 20 ms frames: 6 fit in 120 ms, a 7th doesn't.
+	if err := rp.cat([]byte{0xfb, 3, 1, 2, 3}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := rp.cat([]byte{0xf8, 6}); err != ErrInvalidPacket {
+		t.Errorf("Expected ErrInvalidPacket beyond 120 ms, got %v", err)
+	}
+}
+
+func TestRepacketizerSelfDelimited(t *testing.T) {
+	frames := [][]byte{
+		bytes.Repeat([]byte{1}, 300),
+		bytes.Repeat([]byte{2}, 10),
+	}
+	for _, n := range []int{1, 2} {
+		rp := repacketizer{toc: 0x80, frames: frames[:n], framesize: 20}
+		data, err := rp.outRange(0, n, 1000, true, false)
+		if err != nil {
+			t.Fatalf("%d frames: unexpected error: %v", n, err)
+		}
+		// Trailing bytes belong to the next stream and must be ignored.
+		p, err := parseSelfDelimited(append(data, 0xAA, 0xBB))
+		if err != nil {
+			t.Fatalf("%d frames: can't parse output: %v", n, err)
+		}
+		if p.packetOffset != len(data) {
+			t.Errorf("%d frames: got packet offset %d, want %d", n, p.packetOffset, len(data))
+		}
+		if len(p.frames) != n {
+			t.Fatalf("%d frames: got %d frames back", n, len(p.frames))
+		}
+		for i := range p.frames {
+			if !bytes.Equal(p.frames[i], frames[i]) {
+				t.Errorf("%d frames: frame %d differs after repacketizing", n, i)
+			}
+		}
+	}
+}
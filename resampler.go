@@ -0,0 +1,230 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// maxFrameSize48k is the longest Opus packet (120 ms) in samples per channel
+// at 48 kHz.
+const maxFrameSize48k = 5760
+
+// isOpusSampleRate reports whether libopus can decode at rate directly.
+func isOpusSampleRate(rate int) bool {
+	switch rate {
+	case 8000, 12000, 16000, 24000, 48000:
+		return true
+	}
+	return false
+}
+
+// linearResampler converts interleaved float32 audio between two sample rates
+// by linear interpolation. It keeps the tail of the previous block so
+// consecutive blocks join without discontinuities.
+type linearResampler struct {
+	channels int
+	step     float64   // input samples advanced per output sample
+	pos      float64   // position of the next output sample, relative to the next block
+	last     []float32 // last input frame of the previous block
+}
+
+func newLinearResampler(inRate, outRate, channels int) *linearResampler {
+	return &linearResampler{
+		channels: channels,
+		step:     float64(inRate) / float64(outRate),
+		last:     make([]float32, channels),
+	}
+}
+
+// outputLen returns the number of samples per channel that process produces
+// from an input block of n samples per channel.
+func (r *linearResampler) outputLen(n int) int {
+	count := 0
+	for t := r.pos; t < float64(n-1); t += r.step {
+		count++
+	}
+	return count
+}
+
+// process resamples in (n samples per channel) into out and returns the number
+// of samples per channel written. out must hold outputLen(n) samples per
+// channel.
+func (r *linearResampler) process(in []float32, out []float32) int {
+	ch := r.channels
+	n := len(in) / ch
+	if n == 0 {
+		return 0
+	}
+	sample := func(i, c int) float32 {
+		if i < 0 {
+			return r.last[c]
+		}
+		return in[i*ch+c]
+	}
+
+	written := 0
+	t := r.pos
+	for t < float64(n-1) {
+		i := int(math.Floor(t))
+		frac := float32(t - float64(i))
+		for c := 0; c < ch; c++ {
+			a, b := sample(i, c), sample(i+1, c)
+			out[written*ch+c] = a + (b-a)*frac
+		}
+		written++
+		t += r.step
+	}
+	r.pos = t - float64(n)
+	copy(r.last, in[(n-1)*ch:])
+	return written
+}
+
+// ResamplingDecoder decodes Opus packets to an arbitrary output sample rate,
+// for playback devices fixed at rates libopus doesn't support natively (for
+// example 44.1 kHz). Packets are decoded at 48 kHz and resampled; if the
+// output rate is one of the native Opus rates no resampling takes place.
+type ResamplingDecoder struct {
+	dec        *Decoder
+	outputRate int
+	channels   int
+	resampler  *linearResampler // nil when decoding at the output rate
+	buf        []float32
+	outBuf     []float32
+	mu         sync.Mutex
+}
+
+// NewResamplingDecoder creates a decoder that produces audio at outputRate.
+func NewResamplingDecoder(outputRate int, channels int) (*ResamplingDecoder, error) {
+	if outputRate <= 0 {
+		return nil, fmt.Errorf("opus: invalid output sample rate: %d", outputRate)
+	}
+	decodeRate := outputRate
+	if !isOpusSampleRate(outputRate) {
+		decodeRate = 48000
+	}
+	dec, err := NewDecoder(decodeRate, channels)
+	if err != nil {
+		return nil, err
+	}
+	rd := &ResamplingDecoder{
+		dec:        dec,
+		outputRate: outputRate,
+		channels:   channels,
+	}
+	if decodeRate != outputRate {
+		rd.resampler = newLinearResampler(decodeRate, outputRate, channels)
+		rd.buf = make([]float32, maxFrameSize48k*channels)
+	}
+	return rd, nil
+}
+
+// OutputRate returns the sample rate of the decoded audio in Hz.
+func (rd *ResamplingDecoder) OutputRate() int {
+	return rd.outputRate
+}
+
+// Decoder returns the underlying decoder, which runs at 48 kHz when
+// resampling is active.
+func (rd *ResamplingDecoder) Decoder() *Decoder {
+	return rd.dec
+}
+
+// DecodeFloat32 decodes data into pcm at the output rate. Returns the number
+// of samples per channel written. Because of resampling this may vary by one
+// sample between packets of equal duration.
+func (rd *ResamplingDecoder) DecodeFloat32(data []byte, pcm []float32) (int, error) {
+	if rd.resampler == nil {
+		return rd.dec.DecodeFloat32(data, pcm)
+	}
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	n, err := rd.dec.DecodeFloat32(data, rd.buf)
+	if err != nil {
+		return 0, err
+	}
+	return rd.resample(n, pcm)
+}
+
+// Decode decodes data into pcm at the output rate. Returns the number of
+// samples per channel written.
+func (rd *ResamplingDecoder) Decode(data []byte, pcm []int16) (int, error) {
+	if rd.resampler == nil {
+		return rd.dec.Decode(data, pcm)
+	}
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	n, err := rd.dec.DecodeFloat32(data, rd.buf)
+	if err != nil {
+		return 0, err
+	}
+	return rd.resampleInt16(n, pcm)
+}
+
+// DecodePLC conceals a lost packet. The length of pcm selects the amount of
+// audio to synthesize and is rounded down to a multiple of 2.5 ms.
+func (rd *ResamplingDecoder) DecodePLC(pcm []int16) (int, error) {
+	if rd.resampler == nil {
+		return rd.dec.DecodePLC(pcm)
+	}
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	frameSize := len(pcm) / rd.channels * 48000 / rd.outputRate
+	frameSize -= frameSize % 120
+	if frameSize == 0 {
+		return 0, fmt.Errorf("opus: target PCM buffer too small for PLC")
+	}
+	if frameSize > maxFrameSize48k {
+		frameSize = maxFrameSize48k
+	}
+	pcm48 := make([]int16, frameSize*rd.channels)
+	n, err := rd.dec.DecodePLC(pcm48)
+	if err != nil {
+		return 0, err
+	}
+	for i, v := range pcm48[:n*rd.channels] {
+		rd.buf[i] = float32(v) / 32768
+	}
+	return rd.resampleInt16(n, pcm)
+}
+
+func (rd *ResamplingDecoder) resample(n int, pcm []float32) (int, error) {
+	if need := rd.resampler.outputLen(n); len(pcm) < need*rd.channels {
+		return 0, fmt.Errorf("opus: target PCM buffer too small: need %d samples per channel", need)
+	}
+	return rd.resampler.process(rd.buf[:n*rd.channels], pcm), nil
+}
+
+func (rd *ResamplingDecoder) resampleInt16(n int, pcm []int16) (int, error) {
+	if cap(rd.outBuf) < len(pcm) {
+		rd.outBuf = make([]float32, len(pcm))
+	}
+	out := rd.outBuf[:len(pcm)]
+	written, err := rd.resample(n, out)
+	if err != nil {
+		return 0, err
+	}
+	for i, v := range out[:written*rd.channels] {
+		pcm[i] = floatToInt16(v)
+	}
+	return written, nil
+}
+
+// floatToInt16 converts a float sample in [-1, 1] to int16 with clipping.
+func floatToInt16(v float32) int16 {
+	s := math.Round(float64(v) * 32768)
+	if s > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if s < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(s)
+}
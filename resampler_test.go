@@ -0,0 +1,94 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLinearResampler(t *testing.T) {
+	r := newLinearResampler(48000, 44100, 1)
+	in := make([]float32, 960)
+	out := make([]float32, 1000)
+	total := 0
+	prev := float32(0)
+	for frame := 0; frame < 50; frame++ {
+		for i := range in {
+			// A slow ramp makes discontinuities at block boundaries visible.
+			in[i] = float32(frame*960+i) / 48000
+		}
+		n := r.process(in, out)
+		if n < 881 || n > 883 {
+			t.Fatalf("Frame %d: unexpected output length %d", frame, n)
+		}
+		for i := 0; i < n; i++ {
+			if d := out[i] - prev; total+i > 0 && (d <= 0 || d > 2.0/44100) {
+				t.Fatalf("Frame %d sample %d: discontinuity %g", frame, i, d)
+			}
+			prev = out[i]
+		}
+		total += n
+	}
+	if total < 44099 || total > 44101 {
+		t.Errorf("Expected ~44100 samples for 1s of audio, got %d", total)
+	}
+}
+
+func TestResamplingDecoder(t *testing.T) {
+	const FRAME_SIZE = 960
+	enc, err := NewEncoder(48000, 2, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewResamplingDecoder(44100, 2)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new resampling decoder: %v", err)
+	}
+	if dec.OutputRate() != 44100 {
+		t.Errorf("Unexpected output rate %d", dec.OutputRate())
+	}
+	mono := make([]int16, FRAME_SIZE)
+	addSine(mono, 48000, 440)
+	pcm := interleave(mono, mono)
+	data := make([]byte, 1000)
+	out := make([]int16, 2*FRAME_SIZE)
+	total := 0
+	var packet []byte
+	for i := 0; i < 10; i++ {
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		packet = data[:n]
+		m, err := dec.Decode(packet, out)
+		if err != nil {
+			t.Fatalf("Couldn't decode data: %v", err)
+		}
+		total += m
+	}
+	if want := 10 * FRAME_SIZE * 44100 / 48000; math.Abs(float64(total-want)) > 1 {
+		t.Errorf("Unexpected output length: got %d samples per channel, want ~%d", total, want)
+	}
+	if n, err := dec.DecodePLC(out); err != nil || n == 0 {
+		t.Errorf("Couldn't conceal frame: n=%d err=%v", n, err)
+	}
+	if _, err := dec.Decode(packet, out[:10]); err == nil {
+		t.Errorf("Expected error for undersized output buffer")
+	}
+}
+
+func TestResamplingDecoderNativeRate(t *testing.T) {
+	dec, err := NewResamplingDecoder(16000, 1)
+	if err != nil {
+		t.Fatalf("Error creating new resampling decoder: %v", err)
+	}
+	if dec.resampler != nil {
+		t.Errorf("Expected no resampling for native Opus rate")
+	}
+	if _, err := NewResamplingDecoder(0, 1); err == nil {
+		t.Errorf("Expected error for invalid output rate")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package rtp
+
+import (
+	"errors"
+	"time"
+
+	"github.com/godeps/opus"
+)
+
+// ErrLate is returned by Depayloader.Depayload for a packet at or before
+// one already depayloaded that wasn't reported lost: a duplicate, or a
+// packet reordered too late.
+var ErrLate = errors.New("rtp: late or duplicate packet")
+
+// maxLostRun is the longest run of missing sequence numbers reported as
+// lost frames; a longer one is taken as a discontinuity.
+const maxLostRun = 32
+
+// Frame is an opus.Frame received over RTP, with the sequence number and
+// timestamp, on the 48 kHz RTP clock, of its packet. For a lost frame they
+// are those the missing packet would have had.
+type Frame struct {
+	opus.Frame
+	Seq       uint16
+	Timestamp uint32
+}
+
+// Depayloader extracts the Opus packets from the RTP packets of one stream.
+type Depayloader struct {
+	started      bool
+	nextSeq      uint16 // one past the highest sequence number received
+	nextTS       uint32 // timestamp following the packet of nextSeq-1
+	pos          int64  // RTP clock ticks from the first packet to nextTS
+	lastDuration uint32 // duration of the packet of nextSeq-1
+	lost         uint64 // bit i: nextSeq-1-i was reported lost
+}
+
+// Depayload returns the Opus packet carried by pkt as a Frame. Its PTS
+// counts from the first packet, with the RTP timestamp unwrapped, so a gap
+// between the End of one frame and the PTS of the next is audio the sender
+// didn't transmit, e.g. a DTX pause.
+//
+// When pkt skips sequence numbers, the missing packets come first as
+// frames marked Lost, right before pkt so it can recover the last one with
+// FEC. Each takes the duration of the packet before them if the timestamps
+// leave room for it and an equal share of the gap otherwise. A missing
+// packet that arrives afterwards is still returned, with its own PTS, for a
+// jitter buffer to put in place of the lost frame; other packets at or
+// before the highest sequence number received return ErrLate. The returned
+// Data refers to pkt.Payload.
+func (d *Depayloader) Depayload(pkt Packet) ([]Frame, error) {
+	duration, err := PacketDuration(pkt.Payload)
+	if err != nil {
+		return nil, err
+	}
+	frame := func(pos int64) Frame {
+		return Frame{
+			Frame: opus.Frame{
+				Data:     pkt.Payload,
+				PTS:      clockDuration(pos),
+				Duration: clockDuration(int64(duration)),
+			},
+			Seq:       pkt.SequenceNumber,
+			Timestamp: pkt.Timestamp,
+		}
+	}
+	if !d.started {
+		d.started = true
+		d.advance(pkt, duration, 0)
+		return []Frame{frame(0)}, nil
+	}
+
+	missing := int(int16(pkt.SequenceNumber - d.nextSeq))
+	if missing < 0 {
+		back := -missing - 1
+		if back >= 64 || d.lost&(1<<back) == 0 {
+			return nil, ErrLate
+		}
+		d.lost &^= 1 << back
+		return []Frame{frame(d.pos + int64(int32(pkt.Timestamp-d.nextTS)))}, nil
+	}
+
+	gap := max(int32(pkt.Timestamp-d.nextTS), 0)
+	var frames []Frame
+	if missing > 0 && missing <= maxLostRun {
+		each := d.lastDuration
+		if int64(each)*int64(missing) > int64(gap) {
+			each = uint32(gap) / uint32(missing)
+		}
+		if each > 0 {
+			start := uint32(gap) - uint32(missing)*each
+			for i := 0; i < missing; i++ {
+				off := start + uint32(i)*each
+				frames = append(frames, Frame{
+					Frame: opus.Frame{
+						PTS:      clockDuration(d.pos + int64(off)),
+						Duration: clockDuration(int64(each)),
+						Lost:     true,
+					},
+					Seq:       d.nextSeq + uint16(i),
+					Timestamp: d.nextTS + off,
+				})
+			}
+		}
+	}
+	d.pos += int64(gap)
+	frames = append(frames, frame(d.pos))
+	d.advance(pkt, duration, missing)
+	return frames, nil
+}
+
+// advance moves the stream position past pkt, which follows missing lost
+// packets.
+func (d *Depayloader) advance(pkt Packet, duration uint32, missing int) {
+	if missing > maxLostRun {
+		d.lost = 0
+	} else {
+		d.lost <<= missing + 1
+		// Bits 1 to missing are the lost packets before pkt.
+		d.lost |= (1<<missing - 1) << 1
+	}
+	d.nextSeq = pkt.SequenceNumber + 1
+	d.nextTS = pkt.Timestamp + duration
+	d.pos += int64(duration)
+	d.lastDuration = duration
+}
+
+// Reset forgets the stream position, e.g. after a change of SSRC. The next
+// packet starts the timeline again at 0.
+func (d *Depayloader) Reset() {
+	*d = Depayloader{}
+}
+
+// clockDuration converts ticks of the RTP clock to a duration.
+func clockDuration(ticks int64) time.Duration {
+	return time.Duration(ticks) * time.Second / ClockRate
+}
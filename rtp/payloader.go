@@ -0,0 +1,73 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package rtp
+
+// Payloader wraps consecutive Opus packets of one stream into RTP packets,
+// one Opus packet per RTP packet as RFC 7587 requires.
+//
+// Each packet advances the timestamp by its duration at 48 kHz. Packets of
+// at most 2 bytes, which the encoder produces during discontinuous
+// transmission (DTX), aren't sent: their time is skipped so the receiver
+// sees a timestamp gap, and the next packet sent has the marker bit set as
+// the start of a talkspurt.
+type Payloader struct {
+	payloadType uint8
+	ssrc        uint32
+	seq         uint16
+	timestamp   uint32
+	marker      bool
+}
+
+// NewPayloader returns a Payloader for the stream with the given payload
+// type and SSRC, whose first packet gets the sequence number seq and the
+// timestamp timestamp. RFC 3550 recommends random initial values.
+func NewPayloader(payloadType uint8, ssrc uint32, seq uint16, timestamp uint32) *Payloader {
+	return &Payloader{
+		payloadType: payloadType,
+		ssrc:        ssrc,
+		seq:         seq,
+		timestamp:   timestamp,
+		marker:      true,
+	}
+}
+
+// Payload returns the RTP packet carrying the Opus packet data, and false
+// instead if data is a DTX packet that shouldn't be sent. The returned
+// Payload refers to data.
+func (p *Payloader) Payload(data []byte) (Packet, bool, error) {
+	duration, err := PacketDuration(data)
+	if err != nil {
+		return Packet{}, false, err
+	}
+	if len(data) <= 2 {
+		p.Skip(duration)
+		return Packet{}, false, nil
+	}
+	pkt := Packet{
+		PayloadType:    p.payloadType,
+		Marker:         p.marker,
+		SequenceNumber: p.seq,
+		Timestamp:      p.timestamp,
+		SSRC:           p.ssrc,
+		Payload:        data,
+	}
+	p.seq++
+	p.timestamp += duration
+	p.marker = false
+	return pkt, true, nil
+}
+
+// Skip advances the timestamp by duration, in units of the RTP clock,
+// without sending anything, e.g. for frames the application didn't encode.
+// The next packet starts a talkspurt.
+func (p *Payloader) Skip(duration uint32) {
+	p.timestamp += duration
+	p.marker = true
+}
+
+// Timestamp returns the timestamp the next packet will have.
+func (p *Payloader) Timestamp() uint32 {
+	return p.timestamp
+}
@@ -0,0 +1,63 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package rtp
+
+import "errors"
+
+// PionPayloader implements the Payloader interface of github.com/pion/rtp,
+// so it can be passed to rtp.NewPacketizer or used as a WebRTC track's
+// payloader without this module depending on pion:
+//
+//	packetizer := pionrtp.NewPacketizer(mtu, 111, ssrc, &rtp.PionPayloader{SkipDTX: true}, pionrtp.NewRandomSequencer(), rtp.ClockRate)
+//
+// Pion's packetizer advances the timestamp by the sample count passed to
+// Packetize, which must be the packet duration at 48 kHz (see
+// PacketDuration).
+type PionPayloader struct {
+	// SkipDTX drops DTX packets of at most 2 bytes instead of sending them.
+	// The packetizer still advances the timestamp, leaving a gap, but it
+	// doesn't set the marker bit on the packet that follows.
+	SkipDTX bool
+}
+
+// Payload returns payload as the single RTP payload RFC 7587 allows; the
+// mtu is ignored since Opus packets can't be fragmented. It returns nil for
+// an empty payload, and for DTX packets if SkipDTX is set.
+func (p *PionPayloader) Payload(mtu uint16, payload []byte) [][]byte {
+	if len(payload) == 0 || p.SkipDTX && len(payload) <= 2 {
+		return nil
+	}
+	return [][]byte{append([]byte(nil), payload...)}
+}
+
+// errEmptyPayload is returned by PionDepacketizer.Unmarshal for a packet
+// without payload.
+var errEmptyPayload = errors.New("rtp: empty Opus payload")
+
+// PionDepacketizer implements the Depacketizer interface of
+// github.com/pion/rtp, e.g. for samplebuilder.New, without this module
+// depending on pion. Every RTP packet holds one complete Opus packet.
+type PionDepacketizer struct{}
+
+// Unmarshal returns the Opus packet in payload, the payload of an RTP
+// packet. The result refers to payload.
+func (PionDepacketizer) Unmarshal(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, errEmptyPayload
+	}
+	return payload, nil
+}
+
+// IsPartitionHead reports whether payload starts an Opus packet, which is
+// always true.
+func (PionDepacketizer) IsPartitionHead(payload []byte) bool {
+	return true
+}
+
+// IsPartitionTail reports whether payload ends an Opus packet, which is
+// always true.
+func (PionDepacketizer) IsPartitionTail(marker bool, payload []byte) bool {
+	return true
+}
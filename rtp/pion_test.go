@@ -0,0 +1,59 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package rtp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Copies of the interfaces in github.com/pion/rtp the adapters implement.
+type (
+	pionPayloader interface {
+		Payload(mtu uint16, payload []byte) [][]byte
+	}
+	pionDepacketizer interface {
+		Unmarshal(packet []byte) ([]byte, error)
+		IsPartitionHead(payload []byte) bool
+		IsPartitionTail(marker bool, payload []byte) bool
+	}
+)
+
+var (
+	_ pionPayloader    = (*PionPayloader)(nil)
+	_ pionDepacketizer = PionDepacketizer{}
+)
+
+func TestPionAdapters(t *testing.T) {
+	p := &PionPayloader{}
+	payloads := p.Payload(10, celt20)
+	if len(payloads) != 1 || !bytes.Equal(payloads[0], celt20) {
+		t.Errorf("Payload returned %x", payloads)
+	}
+	if &payloads[0][0] == &celt20[0] {
+		t.Errorf("Payload doesn't copy the packet")
+	}
+	if got := p.Payload(1200, dtx20); len(got) != 1 {
+		t.Errorf("Payload dropped a DTX packet without SkipDTX")
+	}
+	p.SkipDTX = true
+	if got := p.Payload(1200, dtx20); got != nil {
+		t.Errorf("Payload returned %x for a DTX packet with SkipDTX", got)
+	}
+	if got := p.Payload(1200, nil); got != nil {
+		t.Errorf("Payload returned %x for an empty packet", got)
+	}
+
+	var d PionDepacketizer
+	if data, err := d.Unmarshal(celt20); err != nil || !bytes.Equal(data, celt20) {
+		t.Errorf("Unmarshal returned %x, %v", data, err)
+	}
+	if _, err := d.Unmarshal(nil); err == nil {
+		t.Errorf("Expected error for an empty payload")
+	}
+	if !d.IsPartitionHead(celt20) || !d.IsPartitionTail(false, celt20) {
+		t.Errorf("Every Opus payload is a whole partition")
+	}
+}
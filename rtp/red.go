@@ -0,0 +1,191 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package rtp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Limits of the RED block header fields.
+const (
+	maxREDOffset = 1<<14 - 1
+	maxREDLength = 1<<10 - 1
+)
+
+// ErrRED is returned for malformed RED payloads.
+var ErrRED = errors.New("rtp: malformed RED payload")
+
+// REDBlock is one of the encodings in a RED payload (RFC 2198), as used for
+// audio redundancy by WebRTC.
+type REDBlock struct {
+	PayloadType uint8
+	// TimestampOffset is how much earlier than the RTP packet the block's
+	// audio starts, in units of the RTP clock. It is 0 for the primary
+	// encoding.
+	TimestampOffset uint32
+	Data            []byte
+}
+
+// MarshalRED encodes blocks as a RED payload. The last block is the primary
+// encoding, the others are redundant ones, oldest first.
+func MarshalRED(blocks []REDBlock) ([]byte, error) {
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("rtp: RED payload without blocks")
+	}
+	size := 1
+	for _, b := range blocks {
+		size += 4 + len(b.Data)
+	}
+	out := make([]byte, 0, size)
+	for _, b := range blocks[:len(blocks)-1] {
+		if b.TimestampOffset > maxREDOffset || len(b.Data) > maxREDLength {
+			return nil, fmt.Errorf("rtp: RED block too old (%d) or too long (%d bytes)", b.TimestampOffset, len(b.Data))
+		}
+		h := 1<<31 | uint32(b.PayloadType&0x7f)<<24 | b.TimestampOffset<<10 | uint32(len(b.Data))
+		out = append(out, byte(h>>24), byte(h>>16), byte(h>>8), byte(h))
+	}
+	out = append(out, blocks[len(blocks)-1].PayloadType&0x7f)
+	for _, b := range blocks {
+		out = append(out, b.Data...)
+	}
+	return out, nil
+}
+
+// ParseRED decodes a RED payload into its blocks, redundant ones first and
+// the primary encoding last. The blocks' Data refers to payload.
+func ParseRED(payload []byte) ([]REDBlock, error) {
+	var blocks []REDBlock
+	var lengths []int
+	offset := 0
+	for {
+		if offset >= len(payload) {
+			return nil, ErrRED
+		}
+		if payload[offset]&0x80 == 0 {
+			blocks = append(blocks, REDBlock{PayloadType: payload[offset]})
+			offset++
+			break
+		}
+		if offset+4 > len(payload) {
+			return nil, ErrRED
+		}
+		h := uint32(payload[offset])<<24 | uint32(payload[offset+1])<<16 | uint32(payload[offset+2])<<8 | uint32(payload[offset+3])
+		blocks = append(blocks, REDBlock{
+			PayloadType:     uint8(h>>24) & 0x7f,
+			TimestampOffset: h >> 10 & maxREDOffset,
+		})
+		lengths = append(lengths, int(h&maxREDLength))
+		offset += 4
+	}
+	for i, n := range lengths {
+		if offset+n > len(payload) {
+			return nil, ErrRED
+		}
+		blocks[i].Data = payload[offset : offset+n]
+		offset += n
+	}
+	blocks[len(blocks)-1].Data = payload[offset:]
+	return blocks, nil
+}
+
+// SplitRED splits pkt, an RTP packet with a RED payload, into the RTP
+// packets of the Opus packets it carries, oldest first, each with the
+// payload type of its block. Redundant blocks get the timestamp of their
+// offset and the sequence numbers just before pkt's, assuming, as
+// REDEncoder and WebRTC do, that the redundancy repeats the packets sent
+// right before.
+//
+// Passing all of them on to a jitter buffer fills the holes left by lost
+// packets; the ones it already has are discarded as duplicates.
+func SplitRED(pkt Packet) ([]Packet, error) {
+	blocks, err := ParseRED(pkt.Payload)
+	if err != nil {
+		return nil, err
+	}
+	packets := make([]Packet, len(blocks))
+	for i, b := range blocks {
+		packets[i] = Packet{
+			PayloadType:    b.PayloadType,
+			SequenceNumber: pkt.SequenceNumber - uint16(len(blocks)-1-i),
+			Timestamp:      pkt.Timestamp - b.TimestampOffset,
+			SSRC:           pkt.SSRC,
+			Payload:        b.Data,
+		}
+	}
+	packets[len(packets)-1].Marker = pkt.Marker
+	return packets, nil
+}
+
+// REDPolicy decides how much redundancy a REDEncoder adds.
+type REDPolicy struct {
+	// Generations is the number of previous packets repeated in each
+	// payload. WebRTC uses 1 or 2; each generation adds the bitrate of the
+	// stream again.
+	Generations int
+	// MaxSize caps the size of a RED payload in bytes, leaving out the
+	// oldest generations first; the primary encoding is always included.
+	// 0 means no cap.
+	MaxSize int
+}
+
+// redEntry is a packet remembered by a REDEncoder.
+type redEntry struct {
+	timestamp uint32
+	data      []byte
+}
+
+// REDEncoder wraps the Opus packets of a stream into RED payloads that
+// repeat the previous packets, so a receiver can recover from losses of up
+// to Generations packets in a row.
+type REDEncoder struct {
+	payloadType uint8
+	policy      REDPolicy
+	history     []redEntry
+}
+
+// NewREDEncoder returns a REDEncoder for Opus packets with the given
+// payload type. The RED payloads it makes are sent with the payload type
+// negotiated for RED.
+func NewREDEncoder(payloadType uint8, policy REDPolicy) *REDEncoder {
+	return &REDEncoder{payloadType: payloadType, policy: policy}
+}
+
+// Encode returns the RED payload of the RTP packet with the given timestamp
+// carrying data, the next Opus packet sent. Packets not sent, such as DTX
+// packets left out by a Payloader, must not be passed.
+func (e *REDEncoder) Encode(data []byte, timestamp uint32) ([]byte, error) {
+	var blocks []REDBlock
+	size := 1 + len(data)
+	// Take the newest generations that fit, then put them oldest first.
+	for i := len(e.history) - 1; i >= 0; i-- {
+		h := e.history[i]
+		offset := timestamp - h.timestamp
+		if offset > maxREDOffset || len(h.data) > maxREDLength {
+			break
+		}
+		if e.policy.MaxSize > 0 && size+4+len(h.data) > e.policy.MaxSize {
+			break
+		}
+		size += 4 + len(h.data)
+		blocks = append(blocks, REDBlock{PayloadType: e.payloadType, TimestampOffset: offset, Data: h.data})
+	}
+	slices.Reverse(blocks)
+	blocks = append(blocks, REDBlock{PayloadType: e.payloadType, Data: data})
+	out, err := MarshalRED(blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.policy.Generations > 0 {
+		if len(e.history) == e.policy.Generations {
+			copy(e.history, e.history[1:])
+			e.history = e.history[:len(e.history)-1]
+		}
+		e.history = append(e.history, redEntry{timestamp: timestamp, data: append([]byte(nil), data...)})
+	}
+	return out, nil
+}
@@ -0,0 +1,79 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package rtp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRED(t *testing.T) {
+	packets := [][]byte{
+		{31 << 3, 0},
+		{31 << 3, 1, 1},
+		{31 << 3, 2, 2, 2},
+		{31 << 3, 3, 3, 3, 3},
+	}
+	e := NewREDEncoder(111, REDPolicy{Generations: 2})
+	var payloads [][]byte
+	for i, data := range packets {
+		payload, err := e.Encode(data, uint32(1000+960*i))
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		payloads = append(payloads, payload)
+	}
+	// Packet 3 carries packets 1 and 2 as redundancy.
+	if want := 4 + 4 + 1 + 3 + 4 + 5; len(payloads[3]) != want {
+		t.Errorf("RED payload is %d bytes, want %d", len(payloads[3]), want)
+	}
+	split, err := SplitRED(Packet{PayloadType: 63, SequenceNumber: 1, Timestamp: 1000 + 3*960, SSRC: 9, Payload: payloads[3]})
+	if err != nil {
+		t.Fatalf("SplitRED: %v", err)
+	}
+	if len(split) != 3 {
+		t.Fatalf("Got %d packets, want 3", len(split))
+	}
+	for i, pkt := range split {
+		j := i + 1
+		if pkt.PayloadType != 111 || pkt.SequenceNumber != uint16(j-2) || pkt.Timestamp != uint32(1000+960*j) || pkt.SSRC != 9 {
+			t.Errorf("Packet %d: %+v", i, pkt)
+		}
+		if !bytes.Equal(pkt.Payload, packets[j]) {
+			t.Errorf("Packet %d has payload %x, want %x", i, pkt.Payload, packets[j])
+		}
+	}
+
+	// The first payload has no redundancy yet.
+	blocks, err := ParseRED(payloads[0])
+	if err != nil || len(blocks) != 1 || !bytes.Equal(blocks[0].Data, packets[0]) {
+		t.Errorf("ParseRED returned %+v, %v", blocks, err)
+	}
+
+	// MaxSize leaves out the oldest generation.
+	e = NewREDEncoder(111, REDPolicy{Generations: 2, MaxSize: 1 + 5 + 4 + 4})
+	for i, data := range packets {
+		payload, err := e.Encode(data, uint32(960*i))
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		blocks, err := ParseRED(payload)
+		if err != nil {
+			t.Fatalf("ParseRED: %v", err)
+		}
+		if i == 3 && (len(blocks) != 2 || blocks[0].TimestampOffset != 960) {
+			t.Errorf("Got blocks %+v, want packet 2 as redundancy", blocks)
+		}
+	}
+
+	for _, bad := range [][]byte{nil, {0x80 | 111, 0, 0}, {0x80 | 111, 0, 0x3c, 5, 111, 1}} {
+		if _, err := ParseRED(bad); err == nil {
+			t.Errorf("ParseRED(%x): expected error", bad)
+		}
+	}
+	if _, err := MarshalRED([]REDBlock{{TimestampOffset: 1 << 14}, {}}); err == nil {
+		t.Errorf("Expected error for a too old block")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+// Package rtp carries Opus packets in RTP as specified by RFC 7587. A
+// Payloader turns the packets of an encoder into RTP packets with the right
+// timestamps, sequence numbers and marker bits, and a Depayloader turns
+// received RTP packets back into Frames on the stream timeline, reporting
+// missing packets as lost frames. Neither does any I/O, so they fit in front
+// of any RTP stack.
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ClockRate is the RTP timestamp rate of Opus. RFC 7587 fixes it at 48 kHz
+// whatever the sample rate of the encoded audio.
+const ClockRate = 48000
+
+// HeaderSize is the size of an RTP header without CSRCs or extensions.
+const HeaderSize = 12
+
+// ErrMalformed is returned for Opus packets whose duration can't be
+// determined from the TOC.
+var ErrMalformed = errors.New("rtp: malformed Opus packet")
+
+// Packet is an RTP packet. CSRCs and header extensions are skipped when
+// unmarshalling and never marshalled.
+type Packet struct {
+	PayloadType    uint8
+	Marker         bool
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	Payload        []byte
+}
+
+// MarshalBinary encodes p.
+func (p Packet) MarshalBinary() ([]byte, error) {
+	return p.Append(make([]byte, 0, HeaderSize+len(p.Payload))), nil
+}
+
+// Append appends the encoding of p to b.
+func (p Packet) Append(b []byte) []byte {
+	var hdr [HeaderSize]byte
+	hdr[0] = 2 << 6 // version 2, no padding, no extension, no CSRCs
+	hdr[1] = p.PayloadType & 0x7f
+	if p.Marker {
+		hdr[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(hdr[2:], p.SequenceNumber)
+	binary.BigEndian.PutUint32(hdr[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(hdr[8:], p.SSRC)
+	b = append(b, hdr[:]...)
+	return append(b, p.Payload...)
+}
+
+// UnmarshalBinary decodes the RTP packet in data. Payload refers to data,
+// which must not be modified while the payload is in use.
+func (p *Packet) UnmarshalBinary(data []byte) error {
+	if len(data) < HeaderSize {
+		return fmt.Errorf("rtp: packet too short: %d bytes", len(data))
+	}
+	if v := data[0] >> 6; v != 2 {
+		return fmt.Errorf("rtp: unsupported version %d", v)
+	}
+	padding := data[0]&0x20 != 0
+	extension := data[0]&0x10 != 0
+	csrcCount := int(data[0] & 0x0f)
+	p.Marker = data[1]&0x80 != 0
+	p.PayloadType = data[1] & 0x7f
+	p.SequenceNumber = binary.BigEndian.Uint16(data[2:])
+	p.Timestamp = binary.BigEndian.Uint32(data[4:])
+	p.SSRC = binary.BigEndian.Uint32(data[8:])
+
+	offset := HeaderSize + 4*csrcCount
+	if extension {
+		if len(data) < offset+4 {
+			return fmt.Errorf("rtp: header extension truncated")
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:]))
+	}
+	end := len(data)
+	if padding {
+		end -= int(data[end-1])
+	}
+	if offset > end {
+		return fmt.Errorf("rtp: packet truncated")
+	}
+	p.Payload = data[offset:end]
+	return nil
+}
+
+// PacketDuration returns the duration of the Opus packet data in units of
+// the RTP clock.
+func PacketDuration(data []byte) (uint32, error) {
+	if len(data) == 0 {
+		return 0, ErrMalformed
+	}
+	toc := data[0]
+	var frameSize uint32
+	switch config := toc >> 3; {
+	case config < 12: // SILK: 10, 20, 40, 60 ms
+		frameSize = []uint32{480, 960, 1920, 2880}[config&3]
+	case config < 16: // Hybrid: 10, 20 ms
+		frameSize = 480 << (config & 1)
+	default: // CELT: 2.5, 5, 10, 20 ms
+		frameSize = 120 << (config & 3)
+	}
+	frames := uint32(1)
+	switch toc & 3 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(data) < 2 {
+			return 0, ErrMalformed
+		}
+		frames = uint32(data[1] & 0x3f)
+	}
+	// A packet holds at most 120 ms of audio.
+	if frames == 0 || frames*frameSize > 5760 {
+		return 0, ErrMalformed
+	}
+	return frames * frameSize, nil
+}
@@ -0,0 +1,238 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package rtp
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/godeps/opus"
+)
+
+// Opus packets with the TOC of a 20 ms CELT frame and of two 10 ms SILK
+// frames.
+var (
+	celt20   = []byte{31 << 3, 1, 2, 3, 4}
+	silk2x10 = []byte{8<<3 | 1, 1, 2, 3, 4}
+	dtx20    = []byte{31 << 3}
+)
+
+func TestPacketDuration(t *testing.T) {
+	for _, tt := range []struct {
+		data []byte
+		want uint32
+	}{
+		{celt20, 960},
+		{silk2x10, 960},
+		{[]byte{16 << 3}, 120},            // CELT 2.5 ms
+		{[]byte{3 << 3}, 2880},            // SILK 60 ms
+		{[]byte{16<<3 | 3, 3, 0, 0}, 360}, // code 3, 3 x 2.5 ms
+		{[]byte{3<<3 | 3, 3, 0, 0}, 0},    // 180 ms is too long
+		{[]byte{16<<3 | 3}, 0},            // missing frame count
+		{nil, 0},
+	} {
+		got, err := PacketDuration(tt.data)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("PacketDuration(%x): expected error", tt.data)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("PacketDuration(%x) = %d, %v; want %d", tt.data, got, err, tt.want)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	p := Packet{PayloadType: 111, Marker: true, SequenceNumber: 0xfffe, Timestamp: 0xdeadbeef, SSRC: 42, Payload: celt20}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	var got Packet
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if got.PayloadType != p.PayloadType || got.Marker != p.Marker || got.SequenceNumber != p.SequenceNumber ||
+		got.Timestamp != p.Timestamp || got.SSRC != p.SSRC || !bytes.Equal(got.Payload, p.Payload) {
+		t.Errorf("Got %+v, want %+v", got, p)
+	}
+
+	// One CSRC, a one-word extension and 2 bytes of padding.
+	b = append([]byte{0xb1, 111, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3}, 0, 0, 0, 4)
+	b = append(b, 0xbe, 0xde, 0, 1, 0, 0, 0, 0)
+	b = append(b, celt20...)
+	b = append(b, 0, 2)
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if !bytes.Equal(got.Payload, celt20) {
+		t.Errorf("Got payload %x, want %x", got.Payload, celt20)
+	}
+	for _, bad := range [][]byte{b[:11], append([]byte{0x40}, b[1:]...), b[:20]} {
+		if err := got.UnmarshalBinary(bad); err == nil {
+			t.Errorf("%x: expected error", bad)
+		}
+	}
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	p := NewPayloader(111, 7, 0xffff, 0xffffff00)
+	var sent []Packet
+	for _, data := range [][]byte{celt20, silk2x10, dtx20, dtx20, celt20, celt20} {
+		pkt, ok, err := p.Payload(data)
+		if err != nil {
+			t.Fatalf("Payload: %v", err)
+		}
+		if ok {
+			sent = append(sent, pkt)
+		}
+	}
+	if len(sent) != 4 {
+		t.Fatalf("Sent %d packets, want 4", len(sent))
+	}
+	start := uint32(0xffffff00)
+	wantTS := []uint32{start, start + 960, start + 4*960, start + 5*960}
+	wantMarker := []bool{true, false, true, false}
+	for i, pkt := range sent {
+		if pkt.SequenceNumber != uint16(0xffff+i) || pkt.Timestamp != wantTS[i] || pkt.Marker != wantMarker[i] {
+			t.Errorf("Packet %d: seq %d, ts %d, marker %v", i, pkt.SequenceNumber, pkt.Timestamp, pkt.Marker)
+		}
+	}
+	if _, _, err := p.Payload(nil); err == nil {
+		t.Errorf("Expected error for an empty packet")
+	}
+
+	// Lose the last packet but one.
+	var d Depayloader
+	var frames []Frame
+	for i, pkt := range sent {
+		if i == 2 {
+			continue
+		}
+		fs, err := d.Depayload(pkt)
+		if err != nil {
+			t.Fatalf("Depayload: %v", err)
+		}
+		frames = append(frames, fs...)
+	}
+	if len(frames) != 4 {
+		t.Fatalf("Got %d frames, want 4", len(frames))
+	}
+	const ms = time.Millisecond
+	if f := frames[1]; f.PTS != frames[0].End() || f.Seq != frames[0].Seq+1 || f.Duration != 20*ms || f.Lost {
+		t.Errorf("Frame 1: %+v", f)
+	}
+	// The lost packet is reported right before the next one, after the
+	// DTX pause.
+	if f := frames[2]; !f.Lost || f.Data != nil || f.Seq != frames[1].Seq+1 || f.PTS-frames[1].End() != 40*ms || f.Duration != 20*ms {
+		t.Errorf("Frame 2: %+v", f)
+	}
+	if f := frames[3]; f.Lost || f.Seq != frames[1].Seq+2 || f.PTS != frames[2].End() || f.Timestamp != start+5*960 {
+		t.Errorf("Frame 3: %+v", f)
+	}
+	// It is still accepted if it turns up late, once.
+	fs, err := d.Depayload(sent[2])
+	if err != nil || len(fs) != 1 || fs[0].Lost || fs[0].PTS != frames[1].End()+40*ms {
+		t.Errorf("Late packet: %+v, %v", fs, err)
+	}
+	for _, pkt := range []Packet{sent[2], sent[1]} {
+		if _, err := d.Depayload(pkt); err != ErrLate {
+			t.Errorf("Expected ErrLate, got %v", err)
+		}
+	}
+	d.Reset()
+	if fs, err := d.Depayload(sent[0]); err != nil || len(fs) != 1 || fs[0].PTS != 0 {
+		t.Errorf("After Reset got %+v, %v", fs, err)
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	const packets = 30
+	enc, err := opus.NewEncoder(SAMPLE_RATE, 1, opus.AppVoIP)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetInBandFEC(true); err != nil {
+		t.Fatalf("SetInBandFEC: %v", err)
+	}
+	if err := enc.SetPacketLossPerc(30); err != nil {
+		t.Fatalf("SetPacketLossPerc: %v", err)
+	}
+	if err := enc.SetBitrate(24000); err != nil {
+		t.Fatalf("SetBitrate: %v", err)
+	}
+	dec, err := opus.NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+
+	p := NewPayloader(111, 1, 0xfff0, 0xffffe000)
+	pcm := make([]int16, FRAME_SIZE)
+	for i := range pcm {
+		pcm[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(i)/SAMPLE_RATE))
+	}
+	var wire [][]byte
+	for i := 0; i < packets; i++ {
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		pkt, ok, err := p.Payload(data[:n])
+		if err != nil || !ok {
+			t.Fatalf("Payload: %v, %v", ok, err)
+		}
+		b, _ := pkt.MarshalBinary()
+		wire = append(wire, b)
+	}
+	// The network swaps two packets and loses two others.
+	wire[5], wire[6] = wire[6], wire[5]
+	wire = slices.Delete(wire, 20, 21)
+	wire = slices.Delete(wire, 12, 13)
+
+	var d Depayloader
+	jb := opus.NewJitterBuffer(dec, 3)
+	out := make([]int16, 5760)
+	played := 0
+	for _, b := range wire {
+		var pkt Packet
+		if err := pkt.UnmarshalBinary(b); err != nil {
+			t.Fatalf("UnmarshalBinary: %v", err)
+		}
+		frames, err := d.Depayload(pkt)
+		if err != nil {
+			t.Fatalf("Depayload: %v", err)
+		}
+		for _, f := range frames {
+			jb.Push(f.Frame)
+		}
+		n, err := jb.Pop(out)
+		if err != nil {
+			t.Fatalf("Pop: %v", err)
+		}
+		if n != 0 && n != FRAME_SIZE {
+			t.Fatalf("Pop returned %d samples", n)
+		}
+		played += n
+	}
+	for played < packets*FRAME_SIZE {
+		n, err := jb.Pop(out)
+		if err != nil || n != FRAME_SIZE {
+			t.Fatalf("Pop = %d, %v", n, err)
+		}
+		played += n
+	}
+	want := opus.JitterStats{Decoded: packets - 2, FEC: 2}
+	if got := jb.Stats(); got != want {
+		t.Errorf("Got stats %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+//go:build !opus_precompiled
+
+package opus
+
+import (
+	"github.com/tetratelabs/wazero"
+)
+
+// newRuntimeConfig returns the wazero runtime configuration for e. Builds
+// tagged opus_precompiled use an embedded compilation cache instead (see
+// precompiled.go).
+func newRuntimeConfig(e Engine) (wazero.RuntimeConfig, func(), error) {
+	return engineRuntimeConfig(e), func() {}, nil
+}
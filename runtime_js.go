@@ -0,0 +1,356 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+//go:build js && !opus_cgo
+
+package opus
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"syscall/js"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// In js/wasm builds libopus runs in the WebAssembly engine of the browser or
+// Node.js hosting the Go program, rather than in wazero, which can only
+// interpret it there. The types below wrap the instances in the parts of
+// the wazero API the codecs use, so they run unchanged on top of them.
+//
+// Modules are compiled and instantiated synchronously: waiting for a
+// promise needs every goroutine to be idle, and deadlocks in js.FuncOf
+// callbacks. Browsers refuse to compile large modules synchronously on the
+// main thread, but libopus is far below the limit.
+
+// WASI errnos returned by the host functions.
+const (
+	errnoBadF  = 8
+	errnoFault = 21
+	errnoIO    = 29
+)
+
+var (
+	jsWebAssembly = js.Global().Get("WebAssembly")
+	jsUint8Array  = js.Global().Get("Uint8Array")
+	jsObject      = js.Global().Get("Object")
+)
+
+// start compiles the libopus module chosen by opts with the host's
+// WebAssembly API. Options selecting a wazero runtime or engine don't apply.
+func (m *wasmManager) start(ctx context.Context, opts runtimeOptions) error {
+	if jsWebAssembly.IsUndefined() {
+		return errors.New("opus: the JavaScript host has no WebAssembly API")
+	}
+	wasmBinary, err := opts.wasmBinary()
+	if err != nil {
+		return err
+	}
+	module, err := jsCompile(wasmBinary)
+	if err != nil {
+		return fmt.Errorf("failed to compile wasm module: %w", err)
+	}
+	m.compiledModule = &jsCompiledModule{module: module, noWASI: opts.noWASI}
+	m.moduleID = moduleID(wasmBinary)
+	return nil
+}
+
+func jsCompile(wasmBinary []byte) (js.Value, error) {
+	bytes := jsUint8Array.New(len(wasmBinary))
+	js.CopyBytesToJS(bytes, wasmBinary)
+	return jsNew(jsWebAssembly.Get("Module"), bytes)
+}
+
+// jsNew calls the JavaScript constructor with args, returning what it
+// throws as a js.Error.
+func jsNew(constructor js.Value, args ...any) (v js.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			v, err = js.Undefined(), jsErr
+		}
+	}()
+	return constructor.New(args...), nil
+}
+
+// jsCompiledModule implements the parts of wazero.CompiledModule the
+// manager uses over a WebAssembly.Module.
+type jsCompiledModule struct {
+	wazero.CompiledModule // not implemented; only the methods below are called
+	module                js.Value
+	noWASI                bool
+}
+
+func (c *jsCompiledModule) Close(context.Context) error { return nil }
+
+// instantiate creates an instance named name, with the WASI functions it
+// imports provided by Go.
+func (m *wasmManager) instantiate(ctx context.Context, name string) (api.Module, error) {
+	compiled := m.compiledModule.(*jsCompiledModule)
+	mod := &jsModule{name: name}
+	wasi := jsObject.New()
+	imports := jsWebAssembly.Get("Module").Call("imports", compiled.module)
+	for i := 0; i < imports.Length(); i++ {
+		imp := imports.Index(i)
+		if imp.Get("module").String() != wasi_snapshot_preview1.ModuleName || imp.Get("kind").String() != "function" {
+			// Left unresolved, it fails the instantiation.
+			continue
+		}
+		fn := js.FuncOf(mod.wasiFunction(imp.Get("name").String(), compiled.noWASI))
+		mod.hostFuncs = append(mod.hostFuncs, fn)
+		wasi.Set(imp.Get("name").String(), fn)
+	}
+	importObject := jsObject.New()
+	importObject.Set(wasi_snapshot_preview1.ModuleName, wasi)
+
+	instance, err := jsNew(jsWebAssembly.Get("Instance"), compiled.module, importObject)
+	if err != nil {
+		mod.Close(ctx)
+		return nil, err
+	}
+	mod.exports = instance.Get("exports")
+	if memory := mod.exports.Get("memory"); memory.InstanceOf(jsWebAssembly.Get("Memory")) {
+		mod.mem = &jsMemory{memory: memory}
+	}
+	// Like wazero, run _start if the module has one.
+	if start := mod.ExportedFunction("_start"); start != nil {
+		if _, err := start.Call(ctx); err != nil {
+			mod.Close(ctx)
+			return nil, fmt.Errorf("_start: %w", err)
+		}
+	}
+	return mod, nil
+}
+
+// jsModule implements the parts of api.Module the codecs use over a
+// WebAssembly.Instance.
+type jsModule struct {
+	api.Module // not implemented; only the methods below are called
+	name       string
+	exports    js.Value
+	mem        *jsMemory
+	hostFuncs  []js.Func
+	closed     bool
+}
+
+func (m *jsModule) Name() string { return m.name }
+
+func (m *jsModule) Memory() api.Memory {
+	if m.mem == nil {
+		return nil
+	}
+	return m.mem
+}
+
+func (m *jsModule) ExportedFunction(name string) api.Function {
+	fn := m.exports.Get(name)
+	if fn.Type() != js.TypeFunction {
+		return nil
+	}
+	return &jsFunction{fn: fn, params: fn.Length()}
+}
+
+func (m *jsModule) IsClosed() bool { return m.closed }
+
+// Close releases the host functions; the instance itself is garbage
+// collected by the JavaScript engine.
+func (m *jsModule) Close(context.Context) error {
+	if !m.closed {
+		for _, fn := range m.hostFuncs {
+			fn.Release()
+		}
+		m.hostFuncs, m.exports, m.mem, m.closed = nil, js.Undefined(), nil, true
+	}
+	return nil
+}
+
+// wasiFunction returns the host function of the WASI import name. The C
+// library only writes to stdout and stderr and reads entropy; every other
+// function, and all of them WithWASI(false), fails with ENOSYS.
+func (m *jsModule) wasiFunction(name string, noWASI bool) func(js.Value, []js.Value) any {
+	if !noWASI {
+		switch name {
+		case "fd_write":
+			return m.fdWrite
+		case "random_get":
+			return m.randomGet
+		}
+	}
+	return func(js.Value, []js.Value) any { return errnoNoSys }
+}
+
+// fdWrite implements fd_write(fd, iovs, iovs_len, nwritten) for stdout and
+// stderr, which the Go js/wasm runtime forwards to the console.
+func (m *jsModule) fdWrite(_ js.Value, args []js.Value) any {
+	var w *os.File
+	switch args[0].Int() {
+	case 1:
+		w = os.Stdout
+	case 2:
+		w = os.Stderr
+	default:
+		return errnoBadF
+	}
+	iovs, iovsLen := uint32(args[1].Int()), uint32(args[2].Int())
+	var written uint32
+	for i := uint32(0); i < iovsLen; i++ {
+		ptr, ok := m.mem.ReadUint32Le(iovs + 8*i)
+		size, ok2 := m.mem.ReadUint32Le(iovs + 8*i + 4)
+		if !ok || !ok2 {
+			return errnoFault
+		}
+		b, ok := m.mem.Read(ptr, size)
+		if !ok {
+			return errnoFault
+		}
+		n, err := w.Write(b)
+		written += uint32(n)
+		if err != nil {
+			return errnoIO
+		}
+	}
+	if !m.mem.WriteUint32Le(uint32(args[3].Int()), written) {
+		return errnoFault
+	}
+	return 0
+}
+
+// randomGet implements random_get(buf, buf_len).
+func (m *jsModule) randomGet(_ js.Value, args []js.Value) any {
+	b := make([]byte, uint32(args[1].Int()))
+	if _, err := rand.Read(b); err != nil {
+		return errnoIO
+	}
+	if !m.mem.Write(uint32(args[0].Int()), b) {
+		return errnoFault
+	}
+	return 0
+}
+
+// jsMemory implements the parts of api.Memory the codecs use over a
+// WebAssembly.Memory. Unlike wazero's, Read returns a copy: the Go program
+// can't address the memory of another instance.
+type jsMemory struct {
+	api.Memory // not implemented; only the methods below are called
+	memory     js.Value
+}
+
+func (m *jsMemory) Size() uint32 {
+	return uint32(m.memory.Get("buffer").Get("byteLength").Int())
+}
+
+func (m *jsMemory) Grow(deltaPages uint32) (previousPages uint32, ok bool) {
+	defer func() {
+		// grow throws a RangeError beyond the maximum.
+		if r := recover(); r != nil {
+			if _, isJS := r.(js.Error); !isJS {
+				panic(r)
+			}
+			previousPages, ok = 0, false
+		}
+	}()
+	return uint32(m.memory.Call("grow", deltaPages).Int()), true
+}
+
+// view returns a Uint8Array of byteCount bytes at offset. The buffer is
+// fetched every time, as growing the memory replaces it.
+func (m *jsMemory) view(offset, byteCount uint32) (js.Value, bool) {
+	buffer := m.memory.Get("buffer")
+	if uint64(offset)+uint64(byteCount) > uint64(buffer.Get("byteLength").Int()) {
+		return js.Value{}, false
+	}
+	return jsUint8Array.New(buffer, offset, byteCount), true
+}
+
+func (m *jsMemory) Read(offset, byteCount uint32) ([]byte, bool) {
+	view, ok := m.view(offset, byteCount)
+	if !ok {
+		return nil, false
+	}
+	b := make([]byte, byteCount)
+	js.CopyBytesToGo(b, view)
+	return b, true
+}
+
+func (m *jsMemory) Write(offset uint32, v []byte) bool {
+	view, ok := m.view(offset, uint32(len(v)))
+	if !ok {
+		return false
+	}
+	js.CopyBytesToJS(view, v)
+	return true
+}
+
+func (m *jsMemory) ReadUint32Le(offset uint32) (uint32, bool) {
+	b, ok := m.Read(offset, 4)
+	if !ok {
+		return 0, false
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24, true
+}
+
+func (m *jsMemory) WriteUint32Le(offset, v uint32) bool {
+	return m.Write(offset, []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+}
+
+// jsFunction implements the parts of api.Function the codecs use over an
+// exported function. Context cancellation can't interrupt it.
+type jsFunction struct {
+	api.Function // not implemented; only the methods below are called
+	fn           js.Value
+	params       int
+}
+
+func (f *jsFunction) Call(_ context.Context, params ...uint64) (results []uint64, err error) {
+	if len(params) != f.params {
+		return nil, fmt.Errorf("expected %d params, but passed %d", f.params, len(params))
+	}
+	args := make([]any, len(params))
+	for i, p := range params {
+		args[i] = int32(uint32(p))
+	}
+	defer func() {
+		// Traps are thrown as WebAssembly.RuntimeError.
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			results, err = nil, jsErr
+		}
+	}()
+	v := f.fn.Invoke(args...)
+	if v.Type() != js.TypeNumber {
+		return nil, nil
+	}
+	return []uint64{uint64(uint32(v.Int()))}, nil
+}
+
+func (f *jsFunction) Definition() api.FunctionDefinition {
+	return jsFunctionDefinition{params: f.params}
+}
+
+// jsFunctionDefinition describes an exported function as taking i32s. The
+// JavaScript API exposes neither the parameter nor the result types; a
+// module built for another ABI fails when called.
+type jsFunctionDefinition struct {
+	api.FunctionDefinition // not implemented; only the methods below are called
+	params                 int
+}
+
+func (d jsFunctionDefinition) ParamTypes() []api.ValueType {
+	return i32Types(d.params)
+}
+
+func (d jsFunctionDefinition) ResultTypes() []api.ValueType {
+	return nil
+}
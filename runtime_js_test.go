@@ -0,0 +1,39 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+//go:build js && !opus_cgo
+
+package opus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestJSBackend(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	mod, ok := dec.wctx.module.(*jsModule)
+	if !ok {
+		t.Fatalf("Decoder runs in %T, want the host's engine", dec.wctx.module)
+	}
+	size := mod.Memory().Size()
+	if _, ok := mod.Memory().Grow(1 << 17); ok {
+		t.Errorf("Grew the memory beyond 4 GiB")
+	}
+	if got := mod.Memory().Size(); got != size {
+		t.Errorf("Failed grow changed the memory from %d to %d bytes", size, got)
+	}
+	if _, ok := mod.Memory().Read(size-2, 4); ok {
+		t.Errorf("Read past the end of the memory")
+	}
+
+	// Traps surface as errors rather than panics.
+	if _, err := mod.ExportedFunction("free").Call(context.Background(), uint64(size)+8); err == nil {
+		t.Errorf("Expected an error freeing an invalid pointer")
+	}
+}
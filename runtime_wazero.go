@@ -0,0 +1,61 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+//go:build !opus_cgo && !js
+
+package opus
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// start creates or adopts the wazero runtime chosen by opts and compiles the
+// libopus module in it.
+func (m *wasmManager) start(ctx context.Context, opts runtimeOptions) error {
+	wasmBinary, err := opts.wasmBinary()
+	if err != nil {
+		return err
+	}
+	rt, ownsRuntime := opts.runtime, opts.runtime == nil
+	cleanup := func() {}
+	if ownsRuntime {
+		rtConfig, rtCleanup, err := newRuntimeConfig(opts.engine)
+		if err != nil {
+			return fmt.Errorf("failed to configure wasm runtime: %w", err)
+		}
+		cleanup = rtCleanup
+		// Let cancelled contexts interrupt long-running wasm calls.
+		rtConfig = rtConfig.WithCloseOnContextDone(true)
+		rt = wazero.NewRuntimeWithConfig(ctx, rtConfig)
+	}
+	closeRuntime := func() {
+		if ownsRuntime {
+			_ = rt.Close(ctx)
+		}
+	}
+
+	compiledModule, err := rt.CompileModule(ctx, wasmBinary)
+	cleanup()
+	if err != nil {
+		closeRuntime()
+		return fmt.Errorf("failed to compile wasm module: %w", err)
+	}
+	if err := instantiateWASI(ctx, rt, compiledModule, opts.noWASI); err != nil {
+		_ = compiledModule.Close(ctx)
+		closeRuntime()
+		return fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+	m.runtime, m.compiledModule, m.ownsRuntime = rt, compiledModule, ownsRuntime
+	m.moduleID = moduleID(wasmBinary)
+	return nil
+}
+
+// instantiate creates a libopus instance named name.
+func (m *wasmManager) instantiate(ctx context.Context, name string) (api.Module, error) {
+	return m.runtime.InstantiateModule(ctx, m.compiledModule, wazero.NewModuleConfig().WithName(name))
+}
@@ -0,0 +1,145 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+//go:build !opus_cgo && !js
+
+package opus
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+func TestWithRuntime(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	ctx := context.Background()
+	if _, err := NewEncoder(SAMPLE_RATE, 1, AppAudio); err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	defer rt.Close(ctx)
+	if err := Configure(WithRuntime(rt)); err == nil {
+		t.Fatalf("Expected an error configuring a running runtime")
+	}
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	if err := Configure(WithRuntime(rt)); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	defer func() {
+		CloseWasmContext(ctx)
+		Configure(WithRuntime(nil))
+	}()
+
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	if _, err := enc.Encode(pcm, data); err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if rt.Module(wasi_snapshot_preview1.ModuleName) == nil {
+		t.Errorf("WASI not instantiated in the runtime")
+	}
+
+	// The runtime stays usable after the package lets go of it.
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	if _, err := NewEncoder(SAMPLE_RATE, 1, AppAudio); err != nil {
+		t.Fatalf("Error creating new encoder in the same runtime: %v", err)
+	}
+}
+
+func TestWithWasmBinary(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	ctx := context.Background()
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	defer func() {
+		CloseWasmContext(ctx)
+		Configure(WithWasmBinary(nil))
+	}()
+
+	// An empty module has none of the exports.
+	if err := Configure(WithWasmBinary([]byte("\x00asm\x01\x00\x00\x00"))); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if _, err := NewEncoder(SAMPLE_RATE, 1, AppAudio); err == nil {
+		t.Errorf("Expected an error for a module without libopus")
+	}
+	if err := Configure(WithWasmFile(filepath.Join(t.TempDir(), "missing.wasm"))); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if _, err := NewEncoder(SAMPLE_RATE, 1, AppAudio); err == nil {
+		t.Errorf("Expected an error for a missing wasm file")
+	}
+
+	if err := Configure(WithWasmFile("wasm-bridge/build/wasm_bridge")); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if _, err := NewEncoder(SAMPLE_RATE, 1, AppAudio); err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+}
+
+func TestWithWASI(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	ctx := context.Background()
+	if err := CloseWasmContext(ctx); err != nil {
+		t.Fatalf("CloseWasmContext: %v", err)
+	}
+	rt := wazero.NewRuntime(ctx)
+	defer rt.Close(ctx)
+	if err := Configure(WithRuntime(rt), WithWASI(false)); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	defer func() {
+		CloseWasmContext(ctx)
+		Configure(WithRuntime(nil), WithWASI(true))
+	}()
+
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if _, err := dec.Decode(data[:n], pcm); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+
+	// Only the imports are stubbed, not all of WASI.
+	wasi := rt.Module(wasi_snapshot_preview1.ModuleName)
+	if wasi == nil {
+		t.Fatalf("WASI stubs not instantiated in the runtime")
+	}
+	defs := wasi.ExportedFunctionDefinitions()
+	if _, ok := defs["fd_write"]; !ok {
+		t.Errorf("fd_write not stubbed")
+	}
+	if _, ok := defs["proc_exit"]; ok {
+		t.Errorf("Stubs export functions the module doesn't import")
+	}
+}
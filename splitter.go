@@ -0,0 +1,134 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultSilenceThreshold is the level, in dBFS, below which decoded audio is
+// considered silent by SilenceSplitter.
+const DefaultSilenceThreshold = -50.0
+
+// Segment is a contiguous range of frames produced by SilenceSplitter.
+type Segment struct {
+	// Start and End delimit the frames of the segment: frames[Start:End].
+	Start, End int
+	// StartPTS and EndPTS are the stream positions of the segment bounds.
+	StartPTS, EndPTS time.Duration
+}
+
+// Duration returns the length of the segment.
+func (s Segment) Duration() time.Duration {
+	return s.EndPTS - s.StartPTS
+}
+
+// SilenceSplitter splits a recording into segments at silence boundaries.
+//
+// Without a Decoder only DTX packets (2 bytes or less) count as silence, which
+// is cheap and works well for streams encoded with DTX enabled. With a
+// Decoder every frame is decoded and frames whose level is below Threshold
+// count as silence as well.
+//
+// Segments are contiguous and cover the whole input: each silence run of at
+// least MinSilence is cut in its middle, so every segment keeps some leading
+// and trailing silence and no packet is dropped.
+type SilenceSplitter struct {
+	// MinSilence is the shortest silence run that separates two segments.
+	MinSilence time.Duration
+	// Threshold is the decoded level in dBFS below which a frame is silent.
+	// Zero means DefaultSilenceThreshold.
+	Threshold float64
+	// Decoder, if set, is used to measure the level of decoded audio.
+	Decoder *Decoder
+}
+
+// Split returns the segments of frames.
+func (s *SilenceSplitter) Split(frames []Frame) ([]Segment, error) {
+	silent, err := s.classify(frames)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []Segment
+	start := 0
+	for i := 0; i < len(frames); {
+		if !silent[i] {
+			i++
+			continue
+		}
+		runStart := i
+		for i < len(frames) && silent[i] {
+			i++
+		}
+		if runStart == 0 || i == len(frames) {
+			// Leading and trailing silence stay attached to their segment.
+			continue
+		}
+		if frames[i-1].End()-frames[runStart].PTS < s.MinSilence {
+			continue
+		}
+		cut := (runStart + i) / 2
+		segments = append(segments, makeSegment(frames, start, cut))
+		start = cut
+	}
+	if start < len(frames) {
+		segments = append(segments, makeSegment(frames, start, len(frames)))
+	}
+	return segments, nil
+}
+
+func makeSegment(frames []Frame, start, end int) Segment {
+	return Segment{
+		Start:    start,
+		End:      end,
+		StartPTS: frames[start].PTS,
+		EndPTS:   frames[end-1].End(),
+	}
+}
+
+// classify reports, for every frame, whether it is silent.
+func (s *SilenceSplitter) classify(frames []Frame) ([]bool, error) {
+	silent := make([]bool, len(frames))
+	threshold := s.Threshold
+	if threshold == 0 {
+		threshold = DefaultSilenceThreshold
+	}
+	var pcm []float32
+	if s.Decoder != nil {
+		pcm = make([]float32, maxFrameSize48k*s.Decoder.channels)
+	}
+	for i, f := range frames {
+		if f.Lost {
+			continue
+		}
+		if IsDTXPacket(f.Data) {
+			silent[i] = true
+			continue
+		}
+		if s.Decoder == nil {
+			continue
+		}
+		n, err := s.Decoder.DecodeFloat32(f.Data, pcm)
+		if err != nil {
+			return nil, err
+		}
+		silent[i] = levelDBFS(pcm[:n*s.Decoder.channels]) < threshold
+	}
+	return silent, nil
+}
+
+// levelDBFS returns the RMS level of pcm in dBFS.
+func levelDBFS(pcm []float32) float64 {
+	if len(pcm) == 0 {
+		return math.Inf(-1)
+	}
+	var sum float64
+	for _, v := range pcm {
+		sum += float64(v) * float64(v)
+	}
+	return 10 * math.Log10(sum/float64(len(pcm)))
+}
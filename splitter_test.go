@@ -0,0 +1,99 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+	"time"
+)
+
+// framesFromPattern builds 20 ms frames where 's' marks a DTX packet and any
+// other character an audio packet.
+func framesFromPattern(pattern string) []Frame {
+	frames := make([]Frame, len(pattern))
+	for i, c := range pattern {
+		data := []byte{0x08, 1, 2, 3}
+		if c == 's' {
+			data = data[:1]
+		}
+		frames[i] = Frame{
+			Data:     data,
+			PTS:      time.Duration(i) * 20 * time.Millisecond,
+			Duration: 20 * time.Millisecond,
+		}
+	}
+	return frames
+}
+
+func TestSilenceSplitterDTX(t *testing.T) {
+	frames := framesFromPattern("ssaaaassssssaaasaaassssss")
+	splitter := SilenceSplitter{MinSilence: 100 * time.Millisecond}
+	segments, err := splitter.Split(frames)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Only the 6-frame run splits; the single DTX frame and the trailing
+	// silence don't.
+	want := []Segment{
+		{Start: 0, End: 9, StartPTS: 0, EndPTS: 180 * time.Millisecond},
+		{Start: 9, End: 25, StartPTS: 180 * time.Millisecond, EndPTS: 500 * time.Millisecond},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("Expected %d segments, got %+v", len(want), segments)
+	}
+	for i := range want {
+		if segments[i] != want[i] {
+			t.Errorf("Segment %d: got %+v, want %+v", i, segments[i], want[i])
+		}
+	}
+}
+
+func TestSilenceSplitterDecoded(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	tone := make([]int16, FRAME_SIZE)
+	addSine(tone, SAMPLE_RATE, 440)
+	silence := make([]int16, FRAME_SIZE)
+
+	var frames []Frame
+	for i, c := range "aaaaassssssssssaaaaa" {
+		pcm := tone
+		if c == 's' {
+			pcm = silence
+		}
+		data := make([]byte, 1000)
+		n, err := enc.Encode(pcm, data)
+		if err != nil {
+			t.Fatalf("Couldn't encode data: %v", err)
+		}
+		frames = append(frames, Frame{
+			Data:     data[:n],
+			PTS:      time.Duration(i) * 20 * time.Millisecond,
+			Duration: 20 * time.Millisecond,
+		})
+	}
+	splitter := SilenceSplitter{MinSilence: 100 * time.Millisecond, Decoder: dec}
+	segments, err := splitter.Split(frames)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("Expected 2 segments, got %+v", segments)
+	}
+	if segments[0].Start != 0 || segments[1].End != len(frames) || segments[0].End != segments[1].Start {
+		t.Errorf("Segments are not contiguous: %+v", segments)
+	}
+	if cut := segments[0].End; cut < 7 || cut > 13 {
+		t.Errorf("Unexpected cut position %d", cut)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CodecStats is a snapshot of the counters kept for all the Encoders and
+// Decoders of the process since it started. The counters only grow, so a
+// monitoring system should export them as counters and compute rates from
+// the difference between two snapshots.
+type CodecStats struct {
+	// FramesEncoded and BytesEncoded count the packets produced and their
+	// size.
+	FramesEncoded, BytesEncoded uint64
+	// FramesDecoded and BytesDecoded count the packets decoded.
+	FramesDecoded, BytesDecoded uint64
+	// FECFrames counts the frames recovered from the in-band FEC of the
+	// next packet, PLCFrames the ones concealed without any data.
+	FECFrames, PLCFrames uint64
+	// Mallocs counts the allocations made in wasm memory. It stops growing
+	// once the codecs' buffers have reached their working size.
+	Mallocs uint64
+	// EncodeTime and DecodeTime are the time spent in libopus calls.
+	EncodeTime, DecodeTime time.Duration
+}
+
+// EncodeLatency returns the average time taken to encode a frame.
+func (s CodecStats) EncodeLatency() time.Duration {
+	if s.FramesEncoded == 0 {
+		return 0
+	}
+	return s.EncodeTime / time.Duration(s.FramesEncoded)
+}
+
+// DecodeLatency returns the average time taken to decode, recover or
+// conceal a frame.
+func (s CodecStats) DecodeLatency() time.Duration {
+	calls := s.FramesDecoded + s.FECFrames + s.PLCFrames
+	if calls == 0 {
+		return 0
+	}
+	return s.DecodeTime / time.Duration(calls)
+}
+
+// codecCounters holds the counters behind Stats.
+type codecCounters struct {
+	framesEncoded, bytesEncoded atomic.Uint64
+	framesDecoded, bytesDecoded atomic.Uint64
+	fecFrames, plcFrames        atomic.Uint64
+	mallocs                     atomic.Uint64
+	encodeTime, decodeTime      atomic.Int64
+}
+
+var stats codecCounters
+
+// encoded counts a packet of n bytes encoded in d.
+func (c *codecCounters) encoded(n int, d time.Duration) {
+	c.framesEncoded.Add(1)
+	c.bytesEncoded.Add(uint64(n))
+	c.encodeTime.Add(int64(d))
+}
+
+// decoded counts a call to opus_decode with a packet of n bytes, or none
+// for PLC, which took d.
+func (c *codecCounters) decoded(n int, decodeFEC int, d time.Duration) {
+	switch {
+	case decodeFEC != 0:
+		c.fecFrames.Add(1)
+	case n == 0:
+		c.plcFrames.Add(1)
+	default:
+		c.framesDecoded.Add(1)
+		c.bytesDecoded.Add(uint64(n))
+	}
+	c.decodeTime.Add(int64(d))
+}
+
+// Stats returns the current value of the codec counters. It is cheap enough
+// to call on every scrape of a metrics endpoint.
+func Stats() CodecStats {
+	return CodecStats{
+		FramesEncoded: stats.framesEncoded.Load(),
+		BytesEncoded:  stats.bytesEncoded.Load(),
+		FramesDecoded: stats.framesDecoded.Load(),
+		BytesDecoded:  stats.bytesDecoded.Load(),
+		FECFrames:     stats.fecFrames.Load(),
+		PLCFrames:     stats.plcFrames.Load(),
+		Mallocs:       stats.mallocs.Load(),
+		EncodeTime:    time.Duration(stats.encodeTime.Load()),
+		DecodeTime:    time.Duration(stats.decodeTime.Load()),
+	}
+}
+
+// WatchStats calls f with a snapshot of the counters every interval, for
+// monitoring systems that are pushed to rather than scraped. It returns a
+// function stopping the calls; f isn't called anymore once it returned, so
+// it must not be called from f.
+func WatchStats(interval time.Duration, f func(CodecStats)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				f(Stats())
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once atomic.Bool
+	return func() {
+		if once.CompareAndSwap(false, true) {
+			ticker.Stop()
+			close(done)
+		}
+		<-stopped
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	dec, err := NewDecoder(SAMPLE_RATE, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+
+	before := Stats()
+	pcm := make([]int16, FRAME_SIZE)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(pcm, data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if _, err := dec.Decode(data[:n], pcm); err != nil {
+		t.Fatalf("Couldn't decode data: %v", err)
+	}
+	if _, err := dec.DecodePLC(pcm); err != nil {
+		t.Fatalf("Couldn't conceal frame: %v", err)
+	}
+	if _, err := dec.DecodeFEC(data[:n], pcm); err != nil {
+		t.Fatalf("Couldn't decode FEC: %v", err)
+	}
+	after := Stats()
+
+	// Other tests may run in parallel, so only check for growth.
+	if after.FramesEncoded-before.FramesEncoded < 1 || after.BytesEncoded-before.BytesEncoded < uint64(n) {
+		t.Errorf("Encoded counters didn't grow: %+v -> %+v", before, after)
+	}
+	if after.FramesDecoded-before.FramesDecoded < 1 || after.BytesDecoded-before.BytesDecoded < uint64(n) {
+		t.Errorf("Decoded counters didn't grow: %+v -> %+v", before, after)
+	}
+	if after.PLCFrames-before.PLCFrames < 1 || after.FECFrames-before.FECFrames < 1 {
+		t.Errorf("PLC and FEC counters didn't grow: %+v -> %+v", before, after)
+	}
+	if after.Mallocs == 0 {
+		t.Errorf("No wasm allocations counted")
+	}
+	if after.EncodeLatency() <= 0 || after.DecodeLatency() <= 0 {
+		t.Errorf("Expected positive latencies, got %v and %v", after.EncodeLatency(), after.DecodeLatency())
+	}
+}
+
+func TestWatchStats(t *testing.T) {
+	var calls atomic.Int32
+	stop := WatchStats(time.Millisecond, func(CodecStats) { calls.Add(1) })
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	stop()
+	got := calls.Load()
+	if got < 2 {
+		t.Fatalf("Expected at least 2 callbacks, got %d", got)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if calls.Load() != got {
+		t.Errorf("Callback called after stop")
+	}
+}
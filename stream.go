@@ -0,0 +1,82 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"fmt"
+	"io"
+)
+
+// Stream decodes an Ogg Opus stream read from an io.Reader, with the same
+// API as the Stream type of github.com/hraban/opus (a libopusfile wrapper),
+// so code using that package can switch to this one by changing the import.
+// Output is 48 kHz, interleaved, with the stream's channel count. Use File
+// for seeking and gain control.
+type Stream struct {
+	read io.Reader
+	f    *File
+}
+
+// NewStream creates and initializes a Stream reading from read.
+func NewStream(read io.Reader) (*Stream, error) {
+	var s Stream
+	if err := s.Init(read); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Init reads the Ogg Opus headers from read and prepares the stream for
+// decoding. It is only needed for a Stream not created by NewStream.
+func (s *Stream) Init(read io.Reader) error {
+	if s.f != nil {
+		return fmt.Errorf("opus: stream is already initialized")
+	}
+	if read == nil {
+		return fmt.Errorf("opus: reader must be non-nil")
+	}
+	f, err := newFile(read)
+	if err != nil {
+		return err
+	}
+	s.read, s.f = read, f
+	return nil
+}
+
+// Read decodes the next chunk of audio into pcm and returns the number of
+// samples per channel decoded. It returns io.EOF at the end of the stream.
+func (s *Stream) Read(pcm []int16) (int, error) {
+	if s.f == nil {
+		return 0, fmt.Errorf("opus: stream is uninitialized or already closed")
+	}
+	if len(pcm) == 0 {
+		return 0, fmt.Errorf("opus: no data supplied")
+	}
+	return s.f.Read(pcm)
+}
+
+// ReadFloat32 is Read for float32 samples.
+func (s *Stream) ReadFloat32(pcm []float32) (int, error) {
+	if s.f == nil {
+		return 0, fmt.Errorf("opus: stream is uninitialized or already closed")
+	}
+	if len(pcm) == 0 {
+		return 0, fmt.Errorf("opus: no data supplied")
+	}
+	return s.f.ReadFloat(pcm)
+}
+
+// Close releases the stream and closes the underlying reader if it is an
+// io.Closer.
+func (s *Stream) Close() error {
+	if s.f == nil {
+		return fmt.Errorf("opus: stream is uninitialized or already closed")
+	}
+	s.f = nil
+	if closer, ok := s.read.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
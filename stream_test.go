@@ -0,0 +1,84 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	data, err := os.ReadFile("testdata/speech_8.opus")
+	if err != nil {
+		t.Fatalf("Error reading test file: %v", err)
+	}
+	// A plain io.Reader, as from a network connection.
+	s, err := NewStream(io.MultiReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("Error creating new stream: %v", err)
+	}
+	f, err := OpenFile("testdata/speech_8.opus")
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	defer f.Close()
+	duration, err := f.Duration()
+	if err != nil {
+		t.Fatalf("Duration: %v", err)
+	}
+
+	pcm := make([]int16, 2000)
+	total := 0
+	for {
+		n, err := s.Read(pcm)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		total += n
+	}
+	if want := int(duration.Seconds() * 48000); total != want {
+		t.Errorf("Read %d samples, want %d", total, want)
+	}
+	if _, err := s.Read(nil); err == nil {
+		t.Errorf("Expected error for an empty buffer")
+	}
+	if err := s.Init(bytes.NewReader(data)); err == nil {
+		t.Errorf("Expected error initializing a stream twice")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := s.ReadFloat32(make([]float32, 100)); err == nil {
+		t.Errorf("Expected error reading a closed stream")
+	}
+	if err := s.Close(); err == nil {
+		t.Errorf("Expected error closing a stream twice")
+	}
+
+	// A zero Stream works after Init, and Close closes the source.
+	var s2 Stream
+	file, err := os.Open("testdata/speech_8.opus")
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	if err := s2.Init(file); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	fpcm := make([]float32, 2000)
+	if n, err := s2.ReadFloat32(fpcm); err != nil || n == 0 {
+		t.Errorf("ReadFloat32 returned %d, %v", n, err)
+	}
+	if err := s2.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := file.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Close didn't close the source file")
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/godeps/opus/oggopus"
+)
+
+// StreamWriter encodes raw PCM written to it into an Ogg Opus stream, the
+// inverse of Stream. It implements io.WriteCloser.
+type StreamWriter struct {
+	ogg      *oggopus.Writer
+	enc      *Encoder
+	ctx      context.Context // interrupts encoding for TranscodeContext
+	channels int
+	// scale converts samples at the input rate to 48 kHz granule units.
+	scale   int64
+	preSkip int64
+
+	frames  *FrameBuffer[int16]
+	odd     []byte // half of a sample split across Write calls
+	samples []int16
+	packet  []byte
+	// written counts the input samples per channel encoded, granule the
+	// 48 kHz position at the end of the packets written.
+	written int64
+	granule int64
+	err     error
+	closed  bool
+}
+
+// NewStreamWriter returns a StreamWriter encoding interleaved little-endian
+// 16-bit PCM at sampleRate (8, 12, 16, 24 or 48 kHz) with the given number
+// of channels into an Ogg Opus stream written to w. Streams with more than
+// two channels use the Vorbis channel order. The audio is cut into 20 ms
+// frames unless WithFrameDuration says otherwise.
+//
+// Close must be called to encode the final partial frame and end the
+// stream; it doesn't close w.
+func NewStreamWriter(w io.Writer, sampleRate, channels int, opts ...EncoderOption) (*StreamWriter, error) {
+	o, err := newEncoderOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := defaultMapping(channels)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := NewMultistreamEncoder(sampleRate, mapping, o.application)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Apply(o.config); err != nil {
+		return nil, err
+	}
+	lookahead, err := enc.Lookahead()
+	if err != nil {
+		return nil, err
+	}
+	frames, err := NewFrameBuffer[int16](sampleRate, channels, o.frameSize)
+	if err != nil {
+		return nil, err
+	}
+	if o.inputSampleRate == 0 {
+		o.inputSampleRate = sampleRate
+	}
+	scale := int64(48000 / sampleRate)
+	head := oggopus.OpusHead{
+		Channels:        channels,
+		PreSkip:         lookahead * int(scale),
+		InputSampleRate: o.inputSampleRate,
+		MappingFamily:   int(mapping.Family),
+	}
+	if mapping.Family != MappingFamilyRTP {
+		head.StreamCount, head.CoupledCount = mapping.Streams, mapping.CoupledStreams
+		head.ChannelMapping = mapping.Table
+	}
+	vendor, err := VersionErr()
+	if err != nil {
+		return nil, err
+	}
+	tags := oggopus.OpusTags{Vendor: vendor, Comments: o.comments}
+	ogg, err := oggopus.NewWriter(w, head, tags)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamWriter{
+		ogg:      ogg,
+		enc:      enc,
+		ctx:      context.Background(),
+		channels: channels,
+		scale:    scale,
+		preSkip:  int64(head.PreSkip),
+		frames:   frames,
+		packet:   make([]byte, DefaultMaxPacketSize),
+	}, nil
+}
+
+// Encoder returns the underlying encoder, e.g. to change its bitrate
+// between writes.
+func (s *StreamWriter) Encoder() *Encoder {
+	return s.enc
+}
+
+// Write encodes p, interleaved little-endian int16 samples. Samples may be
+// split across calls; audio is encoded a frame at a time as it fills up.
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("opus: write to closed StreamWriter")
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := len(p)
+	s.samples = s.samples[:0]
+	if len(s.odd) > 0 && len(p) > 0 {
+		s.odd = append(s.odd, p[0])
+		p = p[1:]
+		s.samples = append(s.samples, int16(binary.LittleEndian.Uint16(s.odd)))
+		s.odd = s.odd[:0]
+	}
+	for ; len(p) >= 2; p = p[2:] {
+		s.samples = append(s.samples, int16(binary.LittleEndian.Uint16(p)))
+	}
+	s.odd = append(s.odd, p...)
+	if err := s.writeSamples(s.samples); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// writeSamples buffers interleaved samples and encodes every complete
+// frame.
+func (s *StreamWriter) writeSamples(pcm []int16) error {
+	s.frames.Write(pcm)
+	for {
+		frame, ok := s.frames.Next()
+		if !ok {
+			return nil
+		}
+		s.written += int64(s.frames.FrameSize())
+		if err := s.encodeFrame(frame, -1); err != nil {
+			return err
+		}
+	}
+}
+
+// encodeFrame encodes frame and writes the packet. end is the granule
+// position at which the stream ends, or -1 if it isn't known yet.
+func (s *StreamWriter) encodeFrame(frame []int16, end int64) error {
+	n, err := s.enc.EncodeContext(s.ctx, frame, s.packet)
+	if err != nil {
+		s.err = err
+		return err
+	}
+	s.granule += int64(s.frames.FrameSize()) * s.scale
+	granule := s.granule
+	if end >= 0 {
+		granule = min(granule, end)
+	}
+	if err := s.ogg.WritePacket(s.packet[:n], granule); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+// Flush writes the packets encoded so far to the underlying writer as
+// complete pages. The partial frame still buffered is not encoded.
+func (s *StreamWriter) Flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.ogg.Flush()
+}
+
+// Close pads and encodes the final partial frame, encodes enough silence
+// to flush the encoder's lookahead, and ends the Ogg stream with the exact
+// length of the input. A trailing odd byte is dropped.
+func (s *StreamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.err != nil {
+		return s.err
+	}
+	s.written += int64(s.frames.Buffered())
+	end := s.preSkip + s.written*s.scale
+	silence := make([]int16, s.frames.FrameSize()*s.channels)
+	for s.granule < end {
+		frame, ok := s.frames.Flush()
+		if !ok {
+			frame = silence
+		}
+		if err := s.encodeFrame(frame, end); err != nil {
+			return err
+		}
+	}
+	return s.ogg.Close()
+}
@@ -0,0 +1,132 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godeps/opus/wav"
+)
+
+// readAllFile decodes the whole Ogg Opus stream in data.
+func readAllFile(t *testing.T, data []byte) (*File, []int16) {
+	f, err := OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Error opening the written stream: %v", err)
+	}
+	var out []int16
+	pcm := make([]int16, maxPacketSamples*f.Channels())
+	for {
+		n, err := f.Read(pcm)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		out = append(out, pcm[:n*f.Channels()]...)
+	}
+	return f, out
+}
+
+func TestStreamWriter(t *testing.T) {
+	pcm, format, err := wav.ReadFile("testdata/speech_8.wav")
+	if err != nil {
+		t.Fatalf("Error reading test file: %v", err)
+	}
+	var buf bytes.Buffer
+	w, err := NewStreamWriter(&buf, format.SampleRate, format.Channels, WithBitrate(32000), WithComment("TITLE", "speech"))
+	if err != nil {
+		t.Fatalf("Error creating new stream writer: %v", err)
+	}
+	raw := make([]byte, 2*len(pcm))
+	for i, v := range pcm {
+		binary.LittleEndian.PutUint16(raw[2*i:], uint16(v))
+	}
+	// Odd-sized writes split samples across calls.
+	for p := raw; len(p) > 0; {
+		n := min(len(p), 777)
+		if m, err := w.Write(p[:n]); err != nil || m != n {
+			t.Fatalf("Write returned %d, %v", m, err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := w.Write(raw[:2]); err == nil {
+		t.Errorf("Expected error writing to a closed StreamWriter")
+	}
+
+	f, out := readAllFile(t, buf.Bytes())
+	tags := f.Tags()
+	if title, _ := tags.Get("TITLE"); title != "speech" || !strings.HasPrefix(tags.Vendor, "libopus") {
+		t.Errorf("Unexpected tags %+v", tags)
+	}
+	if d, err := f.Duration(); err != nil || d != time.Duration(len(pcm))*time.Second/48000 {
+		t.Errorf("Duration() = %v, %v", d, err)
+	}
+	if len(out) != len(pcm) {
+		t.Fatalf("Decoded %d samples, want %d", len(out), len(pcm))
+	}
+	// The decoded audio is aligned with the input: the error is well below
+	// the signal.
+	var signal, noise float64
+	for i := range pcm {
+		d := float64(out[i]) - float64(pcm[i])
+		signal += float64(pcm[i]) * float64(pcm[i])
+		noise += d * d
+	}
+	if noise > signal/4 {
+		t.Errorf("Decoded audio differs too much: signal %g, noise %g", signal, noise)
+	}
+}
+
+func TestStreamWriterChannels(t *testing.T) {
+	for _, c := range []struct{ sampleRate, channels int }{{16000, 2}, {48000, 6}} {
+		var buf bytes.Buffer
+		w, err := NewStreamWriter(&buf, c.sampleRate, c.channels, WithFrameDuration(10*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Error creating new stream writer: %v", err)
+		}
+		samples := c.sampleRate/3 + 17 // not a whole number of frames
+		mono := make([]int16, samples)
+		addSine(mono, c.sampleRate, 440)
+		raw := make([]byte, 0, 2*samples*c.channels)
+		for _, v := range mono {
+			for ch := 0; ch < c.channels; ch++ {
+				raw = binary.LittleEndian.AppendUint16(raw, uint16(v/2))
+			}
+		}
+		if _, err := w.Write(raw); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		f, out := readAllFile(t, buf.Bytes())
+		head := f.Head()
+		if head.Channels != c.channels || head.InputSampleRate != c.sampleRate {
+			t.Errorf("Unexpected head %+v", head)
+		}
+		if want := samples * 48000 / c.sampleRate * c.channels; len(out) != want {
+			t.Errorf("%+v: decoded %d samples, want %d", c, len(out), want)
+		}
+	}
+	if _, err := NewStreamWriter(io.Discard, 48000, 2, WithFrameDuration(15*time.Millisecond)); err == nil {
+		t.Errorf("Expected error for an invalid frame duration")
+	}
+	if _, err := NewStreamWriter(io.Discard, 44100, 2); err == nil {
+		t.Errorf("Expected error for an unsupported sample rate")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/godeps/opus/wav"
+)
+
+// PCMFormat describes the raw audio read by Transcode.
+type PCMFormat struct {
+	SampleRate int
+	Channels   int
+	// Float selects little-endian float32 samples instead of int16.
+	Float bool
+	// WAV means the source is a WAV file; the other fields are then taken
+	// from its header.
+	WAV bool
+}
+
+// transcodeBlock is the number of samples per channel Transcode reads at a
+// time.
+const transcodeBlock = 4096
+
+// Transcode encodes the interleaved PCM (or WAV file) read from src into an
+// Ogg Opus stream written to dst, until src returns io.EOF. Audio at sample
+// rates Opus doesn't support, such as 44.1 kHz, is resampled to 48 kHz; the
+// OpusHead header records the original rate. dst is not closed.
+func Transcode(dst io.Writer, src io.Reader, srcFormat PCMFormat, opts ...EncoderOption) error {
+	return TranscodeContext(context.Background(), dst, src, srcFormat, opts...)
+}
+
+// TranscodeContext is Transcode with a context. Cancelling ctx interrupts
+// the frame being encoded and makes TranscodeContext return ctx's error; a
+// read from src that blocks is not interrupted.
+func TranscodeContext(ctx context.Context, dst io.Writer, src io.Reader, srcFormat PCMFormat, opts ...EncoderOption) error {
+	var read func(pcm []float32) (int, error)
+	if srcFormat.WAV {
+		r, err := wav.NewReader(src)
+		if err != nil {
+			return err
+		}
+		srcFormat.SampleRate, srcFormat.Channels = r.Format().SampleRate, r.Format().Channels
+		read = r.ReadFloat32
+	} else {
+		if srcFormat.SampleRate <= 0 || srcFormat.Channels <= 0 {
+			return fmt.Errorf("opus: invalid sample rate %d or channel count %d", srcFormat.SampleRate, srcFormat.Channels)
+		}
+		read = (&rawPCMReader{r: src, format: srcFormat}).read
+	}
+	channels := srcFormat.Channels
+
+	rate := srcFormat.SampleRate
+	var resampler *linearResampler
+	if !isOpusSampleRate(rate) {
+		resampler = newLinearResampler(rate, 48000, channels)
+		rate = 48000
+	}
+	w, err := NewStreamWriter(dst, rate, channels, append(opts, withInputSampleRate(srcFormat.SampleRate))...)
+	if err != nil {
+		return err
+	}
+	w.ctx = ctx
+
+	in := make([]float32, transcodeBlock*channels)
+	var resampled []float32
+	var pcm []int16
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := read(in)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		samples := in[:n*channels]
+		if resampler != nil {
+			size := resampler.outputLen(n) * channels
+			if cap(resampled) < size {
+				resampled = make([]float32, size)
+			}
+			m := resampler.process(samples, resampled[:size])
+			samples = resampled[:m*channels]
+		}
+		pcm = pcm[:0]
+		for _, v := range samples {
+			pcm = append(pcm, floatToInt16(v))
+		}
+		if err := w.writeSamples(pcm); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// rawPCMReader reads headerless little-endian PCM as float32 samples.
+type rawPCMReader struct {
+	r      io.Reader
+	format PCMFormat
+	buf    []byte
+}
+
+// read reads up to len(pcm) samples, whole samples per channel only, and
+// returns the number of samples per channel read. A trailing partial sample
+// is dropped.
+func (r *rawPCMReader) read(pcm []float32) (int, error) {
+	width := 2
+	if r.format.Float {
+		width = 4
+	}
+	frame := width * r.format.Channels
+	size := len(pcm) / r.format.Channels * frame
+	if cap(r.buf) < size {
+		r.buf = make([]byte, size)
+	}
+	n, err := io.ReadFull(r.r, r.buf[:size])
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	frames := n / frame
+	if frames == 0 {
+		return 0, io.EOF
+	}
+	for i := 0; i < frames*r.format.Channels; i++ {
+		if r.format.Float {
+			pcm[i] = math.Float32frombits(binary.LittleEndian.Uint32(r.buf[4*i:]))
+		} else {
+			pcm[i] = float32(int16(binary.LittleEndian.Uint16(r.buf[2*i:]))) / 32768
+		}
+	}
+	return frames, nil
+}
@@ -0,0 +1,81 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTranscodeWAV(t *testing.T) {
+	src, err := os.Open("testdata/speech_8.wav")
+	if err != nil {
+		t.Fatalf("Error opening test file: %v", err)
+	}
+	defer src.Close()
+	var dst bytes.Buffer
+	if err := Transcode(&dst, src, PCMFormat{WAV: true}, WithBitrate(24000)); err != nil {
+		t.Fatalf("Transcode: %v", err)
+	}
+	f, out := readAllFile(t, dst.Bytes())
+	if f.Head().InputSampleRate != 48000 {
+		t.Errorf("Unexpected head %+v", f.Head())
+	}
+	if want := 0x0fd200 / 2; len(out) != want {
+		t.Errorf("Decoded %d samples, want %d", len(out), want)
+	}
+}
+
+func TestTranscodeResample(t *testing.T) {
+	const rate = 44100
+	var src bytes.Buffer
+	for i := 0; i < rate; i++ {
+		v := float32(0.5 * math.Sin(2*math.Pi*440*float64(i)/rate))
+		src.Write(binary.LittleEndian.AppendUint32(nil, math.Float32bits(v)))
+		src.Write(binary.LittleEndian.AppendUint32(nil, math.Float32bits(-v)))
+	}
+	var dst bytes.Buffer
+	if err := Transcode(&dst, &src, PCMFormat{SampleRate: rate, Channels: 2, Float: true}); err != nil {
+		t.Fatalf("Transcode: %v", err)
+	}
+	f, out := readAllFile(t, dst.Bytes())
+	if h := f.Head(); h.InputSampleRate != rate || h.Channels != 2 {
+		t.Errorf("Unexpected head %+v", h)
+	}
+	if d, err := f.Duration(); err != nil || d < time.Second-time.Millisecond || d > time.Second+time.Millisecond {
+		t.Errorf("Duration() = %v, %v; want about 1s", d, err)
+	}
+	var energy float64
+	for i := 0; i < len(out); i += 2 {
+		energy += float64(out[i]) * float64(out[i])
+	}
+	if energy == 0 {
+		t.Errorf("Decoded only silence")
+	}
+
+	if err := Transcode(&dst, &src, PCMFormat{SampleRate: rate}); err == nil {
+		t.Errorf("Expected error for a format without channels")
+	}
+	if err := Transcode(&dst, bytes.NewReader([]byte("not a wav file")), PCMFormat{WAV: true}); err == nil {
+		t.Errorf("Expected error for an invalid WAV file")
+	}
+}
+
+func TestTranscodeContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	src := bytes.NewReader(make([]byte, 48000*2))
+	var dst bytes.Buffer
+	err := TranscodeContext(ctx, &dst, src, PCMFormat{SampleRate: 48000, Channels: 1})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"time"
+)
+
+// VoiceEventType distinguishes the start and the end of a speech segment.
+type VoiceEventType int
+
+const (
+	// SpeechStart is emitted when the encoder leaves DTX.
+	SpeechStart VoiceEventType = iota
+	// SpeechEnd is emitted once the encoder has stayed in DTX for longer
+	// than the hangover.
+	SpeechEnd
+)
+
+func (t VoiceEventType) String() string {
+	switch t {
+	case SpeechStart:
+		return "speech start"
+	case SpeechEnd:
+		return "speech end"
+	}
+	return "unknown"
+}
+
+// VoiceEvent marks a boundary of a speech segment.
+type VoiceEvent struct {
+	Type VoiceEventType
+	// PTS is the stream position of the boundary: the start of the first
+	// active frame for SpeechStart, the end of the last active frame for
+	// SpeechEnd.
+	PTS time.Duration
+}
+
+// VoiceSegmenter encodes audio and reports speech segments from the encoder's
+// DTX state, so recording or transcription can be triggered without running
+// a second voice activity detector over the same audio.
+//
+// A segment ends only after the encoder has been in DTX for at least the
+// hangover duration, so short pauses don't split a segment.
+type VoiceSegmenter struct {
+	enc      *Encoder
+	hangover time.Duration
+	onEvent  func(VoiceEvent)
+
+	pts        time.Duration // start of the next frame
+	inSpeech   bool
+	lastActive time.Duration // end of the last active frame
+}
+
+// NewVoiceSegmenter enables DTX on enc and returns a segmenter that calls
+// onEvent for every speech boundary. onEvent is called synchronously from
+// Encode, EncodeFloat32 and Flush.
+func NewVoiceSegmenter(enc *Encoder, hangover time.Duration, onEvent func(VoiceEvent)) (*VoiceSegmenter, error) {
+	if err := enc.SetDTX(true); err != nil {
+		return nil, err
+	}
+	return &VoiceSegmenter{
+		enc:      enc,
+		hangover: hangover,
+		onEvent:  onEvent,
+	}, nil
+}
+
+// Encode encodes pcm with the underlying encoder and updates the speech state.
+func (s *VoiceSegmenter) Encode(pcm []int16, data []byte) (int, error) {
+	n, err := s.enc.Encode(pcm, data)
+	if err != nil {
+		return n, err
+	}
+	return n, s.update(len(pcm))
+}
+
+// EncodeFloat32 encodes pcm with the underlying encoder and updates the speech
+// state.
+func (s *VoiceSegmenter) EncodeFloat32(pcm []float32, data []byte) (int, error) {
+	n, err := s.enc.EncodeFloat32(pcm, data)
+	if err != nil {
+		return n, err
+	}
+	return n, s.update(len(pcm))
+}
+
+// Flush ends the current speech segment, if any, without waiting for the
+// hangover. Call it when the stream ends.
+func (s *VoiceSegmenter) Flush() {
+	if s.inSpeech {
+		s.inSpeech = false
+		s.emit(VoiceEvent{Type: SpeechEnd, PTS: s.lastActive})
+	}
+}
+
+// InSpeech reports whether the segmenter is inside a speech segment.
+func (s *VoiceSegmenter) InSpeech() bool {
+	return s.inSpeech
+}
+
+func (s *VoiceSegmenter) update(samples int) error {
+	start := s.pts
+	s.pts += time.Duration(samples/s.enc.channels) * time.Second / time.Duration(s.enc.sampleRate)
+
+	inDTX, err := s.enc.InDTX()
+	if err != nil {
+		return err
+	}
+	if !inDTX {
+		if !s.inSpeech {
+			s.inSpeech = true
+			s.emit(VoiceEvent{Type: SpeechStart, PTS: start})
+		}
+		s.lastActive = s.pts
+		return nil
+	}
+	if s.inSpeech && s.pts-s.lastActive >= s.hangover {
+		s.inSpeech = false
+		s.emit(VoiceEvent{Type: SpeechEnd, PTS: s.lastActive})
+	}
+	return nil
+}
+
+func (s *VoiceSegmenter) emit(ev VoiceEvent) {
+	if s.onEvent != nil {
+		s.onEvent(ev)
+	}
+}
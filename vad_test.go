@@ -0,0 +1,63 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVoiceSegmenter(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	const FRAME_SIZE = SAMPLE_RATE * 20 / 1000
+	enc, err := NewEncoder(SAMPLE_RATE, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	var events []VoiceEvent
+	seg, err := NewVoiceSegmenter(enc, 100*time.Millisecond, func(ev VoiceEvent) {
+		events = append(events, ev)
+	})
+	if err != nil {
+		t.Fatalf("Error creating voice segmenter: %v", err)
+	}
+
+	speech := make([]int16, FRAME_SIZE)
+	addSine(speech, SAMPLE_RATE, 440)
+	silence := make([]int16, FRAME_SIZE)
+	data := make([]byte, 1000)
+	encode := func(pcm []int16, frames int) {
+		for i := 0; i < frames; i++ {
+			if _, err := seg.Encode(pcm, data); err != nil {
+				t.Fatalf("Couldn't encode data: %v", err)
+			}
+		}
+	}
+
+	encode(speech, 10)
+	if len(events) != 1 || events[0].Type != SpeechStart || events[0].PTS != 0 {
+		t.Fatalf("Expected speech start at 0, got %+v", events)
+	}
+	// libopus needs a few hundred ms of silence before it enters DTX.
+	encode(silence, 50)
+	if len(events) != 2 || events[1].Type != SpeechEnd {
+		t.Fatalf("Expected speech end after silence, got %+v", events)
+	}
+	if end := events[1].PTS; end < 200*time.Millisecond || end > time.Second {
+		t.Errorf("Unexpected speech end position %v", end)
+	}
+	if seg.InSpeech() {
+		t.Errorf("Segmenter still in speech after end event")
+	}
+
+	encode(speech, 5)
+	if len(events) != 3 || events[2].Type != SpeechStart || events[2].PTS != time.Second+200*time.Millisecond {
+		t.Fatalf("Expected second speech start at 1.2s, got %+v", events)
+	}
+	seg.Flush()
+	if len(events) != 4 || events[3].Type != SpeechEnd || events[3].PTS != time.Second+300*time.Millisecond {
+		t.Fatalf("Expected flushed speech end at 1.3s, got %+v", events)
+	}
+}
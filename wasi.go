@@ -0,0 +1,52 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// errnoNoSys is WASI's ENOSYS, returned by the stubs of WithWASI(false).
+const errnoNoSys = 52
+
+// instantiateWASI sets up the WASI functions compiled imports in rt: the
+// real ones, or stubs if noWASI is set. Nothing is done if compiled doesn't
+// import WASI or rt already has a module of that name, whatever noWASI says
+// (see WithWASI).
+func instantiateWASI(ctx context.Context, rt wazero.Runtime, compiled wazero.CompiledModule, noWASI bool) error {
+	var imports []api.FunctionDefinition
+	for _, def := range compiled.ImportedFunctions() {
+		if module, _, _ := def.Import(); module == wasi_snapshot_preview1.ModuleName {
+			imports = append(imports, def)
+		}
+	}
+	if len(imports) == 0 || rt.Module(wasi_snapshot_preview1.ModuleName) != nil {
+		return nil
+	}
+	if !noWASI {
+		_, err := wasi_snapshot_preview1.Instantiate(ctx, rt)
+		return err
+	}
+
+	builder := rt.NewHostModuleBuilder(wasi_snapshot_preview1.ModuleName)
+	for _, def := range imports {
+		_, name, _ := def.Import()
+		results := def.ResultTypes()
+		stub := api.GoModuleFunc(func(_ context.Context, _ api.Module, stack []uint64) {
+			if len(results) > 0 {
+				stack[0] = errnoNoSys
+			}
+		})
+		builder.NewFunctionBuilder().
+			WithGoModuleFunction(stub, def.ParamTypes(), results).
+			Export(name)
+	}
+	_, err := builder.Instantiate(ctx)
+	return err
+}
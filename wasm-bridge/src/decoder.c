@@ -7,3 +7,10 @@ bridge_decoder_get_last_packet_duration(OpusDecoder *st, opus_int32 *samples)
 {
 	return opus_decoder_ctl(st, OPUS_GET_LAST_PACKET_DURATION(samples));
 }
+
+EXPORT(bridge_decoder_reset_state)
+int
+bridge_decoder_reset_state(OpusDecoder *st)
+{
+	return opus_decoder_ctl(st, OPUS_RESET_STATE);
+}
@@ -133,3 +133,23 @@ bridge_encoder_reset_state(OpusEncoder *st)
 {
 	return opus_encoder_ctl(st, OPUS_RESET_STATE);
 }
+
+/*
+ * Encodes `frames` consecutive frames of `frame_size` samples per channel.
+ * Frame i is read from pcm + i * stride and its packet written to
+ * data + i * max_data_bytes, with its length stored in lens[i]. Returns the
+ * number of frames encoded; if a frame fails, lens of that frame holds the
+ * error code.
+ */
+EXPORT(bridge_encode_batch)
+int
+bridge_encode_batch(OpusEncoder *st, const opus_int16 *pcm, int frame_size, int stride, int frames,
+		unsigned char *data, opus_int32 max_data_bytes, opus_int32 *lens)
+{
+	for (int i = 0; i < frames; i++) {
+		lens[i] = opus_encode(st, pcm + i * stride, frame_size, data + i * max_data_bytes, max_data_bytes);
+		if (lens[i] < 0)
+			return i;
+	}
+	return frames;
+}
@@ -8,8 +8,9 @@ package opus
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
-	"log"
+	"math"
 	"runtime"
 	"strings"
 	"sync"
@@ -18,14 +19,8 @@ import (
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
-	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
-
-	_ "embed"
 )
 
-//go:embed wasm-bridge/build/wasm_bridge
-var opusWasmBinary []byte
-
 // WasmFunctions holds cached an api.Function instances from the Wasm module.
 type WasmFunctions struct {
 	// Common
@@ -37,6 +32,7 @@ type WasmFunctions struct {
 	OpusEncoderInit                api.Function
 	OpusEncode                     api.Function
 	OpusEncodeFloat                api.Function
+	OpusEncoderCtl                 api.Function
 	BridgeEncoderSetDtx            api.Function
 	BridgeEncoderGetDtx            api.Function
 	BridgeEncoderGetInDtx          api.Function
@@ -63,6 +59,23 @@ type WasmFunctions struct {
 	OpusDecode                         api.Function
 	OpusDecodeFloat                    api.Function
 	BridgeDecoderGetLastPacketDuration api.Function
+	// OpusDecoderCtl and BridgeDecoderResetState are optional, so modules
+	// built before their export still load.
+	OpusDecoderCtl          api.Function
+	BridgeDecoderResetState api.Function
+	// BridgeEncodeBatch is optional for the same reason.
+	BridgeEncodeBatch api.Function
+
+	// Projection (ambisonics) functions, also optional.
+	OpusProjectionAmbisonicsEncoderGetSize api.Function
+	OpusProjectionAmbisonicsEncoderInit    api.Function
+	OpusProjectionEncode                   api.Function
+	OpusProjectionEncodeFloat              api.Function
+	OpusProjectionEncoderCtl               api.Function
+	OpusProjectionDecoderGetSize           api.Function
+	OpusProjectionDecoderInit              api.Function
+	OpusProjectionDecode                   api.Function
+	OpusProjectionDecodeFloat              api.Function
 
 	// Constant getter functions
 	GetOpusOkAddress                     api.Function
@@ -89,21 +102,35 @@ type wasmContext struct {
 	functions WasmFunctions
 }
 
+// wasmMu guards the shared runtime state below and the options set by
+// Configure. Each wasm instance is used by one Encoder, Decoder, etc. at a
+// time, which serializes its calls with its own mutex.
 var (
+	wasmMu            sync.Mutex
 	globalWasmManager *wasmManager
 	wasmInitOnce      sync.Once
 	wasmInitErr       error
 )
 
 type wasmManager struct {
-	runtime         wazero.Runtime
-	compiledModule  wazero.CompiledModule
-	pool            chan *wasmContext
-	poolSize        int
-	createMu        sync.Mutex
-	instanceCounter uint64
+	runtime        wazero.Runtime
+	compiledModule wazero.CompiledModule
+	pool           chan *wasmContext
+	poolSize       int
+	ownsRuntime    bool          // false for a runtime passed to WithRuntime
+	codecs         Codecs        // the halves of the codec resolved
+	allocs         *allocTracker // nil without WithLeakDetector
+	memoryPages    uint32        // see WithInitialMemoryPages
+	// moduleID is the SHA-256 of the libopus module, which decoder states
+	// are tied to; nil for the cgo backend, which has no module.
+	moduleID []byte
+	createMu sync.Mutex
 }
 
+// wasmInstanceCounter names the module instances. It outlives a manager, as
+// instances still in use keep their name in a runtime passed to WithRuntime.
+var wasmInstanceCounter uint64
+
 // Constants to be loaded from Wasm
 var (
 	opusOk                     int32
@@ -126,66 +153,80 @@ var (
 type Bandwidth int32
 
 var ( // Changed from const to var
-	// Values will be initialized from Wasm
-	Narrowband    Bandwidth
-	Mediumband    Bandwidth
-	Wideband      Bandwidth
-	SuperWideband Bandwidth
-	Fullband      Bandwidth
+	// Values are reloaded from Wasm; the defaults from opus_defines.h let the
+	// pure-Go packet helpers be compared against them before that.
+	Narrowband    Bandwidth = bandwidthNarrowband
+	Mediumband    Bandwidth = bandwidthMediumband
+	Wideband      Bandwidth = bandwidthWideband
+	SuperWideband Bandwidth = bandwidthSuperWideband
+	Fullband      Bandwidth = bandwidthFullband
 )
 
-// initWasm initializes the Wazero runtime, compiles the wasm module, and loads constants.
-// It is designed to be called multiple times but only executes the initialization logic once.
-func initWasm(ctx context.Context, wasmBinary []byte) error {
+// initWasm sets up the libopus backend, the wazero runtime unless built
+// with opus_cgo, as chosen by Configure, loads constants, and returns the
+// manager of the instances. It is designed to be called multiple times but
+// only executes the initialization logic once.
+func initWasm(ctx context.Context) (*wasmManager, error) {
+	wasmMu.Lock()
+	defer wasmMu.Unlock()
 	wasmInitOnce.Do(func() {
 		initCtx := context.Background()
-		rt := wazero.NewRuntime(initCtx)
-		wasi_snapshot_preview1.MustInstantiate(initCtx, rt)
-
-		compiledModule, err := rt.CompileModule(initCtx, wasmBinary)
-		if err != nil {
-			wasmInitErr = fmt.Errorf("failed to compile wasm module: %w", err)
-			log.Printf("initWasm: %v", wasmInitErr)
-			_ = rt.Close(initCtx)
-			return
-		}
+		opts := options
 
 		poolSize := runtime.NumCPU()
 		if poolSize < 2 {
 			poolSize = 2
 		}
-
 		manager := &wasmManager{
-			runtime:        rt,
-			compiledModule: compiledModule,
-			pool:           make(chan *wasmContext, poolSize),
-			poolSize:       poolSize,
+			pool:        make(chan *wasmContext, poolSize),
+			poolSize:    poolSize,
+			codecs:      opts.codecs,
+			memoryPages: opts.memoryPages,
+		}
+		if opts.leakDetector {
+			manager.allocs = newAllocTracker()
+		}
+		if err := manager.start(initCtx, opts); err != nil {
+			wasmInitErr = err
+			logger().Error("opus: wasm runtime initialization failed", "err", wasmInitErr)
+			return
 		}
 
 		// Create an initial context to populate function cache and constants.
 		initialCtx, err := manager.newContext(initCtx)
 		if err != nil {
 			wasmInitErr = fmt.Errorf("failed to instantiate initial wasm module: %w", err)
-			log.Printf("initWasm: %v", wasmInitErr)
-			_ = compiledModule.Close(initCtx)
-			_ = rt.Close(initCtx)
+			logger().Error("opus: wasm runtime initialization failed", "err", wasmInitErr)
+			_ = manager.close(initCtx)
 			return
 		}
 
 		if err := loadOpusConstants(initCtx, initialCtx); err != nil {
 			wasmInitErr = fmt.Errorf("failed to load opus constants from wasm: %w", err)
-			log.Printf("initWasm: %v", wasmInitErr)
+			logger().Error("opus: wasm runtime initialization failed", "err", wasmInitErr)
 			initialCtx.close(initCtx)
-			_ = compiledModule.Close(initCtx)
-			_ = rt.Close(initCtx)
+			_ = manager.close(initCtx)
 			return
 		}
 
+		if opts.warmup {
+			if err := initialCtx.warmup(initCtx); err != nil {
+				wasmInitErr = fmt.Errorf("failed to warm up the codecs: %w", err)
+				logger().Error("opus: wasm runtime initialization failed", "err", wasmInitErr)
+				initialCtx.close(initCtx)
+				_ = manager.close(initCtx)
+				return
+			}
+		}
+
 		manager.release(initialCtx)
 		globalWasmManager = manager
 	})
 
-	return wasmInitErr
+	if wasmInitErr != nil {
+		return nil, wasmInitErr
+	}
+	return globalWasmManager, nil
 }
 
 func (m *wasmManager) newContext(ctx context.Context) (*wasmContext, error) {
@@ -196,9 +237,8 @@ func (m *wasmManager) newContext(ctx context.Context) (*wasmContext, error) {
 	m.createMu.Lock()
 	defer m.createMu.Unlock()
 
-	modName := fmt.Sprintf("opus-%d", atomic.AddUint64(&m.instanceCounter, 1))
-	cfg := wazero.NewModuleConfig().WithName(modName)
-	mod, err := m.runtime.InstantiateModule(ctx, m.compiledModule, cfg)
+	modName := fmt.Sprintf("opus-%d", atomic.AddUint64(&wasmInstanceCounter, 1))
+	mod, err := m.instantiate(ctx, modName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to instantiate wasm module: %w", err)
 	}
@@ -211,6 +251,12 @@ func (m *wasmManager) newContext(ctx context.Context) (*wasmContext, error) {
 		mod.Close(ctx)
 		return nil, err
 	}
+	if pages := mod.Memory().Size() / wasmPageSize; pages < m.memoryPages {
+		if _, ok := mod.Memory().Grow(m.memoryPages - pages); !ok {
+			mod.Close(ctx)
+			return nil, fmt.Errorf("failed to grow wasm memory to %d pages", m.memoryPages)
+		}
+	}
 	return wc, nil
 }
 
@@ -227,6 +273,13 @@ func (m *wasmManager) release(wc *wasmContext) {
 	if m == nil || wc == nil {
 		return
 	}
+	if wc.module == nil || wc.module.IsClosed() {
+		// A call interrupted by its context closed the module.
+		if wc.module != nil {
+			m.allocs.forget(wc.module.Name())
+		}
+		return
+	}
 	wc.manager = m
 	select {
 	case m.pool <- wc:
@@ -252,7 +305,7 @@ drained:
 	if m.compiledModule != nil {
 		m.compiledModule.Close(ctx)
 	}
-	if m.runtime != nil {
+	if m.runtime != nil && m.ownsRuntime {
 		return m.runtime.Close(ctx)
 	}
 	return nil
@@ -262,24 +315,63 @@ func (wc *wasmContext) close(ctx context.Context) {
 	if wc == nil || wc.module == nil {
 		return
 	}
+	if wc.manager != nil {
+		wc.manager.allocs.forget(wc.module.Name())
+	}
 	_ = wc.module.Close(ctx)
 	wc.module = nil
 	wc.functions = WasmFunctions{}
 }
 
+// wasm32Signature reports whether def only takes and returns 32-bit
+// integers, as the libopus functions do in a wasm32 build. A build for
+// another ABI, e.g. wasm64, exports them with other types.
+func wasm32Signature(def api.FunctionDefinition) bool {
+	for _, t := range append(def.ParamTypes(), def.ResultTypes()...) {
+		if t != api.ValueTypeI32 {
+			return false
+		}
+	}
+	return true
+}
+
+// i32Types returns the types of n 32-bit integers.
+func i32Types(n int) []api.ValueType {
+	types := make([]api.ValueType, n)
+	for i := range types {
+		types[i] = api.ValueTypeI32
+	}
+	return types
+}
+
 func (wc *wasmContext) populateFunctions() error {
 	if wc == nil || wc.module == nil {
 		return fmt.Errorf("wasm context module uninitialized")
 	}
 
-	var missing []string
+	if wc.module.Memory() == nil {
+		return fmt.Errorf("wasm module exports no memory")
+	}
+	var missing, mismatched []string
 	loadFunc := func(name string) api.Function {
 		f := wc.module.ExportedFunction(name)
 		if f == nil {
 			missing = append(missing, name)
+		} else if !wasm32Signature(f.Definition()) {
+			mismatched = append(mismatched, name)
 		}
 		return f
 	}
+	// Without one half of the codec its exports are neither resolved nor
+	// required, so a build lacking them still loads.
+	skip := func(string) api.Function { return nil }
+	loadEncoderFunc, loadDecoderFunc := loadFunc, loadFunc
+	switch wc.manager.codecs {
+	case DecoderOnly:
+		loadEncoderFunc = skip
+	case EncoderOnly:
+		loadDecoderFunc = skip
+	}
 
 	var funcs WasmFunctions
 	// Common
@@ -287,36 +379,37 @@ func (wc *wasmContext) populateFunctions() error {
 	funcs.Free = loadFunc("free")
 
 	// Encoder functions
-	funcs.OpusEncoderGetSize = loadFunc("opus_encoder_get_size")
-	funcs.OpusEncoderInit = loadFunc("opus_encoder_init")
-	funcs.OpusEncode = loadFunc("opus_encode")
-	funcs.OpusEncodeFloat = loadFunc("opus_encode_float")
-	funcs.BridgeEncoderSetDtx = loadFunc("bridge_encoder_set_dtx")
-	funcs.BridgeEncoderGetDtx = loadFunc("bridge_encoder_get_dtx")
-	funcs.BridgeEncoderGetInDtx = loadFunc("bridge_encoder_get_in_dtx")
-	funcs.BridgeEncoderGetSampleRate = loadFunc("bridge_encoder_get_sample_rate")
-	funcs.BridgeEncoderSetBitrate = loadFunc("bridge_encoder_set_bitrate")
-	funcs.BridgeEncoderGetBitrate = loadFunc("bridge_encoder_get_bitrate")
-	funcs.BridgeEncoderSetComplexity = loadFunc("bridge_encoder_set_complexity")
-	funcs.BridgeEncoderGetComplexity = loadFunc("bridge_encoder_get_complexity")
-	funcs.BridgeEncoderSetMaxBandwidth = loadFunc("bridge_encoder_set_max_bandwidth")
-	funcs.BridgeEncoderGetMaxBandwidth = loadFunc("bridge_encoder_get_max_bandwidth")
-	funcs.BridgeEncoderSetInbandFec = loadFunc("bridge_encoder_set_inband_fec")
-	funcs.BridgeEncoderGetInbandFec = loadFunc("bridge_encoder_get_inband_fec")
-	funcs.BridgeEncoderSetPacketLossPerc = loadFunc("bridge_encoder_set_packet_loss_perc")
-	funcs.BridgeEncoderGetPacketLossPerc = loadFunc("bridge_encoder_get_packet_loss_perc")
-	funcs.BridgeEncoderSetVbr = loadFunc("bridge_encoder_set_vbr")
-	funcs.BridgeEncoderGetVbr = loadFunc("bridge_encoder_get_vbr")
-	funcs.BridgeEncoderSetVbrConstraint = loadFunc("bridge_encoder_set_vbr_constraint")
-	funcs.BridgeEncoderGetVbrConstraint = loadFunc("bridge_encoder_get_vbr_constraint")
-	funcs.BridgeEncoderResetState = loadFunc("bridge_encoder_reset_state")
+	funcs.OpusEncoderGetSize = loadEncoderFunc("opus_encoder_get_size")
+	funcs.OpusEncoderInit = loadEncoderFunc("opus_encoder_init")
+	funcs.OpusEncode = loadEncoderFunc("opus_encode")
+	funcs.OpusEncodeFloat = loadEncoderFunc("opus_encode_float")
+	funcs.OpusEncoderCtl = loadEncoderFunc("opus_encoder_ctl")
+	funcs.BridgeEncoderSetDtx = loadEncoderFunc("bridge_encoder_set_dtx")
+	funcs.BridgeEncoderGetDtx = loadEncoderFunc("bridge_encoder_get_dtx")
+	funcs.BridgeEncoderGetInDtx = loadEncoderFunc("bridge_encoder_get_in_dtx")
+	funcs.BridgeEncoderGetSampleRate = loadEncoderFunc("bridge_encoder_get_sample_rate")
+	funcs.BridgeEncoderSetBitrate = loadEncoderFunc("bridge_encoder_set_bitrate")
+	funcs.BridgeEncoderGetBitrate = loadEncoderFunc("bridge_encoder_get_bitrate")
+	funcs.BridgeEncoderSetComplexity = loadEncoderFunc("bridge_encoder_set_complexity")
+	funcs.BridgeEncoderGetComplexity = loadEncoderFunc("bridge_encoder_get_complexity")
+	funcs.BridgeEncoderSetMaxBandwidth = loadEncoderFunc("bridge_encoder_set_max_bandwidth")
+	funcs.BridgeEncoderGetMaxBandwidth = loadEncoderFunc("bridge_encoder_get_max_bandwidth")
+	funcs.BridgeEncoderSetInbandFec = loadEncoderFunc("bridge_encoder_set_inband_fec")
+	funcs.BridgeEncoderGetInbandFec = loadEncoderFunc("bridge_encoder_get_inband_fec")
+	funcs.BridgeEncoderSetPacketLossPerc = loadEncoderFunc("bridge_encoder_set_packet_loss_perc")
+	funcs.BridgeEncoderGetPacketLossPerc = loadEncoderFunc("bridge_encoder_get_packet_loss_perc")
+	funcs.BridgeEncoderSetVbr = loadEncoderFunc("bridge_encoder_set_vbr")
+	funcs.BridgeEncoderGetVbr = loadEncoderFunc("bridge_encoder_get_vbr")
+	funcs.BridgeEncoderSetVbrConstraint = loadEncoderFunc("bridge_encoder_set_vbr_constraint")
+	funcs.BridgeEncoderGetVbrConstraint = loadEncoderFunc("bridge_encoder_get_vbr_constraint")
+	funcs.BridgeEncoderResetState = loadEncoderFunc("bridge_encoder_reset_state")
 
 	// Decoder functions
-	funcs.OpusDecoderGetSize = loadFunc("opus_decoder_get_size")
-	funcs.OpusDecoderInit = loadFunc("opus_decoder_init")
-	funcs.OpusDecode = loadFunc("opus_decode")
-	funcs.OpusDecodeFloat = loadFunc("opus_decode_float")
-	funcs.BridgeDecoderGetLastPacketDuration = loadFunc("bridge_decoder_get_last_packet_duration")
+	funcs.OpusDecoderGetSize = loadDecoderFunc("opus_decoder_get_size")
+	funcs.OpusDecoderInit = loadDecoderFunc("opus_decoder_init")
+	funcs.OpusDecode = loadDecoderFunc("opus_decode")
+	funcs.OpusDecodeFloat = loadDecoderFunc("opus_decode_float")
+	funcs.BridgeDecoderGetLastPacketDuration = loadDecoderFunc("bridge_decoder_get_last_packet_duration")
 
 	// Constant getter functions
 	funcs.GetOpusOkAddress = loadFunc("get_opus_ok_address")
@@ -335,72 +428,97 @@ func (wc *wasmContext) populateFunctions() error {
 	funcs.GetOpusAutoAddress = loadFunc("get_opus_auto_address")
 	funcs.GetOpusBitrateMaxAddress = loadFunc("get_opus_bitrate_max_address")
 
+	// Optional functions
+	funcs.OpusDecoderCtl = wc.module.ExportedFunction("opus_decoder_ctl")
+	funcs.BridgeDecoderResetState = wc.module.ExportedFunction("bridge_decoder_reset_state")
+	funcs.BridgeEncodeBatch = wc.module.ExportedFunction("bridge_encode_batch")
+	funcs.OpusProjectionAmbisonicsEncoderGetSize = wc.module.ExportedFunction("opus_projection_ambisonics_encoder_get_size")
+	funcs.OpusProjectionAmbisonicsEncoderInit = wc.module.ExportedFunction("opus_projection_ambisonics_encoder_init")
+	funcs.OpusProjectionEncode = wc.module.ExportedFunction("opus_projection_encode")
+	funcs.OpusProjectionEncodeFloat = wc.module.ExportedFunction("opus_projection_encode_float")
+	funcs.OpusProjectionEncoderCtl = wc.module.ExportedFunction("opus_projection_encoder_ctl")
+	funcs.OpusProjectionDecoderGetSize = wc.module.ExportedFunction("opus_projection_decoder_get_size")
+	funcs.OpusProjectionDecoderInit = wc.module.ExportedFunction("opus_projection_decoder_init")
+	funcs.OpusProjectionDecode = wc.module.ExportedFunction("opus_projection_decode")
+	funcs.OpusProjectionDecodeFloat = wc.module.ExportedFunction("opus_projection_decode_float")
+
 	if len(missing) > 0 {
 		return fmt.Errorf("wasm functions not found: %s", strings.Join(missing, ", "))
 	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("wasm functions with incompatible signatures: %s", strings.Join(mismatched, ", "))
+	}
 
 	wc.functions = funcs
 	return nil
 }
 
-// mustReadInt32Constant reads an int32 constant from wasm memory via an exported getter function.
-// It now takes the api.Function directly.
-func mustReadInt32Constant(ctx context.Context, module api.Module, fn api.Function, funcNameForLog string) int32 {
+// readInt32Constant reads an int32 constant from wasm memory via an exported getter function.
+func readInt32Constant(ctx context.Context, module api.Module, fn api.Function, funcNameForLog string) (int32, error) {
 	if fn == nil { // Should have been caught during initWasm
-		log.Fatalf("Wasm function for %s is nil", funcNameForLog)
+		return 0, fmt.Errorf("wasm function for %s is nil", funcNameForLog)
 	}
 	results, err := fn.Call(ctx)
 	if err != nil {
-		log.Fatalf("Failed to call %s: %v", funcNameForLog, err)
+		return 0, fmt.Errorf("failed to call %s: %w", funcNameForLog, err)
 	}
 	ptr := uint32(results[0])
 	val, ok := module.Memory().ReadUint32Le(ptr)
 	if !ok {
-		log.Fatalf("Failed to read memory at %d for %s", ptr, funcNameForLog)
+		return 0, fmt.Errorf("failed to read memory at %d for %s", ptr, funcNameForLog)
 	}
-	return int32(val)
+	return int32(val), nil
 }
 
-// loadOpusConstants loads the Opus constants from the wasm module into global variables.
 func loadOpusConstants(ctx context.Context, wc *wasmContext) error {
-	// Pass the module and the specific function from the cached WasmFunctions struct
-	opusOk = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusOkAddress, "get_opus_ok_address")
-	opusBadArg = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusBadArgAddress, "get_opus_bad_arg_address")
-	opusBufferTooSmall = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusBufferTooSmallAddress, "get_opus_buffer_too_small_address")
-	opusInternalError = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusInternalErrorAddress, "get_opus_internal_error_address")
-	opusInvalidPacket = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusInvalidPacketAddress, "get_opus_invalid_packet_address")
-	opusUnimplemented = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusUnimplementedAddress, "get_opus_unimplemented_address")
-	opusInvalidState = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusInvalidStateAddress, "get_opus_invalid_state_address")
-	opusAllocFail = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusAllocFailAddress, "get_opus_alloc_fail_address")
-
-	opusBandwidthNarrowband = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusBandwidthNarrowbandAddress, "get_opus_bandwidth_narrowband_address")
-	opusBandwidthMediumband = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusBandwidthMediumbandAddress, "get_opus_bandwidth_mediumband_address")
-	opusBandwidthWideband = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusBandwidthWidebandAddress, "get_opus_bandwidth_wideband_address")
-	opusBandwidthSuperWideband = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusBandwidthSuperWidebandAddress, "get_opus_bandwidth_superwideband_address")
-	opusBandwidthFullband = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusBandwidthFullbandAddress, "get_opus_bandwidth_fullband_address")
+	f := &wc.functions
+	constants := []struct {
+		dst  *int32
+		fn   api.Function
+		name string
+	}{
+		{&opusOk, f.GetOpusOkAddress, "get_opus_ok_address"},
+		{&opusBadArg, f.GetOpusBadArgAddress, "get_opus_bad_arg_address"},
+		{&opusBufferTooSmall, f.GetOpusBufferTooSmallAddress, "get_opus_buffer_too_small_address"},
+		{&opusInternalError, f.GetOpusInternalErrorAddress, "get_opus_internal_error_address"},
+		{&opusInvalidPacket, f.GetOpusInvalidPacketAddress, "get_opus_invalid_packet_address"},
+		{&opusUnimplemented, f.GetOpusUnimplementedAddress, "get_opus_unimplemented_address"},
+		{&opusInvalidState, f.GetOpusInvalidStateAddress, "get_opus_invalid_state_address"},
+		{&opusAllocFail, f.GetOpusAllocFailAddress, "get_opus_alloc_fail_address"},
+
+		{&opusBandwidthNarrowband, f.GetOpusBandwidthNarrowbandAddress, "get_opus_bandwidth_narrowband_address"},
+		{&opusBandwidthMediumband, f.GetOpusBandwidthMediumbandAddress, "get_opus_bandwidth_mediumband_address"},
+		{&opusBandwidthWideband, f.GetOpusBandwidthWidebandAddress, "get_opus_bandwidth_wideband_address"},
+		{&opusBandwidthSuperWideband, f.GetOpusBandwidthSuperWidebandAddress, "get_opus_bandwidth_superwideband_address"},
+		{&opusBandwidthFullband, f.GetOpusBandwidthFullbandAddress, "get_opus_bandwidth_fullband_address"},
+
+		{&opusAuto, f.GetOpusAutoAddress, "get_opus_auto_address"},
+		{&opusBitrateMax, f.GetOpusBitrateMaxAddress, "get_opus_bitrate_max_address"},
+	}
+	for _, c := range constants {
+		v, err := readInt32Constant(ctx, wc.module, c.fn, c.name)
+		if err != nil {
+			return err
+		}
+		*c.dst = v
+	}
 
 	Narrowband = Bandwidth(opusBandwidthNarrowband)
 	Mediumband = Bandwidth(opusBandwidthMediumband)
 	Wideband = Bandwidth(opusBandwidthWideband)
 	SuperWideband = Bandwidth(opusBandwidthSuperWideband)
 	Fullband = Bandwidth(opusBandwidthFullband)
-
-	opusAuto = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusAutoAddress, "get_opus_auto_address")
-	opusBitrateMax = mustReadInt32Constant(ctx, wc.module, wc.functions.GetOpusBitrateMaxAddress, "get_opus_bitrate_max_address")
-
 	return nil
 }
 
 // GetWasmContext returns the initialized global Wasm context.
 // It will trigger initialization if not already done.
 func GetWasmContext(ctx context.Context) (*wasmContext, error) {
-	if err := initWasm(ctx, opusWasmBinary); err != nil {
+	manager, err := initWasm(ctx)
+	if err != nil {
 		return nil, fmt.Errorf("failed to initialize wasm context: %w", err)
 	}
-	if globalWasmManager == nil {
-		return nil, fmt.Errorf("wasm manager not initialized")
-	}
-	return globalWasmManager.acquire(ctx)
+	return manager.acquire(ctx)
 }
 
 // releaseWasmContext returns the wasm context to the internal pool.
@@ -414,14 +532,18 @@ func releaseWasmContext(wc *wasmContext) {
 // CloseWasmContext closes the global Wasm runtime.
 // This should typically be called when the application exits.
 func CloseWasmContext(ctx context.Context) error {
+	wasmMu.Lock()
+	defer wasmMu.Unlock()
+	var err error
 	if globalWasmManager != nil {
-		err := globalWasmManager.close(ctx)
+		globalWasmManager.allocs.report()
+		err = globalWasmManager.close(ctx)
 		globalWasmManager = nil
-		wasmInitOnce = sync.Once{}
-		wasmInitErr = nil
-		return err
 	}
-	return nil // Already closed or not initialized
+	// Forget a failed initialization too, so the next use retries it.
+	wasmInitOnce = sync.Once{}
+	wasmInitErr = nil
+	return err
 }
 
 // --- Shared Helper functions for wasm memory management ---
@@ -438,11 +560,10 @@ func (wc *wasmContext) writeToMemory(ctx context.Context, data []byte) (ptr uint
 		return 0, fmt.Errorf("wasm malloc function not initialized in wasmContext")
 	}
 
-	results, err := wc.functions.Malloc.Call(ctx, uint64(byteCount))
+	ptr, err = wc.malloc(ctx, byteCount)
 	if err != nil {
-		return 0, fmt.Errorf("wasm malloc failed: %w", err)
+		return 0, callError(ctx, "wasm malloc", err)
 	}
-	ptr = uint32(results[0])
 	if ptr == 0 && byteCount > 0 {
 		return 0, fmt.Errorf("wasm malloc returned NULL for non-zero size (%d bytes)", byteCount)
 	}
@@ -451,7 +572,7 @@ func (wc *wasmContext) writeToMemory(ctx context.Context, data []byte) (ptr uint
 		if !wc.module.Memory().Write(ptr, data) {
 			if ptr != 0 && wc.functions.Free != nil {
 				// Attempt to free if write failed, but only if free is available
-				wc.functions.Free.Call(ctx, uint64(ptr))
+				wc.free(ctx, ptr)
 			}
 			return 0, fmt.Errorf("wasm memory write failed")
 		}
@@ -459,16 +580,48 @@ func (wc *wasmContext) writeToMemory(ctx context.Context, data []byte) (ptr uint
 	return ptr, nil
 }
 
+// callError wraps the error of a failed call to the wasm function name. If
+// ctx was cancelled, which interrupts the call, ctx's error is returned.
+func callError(ctx context.Context, name string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("opus: %s interrupted: %w", name, ctxErr)
+	}
+	return fmt.Errorf("%s call failed: %w", name, err)
+}
+
+// callCtl calls a variadic libopus CTL function, fn(st, request, args...).
+// Under the wasm32 C ABI variadic arguments are passed as a pointer to a
+// buffer holding them, so args are spilled to wasm memory first.
+func (wc *wasmContext) callCtl(ctx context.Context, fn api.Function, name string, st uint32, request int32, args ...uint32) error {
+	buf := make([]byte, 4*len(args))
+	for i, arg := range args {
+		binary.LittleEndian.PutUint32(buf[4*i:], arg)
+	}
+	argsPtr, err := wc.writeToMemory(ctx, buf)
+	if err != nil {
+		return fmt.Errorf("failed to write ctl arguments to Wasm memory: %w", err)
+	}
+	defer wc.freeMemory(ctx, argsPtr)
+	results, err := fn.Call(ctx, uint64(st), uint64(request), uint64(argsPtr))
+	if err != nil {
+		return fmt.Errorf("%s call failed: %w", name, err)
+	}
+	res := int32(results[0])
+	if res != opusOk {
+		return Error(int(res))
+	}
+	return nil
+}
+
 // allocateInt32Ptr allocates memory for an int32 in wasm memory using the wasmContext's malloc.
 func (wc *wasmContext) allocateInt32Ptr(ctx context.Context) (ptr uint32, err error) {
 	if wc.functions.Malloc == nil {
 		return 0, fmt.Errorf("wasm malloc function not initialized in wasmContext for allocateInt32Ptr")
 	}
-	results, err := wc.functions.Malloc.Call(ctx, 4) // sizeof(int32) is 4
+	ptr, err = wc.malloc(ctx, 4) // sizeof(int32) is 4
 	if err != nil {
 		return 0, fmt.Errorf("wasm malloc for int32 ptr failed: %w", err)
 	}
-	ptr = uint32(results[0])
 	if ptr == 0 {
 		return 0, fmt.Errorf("wasm malloc for int32 ptr returned NULL")
 	}
@@ -483,22 +636,99 @@ func (wc *wasmContext) freeMemory(ctx context.Context, ptr uint32) error {
 	if wc.functions.Free == nil {
 		return fmt.Errorf("wasm free function not initialized in wasmContext")
 	}
-	_, err := wc.functions.Free.Call(ctx, uint64(ptr))
-	if err != nil {
+	if err := wc.free(ctx, ptr); err != nil {
 		return fmt.Errorf("wasm free call failed: %w", err)
 	}
 	return nil
 }
 
-// --- Shared Helper functions for byte slice conversions ---
-// These handle endianness correctly (Wasm is little-endian).
+// malloc calls the wasm malloc function, counting the allocation and
+// tracking it for the leak detector. Callers check that it is exported.
+func (wc *wasmContext) malloc(ctx context.Context, size uint32) (uint32, error) {
+	results, err := wc.functions.Malloc.Call(ctx, uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	stats.mallocs.Add(1)
+	ptr := uint32(results[0])
+	if ptr != 0 && wc.manager != nil {
+		wc.manager.allocs.add(wc.module.Name(), ptr, size)
+	}
+	return ptr, nil
+}
+
+// free calls the wasm free function; it is the counterpart of malloc.
+func (wc *wasmContext) free(ctx context.Context, ptr uint32) error {
+	if _, err := wc.functions.Free.Call(ctx, uint64(ptr)); err != nil {
+		return err
+	}
+	if wc.manager != nil {
+		wc.manager.allocs.remove(wc.module.Name(), ptr)
+	}
+	return nil
+}
+
+// wasmBuffer is a region of wasm memory kept across calls and grown on
+// demand, so that hot paths don't malloc and free on every call.
+type wasmBuffer struct {
+	ptr  uint32
+	size uint32
+}
+
+// reserve returns the address of at least size bytes, reallocating the
+// buffer if it is smaller. The contents are not preserved.
+func (b *wasmBuffer) reserve(ctx context.Context, wc *wasmContext, size uint32) (uint32, error) {
+	if b.ptr != 0 && size <= b.size {
+		return b.ptr, nil
+	}
+	if err := b.free(ctx, wc); err != nil {
+		return 0, err
+	}
+	if wc.functions.Malloc == nil {
+		return 0, fmt.Errorf("wasm malloc function not initialized in wasmContext")
+	}
+	ptr, err := wc.malloc(ctx, size)
+	if err != nil {
+		return 0, callError(ctx, "wasm malloc", err)
+	}
+	if ptr == 0 {
+		return 0, fmt.Errorf("wasm malloc returned NULL for %d bytes", size)
+	}
+	b.ptr, b.size = ptr, size
+	return ptr, nil
+}
 
-// int16SliceToByteSlice converts an int16 slice to a little-endian byte slice.
+// free releases the buffer.
+func (b *wasmBuffer) free(ctx context.Context, wc *wasmContext) error {
+	if b.ptr == 0 {
+		return nil
+	}
+	ptr := b.ptr
+	b.ptr, b.size = 0, 0
+	return wc.freeMemory(ctx, ptr)
+}
+
+// --- Shared Helper functions for byte slice conversions ---
+// Wasm memory is little-endian. On little-endian hosts, which is nearly all
+// of them, samples have the same bytes in Go and in wasm, so the helpers
+// reinterpret the slices instead of converting every sample; copying to or
+// from the views returned by Memory().Read is then the only copy per frame.
+
+// hostLittleEndian reports whether the host stores numbers like wasm does.
+var hostLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// int16SliceToByteSlice returns s as a little-endian byte slice. On
+// little-endian hosts it shares the memory of s, so it must not be modified.
 func int16SliceToByteSlice(s []int16) []byte {
+	if hostLittleEndian {
+		return unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(s))), len(s)*2)
+	}
 	b := make([]byte, len(s)*2)
 	for i, v := range s {
-		b[i*2] = byte(v)
-		b[i*2+1] = byte(v >> 8)
+		binary.LittleEndian.PutUint16(b[i*2:], uint16(v))
 	}
 	return b
 }
@@ -511,30 +741,30 @@ func int16SliceFromByteSlice(src []byte, dest []int16) error {
 	if len(dest)*2 < len(src) {
 		return fmt.Errorf("destination int16 slice too small (len %d) for byte slice (len %d)", len(dest), len(src))
 	}
+	if hostLittleEndian {
+		copy(int16SliceToByteSlice(dest), src)
+		return nil
+	}
 	for i := 0; i < len(src)/2; i++ {
-		dest[i] = int16(src[i*2]) | (int16(src[i*2+1]) << 8)
+		dest[i] = int16(binary.LittleEndian.Uint16(src[i*2:]))
 	}
 	return nil
 }
 
-// float32SliceToByteSlice converts a float32 slice to a little-endian byte slice.
-// This uses unsafe, assuming a direct bitwise representation matches Wasm float representation.
-// For maximum portability, encoding/binary with Float32bits and LittleEndian should be used.
+// float32SliceToByteSlice returns s as a little-endian byte slice. On
+// little-endian hosts it shares the memory of s, so it must not be modified.
 func float32SliceToByteSlice(s []float32) []byte {
+	if hostLittleEndian {
+		return unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(s))), len(s)*4)
+	}
 	b := make([]byte, len(s)*4)
-	for i, v_float := range s {
-		u := *(*uint32)(unsafe.Pointer(&v_float)) // Reinterpret float32 as uint32 bits
-		b[i*4+0] = byte(u)
-		b[i*4+1] = byte(u >> 8)
-		b[i*4+2] = byte(u >> 16)
-		b[i*4+3] = byte(u >> 24)
+	for i, v := range s {
+		binary.LittleEndian.PutUint32(b[i*4:], math.Float32bits(v))
 	}
 	return b
 }
 
 // float32SliceFromByteSlice converts a little-endian byte slice to a float32 slice.
-// This uses unsafe, assuming a direct bitwise representation matches Wasm float representation.
-// For maximum portability, encoding/binary with Float32frombits and LittleEndian should be used.
 func float32SliceFromByteSlice(src []byte, dest []float32) error {
 	if len(src)%4 != 0 {
 		return fmt.Errorf("byte slice length %d is not a multiple of 4 for float32 conversion", len(src))
@@ -542,9 +772,12 @@ func float32SliceFromByteSlice(src []byte, dest []float32) error {
 	if len(dest)*4 < len(src) {
 		return fmt.Errorf("destination float32 slice too small (len %d) for byte slice (len %d)", len(dest), len(src))
 	}
+	if hostLittleEndian {
+		copy(float32SliceToByteSlice(dest), src)
+		return nil
+	}
 	for i := 0; i < len(src)/4; i++ {
-		u := uint32(src[i*4+0]) | uint32(src[i*4+1])<<8 | uint32(src[i*4+2])<<16 | uint32(src[i*4+3])<<24
-		dest[i] = *(*float32)(unsafe.Pointer(&u))
+		dest[i] = math.Float32frombits(binary.LittleEndian.Uint32(src[i*4:]))
 	}
 	return nil
 }
@@ -0,0 +1,191 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultWatchdogMaxFailures is the number of consecutive state errors that
+// trigger a recovery when Watchdog.MaxFailures is zero.
+const DefaultWatchdogMaxFailures = 3
+
+// Watchdog configures automatic recovery of an Encoder or Decoder whose
+// libopus state has become unusable.
+//
+// When MaxFailures consecutive calls fail with ErrInvalidState or
+// ErrInternalError, the instance is re-initialized in place from the
+// parameters it was created with. Encoders also get their CTL settings
+// (bitrate, complexity, FEC, ...) restored.
+type Watchdog struct {
+	// MaxFailures is the number of consecutive state errors tolerated before
+	// recovering. Zero means DefaultWatchdogMaxFailures.
+	MaxFailures int
+	// OnRecover, if not nil, is called after every recovery attempt. It runs
+	// after the instance lock is released, so it may use the instance.
+	OnRecover func(RecoveryEvent)
+}
+
+// RecoveryEvent describes a recovery performed by a Watchdog.
+type RecoveryEvent struct {
+	// Err is the error that tripped the watchdog.
+	Err error
+	// Failures is the number of consecutive state errors observed.
+	Failures int
+	// RecoverErr is non-nil if re-initializing the instance failed.
+	RecoverErr error
+}
+
+// watchdogState tracks consecutive failures of one instance. It is guarded by
+// the owning instance's mutex.
+type watchdogState struct {
+	cfg      *Watchdog
+	failures int
+	pending  []RecoveryEvent
+}
+
+// observe records a failed libopus call and re-initializes the instance
+// through reinit once the failure threshold is reached. It returns err.
+func (w *watchdogState) observe(err Error, reinit func() error) error {
+	if w.cfg == nil {
+		return err
+	}
+	if err != Error(opusInvalidState) && err != Error(opusInternalError) {
+		return err
+	}
+	w.failures++
+	limit := w.cfg.MaxFailures
+	if limit <= 0 {
+		limit = DefaultWatchdogMaxFailures
+	}
+	if w.failures < limit {
+		return err
+	}
+	ev := RecoveryEvent{Err: err, Failures: w.failures, RecoverErr: reinit()}
+	w.failures = 0
+	if w.cfg.OnRecover != nil {
+		w.pending = append(w.pending, ev)
+	}
+	return err
+}
+
+// succeeded resets the consecutive failure count.
+func (w *watchdogState) succeeded() {
+	w.failures = 0
+}
+
+// takePending returns and clears the queued recovery events together with
+// the callback that should receive them.
+func (w *watchdogState) takePending() ([]RecoveryEvent, func(RecoveryEvent)) {
+	if len(w.pending) == 0 || w.cfg == nil {
+		w.pending = nil
+		return nil, nil
+	}
+	events := w.pending
+	w.pending = nil
+	return events, w.cfg.OnRecover
+}
+
+// SetWatchdog enables automatic recovery for the encoder. A nil w disables it.
+func (enc *Encoder) SetWatchdog(w *Watchdog) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	enc.watchdog = watchdogState{cfg: w}
+}
+
+// unlockAndNotify releases enc.mu and delivers queued watchdog events.
+func (enc *Encoder) unlockAndNotify() {
+	events, notify := enc.watchdog.takePending()
+	enc.mu.Unlock()
+	for _, ev := range events {
+		notify(ev)
+	}
+}
+
+// reinitLocked re-initializes the encoder state in place and restores its CTL
+// settings. Callers must hold enc.mu.
+func (enc *Encoder) reinitLocked() error {
+	if enc.encoderPtr == 0 || enc.wctx == nil {
+		return errEncUninitialized
+	}
+	snapshot, err := enc.snapshotLocked()
+	if err != nil {
+		return fmt.Errorf("opus: failed to snapshot encoder settings: %w", err)
+	}
+	ctx := context.Background()
+	for s, ptr := range enc.states() {
+		channels := enc.channels
+		if enc.layout != nil {
+			channels = enc.layout.streamChannels(s)
+		}
+		results, err := enc.wctx.functions.OpusEncoderInit.Call(ctx,
+			uint64(ptr), uint64(int32(enc.sampleRate)), uint64(int32(channels)), uint64(int32(enc.application)))
+		if err != nil {
+			return fmt.Errorf("opus_encoder_init call failed: %w", err)
+		}
+		if errno := int32(results[0]); errno != opusOk {
+			return Error(int(errno))
+		}
+	}
+	if err := enc.markLFELocked(ctx); err != nil {
+		return err
+	}
+	return enc.restoreLocked(snapshot)
+}
+
+// SetWatchdog enables automatic recovery for the decoder. A nil w disables it.
+func (dec *Decoder) SetWatchdog(w *Watchdog) {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+	dec.watchdog = watchdogState{cfg: w}
+}
+
+// unlockAndNotify releases dec.mu and delivers queued watchdog events.
+func (dec *Decoder) unlockAndNotify() {
+	events, notify := dec.watchdog.takePending()
+	dec.mu.Unlock()
+	for _, ev := range events {
+		notify(ev)
+	}
+}
+
+// reinitLocked re-initializes the decoder state in place and restores the
+// gain and complexity set on it. Callers must hold dec.mu.
+func (dec *Decoder) reinitLocked() error {
+	if dec.decoderPtr == 0 || dec.wctx == nil {
+		return errDecUninitialized
+	}
+	ctx := context.Background()
+	restore := []requestValue{
+		{request: opusSetGainRequest, value: dec.gain},
+		{request: opusSetComplexityRequest, value: dec.complexity},
+	}
+	for s, ptr := range dec.states() {
+		channels := dec.channels
+		if dec.layout != nil {
+			channels = dec.layout.streamChannels(s)
+		}
+		results, err := dec.wctx.functions.OpusDecoderInit.Call(ctx,
+			uint64(ptr), uint64(int32(dec.sample_rate)), uint64(int32(channels)))
+		if err != nil {
+			return fmt.Errorf("opus_decoder_init call failed: %w", err)
+		}
+		if errno := int32(results[0]); errno != opusOk {
+			return Error(int(errno))
+		}
+		ctlFunc := dec.wctx.functions.OpusDecoderCtl
+		if ctlFunc == nil {
+			// Neither setting can have been changed without it.
+			continue
+		}
+		for _, r := range restore {
+			if err := dec.wctx.callCtl(ctx, ctlFunc, "opus_decoder_ctl", ptr, r.request, uint32(r.value)); err != nil {
+				return fmt.Errorf("opus: failed to restore ctl request %d: %w", r.request, err)
+			}
+		}
+	}
+	return nil
+}
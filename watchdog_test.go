@@ -0,0 +1,155 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package opus
+
+import "testing"
+
+// simulateEncoderFailures feeds n state errors to the encoder's watchdog, as if n
+// consecutive Encode calls had failed.
+func simulateEncoderFailures(enc *Encoder, n int) {
+	enc.mu.Lock()
+	for i := 0; i < n; i++ {
+		enc.watchdog.observe(ErrInvalidState, enc.reinitLocked)
+	}
+	enc.unlockAndNotify()
+}
+
+func TestEncoder_Watchdog(t *testing.T) {
+	enc, err := NewEncoder(48000, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetBitrate(20000); err != nil {
+		t.Fatalf("Error setting bitrate: %v", err)
+	}
+	var events []RecoveryEvent
+	var bitrateInCallback int
+	enc.SetWatchdog(&Watchdog{
+		MaxFailures: 2,
+		OnRecover: func(ev RecoveryEvent) {
+			events = append(events, ev)
+			// The instance lock must be released by now.
+			bitrateInCallback, _ = enc.Bitrate()
+		},
+	})
+
+	simulateEncoderFailures(enc, 1)
+	if len(events) != 0 {
+		t.Fatalf("Watchdog tripped after a single failure")
+	}
+	simulateEncoderFailures(enc, 1)
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 recovery event, got %d", len(events))
+	}
+	if ev := events[0]; ev.Err != ErrInvalidState || ev.Failures != 2 || ev.RecoverErr != nil {
+		t.Errorf("Unexpected recovery event: %+v", ev)
+	}
+	if bitrateInCallback != 20000 {
+		t.Errorf("Bitrate not restored after recovery. Got %d, but expected %d", bitrateInCallback, 20000)
+	}
+	RunTestCodec(t, enc)
+}
+
+func TestEncoder_WatchdogRestoresRequests(t *testing.T) {
+	const SAMPLE_RATE = 48000
+	enc, err := NewEncoder(SAMPLE_RATE, 2, AppAudio)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	if err := enc.SetLSBDepth(16); err != nil {
+		t.Fatalf("SetLSBDepth: %v", err)
+	}
+	if err := enc.SetPredictionDisabled(true); err != nil {
+		t.Fatalf("SetPredictionDisabled: %v", err)
+	}
+	if err := enc.SetForceChannels(1); err != nil {
+		t.Fatalf("SetForceChannels: %v", err)
+	}
+	if err := enc.SetBandwidth(Wideband); err != nil {
+		t.Fatalf("SetBandwidth: %v", err)
+	}
+	enc.SetWatchdog(&Watchdog{MaxFailures: 1})
+	simulateEncoderFailures(enc, 1)
+
+	if depth, err := enc.LSBDepth(); err != nil || depth != 16 {
+		t.Errorf("LSB depth after recovery: %d, %v; want 16", depth, err)
+	}
+	if disabled, err := enc.PredictionDisabled(); err != nil || !disabled {
+		t.Errorf("Prediction disabled after recovery: %v, %v; want true", disabled, err)
+	}
+	if channels, err := enc.ForceChannels(); err != nil || channels != 1 {
+		t.Errorf("Forced channels after recovery: %d, %v; want 1", channels, err)
+	}
+	pcm := make([]int16, SAMPLE_RATE/50)
+	addSine(pcm, SAMPLE_RATE, 440)
+	data := make([]byte, 1000)
+	n, err := enc.Encode(interleave(pcm, pcm), data)
+	if err != nil {
+		t.Fatalf("Couldn't encode data: %v", err)
+	}
+	if bw, err := PacketBandwidth(data[:n]); err != nil || bw != Wideband {
+		t.Errorf("Bandwidth after recovery: %v, %v; want %v", bw, err, Wideband)
+	}
+}
+
+func TestEncoder_WatchdogIgnoresOtherErrors(t *testing.T) {
+	enc, err := NewEncoder(48000, 1, AppVoIP)
+	if err != nil || enc == nil {
+		t.Fatalf("Error creating new encoder: %v", err)
+	}
+	recovered := false
+	enc.SetWatchdog(&Watchdog{MaxFailures: 1, OnRecover: func(RecoveryEvent) { recovered = true }})
+	enc.mu.Lock()
+	enc.watchdog.observe(ErrBadArg, enc.reinitLocked)
+	enc.unlockAndNotify()
+	if recovered {
+		t.Errorf("Watchdog tripped on a non-state error")
+	}
+}
+
+func TestDecoder_Watchdog(t *testing.T) {
+	dec, err := NewDecoder(48000, 1)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	var events []RecoveryEvent
+	dec.SetWatchdog(&Watchdog{OnRecover: func(ev RecoveryEvent) { events = append(events, ev) }})
+	dec.mu.Lock()
+	for i := 0; i < DefaultWatchdogMaxFailures; i++ {
+		dec.watchdog.observe(ErrInternalError, dec.reinitLocked)
+	}
+	dec.unlockAndNotify()
+	if len(events) != 1 || events[0].RecoverErr != nil {
+		t.Fatalf("Unexpected recovery events: %+v", events)
+	}
+	pcm := make([]int16, 960)
+	if _, err := dec.DecodePLC(pcm); err != nil {
+		t.Errorf("Decoder unusable after recovery: %v", err)
+	}
+}
+
+func TestDecoder_WatchdogRestoresSettings(t *testing.T) {
+	dec, err := NewDecoder(48000, 2)
+	if err != nil || dec == nil {
+		t.Fatalf("Error creating new decoder: %v", err)
+	}
+	if err := dec.SetGain(-512); err != nil {
+		t.Fatalf("SetGain: %v", err)
+	}
+	if err := dec.SetComplexity(7); err != nil {
+		t.Fatalf("SetComplexity: %v", err)
+	}
+	dec.SetWatchdog(&Watchdog{MaxFailures: 1})
+	dec.mu.Lock()
+	dec.watchdog.observe(ErrInvalidState, dec.reinitLocked)
+	dec.unlockAndNotify()
+
+	if gain, err := dec.Gain(); err != nil || gain != -512 {
+		t.Errorf("Gain after recovery: %d, %v; want -512", gain, err)
+	}
+	if c, err := dec.Complexity(); err != nil || c != 7 {
+		t.Errorf("Complexity after recovery: %d, %v; want 7", c, err)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// unknownSize is the chunk size streaming writers use when the length isn't
+// known in advance.
+const unknownSize = 0xffffffff
+
+// Reader reads the samples of a WAV file.
+type Reader struct {
+	r      io.Reader
+	format Format
+	// remaining is the number of bytes left in the data chunk, or -1 if the
+	// chunk runs to the end of the file.
+	remaining int64
+	buf       []byte
+}
+
+// NewReader reads the WAV header from r and returns a Reader positioned at
+// the first sample.
+func NewReader(r io.Reader) (*Reader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrFormat
+		}
+		return nil, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, ErrFormat
+	}
+	var format *Format
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("wav: no data chunk")
+			}
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(hdr[4:]))
+		switch string(hdr[0:4]) {
+		case "fmt ":
+			body := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, ErrFormat
+			}
+			f, err := parseFmt(body[:size])
+			if err != nil {
+				return nil, err
+			}
+			format = &f
+		case "data":
+			if format == nil {
+				return nil, fmt.Errorf("wav: data chunk before fmt chunk")
+			}
+			if size == unknownSize {
+				size = -1
+			}
+			return &Reader{r: r, format: *format, remaining: size}, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, ErrFormat
+			}
+		}
+	}
+}
+
+// Format returns the format of the samples.
+func (r *Reader) Format() Format {
+	return r.format
+}
+
+// readFrames reads up to n frames of raw sample data. It returns io.EOF at
+// the end of the data; a trailing partial frame is dropped.
+func (r *Reader) readFrames(n int) ([]byte, error) {
+	size := int64(n * r.format.frameSize())
+	if r.remaining >= 0 {
+		size = min(size, r.remaining)
+	}
+	if size == 0 && n > 0 {
+		return nil, io.EOF
+	}
+	if int64(cap(r.buf)) < size {
+		r.buf = make([]byte, size)
+	}
+	got, err := io.ReadFull(r.r, r.buf[:size])
+	if r.remaining >= 0 {
+		r.remaining -= int64(got)
+	}
+	if err == io.ErrUnexpectedEOF || (err == io.EOF && got == 0) {
+		// The file ended early or, with an unknown size, where expected.
+		r.remaining, err = 0, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	frames := got / r.format.frameSize()
+	if frames == 0 && n > 0 {
+		return nil, io.EOF
+	}
+	return r.buf[:frames*r.format.frameSize()], nil
+}
+
+// ReadInt16 reads interleaved samples into pcm, converting them to int16,
+// and returns the number of samples per channel read. It returns io.EOF at
+// the end of the data.
+func (r *Reader) ReadInt16(pcm []int16) (int, error) {
+	data, err := r.readFrames(len(pcm) / r.format.Channels)
+	if err != nil {
+		return 0, err
+	}
+	width := r.format.BitsPerSample / 8
+	for i := 0; i < len(data)/width; i++ {
+		pcm[i] = r.format.sampleInt16(data[i*width:])
+	}
+	return len(data) / r.format.frameSize(), nil
+}
+
+// ReadFloat32 reads interleaved samples into pcm, converting them to
+// float32 in [-1, 1), see ReadInt16.
+func (r *Reader) ReadFloat32(pcm []float32) (int, error) {
+	data, err := r.readFrames(len(pcm) / r.format.Channels)
+	if err != nil {
+		return 0, err
+	}
+	width := r.format.BitsPerSample / 8
+	for i := 0; i < len(data)/width; i++ {
+		pcm[i] = r.format.sampleFloat32(data[i*width:])
+	}
+	return len(data) / r.format.frameSize(), nil
+}
+
+// ReadFile reads all the samples of the WAV file at path as interleaved
+// int16 PCM.
+func ReadFile(path string) ([]int16, Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, Format{}, err
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, Format{}, fmt.Errorf("%s: %w", path, err)
+	}
+	var pcm []int16
+	buf := make([]int16, 4096*r.format.Channels)
+	for {
+		n, err := r.ReadInt16(buf)
+		if err == io.EOF {
+			return pcm, r.format, nil
+		}
+		if err != nil {
+			return nil, Format{}, fmt.Errorf("%s: %w", path, err)
+		}
+		pcm = append(pcm, buf[:n*r.format.Channels]...)
+	}
+}
+
+// ReadFileFloat32 reads all the samples of the WAV file at path as
+// interleaved float32 PCM.
+func ReadFileFloat32(path string) ([]float32, Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, Format{}, err
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, Format{}, fmt.Errorf("%s: %w", path, err)
+	}
+	var pcm []float32
+	buf := make([]float32, 4096*r.format.Channels)
+	for {
+		n, err := r.ReadFloat32(buf)
+		if err == io.EOF {
+			return pcm, r.format, nil
+		}
+		if err != nil {
+			return nil, Format{}, fmt.Errorf("%s: %w", path, err)
+		}
+		pcm = append(pcm, buf[:n*r.format.Channels]...)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+// Package wav reads and writes RIFF/WAVE files of linear PCM, enough to get
+// audio into an opus.Encoder and decoded audio back out without another
+// dependency. Samples are exchanged as interleaved int16 or float32 slices,
+// like the opus package uses.
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrFormat is returned for files that aren't RIFF/WAVE or use a sample
+// format this package doesn't handle.
+var ErrFormat = errors.New("wav: not a supported RIFF/WAVE file")
+
+// WAVE format tags.
+const (
+	formatPCM        = 1
+	formatFloat      = 3
+	formatExtensible = 0xfffe
+)
+
+// Format describes the samples of a WAV file.
+type Format struct {
+	SampleRate int
+	Channels   int
+	// BitsPerSample is 8, 16, 24 or 32 for integer PCM and 32 or 64 for
+	// floating point. 0 means 16 when writing.
+	BitsPerSample int
+	// Float selects IEEE floating-point samples.
+	Float bool
+}
+
+// validate checks that the format is one this package reads and writes.
+func (f Format) validate() error {
+	if f.SampleRate <= 0 || f.Channels <= 0 || f.Channels > 0xffff {
+		return fmt.Errorf("wav: invalid sample rate %d or channel count %d", f.SampleRate, f.Channels)
+	}
+	switch {
+	case f.Float && (f.BitsPerSample == 32 || f.BitsPerSample == 64):
+	case !f.Float && (f.BitsPerSample == 8 || f.BitsPerSample == 16 || f.BitsPerSample == 24 || f.BitsPerSample == 32):
+	default:
+		return fmt.Errorf("wav: unsupported sample format: %d bits, float %v", f.BitsPerSample, f.Float)
+	}
+	return nil
+}
+
+// frameSize returns the size of one sample for every channel in bytes.
+func (f Format) frameSize() int {
+	return f.Channels * f.BitsPerSample / 8
+}
+
+// parseFmt parses the body of a "fmt " chunk.
+func parseFmt(body []byte) (Format, error) {
+	if len(body) < 16 {
+		return Format{}, ErrFormat
+	}
+	tag := binary.LittleEndian.Uint16(body[0:])
+	f := Format{
+		Channels:      int(binary.LittleEndian.Uint16(body[2:])),
+		SampleRate:    int(binary.LittleEndian.Uint32(body[4:])),
+		BitsPerSample: int(binary.LittleEndian.Uint16(body[14:])),
+	}
+	if tag == formatExtensible {
+		// The real format tag is the first two bytes of the SubFormat GUID.
+		if len(body) < 26 {
+			return Format{}, ErrFormat
+		}
+		tag = binary.LittleEndian.Uint16(body[24:])
+	}
+	switch tag {
+	case formatPCM:
+	case formatFloat:
+		f.Float = true
+	default:
+		return Format{}, ErrFormat
+	}
+	if err := f.validate(); err != nil {
+		return Format{}, err
+	}
+	return f, nil
+}
+
+// appendFmt appends the body of a "fmt " chunk describing f.
+func appendFmt(b []byte, f Format) []byte {
+	tag := uint16(formatPCM)
+	if f.Float {
+		tag = formatFloat
+	}
+	b = binary.LittleEndian.AppendUint16(b, tag)
+	b = binary.LittleEndian.AppendUint16(b, uint16(f.Channels))
+	b = binary.LittleEndian.AppendUint32(b, uint32(f.SampleRate))
+	b = binary.LittleEndian.AppendUint32(b, uint32(f.SampleRate*f.frameSize()))
+	b = binary.LittleEndian.AppendUint16(b, uint16(f.frameSize()))
+	return binary.LittleEndian.AppendUint16(b, uint16(f.BitsPerSample))
+}
+
+// sampleInt16 decodes the sample at the start of b to int16.
+func (f Format) sampleInt16(b []byte) int16 {
+	if f.Float {
+		return floatToInt16(f.sampleFloat32(b))
+	}
+	switch f.BitsPerSample {
+	case 8:
+		return int16(int8(b[0]-128)) << 8
+	case 16:
+		return int16(binary.LittleEndian.Uint16(b))
+	}
+	// Keep the two most significant bytes of 24 and 32-bit samples.
+	n := f.BitsPerSample / 8
+	return int16(binary.LittleEndian.Uint16(b[n-2:]))
+}
+
+// sampleFloat32 decodes the sample at the start of b to float32 in
+// [-1, 1).
+func (f Format) sampleFloat32(b []byte) float32 {
+	if f.Float {
+		if f.BitsPerSample == 64 {
+			return float32(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b))
+	}
+	switch f.BitsPerSample {
+	case 8:
+		return float32(int8(b[0]-128)) / (1 << 7)
+	case 16:
+		return float32(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+	case 24:
+		v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+		return float32(v) / (1 << 23)
+	}
+	return float32(float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31))
+}
+
+// putInt16 encodes v at the start of b.
+func (f Format) putInt16(b []byte, v int16) {
+	if f.Float {
+		f.putFloat32(b, float32(v)/(1<<15))
+		return
+	}
+	switch f.BitsPerSample {
+	case 8:
+		b[0] = byte(v>>8) + 128
+	case 16:
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	case 24:
+		b[0] = 0
+		binary.LittleEndian.PutUint16(b[1:], uint16(v))
+	case 32:
+		binary.LittleEndian.PutUint32(b, uint32(int32(v)<<16))
+	}
+}
+
+// putFloat32 encodes v, clipping it to [-1, 1] for integer formats.
+func (f Format) putFloat32(b []byte, v float32) {
+	if f.Float {
+		if f.BitsPerSample == 64 {
+			binary.LittleEndian.PutUint64(b, math.Float64bits(float64(v)))
+		} else {
+			binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+		}
+		return
+	}
+	scaled := func(bits int) int64 {
+		full := float64(int64(1) << (bits - 1))
+		return int64(max(min(math.Round(float64(v)*full), full-1), -full))
+	}
+	switch f.BitsPerSample {
+	case 8:
+		b[0] = byte(scaled(8) + 128)
+	case 16:
+		binary.LittleEndian.PutUint16(b, uint16(scaled(16)))
+	case 24:
+		s := scaled(24)
+		b[0], b[1], b[2] = byte(s), byte(s>>8), byte(s>>16)
+	case 32:
+		binary.LittleEndian.PutUint32(b, uint32(scaled(32)))
+	}
+}
+
+// floatToInt16 converts a float sample in [-1, 1) to int16, clipping values
+// out of range.
+func floatToInt16(v float32) int16 {
+	s := math.Round(float64(v) * 32768)
+	return int16(max(min(s, math.MaxInt16), math.MinInt16))
+}
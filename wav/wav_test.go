@@ -0,0 +1,157 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	pcm, format, err := ReadFile("../testdata/speech_8.wav")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	want := Format{SampleRate: 48000, Channels: 1, BitsPerSample: 16}
+	if format != want {
+		t.Errorf("Got format %+v, want %+v", format, want)
+	}
+	if len(pcm) != 0x0fd200/2 {
+		t.Errorf("Read %d samples, want %d", len(pcm), 0x0fd200/2)
+	}
+	fpcm, _, err := ReadFileFloat32("../testdata/speech_8.wav")
+	if err != nil {
+		t.Fatalf("ReadFileFloat32: %v", err)
+	}
+	for i := range pcm {
+		if fpcm[i] != float32(pcm[i])/32768 {
+			t.Fatalf("Sample %d: got %v as float32, %d as int16", i, fpcm[i], pcm[i])
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	pcm := []int16{0, 1, -1, 256, -256, 12345, -12345, 32767, -32768, 1000}
+	for _, format := range []Format{
+		{SampleRate: 8000, Channels: 1, BitsPerSample: 8},
+		{SampleRate: 16000, Channels: 2},
+		{SampleRate: 44100, Channels: 2, BitsPerSample: 24},
+		{SampleRate: 48000, Channels: 1, BitsPerSample: 32},
+		{SampleRate: 48000, Channels: 5, BitsPerSample: 32, Float: true},
+		{SampleRate: 48000, Channels: 2, BitsPerSample: 64, Float: true},
+	} {
+		path := filepath.Join(t.TempDir(), "test.wav")
+		w, err := Create(path, format)
+		if err != nil {
+			t.Fatalf("%+v: Create: %v", format, err)
+		}
+		n := len(pcm) / format.Channels * format.Channels
+		if err := w.WriteInt16(pcm[:n]); err != nil {
+			t.Fatalf("%+v: WriteInt16: %v", format, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%+v: Close: %v", format, err)
+		}
+		got, gotFormat, err := ReadFile(path)
+		if err != nil {
+			t.Fatalf("%+v: ReadFile: %v", format, err)
+		}
+		if format.BitsPerSample == 0 {
+			format.BitsPerSample = 16
+		}
+		if gotFormat != format {
+			t.Errorf("Got format %+v, want %+v", gotFormat, format)
+		}
+		if len(got) != n {
+			t.Fatalf("%+v: read %d samples, want %d", format, len(got), n)
+		}
+		for i, v := range got {
+			want := pcm[i]
+			if format.BitsPerSample == 8 {
+				want &^= 0xff
+			}
+			if v != want {
+				t.Errorf("%+v: sample %d is %d, want %d", format, i, v, want)
+			}
+		}
+	}
+}
+
+func TestFloat32(t *testing.T) {
+	pcm := []float32{0, 0.5, -0.5, 0.999, -1, 1.5, -1.5, 0.25}
+	var buf bytes.Buffer
+	// A plain io.Writer leaves the sizes unknown.
+	w, err := NewWriter(&buf, Format{SampleRate: 48000, Channels: 2, BitsPerSample: 24})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if err := w.WriteFloat32(pcm); err != nil {
+		t.Fatalf("WriteFloat32: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	got := make([]float32, 16)
+	n, err := r.ReadFloat32(got)
+	if err != nil {
+		t.Fatalf("ReadFloat32: %v", err)
+	}
+	if n != len(pcm)/2 {
+		t.Fatalf("Read %d samples per channel, want %d", n, len(pcm)/2)
+	}
+	for i, v := range pcm {
+		want := max(min(v, 1-1.0/(1<<23)), -1)
+		if d := got[i] - want; d > 1e-6 || d < -1e-6 {
+			t.Errorf("Sample %d is %v, want %v", i, got[i], want)
+		}
+	}
+	if _, err := r.ReadFloat32(got); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestExtensibleAndChunks(t *testing.T) {
+	var b []byte
+	b = append(b, "RIFF\x00\x00\x00\x00WAVE"...)
+	b = append(b, "LIST\x03\x00\x00\x00abc\x00"...) // odd size, padded
+	b = append(b, "fmt "...)
+	b = binary.LittleEndian.AppendUint32(b, 40)
+	b = appendFmt(b, Format{SampleRate: 48000, Channels: 1, BitsPerSample: 16})
+	binary.LittleEndian.PutUint16(b[len(b)-16:], formatExtensible)
+	b = append(b, 22, 0, 16, 0, 4, 0, 0, 0)
+	b = append(b, 1, 0, 0, 0, 0, 0, 0x10, 0, 0x80, 0, 0, 0xaa, 0, 0x38, 0x9b, 0x71)
+	b = append(b, "data\x04\x00\x00\x00"...)
+	b = append(b, 1, 0, 2, 0)
+	b = append(b, "LIST\x00\x00\x00\x00"...)
+
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	pcm := make([]int16, 8)
+	n, err := r.ReadInt16(pcm)
+	if err != nil || n != 2 || pcm[0] != 1 || pcm[1] != 2 {
+		t.Errorf("ReadInt16 returned %d, %v: %v", n, err, pcm[:n])
+	}
+	if _, err := r.ReadInt16(pcm); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	for _, bad := range [][]byte{nil, []byte("RIFF\x00\x00\x00\x00AVI "), b[:20]} {
+		if _, err := NewReader(bytes.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+	if _, err := NewWriter(io.Discard, Format{SampleRate: 48000, Channels: 1, BitsPerSample: 12}); err == nil {
+		t.Errorf("Expected error for 12-bit samples")
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright © Go Opus Authors (see AUTHORS file)
+//
+// License for use of this code is detailed in the LICENSE file
+
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// headerSize is the size of the header written by Writer: the RIFF header,
+// a 16-byte fmt chunk and the data chunk header.
+const headerSize = 44
+
+// maxDataSize is the largest data chunk a RIFF file can describe.
+const maxDataSize = 0xffffffff - (headerSize - 8) - 1
+
+// Writer writes samples to a WAV file.
+type Writer struct {
+	w        io.Writer
+	format   Format
+	closer   io.Closer
+	dataSize int64
+	buf      []byte
+	err      error
+}
+
+// NewWriter writes a WAV header for format to w and returns a Writer for
+// its samples. If w is an io.WriteSeeker, Close fills in the chunk sizes;
+// otherwise they are left at the 0xffffffff "unknown" value that streaming
+// readers, including this package's, accept.
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	if format.BitsPerSample == 0 {
+		format.BitsPerSample = 16
+	}
+	if err := format.validate(); err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, 0, headerSize)
+	hdr = append(hdr, "RIFF"...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, unknownSize)
+	hdr = append(hdr, "WAVEfmt "...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 16)
+	hdr = appendFmt(hdr, format)
+	hdr = append(hdr, "data"...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, unknownSize)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, format: format}, nil
+}
+
+// Create creates the WAV file at path for samples in format. Close the
+// Writer to finish the file.
+func Create(path string, format Format) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f, format)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	w.closer = f
+	return w, nil
+}
+
+// Format returns the format of the samples written.
+func (w *Writer) Format() Format {
+	return w.format
+}
+
+// WriteInt16 writes interleaved int16 samples, converting them to the
+// file's format.
+func (w *Writer) WriteInt16(pcm []int16) error {
+	width := w.format.BitsPerSample / 8
+	buf := w.grow(len(pcm) * width)
+	for i, v := range pcm {
+		w.format.putInt16(buf[i*width:], v)
+	}
+	return w.write(buf)
+}
+
+// WriteFloat32 writes interleaved float32 samples in [-1, 1], converting
+// them to the file's format.
+func (w *Writer) WriteFloat32(pcm []float32) error {
+	width := w.format.BitsPerSample / 8
+	buf := w.grow(len(pcm) * width)
+	for i, v := range pcm {
+		w.format.putFloat32(buf[i*width:], v)
+	}
+	return w.write(buf)
+}
+
+func (w *Writer) grow(n int) []byte {
+	if cap(w.buf) < n {
+		w.buf = make([]byte, n)
+	}
+	return w.buf[:n]
+}
+
+func (w *Writer) write(b []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.dataSize+int64(len(b)) > maxDataSize {
+		w.err = errors.New("wav: data exceeds the 4 GiB RIFF limit")
+		return w.err
+	}
+	n, err := w.w.Write(b)
+	w.dataSize += int64(n)
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+// Close pads the data chunk, fills in the chunk sizes if the destination is
+// seekable, and closes the file opened by Create.
+func (w *Writer) Close() error {
+	err := w.finish()
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+		w.closer = nil
+	}
+	return err
+}
+
+func (w *Writer) finish() error {
+	if w.err != nil {
+		return w.err
+	}
+	// Chunks are padded to an even size.
+	if w.dataSize%2 != 0 {
+		if _, err := w.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	ws, ok := w.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	start := end - w.dataSize - w.dataSize%2 - headerSize
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(headerSize-8+w.dataSize+w.dataSize%2))
+	if err := writeAt(ws, size[:], start+4); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(size[:], uint32(w.dataSize))
+	if err := writeAt(ws, size[:], start+headerSize-4); err != nil {
+		return err
+	}
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
+
+func writeAt(ws io.WriteSeeker, b []byte, off int64) error {
+	if _, err := ws.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := ws.Write(b)
+	return err
+}